@@ -4,6 +4,9 @@ package main
 
 import "fmt"
 
+// productionMode はローカルビルドでは false です
+const productionMode = false
+
 // ローカル実行時（go run --tags=local .）にのみビルドされる
 func init() {
 	// ユーザー指定のテスト用コレクション名を設定
@@ -4,6 +4,9 @@ package main
 
 import "log"
 
+// productionMode は本番ビルドでは true です。署名キーなどの必須設定がない場合は起動を中止します
+const productionMode = true
+
 // デフォルトのビルド（go build .）でビルドされる
 func init() {
 	// ユーザー指定の本番用コレクション名を設定
@@ -22,6 +22,7 @@ func getEncryptionKey() string {
 }
 
 // DecryptPassword はAES-CBCで暗号化されたパスワードを復号化します
+// 認証付き暗号ではないため、移行期間のみ使用します（password_transport.go を参照）
 func DecryptPassword(encryptedPassword string) (string, error) {
 	key := []byte(getEncryptionKey())
 	if len(key) > 16 {
@@ -125,6 +126,7 @@ func pkcs7Unpad(data []byte) ([]byte, error) {
 }
 
 // decryptPassword はフロントエンドの簡易暗号化方式に対応した復号化関数です
+// 移行期間のみ使用し、PASSWORD_TRANSPORT_REQUIRE_ENVELOPE を有効にすると受け付けなくなります
 func decryptPassword(encryptedPassword string) (string, error) {
 	// Base64デコード
 	decoded, err := base64.StdEncoding.DecodeString(encryptedPassword)
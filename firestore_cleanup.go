@@ -4,8 +4,6 @@ import (
 	"context"
 	"log"
 	"time"
-
-	"google.golang.org/api/iterator"
 )
 
 // CleanupExpiredUsers は期限切れの未認証ユーザーとトークンを削除します
@@ -18,6 +16,24 @@ func CleanupExpiredUsers(ctx context.Context) error {
 		return err
 	}
 	
+	// 期限切れセッションの削除
+	if err := cleanupExpiredSessions(ctx); err != nil {
+		log.Printf("ERROR: Failed to cleanup expired sessions: %v", err)
+		return err
+	}
+
+	// 期限切れのリプレイ検知用ノンスの削除
+	if err := cleanupExpiredNonces(ctx); err != nil {
+		log.Printf("ERROR: Failed to cleanup expired nonces: %v", err)
+		return err
+	}
+	
+	// 有効期限が切れた非ログインのスペースの削除
+	if err := cleanupExpiredSpaces(ctx); err != nil {
+		log.Printf("ERROR: Failed to cleanup expired spaces: %v", err)
+		return err
+	}
+	
 	// 期限切れ未認証ユーザーの削除
 	if err := cleanupExpiredUnverifiedUsers(ctx); err != nil {
 		log.Printf("ERROR: Failed to cleanup expired unverified users: %v", err)
@@ -30,65 +46,73 @@ func CleanupExpiredUsers(ctx context.Context) error {
 
 // cleanupExpiredTokens は期限切れの認証トークンを削除します
 func cleanupExpiredTokens(ctx context.Context) error {
-	collectionName := firestoreCollectionName + "_verification_tokens"
-	now := time.Now()
-	
-	// 期限切れトークンを検索
-	iter := firestoreClient.Collection(collectionName).Where("expires_at", "<", now).Documents(ctx)
-	
-	var deletedCount int
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("ERROR: Failed to iterate over expired tokens: %v", err)
-			continue
-		}
-		
-		// トークンを削除
-		if _, err := doc.Ref.Delete(ctx); err != nil {
-			log.Printf("ERROR: Failed to delete expired token %s: %v", doc.Ref.ID, err)
-			continue
-		}
-		
-		deletedCount++
-		log.Printf("INFO: Deleted expired token: %s", doc.Ref.ID)
+	deletedCount, err := dataStore.DeleteExpiredVerificationTokens(ctx, time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to delete expired tokens: %v", err)
+		return err
 	}
 	
 	log.Printf("INFO: Deleted %d expired verification tokens", deletedCount)
 	return nil
 }
 
+// cleanupExpiredSessions は期限切れのログインセッションを削除します
+func cleanupExpiredSessions(ctx context.Context) error {
+	deletedCount, err := dataStore.DeleteExpiredSessions(ctx, time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to delete expired sessions: %v", err)
+		return err
+	}
+
+	log.Printf("INFO: Deleted %d expired sessions", deletedCount)
+	return nil
+}
+
+// cleanupExpiredNonces は期限切れの使用済みノンスを削除します
+func cleanupExpiredNonces(ctx context.Context) error {
+	deletedCount, err := dataStore.DeleteExpiredNonces(ctx, time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to delete expired nonces: %v", err)
+		return err
+	}
+
+	log.Printf("INFO: Deleted %d expired nonces", deletedCount)
+	return nil
+}
+
+// cleanupExpiredSpaces は有効期限が切れた非ログインのスペースと、その監査ログを削除します
+// 有効期限は最後の更新から ANONYMOUS_SPACE_RETENTION_DAYS 日後です（touchSchedule を参照）
+func cleanupExpiredSpaces(ctx context.Context) error {
+	spaceIds, err := dataStore.DeleteExpiredSchedules(ctx, time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to delete expired spaces: %v", err)
+		return err
+	}
+
+	for _, spaceId := range spaceIds {
+		if _, err := dataStore.DeleteScheduleAudit(ctx, spaceId); err != nil {
+			log.Printf("WARN: Failed to delete audit entries of expired space %s: %v", spaceId, err)
+		}
+	}
+	log.Printf("INFO: Deleted %d expired anonymous spaces", len(spaceIds))
+	return nil
+}
+
 // cleanupExpiredUnverifiedUsers は期限切れの未認証ユーザーを削除します
 func cleanupExpiredUnverifiedUsers(ctx context.Context) error {
-	collectionName := firestoreCollectionName + "_verification_tokens"
 	cutoffTime := time.Now().Add(-24 * time.Hour) // 24時間前
 	
 	// 24時間以上前のトークンを検索
-	iter := firestoreClient.Collection(collectionName).Where("created_at", "<", cutoffTime).Documents(ctx)
+	tokens, err := dataStore.ListVerificationTokensCreatedBefore(ctx, cutoffTime)
+	if err != nil {
+		log.Printf("ERROR: Failed to list old tokens: %v", err)
+		return err
+	}
 	
 	var deletedUserCount int
 	processedUIDs := make(map[string]bool) // 重複削除を防ぐため
 	
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("ERROR: Failed to iterate over old tokens: %v", err)
-			continue
-		}
-		
-		// トークンデータを取得
-		var token VerificationToken
-		if err := doc.DataTo(&token); err != nil {
-			log.Printf("ERROR: Failed to parse token data for %s: %v", doc.Ref.ID, err)
-			continue
-		}
-		
+	for _, token := range tokens {
 		// 既に処理済みのUIDはスキップ
 		if processedUIDs[token.UID] {
 			continue
@@ -123,10 +147,10 @@ func cleanupExpiredUnverifiedUsers(ctx context.Context) error {
 	return nil
 }
 
-// deleteUnverifiedUser は未認証ユーザーをFirebase Authenticationから削除します
+// deleteUnverifiedUser は未認証ユーザーを認証プロバイダーから削除します
 func deleteUnverifiedUser(ctx context.Context, uid string) error {
-	// Firebase Authenticationからユーザーを削除
-	if err := authClient.DeleteUser(ctx, uid); err != nil {
+	// 認証プロバイダーからユーザーを削除
+	if err := identityProvider.DeleteUser(ctx, uid); err != nil {
 		return err
 	}
 	
@@ -141,31 +165,12 @@ func deleteUnverifiedUser(ctx context.Context, uid string) error {
 
 // deleteAllTokensForUser は指定されたユーザーのすべてのトークンを削除します
 func deleteAllTokensForUser(ctx context.Context, uid string) error {
-	collectionName := firestoreCollectionName + "_verification_tokens"
-	
-	// ユーザーのトークンを検索
-	iter := firestoreClient.Collection(collectionName).Where("uid", "==", uid).Documents(ctx)
-	
-	var deletedCount int
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("ERROR: Failed to iterate over user tokens: %v", err)
-			continue
-		}
-		
-		// トークンを削除
-		if _, err := doc.Ref.Delete(ctx); err != nil {
-			log.Printf("ERROR: Failed to delete token %s for user %s: %v", doc.Ref.ID, uid, err)
-			continue
-		}
-		
-		deletedCount++
+	deletedCount, err := dataStore.DeleteVerificationTokensForUser(ctx, uid)
+	if err != nil {
+		log.Printf("ERROR: Failed to delete tokens for user %s: %v", uid, err)
+		return err
 	}
 	
 	log.Printf("INFO: Deleted %d tokens for user %s", deletedCount, uid)
 	return nil
-} 
\ No newline at end of file
+}
\ No newline at end of file
@@ -20,15 +20,65 @@ var authClient *auth.Client
 func init() {
 	_ = godotenv.Load() // .envファイルはローカル開発でのみ使用。エラーは無視。
 
-	log.Println("Initializing Firestore client...")
-
 	ctx := context.Background()
+	backend := getStorageBackend()
 
 	serviceAccountJSON := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON")
 	if serviceAccountJSON == "" {
-		log.Fatalf("環境変数 GOOGLE_APPLICATION_CREDENTIALS_JSON が設定されていません。")
+		if backend == storageBackendFirestore {
+			log.Fatalf("環境変数 GOOGLE_APPLICATION_CREDENTIALS_JSON が設定されていません。")
+		}
+		// Firestoreを使わない場合は認証情報なしでも起動できるようにする
+		log.Println("WARN: GOOGLE_APPLICATION_CREDENTIALS_JSON is not set. Firebase clients are disabled.")
+	} else {
+		initFirebaseClients(ctx, serviceAccountJSON)
+	}
+
+	if backend == storageBackendFirestore {
+		dataStore = newFirestoreStore(firestoreClient)
+	} else {
+		store, err := newLocalStore(backend)
+		if err != nil {
+			log.Fatalf("ストレージの初期化に失敗しました: %v", err)
+		}
+		dataStore = store
 	}
 
+	provider, err := newIdentityProvider(getAuthProviderName(), dataStore)
+	if err != nil {
+		log.Fatalf("認証プロバイダーの初期化に失敗しました: %v", err)
+	}
+	identityProvider = provider
+
+	keySet, err := loadSessionKeySet()
+	if err != nil {
+		log.Fatalf("セッション署名キーの読み込みに失敗しました: %v", err)
+	}
+	sessionKeySet = keySet
+
+	hasher, err := loadPasswordHasher()
+	if err != nil {
+		log.Fatalf("パスワードハッシュの設定の読み込みに失敗しました: %v", err)
+	}
+	passwordHasher = hasher
+
+	transport, err := loadPasswordTransport()
+	if err != nil {
+		log.Fatalf("パスワード送信用の鍵の読み込みに失敗しました: %v", err)
+	}
+	passwordTransport = transport
+
+	policy, err := loadPasswordPolicy(passwordHasher)
+	if err != nil {
+		log.Fatalf("パスワードポリシーの読み込みに失敗しました: %v", err)
+	}
+	passwordPolicy = policy
+}
+
+// initFirebaseClients はFirebase Admin SDKを初期化し、FirestoreとAuthのクライアントを設定します
+func initFirebaseClients(ctx context.Context, serviceAccountJSON string) {
+	log.Println("Initializing Firestore client...")
+
 	authOption := option.WithCredentialsJSON([]byte(serviceAccountJSON))
 	app, err := firebase.NewApp(ctx, nil, authOption)
 	if err != nil {
@@ -46,4 +96,4 @@ func init() {
 	}
 
 	log.Println("Firestore and Auth clients initialized successfully.")
-}
\ No newline at end of file
+}
@@ -4,44 +4,32 @@ import (
 	"context"
 	"log"
 	"time"
-
-	"firebase.google.com/go/v4/auth"
 )
 
-func getScheduleFromFirestore(ctx context.Context, spaceId string) (map[string]interface{}, error) {
-	doc, err := firestoreClient.Collection(firestoreCollectionName).Doc(spaceId).Get(ctx)
+// getSchedule は指定されたspaceIdのスケジュールドキュメントを取得します
+// ドキュメントが存在しない場合は ErrNotFound を返します
+// 有効期限を過ぎたスペースも ErrNotFound として扱います
+func getSchedule(ctx context.Context, spaceId string) (*ScheduleDocument, error) {
+	doc, err := dataStore.GetSchedule(ctx, spaceId)
 	if err != nil {
 		return nil, err
 	}
-
-	var data map[string]interface{}
-	if err := doc.DataTo(&data); err != nil {
-		return nil, err
+	if isScheduleExpired(doc, time.Now()) {
+		return nil, ErrSpaceExpired
 	}
-
-	return data, nil
+	return doc, nil
 }
 
-// getVerificationToken は認証トークンをFirestoreから取得します
+// getVerificationToken は認証トークンをストレージから取得します
 func getVerificationToken(ctx context.Context, token string) (*VerificationToken, error) {
-	// 認証トークン用のコレクション名
-	collectionName := firestoreCollectionName + "_verification_tokens"
-	
-	// Firestoreから取得
-	doc, err := firestoreClient.Collection(collectionName).Doc(token).Get(ctx)
+	verificationToken, err := dataStore.GetVerificationToken(ctx, token)
 	if err != nil {
-		log.Printf("ERROR: Failed to get verification token from Firestore: %v", err)
+		log.Printf("ERROR: Failed to get verification token: %v", err)
 		return nil, err
 	}
 	
-	var verificationToken VerificationToken
-	if err := doc.DataTo(&verificationToken); err != nil {
-		log.Printf("ERROR: Failed to convert verification token data: %v", err)
-		return nil, err
-	}
-	
-	log.Printf("INFO: Verification token retrieved from Firestore for email: %s", verificationToken.Email)
-	return &verificationToken, nil
+	log.Printf("INFO: Verification token retrieved for email: %s", verificationToken.Email)
+	return verificationToken, nil
 }
 
 // verifyEmailToken は認証トークンを検証し、メールアドレスを認証します
@@ -91,12 +79,8 @@ func verifyEmailToken(ctx context.Context, token string) (bool, bool, error) {
 		return true, true, nil
 	}
 	
-	// Firebase Authenticationでメールアドレスを認証
-	params := (&auth.UserToUpdate{}).
-		EmailVerified(true)
-	
-	_, err = authClient.UpdateUser(ctx, verificationToken.UID, params)
-	if err != nil {
+	// 認証プロバイダーでメールアドレスを認証済みにする
+	if err := markUserAsVerified(ctx, verificationToken.UID); err != nil {
 		log.Printf("ERROR: Failed to update user email verification status: %v", err)
 		return false, false, err
 	}
@@ -111,35 +95,19 @@ func verifyEmailToken(ctx context.Context, token string) (bool, bool, error) {
 	return true, false, nil
 }
 
-// deleteVerificationToken は認証トークンをFirestoreから削除します
+// deleteVerificationToken は認証トークンをストレージから削除します
 func deleteVerificationToken(ctx context.Context, token string) error {
-	// 認証トークン用のコレクション名
-	collectionName := firestoreCollectionName + "_verification_tokens"
-	
-	// Firestoreから削除
-	_, err := firestoreClient.Collection(collectionName).Doc(token).Delete(ctx)
-	if err != nil {
-		log.Printf("ERROR: Failed to delete verification token from Firestore: %v", err)
+	if err := dataStore.DeleteVerificationToken(ctx, token); err != nil {
+		log.Printf("ERROR: Failed to delete verification token: %v", err)
 		return err
 	}
 	
-	log.Printf("INFO: Verification token deleted from Firestore")
+	log.Printf("INFO: Verification token deleted")
 	return nil
 }
 
-// isUserEmailVerified はユーザーのメールアドレスが既に認証済みかどうかをチェックします
-func isUserEmailVerified(ctx context.Context, uid string) (bool, error) {
-	userRecord, err := authClient.GetUser(ctx, uid)
-	if err != nil {
-		log.Printf("ERROR: Failed to get user record: %v", err)
-		return false, err
-	}
-	
-	return userRecord.EmailVerified, nil
-}
-
-// getUserDataFromFirestore はユーザーデータをFirestoreから取得します
-func getUserDataFromFirestore(ctx context.Context, uid string) (*UserData, error) {
+// getUserData はユーザーデータをストレージから取得します
+func getUserData(ctx context.Context, uid string) (*UserData, error) {
 	// 新しい関数を使用してUIDでユーザーデータを取得
 	userData, err := getUserDataByUID(ctx, uid)
 	if err != nil {
@@ -147,6 +115,6 @@ func getUserDataFromFirestore(ctx context.Context, uid string) (*UserData, error
 		return nil, err
 	}
 	
-	log.Printf("INFO: User data retrieved from Firestore for UID: %s", uid)
+	log.Printf("INFO: User data retrieved for UID: %s", uid)
 	return userData, nil
 }
\ No newline at end of file
@@ -3,50 +3,48 @@ package main
 import (
 	"context"
 	"log"
+	"time"
 )
 
-// saveScheduleToFirestore は、指定されたspaceIdのドキュメントとしてデータをFirestoreに保存します。
+// saveSchedule は、指定されたspaceIdのドキュメントとしてデータを保存します。
 // 既存のドキュメントがある場合は、完全に上書きされます。
-func saveScheduleToFirestore(ctx context.Context, spaceId string, data *ScheduleDocument) error {
-	docRef := firestoreClient.Collection(firestoreCollectionName).Doc(spaceId)
-	
-	// .Set() メソッドは構造体を渡すと、自動的にFirestoreのドキュメント形式に変換します。
-	_, err := docRef.Set(ctx, data)
-	return err
+func saveSchedule(ctx context.Context, spaceId string, data *ScheduleDocument) error {
+	return dataStore.SaveSchedule(ctx, spaceId, data)
+}
+
+// updateSchedule は dataStore.UpdateSchedule と同様にスペースをトランザクションで更新します
+// 有効期限を過ぎたスペースは fn を呼び出さずに ErrNotFound を返します
+func updateSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error {
+	return dataStore.UpdateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		if isScheduleExpired(doc, time.Now()) {
+			return ErrSpaceExpired
+		}
+		return fn(doc)
+	})
 }
 
-// saveVerificationToken は認証トークンをFirestoreに保存します
+// saveVerificationToken は認証トークンをストレージに保存します
 func saveVerificationToken(ctx context.Context, token *VerificationToken) error {
-	// 認証トークン用のコレクション名
-	collectionName := firestoreCollectionName + "_verification_tokens"
-	
-	// Firestoreに保存
-	_, err := firestoreClient.Collection(collectionName).Doc(token.Token).Set(ctx, token)
-	if err != nil {
-		log.Printf("ERROR: Failed to save verification token to Firestore: %v", err)
+	if err := dataStore.SaveVerificationToken(ctx, token); err != nil {
+		log.Printf("ERROR: Failed to save verification token: %v", err)
 		return err
 	}
 	
-	log.Printf("INFO: Verification token saved to Firestore for email: %s", token.Email)
+	log.Printf("INFO: Verification token saved for email: %s", token.Email)
 	return nil
 }
 
-// saveUserDataToFirestore はユーザーデータをFirestoreに保存します
-func saveUserDataToFirestore(ctx context.Context, uid string, userData *UserData) error {
-	// ユーザーデータ用のコレクション名（環境に依存しない固定名）
-	collectionName := "users"
-	
+// saveUserData はユーザーデータをストレージに保存します
+func saveUserData(ctx context.Context, uid string, userData *UserData) error {
 	// UIDを設定
 	userData.UID = uid
 	
-	// Firestoreに保存
-	_, err := firestoreClient.Collection(collectionName).Doc(uid).Set(ctx, userData)
-	if err != nil {
-		log.Printf("ERROR: Failed to save user data to Firestore: %v", err)
+	if err := dataStore.SaveUser(ctx, uid, userData); err != nil {
+		log.Printf("ERROR: Failed to save user data: %v", err)
 		return err
 	}
 	
-	log.Printf("INFO: User data saved to Firestore for UID: %s (UserName: %s, UserColor: %s)", 
+	log.Printf("INFO: User data saved for UID: %s (UserName: %s, UserColor: %s)", 
 		uid, userData.UserName, userData.UserColor)
 	return nil
 }
\ No newline at end of file
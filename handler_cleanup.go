@@ -14,7 +14,7 @@ type CleanupResponse struct {
 }
 
 // ProcessCleanupRequest はクリーンアップリクエストを処理します
-func ProcessCleanupRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
+func ProcessCleanupRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// セキュリティログ
 	log.Printf("INFO: Cleanup request received")
 
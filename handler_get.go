@@ -2,42 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-
-	"github.com/aws/aws-lambda-go/events"
+	"time"
 )
 
-func processGetRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	switch r := req.(type) {
-	case *http.Request:
-		return handleGetRequest(ctx, r)
-	case events.APIGatewayV2HTTPRequest:
-		return handleGetLambdaRequest(ctx, r)
-	case string:
-		// spaceIdが直接渡された場合（Lambda用）
-		return processGetScheduleRequest(ctx, r)
-	default:
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
+// processGetTimeRequest は GET /api/time/{spaceId} を処理します
+func processGetTimeRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	if spaceId == "" {
+		return map[string]interface{}{"error": "spaceIdが指定されていません"}, http.StatusBadRequest
 	}
-}
-
-func handleGetRequest(ctx context.Context, req *http.Request) (map[string]interface{}, int) {
-	// メール設定確認エンドポイント
-	if req.URL.Path == "/email-config" {
-		return checkEmailConfig()
-	}
-	
-	return map[string]interface{}{"error": "エンドポイントが見つかりません"}, http.StatusNotFound
-}
-
-func handleGetLambdaRequest(ctx context.Context, req events.APIGatewayV2HTTPRequest) (map[string]interface{}, int) {
-	// メール設定確認エンドポイント
-	if req.RequestContext.HTTP.Path == "/email-config" {
-		return checkEmailConfig()
-	}
-	
-	return map[string]interface{}{"error": "エンドポイントが見つかりません"}, http.StatusNotFound
+	return processGetScheduleRequest(ctx, req, spaceId)
 }
 
 // checkEmailConfig はメール設定の状況を確認します
@@ -71,16 +48,43 @@ func checkEmailConfig() (map[string]interface{}, int) {
 }
 
 // 既存のスケジュール取得機能
-func processGetScheduleRequest(ctx context.Context, spaceId string) (map[string]interface{}, int) {
-	data, err := getScheduleFromFirestore(ctx, spaceId)
+// クエリパラメータ tz（IANAタイムゾーン）を指定した場合は、予定をそのタイムゾーンに変換して返します
+func processGetScheduleRequest(ctx context.Context, req *Request, spaceId string) (map[string]interface{}, int) {
+	var viewer *time.Location
+	if tz := req.QueryParam("tz"); tz != "" {
+		loc, err := loadTimeZone(tz)
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}, http.StatusBadRequest
+		}
+		viewer = loc
+	}
+
+	data, err := getSchedule(ctx, spaceId)
+	if errors.Is(err, ErrNotFound) {
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	}
 	if err != nil {
 		fmt.Printf("Firestore Getエラー (spaceId: %s): %v\n", spaceId, err)
 		return map[string]interface{}{"error": "データの取得に失敗しました: " + err.Error()}, http.StatusInternalServerError
 	}
+	if err := authorizeSpaceReadRequest(ctx, spaceId, data, req); err != nil {
+		return spaceAccessErrorResponse(err)
+	}
 
-	if data == nil {
-		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	// 更新時に If-Match で送り返せるよう、リビジョンをETagとして返す
+	req.SetResponseHeader("ETag", revisionETag(data.Revision))
+	if viewer == nil {
+		return scheduleDocumentToMap(data), http.StatusOK
 	}
 
-	return data, http.StatusOK
+	spaceZone, err := loadTimeZone(data.TimeZone)
+	if err != nil {
+		fmt.Printf("WARN: Invalid time zone of spaceId %s: %v\n", spaceId, err)
+		spaceZone, _ = loadTimeZone("")
+	}
+	converted := *data
+	converted.Events = convertScheduleEvents(data.Events, spaceZone, viewer)
+	result := scheduleDocumentToMap(&converted)
+	result["viewerTimeZone"] = viewer.String()
+	return result, http.StatusOK
 }
\ No newline at end of file
@@ -10,9 +10,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-
-	"firebase.google.com/go/v4/auth"
-	"github.com/aws/aws-lambda-go/events"
 )
 
 // GitHubAuthRequest はGitHub OAuth2.0認証リクエストの構造体です
@@ -230,7 +227,7 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 		log.Printf("INFO: Account linking mode for UID: %s", linkUID)
 		
 		// 指定されたUIDのユーザーが存在するか確認
-		_, err := authClient.GetUser(ctx, linkUID)
+		_, err := identityProvider.GetUser(ctx, linkUID)
 		if err != nil {
 			return "", fmt.Errorf("リンク先のユーザーが見つかりません: %v", err)
 		}
@@ -260,7 +257,7 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			linkUID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, linkUID, userData); err != nil {
+		if err := saveUserData(ctx, linkUID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 		
@@ -268,18 +265,17 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 	}
 	
 	// 新規ログイン時は、メールアドレスで既存のユーザーを確認
-	userRecord, err := authClient.GetUserByEmail(ctx, githubUser.Email)
+	userRecord, err := identityProvider.GetUserByEmail(ctx, githubUser.Email)
 	if err != nil {
 		// ユーザーが存在しない場合は新しいGitHubユーザーを作成
 		uid := fmt.Sprintf("github_%d", githubUser.ID)
-		params := (&auth.UserToCreate{}).
-			Email(githubUser.Email).
-			DisplayName(githubUser.Name).
-			PhotoURL(githubUser.AvatarURL).
-			EmailVerified(true).
-			UID(uid)
-
-		userRecord, err = authClient.CreateUser(ctx, params)
+		userRecord, err = identityProvider.CreateUser(ctx, &IdentityUserToCreate{
+			UID:           uid,
+			Email:         githubUser.Email,
+			DisplayName:   githubUser.Name,
+			PhotoURL:      githubUser.AvatarURL,
+			EmailVerified: true,
+		})
 		if err != nil {
 			return "", fmt.Errorf("Firebaseユーザー作成エラー: %v", err)
 		}
@@ -297,7 +293,7 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 		}
 		addOAuthProvider(userData, "github", githubUser.Email, uid)
 		
-		if err := saveUserDataToFirestore(ctx, uid, userData); err != nil {
+		if err := saveUserData(ctx, uid, userData); err != nil {
 			log.Printf("WARN: Failed to save user data for new GitHub user: %v", err)
 		}
 	} else {
@@ -332,32 +328,22 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			userRecord.UID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+		if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 		
 		// 既存のユーザーがGitHubプロバイダーで作成されているかチェック
-		providers := userRecord.ProviderUserInfo
-		hasGitHubProvider := false
-		
-		for _, provider := range providers {
-			if provider.ProviderID == "github.com" {
-				hasGitHubProvider = true
-				break
-			}
-		}
-		
-		if !hasGitHubProvider {
+		if !userRecord.HasProvider("github.com") {
 			// 既存のユーザーがメールアドレスログインで作成されている場合
 			log.Printf("INFO: Using existing email user for GitHub login: %s", githubUser.Email)
 			
 			// 既存ユーザーの情報を更新（表示名やプロフィール画像など）
-			updateParams := (&auth.UserToUpdate{}).
-				DisplayName(githubUser.Name).
-				PhotoURL(githubUser.AvatarURL).
-				EmailVerified(true)
-			
-			_, err = authClient.UpdateUser(ctx, userRecord.UID, updateParams)
+			verified := true
+			_, err = identityProvider.UpdateUser(ctx, userRecord.UID, &IdentityUserToUpdate{
+				DisplayName:   &githubUser.Name,
+				PhotoURL:      &githubUser.AvatarURL,
+				EmailVerified: &verified,
+			})
 			if err != nil {
 				log.Printf("WARN: Failed to update user profile: %v", err)
 				// 更新に失敗してもログインは続行
@@ -368,30 +354,11 @@ func createOrGetFirebaseUserForGitHub(ctx context.Context, githubUser *GitHubUse
 	return userRecord.UID, nil
 }
 
-func processGitHubAuthRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processGitHubAuthRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var authData GitHubAuthRequest
-	if err := json.Unmarshal(bodyBytes, &authData); err != nil {
-		log.Printf("WARN: Failed to parse GitHub auth JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&authData); err != nil {
+		log.Printf("WARN: Failed to parse GitHub auth JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -438,7 +405,7 @@ func processGitHubAuthRequest(ctx context.Context, req interface{}) (map[string]
 	}
 
 	// セッショントークンを生成
-	sessionToken, err := generateSessionToken(uid, userInfo.Email)
+	tokens, err := createSession(ctx, uid, userInfo.Email, req.Header("User-Agent"))
 	if err != nil {
 		log.Printf("ERROR: Failed to generate session token for UID %s: %v\n", uid, err)
 		return map[string]interface{}{"error": "セッショントークンの生成に失敗しました"}, http.StatusInternalServerError
@@ -450,6 +417,8 @@ func processGitHubAuthRequest(ctx context.Context, req interface{}) (map[string]
 		"message":      "GitHubアカウントでのログインが成功しました",
 		"uid":          uid,
 		"email":        userInfo.Email,
-		"sessionToken": sessionToken,
+		"sessionToken": tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	}, http.StatusOK
 } 
\ No newline at end of file
@@ -10,9 +10,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-
-	"firebase.google.com/go/v4/auth"
-	"github.com/aws/aws-lambda-go/events"
 )
 
 // GoogleAuthRequest はGoogle OAuth2.0認証リクエストの構造体です
@@ -137,7 +134,7 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 		log.Printf("INFO: Account linking mode for UID: %s", linkUID)
 		
 		// 指定されたUIDのユーザーが存在するか確認
-		_, err := authClient.GetUser(ctx, linkUID)
+		_, err := identityProvider.GetUser(ctx, linkUID)
 		if err != nil {
 			return "", fmt.Errorf("リンク先のユーザーが見つかりません: %v", err)
 		}
@@ -167,7 +164,7 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			linkUID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, linkUID, userData); err != nil {
+		if err := saveUserData(ctx, linkUID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 		
@@ -175,18 +172,17 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 	}
 	
 	// 新規ログイン時は、メールアドレスで既存のユーザーを確認
-	userRecord, err := authClient.GetUserByEmail(ctx, googleUser.Email)
+	userRecord, err := identityProvider.GetUserByEmail(ctx, googleUser.Email)
 	if err != nil {
 		// ユーザーが存在しない場合は新しいGoogleユーザーを作成
 		uid := "google_" + googleUser.ID
-		params := (&auth.UserToCreate{}).
-			Email(googleUser.Email).
-			DisplayName(googleUser.Name).
-			PhotoURL(googleUser.Picture).
-			EmailVerified(googleUser.VerifiedEmail).
-			UID(uid)
-
-		userRecord, err = authClient.CreateUser(ctx, params)
+		userRecord, err = identityProvider.CreateUser(ctx, &IdentityUserToCreate{
+			UID:           uid,
+			Email:         googleUser.Email,
+			DisplayName:   googleUser.Name,
+			PhotoURL:      googleUser.Picture,
+			EmailVerified: googleUser.VerifiedEmail,
+		})
 		if err != nil {
 			return "", fmt.Errorf("Firebaseユーザー作成エラー: %v", err)
 		}
@@ -204,7 +200,7 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 		}
 		addOAuthProvider(userData, "google", googleUser.Email, uid)
 		
-		if err := saveUserDataToFirestore(ctx, uid, userData); err != nil {
+		if err := saveUserData(ctx, uid, userData); err != nil {
 			log.Printf("WARN: Failed to save user data for new Google user: %v", err)
 		}
 	} else {
@@ -239,32 +235,22 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			userRecord.UID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+		if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 		
 		// 既存のユーザーがGoogleプロバイダーで作成されているかチェック
-		providers := userRecord.ProviderUserInfo
-		hasGoogleProvider := false
-		
-		for _, provider := range providers {
-			if provider.ProviderID == "google.com" {
-				hasGoogleProvider = true
-				break
-			}
-		}
-		
-		if !hasGoogleProvider {
+		if !userRecord.HasProvider("google.com") {
 			// 既存のユーザーがメールアドレスログインで作成されている場合
 			log.Printf("INFO: Using existing email user for Google login: %s", googleUser.Email)
 			
 			// 既存ユーザーの情報を更新（表示名やプロフィール画像など）
-			updateParams := (&auth.UserToUpdate{}).
-				DisplayName(googleUser.Name).
-				PhotoURL(googleUser.Picture).
-				EmailVerified(true)
-			
-			_, err = authClient.UpdateUser(ctx, userRecord.UID, updateParams)
+			verified := true
+			_, err = identityProvider.UpdateUser(ctx, userRecord.UID, &IdentityUserToUpdate{
+				DisplayName:   &googleUser.Name,
+				PhotoURL:      &googleUser.Picture,
+				EmailVerified: &verified,
+			})
 			if err != nil {
 				log.Printf("WARN: Failed to update user profile: %v", err)
 				// 更新に失敗してもログインは続行
@@ -275,30 +261,11 @@ func createOrGetFirebaseUser(ctx context.Context, googleUser *GoogleUserInfo, li
 	return userRecord.UID, nil
 }
 
-func processGoogleAuthRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processGoogleAuthRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var authData GoogleAuthRequest
-	if err := json.Unmarshal(bodyBytes, &authData); err != nil {
-		log.Printf("WARN: Failed to parse Google auth JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&authData); err != nil {
+		log.Printf("WARN: Failed to parse Google auth JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -345,7 +312,7 @@ func processGoogleAuthRequest(ctx context.Context, req interface{}) (map[string]
 	}
 
 	// セッショントークンを生成
-	sessionToken, err := generateSessionToken(uid, userInfo.Email)
+	tokens, err := createSession(ctx, uid, userInfo.Email, req.Header("User-Agent"))
 	if err != nil {
 		log.Printf("ERROR: Failed to generate session token for UID %s: %v\n", uid, err)
 		return map[string]interface{}{"error": "セッショントークンの生成に失敗しました"}, http.StatusInternalServerError
@@ -357,6 +324,8 @@ func processGoogleAuthRequest(ctx context.Context, req interface{}) (map[string]
 		"message":      "Googleアカウントでのログインが成功しました",
 		"uid":          uid,
 		"email":        userInfo.Email,
-		"sessionToken": sessionToken,
+		"sessionToken": tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	}, http.StatusOK
 } 
\ No newline at end of file
@@ -2,38 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
 	"log"
 	"net/http"
-
-	"github.com/aws/aws-lambda-go/events"
+	"time"
 )
 
-func processPostRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayProxyRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", req)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processPostRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var postData SchedulePostRequest
-	if err := json.Unmarshal(bodyBytes, &postData); err != nil {
-		log.Printf("WARN: Failed to parse JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&postData); err != nil {
+		log.Printf("WARN: Failed to parse JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -49,6 +28,11 @@ func processPostRequest(ctx context.Context, req interface{}) (map[string]interf
 		}
 	}
 
+	// 日付と時刻を検証し、正規形（YYYY-MM-DD・HH:MM）にそろえる
+	if err := validateSchedulePost(&postData); err != nil {
+		return validationErrorResponse(err)
+	}
+
 	// 既存のspaceIdが指定されているかチェック
 	var targetSpaceId string
 	var isUpdate bool
@@ -60,52 +44,185 @@ func processPostRequest(ctx context.Context, req interface{}) (map[string]interf
 		log.Printf("INFO: Updating existing spaceId: %s", targetSpaceId)
 	} else {
 		// 新規作成時
-		targetSpaceId = firestoreClient.Collection(firestoreCollectionName).NewDoc().ID
+		targetSpaceId = dataStore.NewScheduleID(ctx)
 		isUpdate = false
 		log.Printf("INFO: Creating new spaceId: %s", targetSpaceId)
 	}
 
 	// Firestoreに保存するドキュメントを作成
 	scheduleDoc := &ScheduleDocument{
+		Title:          stringPtrValue(postData.Title),
 		AllowOtherEdit: postData.AllowOtherEdit,
 		StartDate:      postData.StartDate,
 		EndDate:        postData.EndDate,
 		Events:         postData.Events,
 	}
 
-	// コンテキストからUIDを取得し、存在すればドキュメントにセットする
-	// ミドルウェアにより、ログインユーザーの場合のみUIDがセットされている
-	if uid, ok := getUIDFromContext(ctx); ok {
-		scheduleDoc.OwnerUID = uid
-		if isUpdate {
-			log.Printf("INFO: Updating spaceId %s with owner UID %s\n", targetSpaceId, uid)
-		} else {
-			log.Printf("INFO: Associating new spaceId %s with owner UID %s\n", targetSpaceId, uid)
+	// コンテキストからPrincipalを取得する
+	// ルーターにより、ログインユーザーの場合のみPrincipalがセットされている
+	principal, _ := principalFromContext(ctx)
+
+	var editor *SpaceEditor
+	var changes *ScheduleChanges
+	var editKey string             // 非ログインで作成したスペースに発行する編集キー
+	var conflict *ScheduleDocument // If-Match が一致しなかった場合の保存済みの状態
+	var revision int64
+	action := "create"
+
+	if isUpdate {
+		// 共有リンクのパスワードの検証は時間がかかるため、トランザクションの前に行う
+		shareLink, err := loadShareLinkForRequest(ctx, targetSpaceId, req)
+		if isSpaceAccessError(err) {
+			log.Printf("WARN: Share link for spaceId %s rejected: %v\n", targetSpaceId, err)
+			return spaceAccessErrorResponse(err)
 		}
-	} else {
-		if isUpdate {
-			log.Printf("INFO: Updating spaceId %s for anonymous user.\n", targetSpaceId)
+		if err != nil {
+			log.Printf("ERROR: Failed to load spaceId %s: %v\n", targetSpaceId, err)
+			return map[string]interface{}{"error": "データの取得に失敗しました"}, http.StatusInternalServerError
+		}
+
+		// 読み込みから権限の確認・保存までをトランザクションで行う
+		err = updateSchedule(ctx, targetSpaceId, func(existing *ScheduleDocument) error {
+			var err error
+			editor, err = resolveRequestEditor(existing, principal, req, shareLink)
+			if err != nil {
+				return err
+			}
+			// 共有リンクが必要なスペースは、競合時のレスポンスで内容を返す前に閲覧権限を確認する
+			if err := authorizeSpaceRead(existing, editor); err != nil {
+				return err
+			}
+			if !ifMatchSatisfied(req.Header("If-Match"), existing.Revision) {
+				current := *existing
+				conflict = &current
+				return ErrRevisionConflict
+			}
+
+			if err := checkScheduleWritable(existing); err != nil {
+				return err
+			}
+			updated := *scheduleDoc
+			updated.OwnerUID = existing.OwnerUID
+			updated.EditKeyHash = existing.EditKeyHash
+			updated.Participants = existing.Participants
+			updated.Decision = existing.Decision
+			updated.CreatedAt = existing.CreatedAt
+			updated.MemberUIDs = existing.MemberUIDs
+			updated.LinkRequired = existing.LinkRequired
+			updated.ShareLinks = existing.ShareLinks
+			updated.Title = existing.Title
+			if postData.Title != nil {
+				updated.Title = *postData.Title
+			}
+			updated.TimeZone = existing.TimeZone
+			if postData.TimeZone != nil {
+				updated.TimeZone = *postData.TimeZone
+			}
+			updated.Revision = existing.Revision + 1
+			touchSchedule(&updated, time.Now())
+
+			// 編集キー導入前に非ログインで作成されたスペースにはオーナーがいないため、
+			// POST /api/time/{spaceId}/claim で明示的に引き継ぐまで AllowOtherEdit に従って参加者の予定のみ変更できる
+			changes = diffSchedule(existing, &updated)
+			if err := authorizeScheduleUpdate(existing, editor, changes); err != nil {
+				return err
+			}
+			claimSpaceUsername(&updated, editor, changes)
+			recordSpaceMember(&updated, editor, changes)
+			revision = updated.Revision
+			*existing = updated
+			return nil
+		})
+
+		switch {
+		case err == nil:
+			action = "update"
+			if editor.UID != "" {
+				log.Printf("INFO: Updating spaceId %s by UID %s (%s)\n", targetSpaceId, editor.UID, editor.role())
+			} else {
+				log.Printf("INFO: Updating spaceId %s for anonymous user (%s).\n", targetSpaceId, editor.role())
+			}
+		case errors.Is(err, ErrSpaceExpired):
+			// 期限切れのスペースは指定されたIDで作り直さない（新しいスペースのIDは NewScheduleID でのみ発行する）
+			log.Printf("WARN: spaceId %s has expired\n", targetSpaceId)
+			return map[string]interface{}{"error": "指定されたspaceIdのスペースは有効期限が切れています", "code": "space_expired"}, http.StatusGone
+		case errors.Is(err, ErrNotFound):
+			log.Printf("WARN: spaceId %s not found\n", targetSpaceId)
+			return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+		case errors.Is(err, ErrRevisionConflict):
+			log.Printf("WARN: Revision conflict on spaceId %s (current revision %d)\n", targetSpaceId, conflict.Revision)
+			return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+		case isSpaceAccessError(err):
+			log.Printf("WARN: Update of spaceId %s denied for %s (%s): %v\n", targetSpaceId, editor.role(), editor.UID, err)
+			recordScheduleAudit(ctx, targetSpaceId, editor, "denied", changes, err)
+			return spaceAccessErrorResponse(err)
+		default:
+			log.Printf("ERROR: Failed to save to Firestore: %v\n", err)
+			return map[string]interface{}{"error": "データの保存に失敗しました: " + err.Error()}, http.StatusInternalServerError
+		}
+	}
+
+	if action == "create" {
+		editor = &SpaceEditor{IsOwner: true, Scope: spaceScopeAll}
+		if principal != nil {
+			editor.UID = principal.UID
+			scheduleDoc.OwnerUID = principal.UID
+			log.Printf("INFO: Associating new spaceId %s with owner UID %s\n", targetSpaceId, principal.UID)
 		} else {
+			key, hash, err := newSpaceEditKey()
+			if err != nil {
+				log.Printf("ERROR: %v\n", err)
+				return map[string]interface{}{"error": "データの保存に失敗しました: " + err.Error()}, http.StatusInternalServerError
+			}
+			editKey = key
+			scheduleDoc.EditKeyHash = hash
 			log.Printf("INFO: Creating new spaceId %s for anonymous user.\n", targetSpaceId)
 		}
-	}
+		switch {
+		case postData.TimeZone != nil:
+			scheduleDoc.TimeZone = *postData.TimeZone
+		case principal != nil:
+			scheduleDoc.TimeZone = userTimeZone(ctx, principal.UID).String()
+		default:
+			scheduleDoc.TimeZone = getDefaultTimeZone()
+		}
+		scheduleDoc.Revision = 1
+		scheduleDoc.CreatedAt = time.Now()
+		touchSchedule(scheduleDoc, scheduleDoc.CreatedAt)
+		revision = scheduleDoc.Revision
+		changes = diffSchedule(&ScheduleDocument{}, scheduleDoc)
+		claimSpaceUsername(scheduleDoc, editor, changes)
+		if principal != nil {
+			scheduleDoc.MemberUIDs = []string{principal.UID}
+		}
 
-	if err := saveScheduleToFirestore(ctx, targetSpaceId, scheduleDoc); err != nil {
-		log.Printf("ERROR: Failed to save to Firestore: %v\n", err)
-		return map[string]interface{}{"error": "データの保存に失敗しました: " + err.Error()}, http.StatusInternalServerError
+		if err := saveSchedule(ctx, targetSpaceId, scheduleDoc); err != nil {
+			log.Printf("ERROR: Failed to save to Firestore: %v\n", err)
+			return map[string]interface{}{"error": "データの保存に失敗しました: " + err.Error()}, http.StatusInternalServerError
+		}
 	}
 
+	recordScheduleAudit(ctx, targetSpaceId, editor, action, changes, nil)
+
+	var response map[string]interface{}
 	if isUpdate {
 		log.Printf("INFO: Data successfully updated in Firestore. Document ID: %s\n", targetSpaceId)
-		return map[string]interface{}{
+		response = map[string]interface{}{
 			"message":   "データは正常に更新され、Firestoreに保存されました。",
 			"spaceId":   targetSpaceId,
-		}, http.StatusOK
+		}
 	} else {
 		log.Printf("INFO: Data successfully saved to Firestore. Document ID: %s\n", targetSpaceId)
-		return map[string]interface{}{
+		response = map[string]interface{}{
 			"message":   "データは正常に受信され、Firestoreに保存されました。",
 			"spaceId":   targetSpaceId,
-		}, http.StatusOK
+		}
+	}
+	response["revision"] = revision
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	if editKey != "" {
+		// 編集キーはこのレスポンスでのみ返す。以降の更新では X-Space-Edit-Key ヘッダーで送信する
+		response["editKey"] = editKey
 	}
+	return response, http.StatusOK
 }
\ No newline at end of file
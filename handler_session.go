@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// RefreshRequest はトークン更新リクエストの構造体です
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// processRefreshRequest はリフレッシュトークンを検証し、新しいトークンの組を発行します
+func processRefreshRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var refreshData RefreshRequest
+	if err := req.DecodeJSON(&refreshData); err != nil {
+		log.Printf("WARN: Failed to parse refresh JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if refreshData.RefreshToken == "" {
+		return map[string]interface{}{"error": "リフレッシュトークンが指定されていません"}, http.StatusBadRequest
+	}
+
+	tokens, err := refreshSession(ctx, refreshData.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenReused),
+			errors.Is(err, ErrSessionRevoked), errors.Is(err, ErrSessionExpired):
+			log.Printf("WARN: Refresh rejected: %v", err)
+			return map[string]interface{}{"error": "セッションの有効期限が切れました。再度ログインしてください"}, http.StatusUnauthorized
+		default:
+			log.Printf("ERROR: Failed to refresh session: %v", err)
+			return map[string]interface{}{"error": "セッションの更新に失敗しました"}, http.StatusInternalServerError
+		}
+	}
+
+	return map[string]interface{}{
+		"message":      "セッションを更新しました",
+		"sessionToken": tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	}, http.StatusOK
+}
+
+// processLogoutRequest は現在のセッションを失効させます
+func processLogoutRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	if principal.SessionID == "" {
+		// IDトークンで認証された場合はサーバー側のセッションが存在しない
+		return map[string]interface{}{"message": "ログアウトしました"}, http.StatusOK
+	}
+
+	if err := revokeSessionByID(ctx, principal.SessionID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("ERROR: Failed to revoke session %s: %v", principal.SessionID, err)
+		return map[string]interface{}{"error": "ログアウトに失敗しました"}, http.StatusInternalServerError
+	}
+
+	return map[string]interface{}{"message": "ログアウトしました"}, http.StatusOK
+}
+
+// processListSessionsRequest はユーザーの有効なセッションを新しい順に一覧します
+func processListSessionsRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	sessions, err := dataStore.ListSessionsForUser(ctx, principal.UID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list sessions for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "セッション一覧の取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	now := time.Now()
+	result := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.IsActive(now) {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"id":         session.ID,
+			"userAgent":  session.UserAgent,
+			"createdAt":  session.CreatedAt,
+			"lastUsedAt": session.LastUsedAt,
+			"expiresAt":  session.ExpiresAt,
+			"current":    session.ID == principal.SessionID,
+		})
+	}
+
+	return map[string]interface{}{"sessions": result}, http.StatusOK
+}
+
+// processRevokeSessionRequest は指定したセッションを失効させます（他の端末からのログアウト）
+func processRevokeSessionRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	sessionID := req.PathParam("sessionId")
+
+	session, err := dataStore.GetSession(ctx, sessionID)
+	if errors.Is(err, ErrNotFound) || (err == nil && session.UID != principal.UID) {
+		// 他のユーザーのセッションの存在は明かさない
+		return map[string]interface{}{"error": "セッションが見つかりません"}, http.StatusNotFound
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to get session %s: %v", sessionID, err)
+		return map[string]interface{}{"error": "セッションの取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	if err := revokeSession(ctx, session); err != nil {
+		log.Printf("ERROR: Failed to revoke session %s: %v", sessionID, err)
+		return map[string]interface{}{"error": "セッションの失効に失敗しました"}, http.StatusInternalServerError
+	}
+
+	return map[string]interface{}{"message": "セッションを失効させました", "sessionId": sessionID}, http.StatusOK
+}
+
+// processRevokeOtherSessionsRequest は現在のセッション以外のすべてのセッションを失効させます
+func processRevokeOtherSessionsRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	revokedCount, err := revokeOtherSessions(ctx, principal.UID, principal.SessionID)
+	if err != nil {
+		log.Printf("ERROR: Failed to revoke other sessions for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "セッションの失効に失敗しました"}, http.StatusInternalServerError
+	}
+
+	return map[string]interface{}{"message": "他のセッションを失効させました", "revoked": revokedCount}, http.StatusOK
+}
+
+// processJWKSRequest はセッショントークンの検証に使用する公開鍵（JWKS）を返します
+// ESP32などのクライアントや他のサービスは共有鍵なしでトークンを検証できます
+func processJWKSRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	return sessionKeySet.JWKS(), http.StatusOK
+}
+
+// processPasswordPublicKeyRequest はパスワードの暗号化に使用するサーバーの公開鍵を返します
+func processPasswordPublicKeyRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	return passwordTransport.PublicKey(), http.StatusOK
+}
@@ -2,21 +2,18 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
-
-	"firebase.google.com/go/v4/auth"
-	"github.com/aws/aws-lambda-go/events"
 )
 
 // SignupRequest はサインアップリクエストの構造体です
 type SignupRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// PasswordEnvelope は /api/auth/public-key の鍵で暗号化されたパスワードです
+	PasswordEnvelope *PasswordEnvelope `json:"passwordEnvelope,omitempty"`
 }
 
 // SignupResponse はサインアップレスポンスの構造体です
@@ -26,30 +23,11 @@ type SignupResponse struct {
 	Error   string `json:"error,omitempty"`
 }
 
-func processSignupRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processSignupRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var signupData SignupRequest
-	if err := json.Unmarshal(bodyBytes, &signupData); err != nil {
-		log.Printf("WARN: Failed to parse signup JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&signupData); err != nil {
+		log.Printf("WARN: Failed to parse signup JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -60,20 +38,32 @@ func processSignupRequest(ctx context.Context, req interface{}) (map[string]inte
 	if signupData.Email == "" {
 		return map[string]interface{}{"error": "メールアドレスが入力されていません"}, http.StatusBadRequest
 	}
-	if signupData.Password == "" {
+	if signupData.Password == "" && signupData.PasswordEnvelope == nil {
 		return map[string]interface{}{"error": "パスワードが入力されていません"}, http.StatusBadRequest
 	}
 
 	// クライアントから送られてきた暗号化されたパスワードを復号化
-	// まず簡易暗号化方式を試す
-	decryptedPassword, err := decryptPassword(signupData.Password)
-	if err != nil {
-		log.Printf("DEBUG: Simple decryption failed, trying AES-CBC: %v\n", err)
-		// 簡易暗号化が失敗した場合、AES-CBC暗号化を試す
-		decryptedPassword, err = DecryptPassword(signupData.Password)
+	var decryptedPassword string
+	var err error
+	if signupData.PasswordEnvelope != nil {
+		decryptedPassword, err = passwordTransport.Open(ctx, signupData.PasswordEnvelope, passwordPurposeSignup, signupData.Email)
+		if err != nil {
+			log.Printf("WARN: Failed to open password envelope: %v\n", err)
+			return passwordEnvelopeErrorResponse(err)
+		}
+	} else if passwordTransport.Required() {
+		return passwordEnvelopeErrorResponse(ErrPasswordEnvelopeRequired)
+	} else {
+		// 従来の方式：まず簡易暗号化方式を試す
+		decryptedPassword, err = decryptPassword(signupData.Password)
 		if err != nil {
-			log.Printf("ERROR: Both decryption methods failed: %v\n", err)
-			return map[string]interface{}{"error": "パスワードの復号化に失敗しました"}, http.StatusBadRequest
+			log.Printf("DEBUG: Simple decryption failed, trying AES-CBC: %v\n", err)
+			// 簡易暗号化が失敗した場合、AES-CBC暗号化を試す
+			decryptedPassword, err = DecryptPassword(signupData.Password)
+			if err != nil {
+				log.Printf("ERROR: Both decryption methods failed: %v\n", err)
+				return map[string]interface{}{"error": "パスワードの復号化に失敗しました"}, http.StatusBadRequest
+			}
 		}
 	}
 
@@ -90,27 +80,37 @@ func processSignupRequest(ctx context.Context, req interface{}) (map[string]inte
 		return map[string]interface{}{"error": "メールアドレスの形式が不正です"}, http.StatusBadRequest
 	}
 
-	// 復号化されたパスワードで強度チェック
+	// 復号化されたパスワードを正規化して強度チェック（ログイン時も同じ正規化を行う）
+	decryptedPassword = normalizePassword(decryptedPassword)
 	log.Printf("DEBUG: Checking password strength for password length: %d", len(decryptedPassword))
-	passwordStrength := checkPasswordStrength(decryptedPassword)
+	lang := preferredLanguage(req)
+	passwordStrength := checkPasswordStrength(decryptedPassword, cleanEmail, lang)
 	if !passwordStrength.IsValid {
-		log.Printf("DEBUG: Password strength check failed: %v", passwordStrength.Errors)
-		errorMessage := "パスワードの強度が不足しています: " + strings.Join(passwordStrength.Errors, ", ")
-		return map[string]interface{}{"error": errorMessage}, http.StatusBadRequest
+		codes := make([]string, 0, len(passwordStrength.Violations))
+		messages := make([]string, 0, len(passwordStrength.Violations))
+		for _, violation := range passwordStrength.Violations {
+			codes = append(codes, violation.Code)
+			messages = append(messages, violation.Message)
+		}
+		log.Printf("DEBUG: Password strength check failed: %v", codes)
+		return map[string]interface{}{
+			"error":      passwordPolicyMessage(lang, passwordErrWeak, nil) + ": " + strings.Join(messages, ", "),
+			"code":       passwordErrWeak,
+			"violations": passwordStrength.Violations,
+		}, http.StatusBadRequest
 	}
 	log.Printf("DEBUG: Password strength check passed")
 
-	// Firebase Authenticationでユーザーを作成
-	params := (&auth.UserToCreate{}).
-		Email(cleanEmail).
-		Password(decryptedPassword).
-		EmailVerified(false)
-
-	userRecord, err := authClient.CreateUser(ctx, params)
+	// 認証プロバイダーでユーザーを作成
+	userRecord, err := identityProvider.CreateUser(ctx, &IdentityUserToCreate{
+		Email:         cleanEmail,
+		Password:      decryptedPassword,
+		EmailVerified: false,
+	})
 	if err != nil {
-		log.Printf("ERROR: Failed to create user in Firebase Auth for email=%s: %v\n", cleanEmail, err)
-		// Firebaseからのエラーコードに基づいて、より親切なメッセージを返す
-		if auth.IsEmailAlreadyExists(err) {
+		log.Printf("ERROR: Failed to create user for email=%s: %v\n", cleanEmail, err)
+		// 認証プロバイダーからのエラーに基づいて、より親切なメッセージを返す
+		if errors.Is(err, ErrEmailAlreadyExists) {
 			return map[string]interface{}{"error": "このメールアドレスは既に使用されています。"}, http.StatusConflict
 		}
 		return map[string]interface{}{"error": "ユーザーの作成に失敗しました。"}, http.StatusInternalServerError
@@ -203,18 +203,3 @@ func processSignupRequest(ctx context.Context, req interface{}) (map[string]inte
 		"uid":     userRecord.UID,
 	}, http.StatusCreated
 }
-
-// markUserAsVerified はユーザーをメール認証済みとしてマークします
-func markUserAsVerified(ctx context.Context, uid string) error {
-	// Firebase Authでユーザーのメール認証状態を更新
-	params := (&auth.UserToUpdate{}).
-		EmailVerified(true)
-	
-	_, err := authClient.UpdateUser(ctx, uid, params)
-	if err != nil {
-		return fmt.Errorf("ユーザーの認証状態の更新に失敗しました: %v", err)
-	}
-	
-	log.Printf("INFO: User %s marked as email verified", uid)
-	return nil
-}
\ No newline at end of file
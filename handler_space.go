@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpaceParticipantScopeRequest は参加者の編集スコープ変更リクエストの構造体です
+type SpaceParticipantScopeRequest struct {
+	Scope SpaceEditScope `json:"scope"`
+}
+
+// loadSpaceForOwner はスペースを取得し、リクエストの利用者がオーナーであることを確認します
+func loadSpaceForOwner(ctx context.Context, req *Request) (*ScheduleDocument, *SpaceEditor, map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	doc, err := getSchedule(ctx, spaceId)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load spaceId %s: %v", spaceId, err)
+		return nil, nil, map[string]interface{}{"error": "データの取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	principal, _ := principalFromContext(ctx)
+	editor := resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+	if !editor.IsOwner {
+		body, status := spaceAccessErrorResponse(ErrSpaceEditForbidden)
+		return nil, nil, body, status
+	}
+	return doc, editor, nil, 0
+}
+
+// processSpaceAuditRequest はスペースの監査ログを新しい順に返します（オーナーのみ）
+func processSpaceAuditRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	_, _, errBody, status := loadSpaceForOwner(ctx, req)
+	if errBody != nil {
+		return errBody, status
+	}
+
+	limit := 100
+	if raw := req.QueryParam("limit"); raw != "" {
+		if value, err := strconv.Atoi(raw); err == nil && value > 0 && value <= 1000 {
+			limit = value
+		}
+	}
+
+	spaceId := req.PathParam("spaceId")
+	entries, err := dataStore.ListScheduleAudit(ctx, spaceId, limit)
+	if err != nil {
+		log.Printf("ERROR: Failed to list audit for spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "監査ログの取得に失敗しました"}, http.StatusInternalServerError
+	}
+	if entries == nil {
+		entries = []*ScheduleAuditEntry{}
+	}
+	return map[string]interface{}{"spaceId": spaceId, "entries": entries}, http.StatusOK
+}
+
+// processSpaceParticipantScopeRequest は参加者の編集スコープを変更します（オーナーのみ）
+func processSpaceParticipantScopeRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var scopeData SpaceParticipantScopeRequest
+	if err := req.DecodeJSON(&scopeData); err != nil {
+		log.Printf("WARN: Failed to parse participant scope JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if !isValidSpaceEditScope(scopeData.Scope) {
+		return map[string]interface{}{"error": "scope は own・all・none のいずれかを指定してください"}, http.StatusBadRequest
+	}
+
+	spaceId := req.PathParam("spaceId")
+	username := req.PathParam("username")
+	principal, _ := principalFromContext(ctx)
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	var revision int64
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+		if !editor.IsOwner {
+			return ErrSpaceEditForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+
+		uid := spaceParticipantByUsername(doc, username)
+		if uid == "" {
+			return ErrNotFound
+		}
+		participant := doc.Participants[uid]
+		participant.Scope = scopeData.Scope
+		doc.Participants[uid] = participant
+		touchSchedule(doc, time.Now())
+		doc.Revision++
+		revision = doc.Revision
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound) && editor == nil:
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"error": "指定されたユーザー名の参加者が見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrSpaceEditForbidden):
+		return spaceAccessErrorResponse(err)
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case err != nil:
+		log.Printf("ERROR: Failed to save participant scope for spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+
+	recordScheduleAudit(ctx, spaceId, editor, "participant_scope", &ScheduleChanges{Usernames: []string{username}}, nil)
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{
+		"message":  "参加者の編集権限を変更しました",
+		"username": username,
+		"scope":    scopeData.Scope,
+		"revision": revision,
+	}, http.StatusOK
+}
+
+// スペースの引き継ぎで返されるエラーです
+var (
+	// ErrSpaceAlreadyOwned はオーナーが設定済みのスペースを引き継ごうとした場合に返されます
+	ErrSpaceAlreadyOwned = errors.New("space already has an owner")
+	// ErrSpaceClaimForbidden は参加者として登録されていないユーザーが引き継ごうとした場合に返されます
+	ErrSpaceClaimForbidden = errors.New("only a participant can claim the space")
+)
+
+// processSpaceClaimRequest は POST /api/time/{spaceId}/claim を処理します（ログイン必須）
+//
+// 編集キーの導入前に非ログインで作成され、オーナーの認証情報がないスペースを、ログインユーザーのスペースとして引き継ぎます
+// 引き継ぎは明示的なこの操作でのみ行い、監査ログに記録します。オーナーが設定済みのスペースは引き継げません
+// spaceId を知っているだけのユーザーが乗っ取れないよう、ログインして予定を登録した参加者のみ引き継げます
+func processSpaceClaimRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	var revision int64
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, "")
+		if doc.OwnerUID != "" || doc.EditKeyHash != "" {
+			return ErrSpaceAlreadyOwned
+		}
+		if _, ok := doc.Participants[principal.UID]; !ok {
+			return ErrSpaceClaimForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+		if doc.Archived {
+			return ErrSpaceArchived
+		}
+
+		doc.OwnerUID = principal.UID
+		editor.IsOwner = true
+		editor.Scope = spaceScopeAll
+		members := map[string]bool{principal.UID: true}
+		for _, uid := range doc.MemberUIDs {
+			members[uid] = true
+		}
+		doc.MemberUIDs = sortedSetKeys(members)
+		touchSchedule(doc, time.Now())
+		doc.Revision++
+		revision = doc.Revision
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrSpaceAlreadyOwned):
+		return map[string]interface{}{"error": "このスペースにはオーナーが設定されています", "code": "space_already_owned"}, http.StatusConflict
+	case errors.Is(err, ErrSpaceClaimForbidden):
+		return map[string]interface{}{"error": "スペースを引き継げるのは予定を登録した参加者のみです", "code": "space_claim_forbidden"}, http.StatusForbidden
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case errors.Is(err, ErrSpaceArchived):
+		return spaceAccessErrorResponse(err)
+	case err != nil:
+		log.Printf("ERROR: Failed to claim spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+
+	log.Printf("INFO: spaceId %s claimed by UID %s", spaceId, principal.UID)
+	recordScheduleAudit(ctx, spaceId, editor, "claim", &ScheduleChanges{Settings: []string{"owner"}}, nil)
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{
+		"message":  "スペースを引き継ぎました",
+		"spaceId":  spaceId,
+		"revision": revision,
+	}, http.StatusOK
+}
+
+// SpaceEntriesRequest は参加者ごとの予定の更新リクエストの構造体です
+type SpaceEntriesRequest struct {
+	// Username は参加者に "me" を指定し、まだユーザー名を登録していない場合に使用します
+	Username string `json:"username,omitempty"`
+	// Entries は日付（YYYY-MM-DD）ごとの予定です。各予定の username は無視されます
+	Entries map[string][]TimeEntry `json:"entries"`
+	// TimeZone は entries の日付と時刻のタイムゾーンです。省略した場合はスペースのタイムゾーンです
+	// レスポンスの entries も同じタイムゾーンで返します
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// processSpaceEntriesRequest は1人の参加者の予定のみを追加・変更・削除します
+//
+//   - PUT:    参加者の予定をすべて置き換えます
+//   - PATCH:  entries に含まれる日付の予定のみ置き換えます
+//   - DELETE: 参加者の予定をすべて削除します
+//
+// 参加者はパスのユーザー名、またはログインユーザー自身を表す "me" で指定します
+// 読み込みから保存までをトランザクションで行うため、同時に更新した他の参加者の予定は失われません
+func processSpaceEntriesRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	target := req.PathParam("participant")
+
+	var entriesData SpaceEntriesRequest
+	mode := entriesModeReplace
+	switch req.Method {
+	case http.MethodDelete:
+	case http.MethodPatch:
+		mode = entriesModeMerge
+		fallthrough
+	default:
+		if err := req.DecodeJSON(&entriesData); err != nil {
+			log.Printf("WARN: Failed to parse entries JSON: %v", err)
+			return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+		}
+	}
+	if err := validateSpaceEntries(&entriesData); err != nil {
+		return validationErrorResponse(err)
+	}
+	var entriesZone *time.Location
+	if entriesData.TimeZone != "" {
+		entriesZone, _ = loadTimeZone(entriesData.TimeZone)
+	}
+
+	principal, _ := principalFromContext(ctx)
+	if target == "me" && principal == nil {
+		return map[string]interface{}{"error": "認証が必要です"}, http.StatusUnauthorized
+	}
+
+	// 共有リンクのパスワードの検証は時間がかかるため、トランザクションの前に行う
+	shareLink, err := loadShareLinkForRequest(ctx, spaceId, req)
+	if isSpaceAccessError(err) {
+		log.Printf("WARN: Share link for spaceId %s rejected: %v", spaceId, err)
+		return spaceAccessErrorResponse(err)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	var editor *SpaceEditor
+	var changes *ScheduleChanges
+	var username string
+	var updatedEntries map[string][]TimeEntry
+	var conflict *ScheduleDocument
+	var revision int64
+	var validationErrs ValidationErrors
+	err = updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		var err error
+		editor, err = resolveRequestEditor(doc, principal, req, shareLink)
+		if err != nil {
+			return err
+		}
+		if err := authorizeSpaceRead(doc, editor); err != nil {
+			return err
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+		if err := checkScheduleWritable(doc); err != nil {
+			return err
+		}
+
+		username = target
+		if target == "me" {
+			username = editor.Username
+			if username == "" {
+				username = entriesData.Username
+			}
+			if username == "" {
+				return ErrSpaceUsernameRequired
+			}
+		}
+
+		// 参加者のタイムゾーンで送信された予定は、スペースのタイムゾーンに変換して保存する
+		spaceZone, err := loadTimeZone(doc.TimeZone)
+		if err != nil {
+			return err
+		}
+		submitted := entriesData.Entries
+		if entriesZone != nil {
+			submitted = convertScheduleEvents(submitted, entriesZone, spaceZone)
+		}
+		if err := validateEntriesInSpaceRange(doc, submitted); err != nil {
+			return err
+		}
+
+		updated := *doc
+		updated.Events = applyParticipantEntries(doc.Events, username, submitted, mode)
+		changes = diffSchedule(doc, &updated)
+		if err := authorizeScheduleUpdate(doc, editor, changes); err != nil {
+			return err
+		}
+
+		doc.Events = updated.Events
+		claimSpaceUsername(doc, editor, changes)
+		recordSpaceMember(doc, editor, changes)
+		touchSchedule(doc, time.Now())
+		doc.Revision++
+		revision = doc.Revision
+		updatedEntries = participantEntries(doc.Events, username)
+		if entriesZone != nil {
+			updatedEntries = convertScheduleEvents(updatedEntries, spaceZone, entriesZone)
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.As(err, &validationErrs):
+		return validationErrorResponse(validationErrs)
+	case errors.Is(err, ErrSpaceUsernameRequired):
+		return map[string]interface{}{"error": "ユーザー名が指定されていません"}, http.StatusBadRequest
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case isSpaceAccessError(err):
+		log.Printf("WARN: Entries update of spaceId %s denied for %s (%s): %v", spaceId, editor.role(), editor.UID, err)
+		recordScheduleAudit(ctx, spaceId, editor, "denied", changes, err)
+		return spaceAccessErrorResponse(err)
+	case err != nil:
+		log.Printf("ERROR: Failed to update entries of spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+
+	if !changes.IsEmpty() {
+		recordScheduleAudit(ctx, spaceId, editor, "update", changes, nil)
+	}
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{
+		"message":  "参加者の予定を更新しました",
+		"spaceId":  spaceId,
+		"username": username,
+		"entries":  updatedEntries,
+		"revision": revision,
+	}, http.StatusOK
+}
+
+// parseAvailabilityOptions は空き状況の集計条件をクエリパラメータ（slot・minDuration・required・limit）から取得します
+func parseAvailabilityOptions(req *Request) (AvailabilityOptions, error) {
+	opts := AvailabilityOptions{SlotMinutes: availabilityDefaultSlotMinutes, Limit: availabilityDefaultLimit}
+	intParam := func(name string, value *int, min, max int) error {
+		raw := req.QueryParam(name)
+		if raw == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("%s は %d から %d の整数で指定してください", name, min, max)
+		}
+		*value = n
+		return nil
+	}
+
+	if err := intParam("slot", &opts.SlotMinutes, availabilityMinSlotMinutes, availabilityMaxSlotMinutes); err != nil {
+		return opts, err
+	}
+	if 24*60%opts.SlotMinutes != 0 {
+		return opts, errors.New("slot は1日（1440分）を割り切れる分数で指定してください")
+	}
+	if err := intParam("minDuration", &opts.MinDuration, 0, 24*60); err != nil {
+		return opts, err
+	}
+	if err := intParam("limit", &opts.Limit, 1, availabilityMaxLimit); err != nil {
+		return opts, err
+	}
+	for _, name := range strings.Split(req.QueryParam("required"), ",") {
+		if name = strings.TrimSpace(name); name != "" && !containsString(opts.Required, name) {
+			opts.Required = append(opts.Required, name)
+		}
+	}
+	return opts, nil
+}
+
+// processSpaceAvailabilityRequest は GET /api/time/{spaceId}/availability を処理します
+// 日付・時間枠ごとに参加できる参加者と、参加者の多い順に並べた候補の時間帯を返します
+// tz を指定した場合は、予定をそのタイムゾーンに変換してから集計します
+func processSpaceAvailabilityRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	opts, err := parseAvailabilityOptions(req)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}, http.StatusBadRequest
+	}
+	var viewer *time.Location
+	if tz := req.QueryParam("tz"); tz != "" {
+		if viewer, err = loadTimeZone(tz); err != nil {
+			return map[string]interface{}{"error": err.Error()}, http.StatusBadRequest
+		}
+	}
+
+	doc, err := getSchedule(ctx, spaceId)
+	if errors.Is(err, ErrNotFound) {
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの取得に失敗しました"}, http.StatusInternalServerError
+	}
+	if err := authorizeSpaceReadRequest(ctx, spaceId, doc, req); err != nil {
+		return spaceAccessErrorResponse(err)
+	}
+
+	spaceZone, err := loadTimeZone(doc.TimeZone)
+	if err != nil {
+		log.Printf("WARN: Invalid time zone of spaceId %s: %v", spaceId, err)
+		spaceZone, _ = loadTimeZone("")
+	}
+	zone := spaceZone
+	events := doc.Events
+	if viewer != nil {
+		zone = viewer
+		events = convertScheduleEvents(doc.Events, spaceZone, viewer)
+	}
+
+	availability := aggregateAvailability(events, opts)
+	req.SetResponseHeader("ETag", revisionETag(doc.Revision))
+	return map[string]interface{}{
+		"spaceId":      spaceId,
+		"timeZone":     zone.String(),
+		"slotMinutes":  availability.SlotMinutes,
+		"participants": availability.Participants,
+		"dates":        availability.Dates,
+		"bestSlots":    availability.BestSlots,
+		"revision":     doc.Revision,
+	}, http.StatusOK
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SpaceDecision はオーナーが確定したスペースの日時です。日付と時刻はスペースのタイムゾーンです
+type SpaceDecision struct {
+	Date        string    `json:"date" firestore:"date"`
+	Start       string    `json:"start" firestore:"start"`
+	End         string    `json:"end,omitempty" firestore:"end,omitempty"`
+	Title       string    `json:"title" firestore:"title"`
+	Description string    `json:"description,omitempty" firestore:"description,omitempty"`
+	DecidedAt   time.Time `json:"decidedAt" firestore:"decidedAt"`
+}
+
+// sameSlot は確定した日時が同じかを返します
+func (d *SpaceDecision) sameSlot(other *SpaceDecision) bool {
+	return d.Date == other.Date && d.Start == other.Start && d.End == other.End
+}
+
+// SpaceDecisionRequest は日時の確定リクエストの構造体です
+type SpaceDecisionRequest struct {
+	Date        string `json:"date"`
+	Start       string `json:"start"`
+	End         string `json:"end,omitempty"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// spaceDecisionRecipients はタスクを作成するログイン済みの参加者（オーナーを含む）のUIDを返します
+func spaceDecisionRecipients(doc *ScheduleDocument) []string {
+	recipients := make(map[string]bool, len(doc.Participants)+1)
+	if doc.OwnerUID != "" {
+		recipients[doc.OwnerUID] = true
+	}
+	for uid := range doc.Participants {
+		recipients[uid] = true
+	}
+	return sortedSetKeys(recipients)
+}
+
+// findSpaceTask はスペースから作成したタスクの日付と位置を返します。見つからない場合は -1 です
+func findSpaceTask(doc *TaskDocument, spaceId string) (string, int) {
+	for date, tasks := range doc.Events {
+		for i := range tasks {
+			if tasks[i].SpaceID == spaceId {
+				return date, i
+			}
+		}
+	}
+	return "", -1
+}
+
+// deliverSpaceDecision は確定した日時を参加者のタスクに追加します
+// 日時は参加者のタイムゾーンに変換し、日付をまたぐ場合はその日の終わり（24:00）までとします
+// 同じスペースから作成したタスクが確定した日付の月に既にある場合は変更しません（参加者が編集した内容を保つため）
+func deliverSpaceDecision(ctx context.Context, spaceId, uid string, decision *SpaceDecision, spaceZone *time.Location) error {
+	date, start, end := convertClockRange(decision.Date, decision.Start, decision.End, spaceZone, userTimeZone(ctx, uid))
+	if startMinutes, _ := clockMinutes(start); end != "" {
+		if endMinutes, ok := clockMinutes(end); ok && endMinutes <= startMinutes {
+			end = clockEndOfDay
+		}
+	}
+	task := TaskSlot{
+		Title:       decision.Title,
+		Description: decision.Description,
+		Start:       start,
+		End:         end,
+		SpaceID:     spaceId,
+	}
+
+	// 作成済みのタスクは確定した日付の月から探す
+	_, _, _, err := updateTaskItem(ctx, uid, "", "", TaskDocumentScope{Dates: []string{date}}, func(doc *TaskDocument) (TaskOperation, error) {
+		if current, index := findSpaceTask(doc, spaceId); index >= 0 {
+			existing := doc.Events[current][index]
+			return TaskOperation{Op: taskOpUpdate, Target: taskTargetTask, ID: existing.ID, Date: current, Task: &existing}, nil
+		}
+		task.Order = len(doc.Events[date])
+		return TaskOperation{Op: taskOpCreate, Target: taskTargetTask, ID: newDocumentID(), Date: date, Task: &task}, nil
+	})
+	return err
+}
+
+// processSpaceDecisionRequest は POST /api/time/{spaceId}/decision を処理します（オーナーのみ）
+//
+// 日時を確定してスペースを確定済みにし、ログイン済みの参加者全員のタスクに予定を追加します
+// 確定済みのスペースの予定は POST /api/time・エントリーの更新で変更できなくなります
+// 同じ日時で再度確定した場合は、タスクの作成に失敗した参加者への追加のみ行います
+func processSpaceDecisionRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var decisionData SpaceDecisionRequest
+	if err := req.DecodeJSON(&decisionData); err != nil {
+		log.Printf("WARN: Failed to parse space decision JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if err := validateSpaceDecisionRequest(&decisionData); err != nil {
+		return validationErrorResponse(err)
+	}
+
+	spaceId := req.PathParam("spaceId")
+	principal, _ := principalFromContext(ctx)
+	decision := &SpaceDecision{
+		Date:        decisionData.Date,
+		Start:       decisionData.Start,
+		End:         decisionData.End,
+		Title:       decisionData.Title,
+		Description: decisionData.Description,
+		DecidedAt:   time.Now(),
+	}
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	var decided ScheduleDocument
+	var validationErrs ValidationErrors
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+		if !editor.IsOwner {
+			return ErrSpaceEditForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+		if doc.Archived {
+			return ErrSpaceArchived
+		}
+		if doc.Decision != nil {
+			if !doc.Decision.sameSlot(decision) {
+				return ErrSpaceDecided
+			}
+			decided = *doc
+			return nil
+		}
+		if err := validateDecisionInSpaceRange(doc, decision.Date); err != nil {
+			return err
+		}
+
+		doc.Decision = decision
+		touchSchedule(doc, decision.DecidedAt)
+		doc.Revision++
+		decided = *doc
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.As(err, &validationErrs):
+		return validationErrorResponse(validationErrs)
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case errors.Is(err, ErrSpaceEditForbidden), errors.Is(err, ErrSpaceDecided), errors.Is(err, ErrSpaceArchived):
+		log.Printf("WARN: Decision of spaceId %s denied for %s (%s): %v", spaceId, editor.role(), editor.UID, err)
+		return spaceAccessErrorResponse(err)
+	case err != nil:
+		log.Printf("ERROR: Failed to decide spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+	recordScheduleAudit(ctx, spaceId, editor, "decide", &ScheduleChanges{Settings: []string{"decision"}}, nil)
+
+	spaceZone, err := loadTimeZone(decided.TimeZone)
+	if err != nil {
+		log.Printf("WARN: Invalid time zone of spaceId %s: %v", spaceId, err)
+		spaceZone, _ = loadTimeZone("")
+	}
+	delivered, failed := 0, []string{}
+	for _, uid := range spaceDecisionRecipients(&decided) {
+		if err := deliverSpaceDecision(ctx, spaceId, uid, decided.Decision, spaceZone); err != nil {
+			log.Printf("ERROR: Failed to add decided task of spaceId %s for UID %s: %v", spaceId, uid, err)
+			// UIDは公開しないため、参加者はユーザー名で返す
+			if participant, ok := decided.Participants[uid]; ok {
+				failed = append(failed, participant.Username)
+			} else {
+				failed = append(failed, "owner")
+			}
+			continue
+		}
+		delivered++
+	}
+	sort.Strings(failed)
+
+	log.Printf("INFO: spaceId %s decided on %s %s (%d tasks added, %d failed)", spaceId, decided.Decision.Date, decided.Decision.Start, delivered, len(failed))
+	req.SetResponseHeader("ETag", revisionETag(decided.Revision))
+	return map[string]interface{}{
+		"message":   "日程を確定しました",
+		"spaceId":   spaceId,
+		"decision":  decided.Decision,
+		"delivered": delivered,
+		"failed":    failed,
+		"revision":  decided.Revision,
+	}, http.StatusOK
+}
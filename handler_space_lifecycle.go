@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SpaceArchiveRequest はスペースのアーカイブ・アーカイブ解除リクエストの構造体です
+type SpaceArchiveRequest struct {
+	Archived *bool `json:"archived"`
+}
+
+// processSpaceDeleteRequest は DELETE /api/time/{spaceId} を処理します（オーナーのみ）
+// スペースと監査ログを削除します。参加者のタスクに追加した確定済みの予定は削除しません
+// オーナーと If-Match の確認から削除までをトランザクションで行うため、確認後の更新が失われることはありません
+func processSpaceDeleteRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	principal, _ := principalFromContext(ctx)
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	err := dataStore.DeleteSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+		if !editor.IsOwner {
+			return ErrSpaceEditForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrSpaceEditForbidden):
+		return spaceAccessErrorResponse(err)
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case err != nil:
+		log.Printf("ERROR: Failed to delete spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "スペースの削除に失敗しました"}, http.StatusInternalServerError
+	}
+	if _, err := dataStore.DeleteScheduleAudit(ctx, spaceId); err != nil {
+		log.Printf("WARN: Failed to delete audit entries of spaceId %s: %v", spaceId, err)
+	}
+
+	log.Printf("INFO: spaceId %s deleted by %s (%s)", spaceId, editor.role(), editor.UID)
+	return map[string]interface{}{"message": "スペースを削除しました", "spaceId": spaceId}, http.StatusOK
+}
+
+// processSpaceArchiveRequest は PUT /api/time/{spaceId}/archive を処理します（オーナーのみ）
+// アーカイブしたスペースは閲覧のみでき、予定の変更・日程の確定はできません
+func processSpaceArchiveRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var archiveData SpaceArchiveRequest
+	if err := req.DecodeJSON(&archiveData); err != nil {
+		log.Printf("WARN: Failed to parse space archive JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if archiveData.Archived == nil {
+		v := &validator{}
+		v.add("archived", validationRequired, "archived を指定してください")
+		return validationErrorResponse(v.err())
+	}
+	archived := *archiveData.Archived
+
+	spaceId := req.PathParam("spaceId")
+	principal, _ := principalFromContext(ctx)
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	var updated ScheduleDocument
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+		if !editor.IsOwner {
+			return ErrSpaceEditForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+
+		if doc.Archived != archived {
+			now := time.Now()
+			doc.Archived = archived
+			doc.ArchivedAt = nil
+			if archived {
+				doc.ArchivedAt = &now
+			}
+			touchSchedule(doc, now)
+			doc.Revision++
+		}
+		updated = *doc
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrSpaceEditForbidden):
+		return spaceAccessErrorResponse(err)
+	case errors.Is(err, ErrRevisionConflict):
+		return revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+	case err != nil:
+		log.Printf("ERROR: Failed to archive spaceId %s: %v", spaceId, err)
+		return map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+
+	action, message := "archive", "スペースをアーカイブしました"
+	if !archived {
+		action, message = "unarchive", "スペースのアーカイブを解除しました"
+	}
+	recordScheduleAudit(ctx, spaceId, editor, action, &ScheduleChanges{Settings: []string{"archived"}}, nil)
+	req.SetResponseHeader("ETag", revisionETag(updated.Revision))
+	return map[string]interface{}{
+		"message":    message,
+		"spaceId":    spaceId,
+		"archived":   updated.Archived,
+		"archivedAt": updated.ArchivedAt,
+		"revision":   updated.Revision,
+	}, http.StatusOK
+}
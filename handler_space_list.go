@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spaceListDefaultLimit は GET /api/spaces で1回に返すスペース数の既定値です
+	spaceListDefaultLimit = 20
+	// spaceListMaxLimit は limit に指定できるスペース数の上限です
+	spaceListMaxLimit = 100
+)
+
+// SpaceSummary は「自分のスペース」の一覧の1件です
+type SpaceSummary struct {
+	SpaceID   string  `json:"spaceId"`
+	Title     string  `json:"title,omitempty"`
+	Role      string  `json:"role"` // owner・participant
+	StartDate *string `json:"startDate,omitempty"`
+	EndDate   *string `json:"endDate,omitempty"`
+	TimeZone  string  `json:"timeZone"`
+	// Username はこのユーザーがスペースで登録したユーザー名です
+	Username         string `json:"username,omitempty"`
+	ParticipantCount int    `json:"participantCount"`
+	// LastActivityAt は最後に更新された日時です（導入前のスペースは未設定）
+	LastActivityAt *time.Time     `json:"lastActivityAt,omitempty"`
+	CreatedAt      *time.Time     `json:"createdAt,omitempty"`
+	Archived       bool           `json:"archived,omitempty"`
+	Decision       *SpaceDecision `json:"decision,omitempty"`
+}
+
+// spaceParticipantCount は予定を登録したユーザー名と、ログインして参加したユーザーの数を返します
+func spaceParticipantCount(doc *ScheduleDocument) int {
+	usernames := make(map[string]bool)
+	for username := range entriesByUsername(doc.Events) {
+		if username != "" {
+			usernames[username] = true
+		}
+	}
+	for _, participant := range doc.Participants {
+		usernames[participant.Username] = true
+	}
+	return len(usernames)
+}
+
+// newSpaceSummary は uid から見たスペースの概要を作成します
+func newSpaceSummary(spaceId, uid string, doc *ScheduleDocument) SpaceSummary {
+	summary := SpaceSummary{
+		SpaceID:          spaceId,
+		Title:            doc.Title,
+		Role:             "participant",
+		StartDate:        doc.StartDate,
+		EndDate:          doc.EndDate,
+		TimeZone:         effectiveTimeZone(doc.TimeZone),
+		Username:         doc.Participants[uid].Username,
+		ParticipantCount: spaceParticipantCount(doc),
+		Archived:         doc.Archived,
+		Decision:         doc.Decision,
+	}
+	if doc.OwnerUID == uid {
+		summary.Role = "owner"
+	}
+	if !doc.UpdatedAt.IsZero() {
+		updatedAt := doc.UpdatedAt
+		summary.LastActivityAt = &updatedAt
+	}
+	if !doc.CreatedAt.IsZero() {
+		createdAt := doc.CreatedAt
+		summary.CreatedAt = &createdAt
+	}
+	return summary
+}
+
+// encodeSpaceCursor は次のページの取得に使用するカーソルを作成します
+func encodeSpaceCursor(entry *ScheduleListEntry) string {
+	raw := entry.Doc.UpdatedAt.UTC().Format(time.RFC3339Nano) + "|" + entry.SpaceID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeSpaceCursor はカーソルを最終更新日時とspaceIdに戻します
+func decodeSpaceCursor(cursor string) (*ScheduleListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	key, spaceId, ok := strings.Cut(string(raw), "|")
+	if !ok || spaceId == "" {
+		return nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	updatedAt, err := time.Parse(time.RFC3339Nano, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleListCursor{UpdatedAt: updatedAt, SpaceID: spaceId}, nil
+}
+
+// processSpaceListRequest は GET /api/spaces を処理します
+//
+// ログインユーザーがオーナー、または予定を登録した参加者であるスペースを、最終更新日時の新しい順に返します
+//
+//   - role: owner・participant のいずれかで絞り込みます
+//   - includeArchived: true の場合はアーカイブしたスペースも含めます
+//   - limit・cursor: ページングです。続きがある場合はレスポンスの nextCursor を cursor に指定します
+//     ストレージから limit 件ずつ読み込むため、すべてのスペースを読み込むことはありません
+func processSpaceListRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	role := req.QueryParam("role")
+	if role != "" && role != "owner" && role != "participant" {
+		return map[string]interface{}{"error": "role は owner・participant のいずれかを指定してください"}, http.StatusBadRequest
+	}
+	includeArchived := req.QueryParam("includeArchived") == "true"
+	limit := spaceListDefaultLimit
+	if raw := req.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > spaceListMaxLimit {
+			return map[string]interface{}{"error": fmt.Sprintf("limit は 1 から %d の整数で指定してください", spaceListMaxLimit)}, http.StatusBadRequest
+		}
+		limit = n
+	}
+	var after *ScheduleListCursor
+	if cursor := req.QueryParam("cursor"); cursor != "" {
+		var err error
+		if after, err = decodeSpaceCursor(cursor); err != nil {
+			return map[string]interface{}{"error": "cursor が正しくありません"}, http.StatusBadRequest
+		}
+	}
+
+	// 続きがあるかを判定するため、limit より1件多く読み込む
+	entries, err := dataStore.ListSchedulesForUser(ctx, principal.UID, limit+1, after)
+	if err != nil {
+		log.Printf("ERROR: Failed to list spaces for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "スペースの取得に失敗しました"}, http.StatusInternalServerError
+	}
+	response := map[string]interface{}{}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response["nextCursor"] = encodeSpaceCursor(&entries[limit-1])
+	}
+
+	// 絞り込みは読み込んだページに対して行うため、続きがあっても limit より少ない件数を返す場合がある
+	now := time.Now()
+	summaries := make([]SpaceSummary, 0, len(entries))
+	for _, entry := range entries {
+		if isScheduleExpired(entry.Doc, now) {
+			continue
+		}
+		summary := newSpaceSummary(entry.SpaceID, principal.UID, entry.Doc)
+		if role != "" && summary.Role != role || summary.Archived && !includeArchived {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	response["spaces"] = summaries
+	return response, http.StatusOK
+}
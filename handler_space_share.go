@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SpaceShareLinkRequest は共有リンクの作成・再発行リクエストの構造体です
+type SpaceShareLinkRequest struct {
+	Capability SpaceCapability `json:"capability,omitempty"`
+	// Password を指定するとパスワード付きのリンクになります
+	// 再発行で省略した場合は現在のパスワードを維持し、空文字列を指定するとパスワードを解除します
+	Password *string `json:"password,omitempty"`
+}
+
+// SpaceShareSettingsRequest は共有リンクの要否の変更リクエストの構造体です
+type SpaceShareSettingsRequest struct {
+	LinkRequired *bool `json:"linkRequired"`
+}
+
+// spaceShareLinksResponse は共有リンクの一覧のレスポンスを作成します（トークンは含めません）
+func spaceShareLinksResponse(spaceId string, doc *ScheduleDocument) map[string]interface{} {
+	links := make([]map[string]interface{}, 0, len(doc.ShareLinks))
+	for i := range doc.ShareLinks {
+		links = append(links, doc.ShareLinks[i].toMap())
+	}
+	return map[string]interface{}{
+		"spaceId":      spaceId,
+		"linkRequired": doc.LinkRequired,
+		"links":        links,
+		"revision":     doc.Revision,
+	}
+}
+
+// hashShareLinkPassword は共有リンクのパスワードのハッシュを返します。空の場合は空文字列です
+func hashShareLinkPassword(password *string) (string, error) {
+	if password == nil || *password == "" {
+		return "", nil
+	}
+	return hashPassword(*password)
+}
+
+// updateSpaceShare はオーナーであることと If-Match を確認し、共有リンクの設定をトランザクションで更新します
+// mutate が ErrNotFound を返した場合は、指定された共有リンクが見つからないものとして扱います
+func updateSpaceShare(ctx context.Context, req *Request, mutate func(doc *ScheduleDocument, now time.Time) error) (*SpaceEditor, *ScheduleDocument, map[string]interface{}, int) {
+	spaceId := req.PathParam("spaceId")
+	principal, _ := principalFromContext(ctx)
+
+	var editor *SpaceEditor
+	var conflict *ScheduleDocument
+	var updated ScheduleDocument
+	var validationErrs ValidationErrors
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		editor = resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+		if !editor.IsOwner {
+			return ErrSpaceEditForbidden
+		}
+		if !ifMatchSatisfied(req.Header("If-Match"), doc.Revision) {
+			current := *doc
+			conflict = &current
+			return ErrRevisionConflict
+		}
+
+		now := time.Now()
+		if err := mutate(doc, now); err != nil {
+			return err
+		}
+		touchSchedule(doc, now)
+		doc.Revision++
+		updated = *doc
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, ErrNotFound) && editor == nil:
+		return nil, nil, map[string]interface{}{"message": "指定されたspaceIdのデータが見つかりません"}, http.StatusNotFound
+	case errors.Is(err, ErrNotFound):
+		return nil, nil, map[string]interface{}{"error": "指定された共有リンクが見つかりません"}, http.StatusNotFound
+	case errors.As(err, &validationErrs):
+		body, status := validationErrorResponse(validationErrs)
+		return nil, nil, body, status
+	case errors.Is(err, ErrSpaceEditForbidden):
+		body, status := spaceAccessErrorResponse(err)
+		return nil, nil, body, status
+	case errors.Is(err, ErrRevisionConflict):
+		body, status := revisionConflictResponse(req, conflict.Revision, scheduleDocumentToMap(conflict))
+		return nil, nil, body, status
+	case err != nil:
+		log.Printf("ERROR: Failed to save share links of spaceId %s: %v", spaceId, err)
+		return nil, nil, map[string]interface{}{"error": "データの保存に失敗しました"}, http.StatusInternalServerError
+	}
+	req.SetResponseHeader("ETag", revisionETag(updated.Revision))
+	return editor, &updated, nil, 0
+}
+
+// processSpaceShareLinksRequest は GET /api/time/{spaceId}/share-links を処理します（オーナーのみ）
+// 共有リンクの権限・パスワードの有無・作成日時を返します。トークンは作成・再発行時にのみ返します
+func processSpaceShareLinksRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	doc, _, errBody, status := loadSpaceForOwner(ctx, req)
+	if errBody != nil {
+		return errBody, status
+	}
+	req.SetResponseHeader("ETag", revisionETag(doc.Revision))
+	return spaceShareLinksResponse(req.PathParam("spaceId"), doc), http.StatusOK
+}
+
+// processSpaceShareLinkCreateRequest は POST /api/time/{spaceId}/share-links を処理します（オーナーのみ）
+// 閲覧（view）または編集（edit）の共有リンクを作成し、トークンを返します
+// 最初のリンクを作成すると LinkRequired を有効にし、spaceId だけでは閲覧・編集できなくなります
+func processSpaceShareLinkCreateRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var linkData SpaceShareLinkRequest
+	if err := req.DecodeJSON(&linkData); err != nil {
+		log.Printf("WARN: Failed to parse share link JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if err := validateSpaceShareLinkRequest(&linkData, true); err != nil {
+		return validationErrorResponse(err)
+	}
+	token, tokenHash, err := newSpaceShareToken()
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return map[string]interface{}{"error": "共有リンクの作成に失敗しました"}, http.StatusInternalServerError
+	}
+	// パスワードのハッシュ化は時間がかかるため、トランザクションの外で行う
+	passwordHash, err := hashShareLinkPassword(linkData.Password)
+	if err != nil {
+		log.Printf("ERROR: Failed to hash share link password: %v", err)
+		return map[string]interface{}{"error": "共有リンクの作成に失敗しました"}, http.StatusInternalServerError
+	}
+
+	link := SpaceShareLink{
+		ID:           newDocumentID(),
+		Capability:   linkData.Capability,
+		TokenHash:    tokenHash,
+		PasswordHash: passwordHash,
+	}
+	enforced := false
+	editor, updated, errBody, status := updateSpaceShare(ctx, req, func(doc *ScheduleDocument, now time.Time) error {
+		enforced = false
+		if len(doc.ShareLinks) >= spaceShareLinksMax {
+			v := &validator{}
+			v.add("links", validationTooMany, fmt.Sprintf("共有リンクは %d 件まで作成できます", spaceShareLinksMax))
+			return v.err()
+		}
+		if len(doc.ShareLinks) == 0 && !doc.LinkRequired {
+			doc.LinkRequired = true
+			enforced = true
+		}
+		link.CreatedAt = now
+		doc.ShareLinks = append(doc.ShareLinks, link)
+		return nil
+	})
+	if errBody != nil {
+		return errBody, status
+	}
+
+	spaceId := req.PathParam("spaceId")
+	changes := &ScheduleChanges{Settings: []string{"shareLinks"}}
+	if enforced {
+		changes.Settings = append(changes.Settings, "linkRequired")
+	}
+	recordScheduleAudit(ctx, spaceId, editor, "share_link_create", changes, nil)
+	return map[string]interface{}{
+		"message":      "共有リンクを作成しました",
+		"spaceId":      spaceId,
+		"link":         link.toMap(),
+		"token":        token,
+		"linkRequired": updated.LinkRequired,
+		"revision":     updated.Revision,
+	}, http.StatusCreated
+}
+
+// processSpaceShareLinkRegenerateRequest は POST /api/time/{spaceId}/share-links/{linkId}/regenerate を処理します（オーナーのみ）
+// 新しいトークンを発行し、以前のトークンは使用できなくなります。権限・パスワードも同時に変更できます
+func processSpaceShareLinkRegenerateRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var linkData SpaceShareLinkRequest
+	if len(req.Body) > 0 {
+		if err := req.DecodeJSON(&linkData); err != nil {
+			log.Printf("WARN: Failed to parse share link JSON: %v", err)
+			return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+		}
+	}
+	if err := validateSpaceShareLinkRequest(&linkData, false); err != nil {
+		return validationErrorResponse(err)
+	}
+	token, tokenHash, err := newSpaceShareToken()
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return map[string]interface{}{"error": "共有リンクの再発行に失敗しました"}, http.StatusInternalServerError
+	}
+	passwordHash, err := hashShareLinkPassword(linkData.Password)
+	if err != nil {
+		log.Printf("ERROR: Failed to hash share link password: %v", err)
+		return map[string]interface{}{"error": "共有リンクの再発行に失敗しました"}, http.StatusInternalServerError
+	}
+
+	linkId := req.PathParam("linkId")
+	var link SpaceShareLink
+	editor, updated, errBody, status := updateSpaceShare(ctx, req, func(doc *ScheduleDocument, now time.Time) error {
+		index := findShareLink(doc, linkId)
+		if index < 0 {
+			return ErrNotFound
+		}
+		current := &doc.ShareLinks[index]
+		current.TokenHash = tokenHash
+		current.RegeneratedAt = &now
+		current.FailedAttempts = 0
+		current.LockedUntil = nil
+		if linkData.Capability != "" {
+			current.Capability = linkData.Capability
+		}
+		if linkData.Password != nil {
+			current.PasswordHash = passwordHash
+		}
+		link = *current
+		return nil
+	})
+	if errBody != nil {
+		return errBody, status
+	}
+
+	spaceId := req.PathParam("spaceId")
+	recordScheduleAudit(ctx, spaceId, editor, "share_link_regenerate", &ScheduleChanges{Settings: []string{"shareLinks"}}, nil)
+	return map[string]interface{}{
+		"message":  "共有リンクを再発行しました",
+		"spaceId":  spaceId,
+		"link":     link.toMap(),
+		"token":    token,
+		"revision": updated.Revision,
+	}, http.StatusOK
+}
+
+// processSpaceShareLinkRevokeRequest は DELETE /api/time/{spaceId}/share-links/{linkId} を処理します（オーナーのみ）
+// 取り消したリンクのトークンを提示したリクエストは space_link_invalid で拒否されます
+func processSpaceShareLinkRevokeRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	linkId := req.PathParam("linkId")
+	editor, updated, errBody, status := updateSpaceShare(ctx, req, func(doc *ScheduleDocument, now time.Time) error {
+		index := findShareLink(doc, linkId)
+		if index < 0 {
+			return ErrNotFound
+		}
+		doc.ShareLinks = append(doc.ShareLinks[:index], doc.ShareLinks[index+1:]...)
+		return nil
+	})
+	if errBody != nil {
+		return errBody, status
+	}
+
+	spaceId := req.PathParam("spaceId")
+	recordScheduleAudit(ctx, spaceId, editor, "share_link_revoke", &ScheduleChanges{Settings: []string{"shareLinks"}}, nil)
+	return map[string]interface{}{
+		"message":  "共有リンクを取り消しました",
+		"spaceId":  spaceId,
+		"linkId":   linkId,
+		"revision": updated.Revision,
+	}, http.StatusOK
+}
+
+// processSpaceShareSettingsRequest は PUT /api/time/{spaceId}/share-settings を処理します（オーナーのみ）
+// linkRequired を true にすると、spaceId だけではスペースを閲覧・編集できなくなります
+func processSpaceShareSettingsRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	var settingsData SpaceShareSettingsRequest
+	if err := req.DecodeJSON(&settingsData); err != nil {
+		log.Printf("WARN: Failed to parse share settings JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if settingsData.LinkRequired == nil {
+		v := &validator{}
+		v.add("linkRequired", validationRequired, "linkRequired を指定してください")
+		return validationErrorResponse(v.err())
+	}
+
+	editor, updated, errBody, status := updateSpaceShare(ctx, req, func(doc *ScheduleDocument, now time.Time) error {
+		doc.LinkRequired = *settingsData.LinkRequired
+		return nil
+	})
+	if errBody != nil {
+		return errBody, status
+	}
+
+	spaceId := req.PathParam("spaceId")
+	recordScheduleAudit(ctx, spaceId, editor, "share_settings", &ScheduleChanges{Settings: []string{"linkRequired"}}, nil)
+	return spaceShareLinksResponse(spaceId, updated), http.StatusOK
+}
@@ -3,26 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"sort"
+	"strconv"
 	"time"
-
-	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 // TaskSlot タスクスロットの構造体
 type TaskSlot struct {
+	// ID はタスクを識別する不変のIDです（サーバーが割り当てます）
+	ID          string `json:"id,omitempty"`
 	Description string `json:"description"`
 	Start       string `json:"start"`
 	End         string `json:"end"`
 	Title       string `json:"title"`
 	UserColor   string `json:"userColor"`
 	Order       int    `json:"order"`
+	// SpaceID は日程調整スペースで確定した予定から作成したタスクの、元のスペースのIDです
+	SpaceID string `json:"spaceId,omitempty" firestore:"spaceId,omitempty"`
+	// RecurringID と OccurrenceDate は繰り返しタスクを展開した回にのみ設定されます（保存はされません）
+	RecurringID    string `json:"recurringId,omitempty" firestore:"recurringId,omitempty"`
+	OccurrenceDate string `json:"occurrenceDate,omitempty" firestore:"occurrenceDate,omitempty"`
 }
 
 // NotificationSlot 通知スロットの構造体
@@ -31,18 +35,43 @@ type NotificationSlot struct {
 	Order int    `json:"order"`
 }
 
+// TaskDocument ユーザーごとのタスク・通知ドキュメントの構造体
+type TaskDocument struct {
+	UID           string                        `json:"uid"`
+	Events        map[string][]TaskSlot         `json:"events"`
+	Notifications map[string][]NotificationSlot `json:"notifications"`
+	UpdatedAt     time.Time                     `json:"updatedAt"`
+	// Revision は保存のたびに1ずつ増えるリビジョン番号です。同期のカーソルとしても使用します
+	Revision int64 `json:"revision"`
+	// Changes はリビジョンごとの変更履歴です（古いものから taskChangeLogLimit 件まで）
+	Changes []TaskOperation `json:"changes,omitempty"`
+	// ChangeLogBase 以前のリビジョンからの差分は Changes から復元できません
+	ChangeLogBase int64 `json:"changeLogBase,omitempty"`
+	// Recurring は繰り返しタスクの定義です。GET /api/task で期間を指定すると展開されます
+	Recurring []RecurringTask `json:"recurring,omitempty"`
+}
+
 // TaskSaveRequest タスク保存リクエストの構造体
 type TaskSaveRequest struct {
 	UserUID       string                           `json:"useruid"`
 	Events        map[string][]TaskSlot            `json:"events"`
 	Notifications map[string][]NotificationSlot    `json:"notifications,omitempty"`
+	// Mode は "merge"（既定: 送信した日付のみ置き換え）または "replace"（送信しなかった日付を削除）です
+	Mode string `json:"mode,omitempty"`
+	// DeletedEvents は予定を日付ごと削除する日付です
+	DeletedEvents []string `json:"deletedEvents,omitempty"`
+	// DeletedNotifications は通知を日付ごと削除する日付です
+	DeletedNotifications []string `json:"deletedNotifications,omitempty"`
 }
 
 // TaskSaveResponse タスク保存レスポンスの構造体
 type TaskSaveResponse struct {
-	Message string `json:"message"`
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	Message  string `json:"message"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	// Errors はリクエストの検証エラーです（フィールドごと）
+	Errors ValidationErrors `json:"errors,omitempty"`
 }
 
 // TaskGetResponse タスク取得レスポンスの構造体
@@ -52,56 +81,28 @@ type TaskGetResponse struct {
 	Message       string                         `json:"message"`
 	Success       bool                           `json:"success"`
 	Error         string                         `json:"error,omitempty"`
+	Revision      int64                          `json:"revision"`
+	Recurring     []RecurringTask                `json:"recurring,omitempty"`
+	// NextFrom は期間内に残りの日付がある場合に、次のページの from に指定する日付です
+	NextFrom string `json:"nextFrom,omitempty"`
+	// TimeZone は tz を指定した場合の、events・notifications のタイムゾーンです
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
-// handleTaskSave タスク保存ハンドラー
-func handleTaskSave(w http.ResponseWriter, r *http.Request) {
-	// CORSヘッダーを設定
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-	// OPTIONSリクエストの場合は早期リターン
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	// POSTメソッドのみ許可
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 認証トークンを取得
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
-		return
-	}
-
-	// Bearerトークンを抽出
-	token := extractBearerToken(authHeader)
-	if token == "" {
-		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
-		return
-	}
-
-	// トークンを検証してUIDを取得
-	session, err := validateSessionToken(token)
-	if err != nil {
-		log.Printf("Token verification failed: %v", err)
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
+// processTaskSaveRequest タスク保存リクエストを処理します
+// 認証はルート定義で必須とされているため、UIDはコンテキストのPrincipalから取得します
+func processTaskSaveRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		return map[string]interface{}{"error": "認証が必要です"}, http.StatusUnauthorized
 	}
-	uid := session.UID
+	uid := principal.UID
 
 	// リクエストボディをパース
 	var request TaskSaveRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := req.DecodeJSON(&request); err != nil {
 		log.Printf("Failed to decode request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		return map[string]interface{}{"error": "Invalid request body"}, http.StatusBadRequest
 	}
 
 	// デバッグログを追加
@@ -110,436 +111,435 @@ func handleTaskSave(w http.ResponseWriter, r *http.Request) {
 	log.Printf("DEBUG: Request notifications: %+v", request.Notifications)
 	log.Printf("DEBUG: Request UserUID: %s", request.UserUID)
 
-	// Firestoreクライアントを取得
-	ctx := context.Background()
-	client := firestoreClient
-	if client == nil {
-		log.Printf("Firestore client is not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
 	// リクエストのUserUIDとトークンから取得したUIDが一致するかチェック
 	if request.UserUID != uid {
 		log.Printf("UserUID mismatch: request.UserUID=%s, token.UID=%s", request.UserUID, uid)
-		response := TaskSaveResponse{
+		return taskResponseToMap(TaskSaveResponse{
 			Message: "ユーザーIDが一致しません",
 			Success: false,
 			Error:   "UserUID mismatch",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		}), http.StatusOK
+	}
+
+	if err := validateTaskSaveRequest(&request); err != nil {
+		var errs ValidationErrors
+		errors.As(err, &errs)
+		return taskResponseToMap(TaskSaveResponse{
+			Message: errs[0].Message,
+			Success: false,
+			Error:   "Invalid request body",
+			Errors:  errs,
+		}), http.StatusBadRequest
 	}
 
 	// タスクデータを保存
-	if err := saveTaskDataToFirestore(ctx, client, uid, request.Events, request.Notifications); err != nil {
+	taskDoc, err := saveTaskData(ctx, uid, req.Header("If-Match"), &request)
+	if errors.Is(err, ErrRevisionConflict) {
+		log.Printf("WARN: Task revision conflict for UID %s (current revision %d)", uid, taskDoc.Revision)
+		body, status := revisionConflictResponse(req, taskDoc.Revision, map[string]interface{}{
+			"events":        taskDoc.Events,
+			"notifications": taskDoc.Notifications,
+			"revision":      taskDoc.Revision,
+		})
+		body["success"] = false
+		return body, status
+	}
+	if err != nil {
 		log.Printf("Failed to save task data: %v", err)
-		response := TaskSaveResponse{
+		return taskResponseToMap(TaskSaveResponse{
 			Message: "タスクの保存に失敗しました",
 			Success: false,
 			Error:   err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		}), http.StatusOK
 	}
 
 	// 成功レスポンス
-	response := TaskSaveResponse{
-		Message: "タスクが正常に保存されました",
-		Success: true,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	req.SetResponseHeader("ETag", revisionETag(taskDoc.Revision))
+	return taskResponseToMap(TaskSaveResponse{
+		Message:  "タスクが正常に保存されました",
+		Success:  true,
+		Revision: taskDoc.Revision,
+	}), http.StatusOK
 }
 
-// handleTaskGet タスク取得ハンドラー
-func handleTaskGet(w http.ResponseWriter, r *http.Request) {
-	log.Printf("DEBUG: handleTaskGet called - Method: %s, URL: %s", r.Method, r.URL.String())
-	
-	// CORSヘッダーを設定
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-	// OPTIONSリクエストの場合は早期リターン
-	if r.Method == "OPTIONS" {
-		log.Printf("DEBUG: OPTIONS request, returning early")
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	// GETメソッドのみ許可
-	if r.Method != "GET" {
-		log.Printf("DEBUG: Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 認証トークンを取得
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		log.Printf("DEBUG: Authorization header required")
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
-		return
+// processTaskGetRequest タスク取得リクエストを処理します
+func processTaskGetRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		return map[string]interface{}{"error": "認証が必要です"}, http.StatusUnauthorized
+	}
+	uid := principal.UID
+
+	// tz を指定した場合は、ユーザーのタイムゾーンで保存された予定・通知をそのタイムゾーンに変換して返す
+	var zones *taskTimeZones
+	if tz := req.QueryParam("tz"); tz != "" {
+		viewer, err := loadTimeZone(tz)
+		if err != nil {
+			return taskResponseToMap(TaskGetResponse{
+				Message: err.Error(),
+				Success: false,
+				Error:   "Invalid time zone",
+			}), http.StatusBadRequest
+		}
+		zones = &taskTimeZones{Stored: userTimeZone(ctx, uid), Viewer: viewer}
 	}
 
-	// Bearerトークンを抽出
-	token := extractBearerToken(authHeader)
-	if token == "" {
-		log.Printf("DEBUG: Invalid authorization header")
-		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
-		return
+	// from・to（または date）を指定した場合は、その期間の予定・通知のみを読み込み、繰り返しタスクを events に展開する
+	from, to, ranged, err := parseTaskDateRange(req)
+	if err == nil && ranged {
+		var limit int
+		if limit, err = parseTaskPageLimit(req); err == nil {
+			return processTaskRangeRequest(ctx, req, uid, from, to, limit, zones)
+		}
 	}
-
-	// トークンを検証してUIDを取得
-	session, err := validateSessionToken(token)
 	if err != nil {
-		log.Printf("Token verification failed: %v", err)
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-	uid := session.UID
-	log.Printf("DEBUG: Token validated successfully for UID: %s", uid)
-
-	// Firestoreクライアントを取得
-	ctx := context.Background()
-	client := firestoreClient
-	if client == nil {
-		log.Printf("Firestore client is not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return taskResponseToMap(TaskGetResponse{
+			Message: err.Error(),
+			Success: false,
+			Error:   "Invalid date range",
+		}), http.StatusBadRequest
 	}
 
-	// タスクデータを取得
+	// タスク・通知データを取得
 	log.Printf("DEBUG: Getting task data for UID: %s", uid)
-	events, err := getExistingTaskData(ctx, client, uid)
+	taskDoc, err := getTaskDocument(ctx, uid)
 	if err != nil {
 		log.Printf("Failed to get task data: %v", err)
-		response := TaskGetResponse{
+		return taskResponseToMap(TaskGetResponse{
 			Message: "タスクデータの取得に失敗しました",
 			Success: false,
 			Error:   err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		}), http.StatusOK
 	}
 
-	log.Printf("DEBUG: Task data retrieved successfully for UID: %s, events count: %d", uid, len(events))
-	log.Printf("DEBUG: Events data: %+v", events)
+	log.Printf("DEBUG: Task data retrieved successfully for UID: %s, events count: %d", uid, len(taskDoc.Events))
+	log.Printf("DEBUG: Notification data retrieved successfully for UID: %s, notifications count: %d", uid, len(taskDoc.Notifications))
 
-	// 通知データを取得
-	log.Printf("DEBUG: Getting notification data for UID: %s", uid)
-	notifications, err := getExistingNotificationData(ctx, client, uid)
-	if err != nil {
-		log.Printf("Failed to get notification data: %v", err)
-		response := TaskGetResponse{
-			Message: "通知データの取得に失敗しました",
-			Success: false,
-			Error:   err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+	events, notifications := taskDoc.Events, taskDoc.Notifications
+	if zones != nil {
+		events = convertTaskEvents(events, zones.Stored, zones.Viewer)
+		notifications = convertNotifications(notifications, zones.Stored, zones.Viewer)
 	}
 
-	log.Printf("DEBUG: Notification data retrieved successfully for UID: %s, notifications count: %d", uid, len(notifications))
-	log.Printf("DEBUG: Notifications data: %+v", notifications)
-
 	// 成功レスポンス
-	response := TaskGetResponse{
+	req.SetResponseHeader("ETag", revisionETag(taskDoc.Revision))
+	return taskResponseToMap(TaskGetResponse{
 		Events:        events,
 		Notifications: notifications,
 		Message:       "タスクデータを正常に取得しました",
 		Success:       true,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-	log.Printf("DEBUG: Task get response sent successfully")
+		Revision:      taskDoc.Revision,
+		Recurring:     taskDoc.Recurring,
+		TimeZone:      zones.viewerName(),
+	}), http.StatusOK
 }
 
-// saveTaskDataToFirestore Firestoreにタスクデータを保存
-func saveTaskDataToFirestore(ctx context.Context, client *firestore.Client, uid string, events map[string][]TaskSlot, notifications map[string][]NotificationSlot) error {
-	log.Printf("DEBUG: saveTaskDataToFirestore called for UID %s", uid)
-	
-	// 既存のタスクデータを取得
-	existingTasks, err := getExistingTaskData(ctx, client, uid)
-	if err != nil {
-		return fmt.Errorf("failed to get existing task data: %v", err)
+// taskTimeZones はタスクを保存したタイムゾーンと、レスポンスのタイムゾーンです
+type taskTimeZones struct {
+	Stored *time.Location
+	Viewer *time.Location
+}
+
+// viewerName はレスポンスのタイムゾーン名を返します。変換しない場合は空です
+func (z *taskTimeZones) viewerName() string {
+	if z == nil {
+		return ""
 	}
+	return z.Viewer.String()
+}
 
-	// 既存の通知データを取得
-	existingNotifications, err := getExistingNotificationData(ctx, client, uid)
+// processTaskRangeRequest は期間を指定したタスク取得リクエストを処理します
+// 予定・通知のある日付を古い順に limit 件まで返し、続きがある場合は nextFrom を返します
+// zones を指定した場合、期間はレスポンスのタイムゾーンの日付として扱います
+func processTaskRangeRequest(ctx context.Context, req *Request, uid string, from, to time.Time, limit int, zones *taskTimeZones) (map[string]interface{}, int) {
+	log.Printf("DEBUG: Getting task data for UID: %s (%s - %s)", uid, from.Format(taskDateLayout), to.Format(taskDateLayout))
+	// タイムゾーンを変換すると日付が前後にずれるため、前後1日を含めて読み込む
+	readFrom, readTo := from, to
+	if zones != nil {
+		readFrom, readTo = from.AddDate(0, 0, -1), to.AddDate(0, 0, 1)
+	}
+	taskDoc, err := getTaskRange(ctx, uid, readFrom, readTo)
 	if err != nil {
-		return fmt.Errorf("failed to get existing notification data: %v", err)
+		log.Printf("Failed to get task data: %v", err)
+		return taskResponseToMap(TaskGetResponse{
+			Message: "タスクデータの取得に失敗しました",
+			Success: false,
+			Error:   err.Error(),
+		}), http.StatusOK
 	}
 
-	// 新しいイベントデータを既存データにマージ
-	for date, tasks := range events {
-		existingTasks[date] = tasks
+	events := mergeRecurringOccurrences(taskDoc.Events, expandRecurringTasks(taskDoc.Recurring, readFrom, readTo))
+	notifications := taskDoc.Notifications
+	if zones != nil {
+		converted := filterTaskDocument(&TaskDocument{
+			Events:        convertTaskEvents(events, zones.Stored, zones.Viewer),
+			Notifications: convertNotifications(notifications, zones.Stored, zones.Viewer),
+		}, from.Format(taskDateLayout), to.Format(taskDateLayout))
+		events, notifications = converted.Events, converted.Notifications
 	}
+	events, notifications, nextFrom := paginateTaskDates(events, notifications, limit)
+	log.Printf("DEBUG: Task data retrieved successfully for UID: %s, events count: %d, notifications count: %d, next: %q",
+		uid, len(events), len(notifications), nextFrom)
 
-	// 新しい通知データを既存データにマージ（nilチェックを追加）
-	if notifications != nil && len(notifications) > 0 {
-		for date, notifs := range notifications {
-			if len(notifs) > 0 {
-				existingNotifications[date] = notifs
-			}
+	req.SetResponseHeader("ETag", revisionETag(taskDoc.Revision))
+	return taskResponseToMap(TaskGetResponse{
+		Events:        events,
+		Notifications: notifications,
+		Message:       "タスクデータを正常に取得しました",
+		Success:       true,
+		Revision:      taskDoc.Revision,
+		Recurring:     taskDoc.Recurring,
+		NextFrom:      nextFrom,
+		TimeZone:      zones.viewerName(),
+	}), http.StatusOK
+}
+
+// parseTaskDateRange はクエリパラメータ from・to（YYYY-MM-DD、両端を含む）または date（1日分）を解析します
+// いずれも指定されていない場合は ok に false を返します
+func parseTaskDateRange(req *Request) (from, to time.Time, ok bool, err error) {
+	rawFrom, rawTo := req.QueryParam("from"), req.QueryParam("to")
+	if date := req.QueryParam("date"); date != "" {
+		if rawFrom != "" || rawTo != "" {
+			return from, to, false, errors.New("date と from・to は同時に指定できません")
 		}
+		rawFrom, rawTo = date, date
 	}
-
-	log.Printf("DEBUG: Saving task data to Firestore for UID %s", uid)
-	log.Printf("DEBUG: Task data to save: %+v", existingTasks)
-	log.Printf("DEBUG: Notification data to save: %+v", existingNotifications)
-
-	// Firestoreに保存
-	docRef := client.Collection("task").Doc(uid)
-	_, err = docRef.Set(ctx, map[string]interface{}{
-		"events":        existingTasks,
-		"notifications": existingNotifications,
-		"updatedAt":     time.Now(),
-		"uid":           uid,
-	})
-	if err != nil {
-		log.Printf("ERROR: Failed to save task data to Firestore: %v", err)
-		return fmt.Errorf("failed to save to Firestore: %v", err)
+	if rawFrom == "" && rawTo == "" {
+		return from, to, false, nil
 	}
-
-	log.Printf("DEBUG: Task data saved successfully for UID %s", uid)
-	return nil
+	if from, err = time.Parse(taskDateLayout, rawFrom); err != nil {
+		return from, to, false, errors.New("from は YYYY-MM-DD 形式で指定してください")
+	}
+	if to, err = time.Parse(taskDateLayout, rawTo); err != nil {
+		return from, to, false, errors.New("to は YYYY-MM-DD 形式で指定してください")
+	}
+	if to.Before(from) {
+		return from, to, false, errors.New("to は from 以降の日付を指定してください")
+	}
+	if daysBetween(from, to) >= taskExpandMaxDays {
+		return from, to, false, fmt.Errorf("期間は %d 日以内で指定してください", taskExpandMaxDays)
+	}
+	return from, to, true, nil
 }
 
-// getExistingTaskData 既存のタスクデータを取得
-func getExistingTaskData(ctx context.Context, client *firestore.Client, uid string) (map[string][]TaskSlot, error) {
-	docRef := client.Collection("task").Doc(uid)
-	doc, err := docRef.Get(ctx)
-	if err != nil {
-		// デバッグ情報を出力
-		log.Printf("DEBUG: getExistingTaskData error for UID %s: %v", uid, err)
-		
-		// ドキュメントが存在しない場合は空のマップを返す
-		// Firestoreでは、ドキュメントが存在しない場合にNotFoundエラーが返される
-		if status.Code(err) == codes.NotFound || strings.Contains(err.Error(), "NotFound") || err == iterator.Done {
-			log.Printf("DEBUG: Document not found for UID %s, returning empty map", uid)
-			return make(map[string][]TaskSlot), nil
-		}
-		return nil, err
+// parseTaskPageLimit はクエリパラメータ limit（1ページの日付数）を解析します。未指定の場合は既定値です
+func parseTaskPageLimit(req *Request) (int, error) {
+	raw := req.QueryParam("limit")
+	if raw == "" {
+		return taskPageDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > taskPageMaxLimit {
+		return 0, fmt.Errorf("limit は 1 から %d の整数で指定してください", taskPageMaxLimit)
 	}
+	return limit, nil
+}
 
-	var data map[string]interface{}
-	if err := doc.DataTo(&data); err != nil {
-		log.Printf("DEBUG: Failed to convert document data: %v", err)
-		return nil, err
+// mergeRecurringOccurrences は保存されたタスクに繰り返しタスクの回を加えた新しいマップを返します
+// 回を加えた日付は order 順に並べ替えます
+func mergeRecurringOccurrences(events map[string][]TaskSlot, occurrences map[string][]TaskSlot) map[string][]TaskSlot {
+	if len(occurrences) == 0 {
+		return events
 	}
+	merged := make(map[string][]TaskSlot, len(events)+len(occurrences))
+	for date, tasks := range events {
+		merged[date] = tasks
+	}
+	for date, tasks := range occurrences {
+		list := append(append([]TaskSlot(nil), merged[date]...), tasks...)
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Order < list[j].Order
+		})
+		merged[date] = list
+	}
+	return merged
+}
 
-	log.Printf("DEBUG: Raw Firestore data for UID %s: %+v", uid, data)
+// taskResponseToMap はタスクAPIのレスポンス構造体をJSONのキー名のままマップに変換します
+func taskResponseToMap(response interface{}) map[string]interface{} {
+	var result map[string]interface{}
+	data, _ := json.Marshal(response)
+	json.Unmarshal(data, &result)
+	return result
+}
 
-	// eventsフィールドを取得
-	eventsData, ok := data["events"]
-	if !ok {
-		log.Printf("DEBUG: No events field found in document for UID %s", uid)
-		return make(map[string][]TaskSlot), nil
+// taskSaveScope は保存リクエストが変更する月を返します
+// 置き換えモードはすべての月、マージモードは送信した日付の月と、IDを指定したタスクが保存されている月です
+func taskSaveScope(request *TaskSaveRequest) TaskDocumentScope {
+	if request.Mode == taskSaveModeReplace {
+		return taskScopeAll
+	}
+	var scope TaskDocumentScope
+	for date, tasks := range request.Events {
+		scope.Dates = append(scope.Dates, date)
+		for _, task := range tasks {
+			if task.ID != "" {
+				scope.TaskIDs = append(scope.TaskIDs, task.ID)
+			}
+		}
 	}
+	for date := range request.Notifications {
+		scope.Dates = append(scope.Dates, date)
+	}
+	scope.Dates = append(scope.Dates, request.DeletedEvents...)
+	scope.Dates = append(scope.Dates, request.DeletedNotifications...)
+	return scope
+}
 
-	log.Printf("DEBUG: Events data for UID %s: %+v", uid, eventsData)
+// saveTaskData タスクデータを既存データにマージして保存
+// 置き換えモードや削除の指定がある場合は、指定された日付の予定・通知を削除します
+// ifMatch が保存済みのリビジョンと一致しない場合は、保存済みのドキュメントと ErrRevisionConflict を返します
+func saveTaskData(ctx context.Context, uid, ifMatch string, request *TaskSaveRequest) (*TaskDocument, error) {
+	log.Printf("DEBUG: saveTaskData called for UID %s", uid)
 
-	// 型アサーション
-	eventsMap, ok := eventsData.(map[string]interface{})
-	if !ok {
-		return make(map[string][]TaskSlot), nil
-	}
-
-	// TaskSlotの配列に変換
-	result := make(map[string][]TaskSlot)
-	for date, tasksData := range eventsMap {
-		log.Printf("DEBUG: Processing date %s, tasksData: %+v", date, tasksData)
-		
-		// null値の場合は空の配列を設定
-		if tasksData == nil {
-			log.Printf("DEBUG: tasksData is null for date %s, setting empty array", date)
-			result[date] = []TaskSlot{}
-			continue
+	var saved TaskDocument
+	err := dataStore.UpdateTaskDocument(ctx, uid, taskSaveScope(request), func(taskDoc *TaskDocument) error {
+		if taskDoc.Events == nil {
+			taskDoc.Events = make(map[string][]TaskSlot)
 		}
-		
-		tasksArray, ok := tasksData.([]interface{})
-		if !ok {
-			log.Printf("DEBUG: Failed to convert tasksData to array for date %s", date)
-			continue
+		if taskDoc.Notifications == nil {
+			taskDoc.Notifications = make(map[string][]NotificationSlot)
 		}
-
-		var tasks []TaskSlot
-		for i, taskData := range tasksArray {
-			log.Printf("DEBUG: Processing task %d for date %s: %+v", i, date, taskData)
-			taskMap, ok := taskData.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			task := TaskSlot{}
-			log.Printf("DEBUG: Task map for date %s, task %d: %+v", date, i, taskMap)
-			
-			// フィールド名の大文字小文字を考慮して取得
-			if description, ok := taskMap["description"].(string); ok {
-				task.Description = description
-				log.Printf("DEBUG: Set description: %s", description)
-			} else if description, ok := taskMap["Description"].(string); ok {
-				task.Description = description
-				log.Printf("DEBUG: Set Description: %s", description)
-			}
-			
-			if start, ok := taskMap["start"].(string); ok {
-				task.Start = start
-				log.Printf("DEBUG: Set start: %s", start)
-			} else if start, ok := taskMap["Start"].(string); ok {
-				task.Start = start
-				log.Printf("DEBUG: Set Start: %s", start)
-			}
-			
-			if end, ok := taskMap["end"].(string); ok {
-				task.End = end
-				log.Printf("DEBUG: Set end: %s", end)
-			} else if end, ok := taskMap["End"].(string); ok {
-				task.End = end
-				log.Printf("DEBUG: Set End: %s", end)
-			}
-			
-			if title, ok := taskMap["title"].(string); ok {
-				task.Title = title
-				log.Printf("DEBUG: Set title: %s", title)
-			} else if title, ok := taskMap["Title"].(string); ok {
-				task.Title = title
-				log.Printf("DEBUG: Set Title: %s", title)
+		if !ifMatchSatisfied(ifMatch, taskDoc.Revision) {
+			saved = *taskDoc
+			return ErrRevisionConflict
+		}
+		idsAssigned := ensureTaskIDs(taskDoc)
+
+		// 日付ごとの置き換えを同期操作に変換し、同期クライアントが差分を取得できるよう履歴に記録する
+		ops, replaced, removed := bulkTaskOperations(taskDoc, request)
+		var applied []*TaskOperation
+		for _, op := range ops {
+			recorded, err := applyTaskOperation(taskDoc, op)
+			if err != nil {
+				return err
 			}
-			
-			if userColor, ok := taskMap["userColor"].(string); ok {
-				task.UserColor = userColor
-				log.Printf("DEBUG: Set userColor: %s", userColor)
-			} else if userColor, ok := taskMap["UserColor"].(string); ok {
-				task.UserColor = userColor
-				log.Printf("DEBUG: Set UserColor: %s", userColor)
+			if recorded != nil {
+				applied = append(applied, recorded)
 			}
-			
-			if order, ok := taskMap["order"].(float64); ok {
-				task.Order = int(order)
-				log.Printf("DEBUG: Set order: %d", int(order))
-			} else if order, ok := taskMap["Order"].(float64); ok {
-				task.Order = int(order)
-				log.Printf("DEBUG: Set Order: %d", int(order))
+		}
+		// 送信された日付は送信された順序のまま保存する
+		for date, tasks := range replaced {
+			taskDoc.Events[date] = tasks
+		}
+		datesRemoved := false
+		for _, date := range removed {
+			if _, ok := taskDoc.Events[date]; ok {
+				delete(taskDoc.Events, date)
+				datesRemoved = true
 			}
+		}
+
+		log.Printf("DEBUG: Saving task data for UID %s (%d changes)", uid, len(applied))
+		log.Printf("DEBUG: Task data to save: %+v", taskDoc.Events)
+		log.Printf("DEBUG: Notification data to save: %+v", taskDoc.Notifications)
 
-			log.Printf("DEBUG: Final task object: %+v", task)
-			tasks = append(tasks, task)
+		taskDoc.UpdatedAt = time.Now()
+		if len(applied) > 0 || idsAssigned || datesRemoved {
+			recordTaskChanges(taskDoc, applied)
 		}
-		result[date] = tasks
-		log.Printf("DEBUG: Final result for date %s: %+v", date, tasks)
+		saved = *taskDoc
+		return nil
+	})
+	if errors.Is(err, ErrRevisionConflict) {
+		return &saved, err
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to save task data: %v", err)
+		return nil, fmt.Errorf("failed to save task data: %v", err)
 	}
 
-	log.Printf("DEBUG: Final result map: %+v", result)
-	return result, nil
+	log.Printf("DEBUG: Task data saved successfully for UID %s", uid)
+	return &saved, nil
 }
 
-// getExistingNotificationData 既存の通知データを取得
-func getExistingNotificationData(ctx context.Context, client *firestore.Client, uid string) (map[string][]NotificationSlot, error) {
-	docRef := client.Collection("task").Doc(uid)
-	doc, err := docRef.Get(ctx)
-	if err != nil {
-		// デバッグ情報を出力
-		log.Printf("DEBUG: getExistingNotificationData error for UID %s: %v", uid, err)
-		
-		// ドキュメントが存在しない場合は空のマップを返す
-		if status.Code(err) == codes.NotFound || strings.Contains(err.Error(), "NotFound") || err == iterator.Done {
-			log.Printf("DEBUG: Document not found for UID %s, returning empty map", uid)
-			return make(map[string][]NotificationSlot), nil
-		}
+// getTaskDocument 既存のタスクドキュメントを取得
+// ドキュメントが存在しない場合は空のドキュメントを返す
+func getTaskDocument(ctx context.Context, uid string) (*TaskDocument, error) {
+	taskDoc, err := dataStore.GetTaskDocument(ctx, uid)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("DEBUG: Task document not found for UID %s, returning empty document", uid)
+		taskDoc = &TaskDocument{UID: uid}
+	} else if err != nil {
+		log.Printf("DEBUG: getTaskDocument error for UID %s: %v", uid, err)
 		return nil, err
 	}
 
-	var data map[string]interface{}
-	if err := doc.DataTo(&data); err != nil {
-		log.Printf("DEBUG: Failed to convert document data: %v", err)
-		return nil, err
+	if taskDoc.Events == nil {
+		taskDoc.Events = make(map[string][]TaskSlot)
+	}
+	if taskDoc.Notifications == nil {
+		taskDoc.Notifications = make(map[string][]NotificationSlot)
+	}
+
+	// ID導入前に保存されたタスクには、個別に操作できるようIDを割り当てて保存する
+	if hasTasksWithoutID(taskDoc) {
+		return assignLegacyTaskIDs(ctx, uid)
 	}
+	return taskDoc, nil
+}
 
-	log.Printf("DEBUG: Raw Firestore data for UID %s: %+v", uid, data)
+// getTaskRange は from から to（両端を含む）の予定・通知のみを持つタスクドキュメントを取得します
+// ドキュメントが存在しない場合は空のドキュメントを返す
+func getTaskRange(ctx context.Context, uid string, from, to time.Time) (*TaskDocument, error) {
+	rangeFrom, rangeTo := from.Format(taskDateLayout), to.Format(taskDateLayout)
+	taskDoc, err := dataStore.GetTaskRange(ctx, uid, rangeFrom, rangeTo)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("DEBUG: Task document not found for UID %s, returning empty document", uid)
+		taskDoc = &TaskDocument{UID: uid}
+	} else if err != nil {
+		log.Printf("DEBUG: getTaskRange error for UID %s: %v", uid, err)
+		return nil, err
+	}
 
-	// notificationsフィールドを取得
-	notificationsData, ok := data["notifications"]
-	if !ok {
-		log.Printf("DEBUG: No notifications field found in document for UID %s", uid)
-		return make(map[string][]NotificationSlot), nil
+	if taskDoc.Events == nil {
+		taskDoc.Events = make(map[string][]TaskSlot)
+	}
+	if taskDoc.Notifications == nil {
+		taskDoc.Notifications = make(map[string][]NotificationSlot)
 	}
 
-	log.Printf("DEBUG: Notifications data for UID %s: %+v", uid, notificationsData)
+	if hasTasksWithoutID(taskDoc) {
+		fullDoc, err := assignLegacyTaskIDs(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		return filterTaskDocument(fullDoc, rangeFrom, rangeTo), nil
+	}
+	return taskDoc, nil
+}
 
-	// 型アサーション
-	notificationsMap, ok := notificationsData.(map[string]interface{})
-	if !ok {
-		return make(map[string][]NotificationSlot), nil
-	}
-
-	// NotificationSlotの配列に変換
-	result := make(map[string][]NotificationSlot)
-	for date, notifsData := range notificationsMap {
-		log.Printf("DEBUG: Processing date %s, notifsData: %+v", date, notifsData)
-		
-		// null値の場合は空の配列を設定
-		if notifsData == nil {
-			log.Printf("DEBUG: notifsData is null for date %s, setting empty array", date)
-			result[date] = []NotificationSlot{}
-			continue
+// assignLegacyTaskIDs はIDのないタスクにIDを割り当てて保存し、保存後のドキュメントを返します
+func assignLegacyTaskIDs(ctx context.Context, uid string) (*TaskDocument, error) {
+	var saved TaskDocument
+	err := dataStore.UpdateTaskDocument(ctx, uid, taskScopeAll, func(doc *TaskDocument) error {
+		if doc.Events == nil {
+			doc.Events = make(map[string][]TaskSlot)
 		}
-		
-		notifsArray, ok := notifsData.([]interface{})
-		if !ok {
-			log.Printf("DEBUG: Failed to convert notifsData to array for date %s", date)
-			continue
+		if doc.Notifications == nil {
+			doc.Notifications = make(map[string][]NotificationSlot)
 		}
-
-		var notifs []NotificationSlot
-		for i, notifData := range notifsArray {
-			log.Printf("DEBUG: Processing notification %d for date %s: %+v", i, date, notifData)
-			notifMap, ok := notifData.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			notif := NotificationSlot{}
-			log.Printf("DEBUG: Notification map for date %s, notification %d: %+v", date, i, notifMap)
-			
-			// フィールド名の大文字小文字を考慮して取得
-			if time, ok := notifMap["time"].(string); ok {
-				notif.Time = time
-				log.Printf("DEBUG: Set time: %s", time)
-			} else if time, ok := notifMap["Time"].(string); ok {
-				notif.Time = time
-				log.Printf("DEBUG: Set Time: %s", time)
-			}
-			
-			if order, ok := notifMap["order"].(float64); ok {
-				notif.Order = int(order)
-				log.Printf("DEBUG: Set order: %d", int(order))
-			} else if order, ok := notifMap["Order"].(float64); ok {
-				notif.Order = int(order)
-				log.Printf("DEBUG: Set Order: %d", int(order))
-			}
-
-			log.Printf("DEBUG: Final notification object: %+v", notif)
-			notifs = append(notifs, notif)
+		if ensureTaskIDs(doc) {
+			recordTaskChanges(doc, nil)
 		}
-		result[date] = notifs
-		log.Printf("DEBUG: Final result for date %s: %+v", date, notifs)
+		saved = *doc
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign task IDs: %v", err)
 	}
-
-	log.Printf("DEBUG: Final result map: %+v", result)
-	return result, nil
+	log.Printf("INFO: Assigned IDs to legacy tasks for UID %s", uid)
+	return &saved, nil
 }
 
-// extractBearerToken Bearerトークンを抽出
-func extractBearerToken(authHeader string) string {
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		return authHeader[7:]
+// hasTasksWithoutID はIDのないタスクが含まれるかを返します
+func hasTasksWithoutID(doc *TaskDocument) bool {
+	for _, tasks := range doc.Events {
+		for _, task := range tasks {
+			if task.ID == "" {
+				return true
+			}
+		}
 	}
-	return ""
+	return false
 }
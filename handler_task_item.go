@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TaskItemRequest は1件のタスクの作成・変更・移動リクエストの構造体です
+// 変更（PATCH）では指定したフィールドのみ更新し、date を指定した場合はその日付へ移動します
+type TaskItemRequest struct {
+	Date        string  `json:"date,omitempty"`
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Start       *string `json:"start,omitempty"`
+	End         *string `json:"end,omitempty"`
+	UserColor   *string `json:"userColor,omitempty"`
+	Order       *int    `json:"order,omitempty"`
+}
+
+// applyTo はリクエストで指定されたフィールドをタスクに反映します
+func (r *TaskItemRequest) applyTo(task *TaskSlot) {
+	if r.Title != nil {
+		task.Title = *r.Title
+	}
+	if r.Description != nil {
+		task.Description = *r.Description
+	}
+	if r.Start != nil {
+		task.Start = *r.Start
+	}
+	if r.End != nil {
+		task.End = *r.End
+	}
+	if r.UserColor != nil {
+		task.UserColor = *r.UserColor
+	}
+	if r.Order != nil {
+		task.Order = *r.Order
+	}
+}
+
+// isValidTaskDate は日付キーが YYYY-MM-DD 形式かを返します
+func isValidTaskDate(date string) bool {
+	_, err := time.Parse(taskDateLayout, date)
+	return err == nil
+}
+
+// taskItemResponse は1件のタスクのレスポンスを作成します
+func taskItemResponse(req *Request, date string, task TaskSlot, revision int64) map[string]interface{} {
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{
+		"id":       task.ID,
+		"date":     date,
+		"task":     task,
+		"revision": revision,
+	}
+}
+
+// updateTaskItem はタスクドキュメントに1件の操作を適用し、差分同期の履歴に記録します
+// 操作を適用した後のタスクの日付・内容と、ドキュメントのリビジョンを返します
+// ifMatch が保存済みのリビジョンと一致しない場合は、taskID の保存済みのタスクとリビジョン、ErrRevisionConflict を返します
+// build に渡すドキュメントには scope の月の予定・通知のみが含まれます
+func updateTaskItem(ctx context.Context, uid, taskID, ifMatch string, scope TaskDocumentScope, build func(doc *TaskDocument) (TaskOperation, error)) (string, TaskSlot, int64, error) {
+	var date string
+	var task TaskSlot
+	var revision int64
+	err := dataStore.UpdateTaskDocument(ctx, uid, scope, func(taskDoc *TaskDocument) error {
+		date, task = "", TaskSlot{}
+		if taskDoc.Events == nil {
+			taskDoc.Events = make(map[string][]TaskSlot)
+		}
+		if taskDoc.Notifications == nil {
+			taskDoc.Notifications = make(map[string][]NotificationSlot)
+		}
+		if !ifMatchSatisfied(ifMatch, taskDoc.Revision) {
+			if current, index := findTask(taskDoc, taskID); taskID != "" && index >= 0 {
+				date, task = current, taskDoc.Events[current][index]
+			}
+			revision = taskDoc.Revision
+			return ErrRevisionConflict
+		}
+		idsAssigned := ensureTaskIDs(taskDoc)
+
+		op, err := build(taskDoc)
+		if err != nil {
+			return err
+		}
+		recorded, err := applyTaskOperation(taskDoc, op)
+		if err != nil {
+			return err
+		}
+
+		var applied []*TaskOperation
+		if recorded != nil {
+			applied = append(applied, recorded)
+			if recorded.Task != nil {
+				date, task = recorded.Date, *recorded.Task
+			}
+		} else if op.Task != nil {
+			// 変更がない場合は保存済みの内容を返す
+			if current, index := findTask(taskDoc, op.ID); index >= 0 {
+				date, task = current, taskDoc.Events[current][index]
+			}
+		}
+		if len(applied) > 0 || idsAssigned {
+			taskDoc.UpdatedAt = time.Now()
+			recordTaskChanges(taskDoc, applied)
+		}
+		revision = taskDoc.Revision
+		return nil
+	})
+	return date, task, revision, err
+}
+
+// taskItemErrorResponse はタスク操作のエラーをレスポンスに変換します
+func taskItemErrorResponse(uid, taskID string, err error) (map[string]interface{}, int) {
+	if errors.Is(err, ErrTaskNotFound) {
+		return map[string]interface{}{"error": "指定されたタスクが見つかりません"}, http.StatusNotFound
+	}
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return validationErrorResponse(err)
+	}
+	log.Printf("ERROR: Failed to update task %s for UID %s: %v", taskID, uid, err)
+	return map[string]interface{}{"error": "タスクの保存に失敗しました"}, http.StatusInternalServerError
+}
+
+// taskItemConflictResponse はリビジョンの不一致を409レスポンスに変換します（current は保存済みのタスク）
+func taskItemConflictResponse(req *Request, uid, date string, task TaskSlot, revision int64) (map[string]interface{}, int) {
+	log.Printf("WARN: Task revision conflict for UID %s (current revision %d)", uid, revision)
+	current := map[string]interface{}{"revision": revision}
+	if task.ID != "" {
+		current["id"] = task.ID
+		current["date"] = date
+		current["task"] = task
+	}
+	return revisionConflictResponse(req, revision, current)
+}
+
+// processTaskItemCreateRequest は POST /api/task/items を処理します（IDはサーバーが割り当てます）
+func processTaskItemCreateRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	var itemData TaskItemRequest
+	if err := req.DecodeJSON(&itemData); err != nil {
+		log.Printf("WARN: Failed to parse task item JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if err := validateTaskItemRequest(&itemData, true); err != nil {
+		return validationErrorResponse(err)
+	}
+
+	var task TaskSlot
+	itemData.applyTo(&task)
+	if err := validateTaskSlot(&task); err != nil {
+		return validationErrorResponse(err)
+	}
+	date, saved, revision, err := updateTaskItem(ctx, principal.UID, "", req.Header("If-Match"), TaskDocumentScope{Dates: []string{itemData.Date}}, func(doc *TaskDocument) (TaskOperation, error) {
+		return TaskOperation{Op: taskOpCreate, Target: taskTargetTask, ID: newDocumentID(), Date: itemData.Date, Task: &task}, nil
+	})
+	if errors.Is(err, ErrRevisionConflict) {
+		return taskItemConflictResponse(req, principal.UID, date, saved, revision)
+	}
+	if err != nil {
+		return taskItemErrorResponse(principal.UID, "", err)
+	}
+
+	log.Printf("INFO: Task %s created on %s for UID %s", saved.ID, date, principal.UID)
+	return taskItemResponse(req, date, saved, revision), http.StatusCreated
+}
+
+// processTaskItemGetRequest は GET /api/task/items/{taskId} を処理します
+func processTaskItemGetRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	taskID := req.PathParam("taskId")
+	taskDoc, err := getTaskDocument(ctx, principal.UID)
+	if err != nil {
+		log.Printf("ERROR: Failed to get task data for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "タスクデータの取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	date, index := findTask(taskDoc, taskID)
+	if index < 0 {
+		return map[string]interface{}{"error": "指定されたタスクが見つかりません"}, http.StatusNotFound
+	}
+	return taskItemResponse(req, date, taskDoc.Events[date][index], taskDoc.Revision), http.StatusOK
+}
+
+// processTaskItemPatchRequest は PATCH /api/task/items/{taskId} を処理します
+// 指定したフィールドのみ変更し、date を指定した場合はその日付へ移動します
+func processTaskItemPatchRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	var itemData TaskItemRequest
+	if err := req.DecodeJSON(&itemData); err != nil {
+		log.Printf("WARN: Failed to parse task item JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if err := validateTaskItemRequest(&itemData, false); err != nil {
+		return validationErrorResponse(err)
+	}
+	return patchTaskItem(ctx, req, principal, &itemData)
+}
+
+// processTaskItemMoveRequest は POST /api/task/items/{taskId}/move を処理します
+// タスクを date の日付へ移動します。order を指定した場合は表示順も変更します
+func processTaskItemMoveRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	var moveData struct {
+		Date  string `json:"date"`
+		Order *int   `json:"order,omitempty"`
+	}
+	if err := req.DecodeJSON(&moveData); err != nil {
+		log.Printf("WARN: Failed to parse task move JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if !isValidTaskDate(moveData.Date) {
+		return map[string]interface{}{"error": "date は YYYY-MM-DD 形式で指定してください"}, http.StatusBadRequest
+	}
+	return patchTaskItem(ctx, req, principal, &TaskItemRequest{Date: moveData.Date, Order: moveData.Order})
+}
+
+// patchTaskItem は既存のタスクにリクエストの変更を適用します
+func patchTaskItem(ctx context.Context, req *Request, principal *Principal, itemData *TaskItemRequest) (map[string]interface{}, int) {
+	taskID := req.PathParam("taskId")
+	scope := TaskDocumentScope{TaskIDs: []string{taskID}}
+	if itemData.Date != "" {
+		scope.Dates = []string{itemData.Date}
+	}
+	date, saved, revision, err := updateTaskItem(ctx, principal.UID, taskID, req.Header("If-Match"), scope, func(doc *TaskDocument) (TaskOperation, error) {
+		current, index := findTask(doc, taskID)
+		if index < 0 {
+			return TaskOperation{}, ErrTaskNotFound
+		}
+		task := doc.Events[current][index]
+		itemData.applyTo(&task)
+		if err := validateTaskSlot(&task); err != nil {
+			return TaskOperation{}, err
+		}
+		if itemData.Date != "" {
+			current = itemData.Date
+		}
+		return TaskOperation{Op: taskOpUpdate, Target: taskTargetTask, ID: taskID, Date: current, Task: &task}, nil
+	})
+	if errors.Is(err, ErrRevisionConflict) {
+		return taskItemConflictResponse(req, principal.UID, date, saved, revision)
+	}
+	if err != nil {
+		return taskItemErrorResponse(principal.UID, taskID, err)
+	}
+	return taskItemResponse(req, date, saved, revision), http.StatusOK
+}
+
+// processTaskItemDeleteRequest は DELETE /api/task/items/{taskId} を処理します
+func processTaskItemDeleteRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	taskID := req.PathParam("taskId")
+	date, current, revision, err := updateTaskItem(ctx, principal.UID, taskID, req.Header("If-Match"), TaskDocumentScope{TaskIDs: []string{taskID}}, func(doc *TaskDocument) (TaskOperation, error) {
+		if _, index := findTask(doc, taskID); index < 0 {
+			return TaskOperation{}, ErrTaskNotFound
+		}
+		return TaskOperation{Op: taskOpDelete, Target: taskTargetTask, ID: taskID}, nil
+	})
+	if errors.Is(err, ErrRevisionConflict) {
+		return taskItemConflictResponse(req, principal.UID, date, current, revision)
+	}
+	if err != nil {
+		return taskItemErrorResponse(principal.UID, taskID, err)
+	}
+
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{"message": "タスクを削除しました", "id": taskID, "revision": revision}, http.StatusOK
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// RecurringTaskRequest は繰り返しタスクの作成・変更リクエストの構造体です
+type RecurringTaskRequest struct {
+	Task    TaskSlot `json:"task"`
+	DTStart string   `json:"dtstart"`
+	RRule   string   `json:"rrule"`
+	ExDates []string `json:"exdates,omitempty"`
+}
+
+// updateRecurringTasks は繰り返しタスクの定義を fn で変更し、差分同期の履歴に記録します
+// fn は変更した定義の同期操作を返します。繰り返しタスクの定義のみを変更するため、予定・通知は読み込みません
+func updateRecurringTasks(ctx context.Context, uid string, fn func(doc *TaskDocument) (*TaskOperation, error)) (int64, error) {
+	var revision int64
+	err := dataStore.UpdateTaskDocument(ctx, uid, TaskDocumentScope{}, func(taskDoc *TaskDocument) error {
+		if taskDoc.Events == nil {
+			taskDoc.Events = make(map[string][]TaskSlot)
+		}
+		if taskDoc.Notifications == nil {
+			taskDoc.Notifications = make(map[string][]NotificationSlot)
+		}
+
+		op, err := fn(taskDoc)
+		if err != nil {
+			return err
+		}
+		taskDoc.UpdatedAt = time.Now()
+		recordTaskChanges(taskDoc, []*TaskOperation{op})
+		revision = taskDoc.Revision
+		return nil
+	})
+	return revision, err
+}
+
+// recurringTaskResponse は繰り返しタスクの定義のレスポンスを作成します
+func recurringTaskResponse(req *Request, recurring *RecurringTask, revision int64) map[string]interface{} {
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{"recurring": recurring, "revision": revision}
+}
+
+// recurringTaskErrorResponse は繰り返しタスクの操作のエラーをレスポンスに変換します
+func recurringTaskErrorResponse(uid string, err error) (map[string]interface{}, int) {
+	if errors.Is(err, ErrRecurringTaskNotFound) {
+		return map[string]interface{}{"error": "指定された繰り返しタスク、または指定した日付の回が見つかりません"}, http.StatusNotFound
+	}
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return validationErrorResponse(err)
+	}
+	log.Printf("ERROR: Failed to update recurring tasks for UID %s: %v", uid, err)
+	return map[string]interface{}{"error": "繰り返しタスクの保存に失敗しました"}, http.StatusInternalServerError
+}
+
+// decodeRecurringTaskRequest はリクエストを繰り返しタスクの定義に変換して検証します
+func decodeRecurringTaskRequest(req *Request) (*RecurringTask, map[string]interface{}, int) {
+	var recurringData RecurringTaskRequest
+	if err := req.DecodeJSON(&recurringData); err != nil {
+		log.Printf("WARN: Failed to parse recurring task JSON: %v", err)
+		return nil, map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+
+	recurring := &RecurringTask{
+		Task:    recurringData.Task,
+		DTStart: recurringData.DTStart,
+		RRule:   recurringData.RRule,
+		ExDates: recurringData.ExDates,
+	}
+	if err := normalizeRecurringTask(recurring); err != nil {
+		errBody, status := validationErrorResponse(err)
+		return nil, errBody, status
+	}
+	return recurring, nil, 0
+}
+
+// processRecurringTaskListRequest は GET /api/task/recurring を処理します
+func processRecurringTaskListRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	taskDoc, err := getTaskDocument(ctx, principal.UID)
+	if err != nil {
+		log.Printf("ERROR: Failed to get task data for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "タスクデータの取得に失敗しました"}, http.StatusInternalServerError
+	}
+
+	recurring := taskDoc.Recurring
+	if recurring == nil {
+		recurring = []RecurringTask{}
+	}
+	req.SetResponseHeader("ETag", revisionETag(taskDoc.Revision))
+	return map[string]interface{}{"recurring": recurring, "revision": taskDoc.Revision}, http.StatusOK
+}
+
+// processRecurringTaskCreateRequest は POST /api/task/recurring を処理します
+func processRecurringTaskCreateRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	recurring, errBody, status := decodeRecurringTaskRequest(req)
+	if errBody != nil {
+		return errBody, status
+	}
+	recurring.ID = newDocumentID()
+
+	revision, err := updateRecurringTasks(ctx, principal.UID, func(doc *TaskDocument) (*TaskOperation, error) {
+		recurring.UpdatedAt = time.Now()
+		doc.Recurring = append(doc.Recurring, *recurring)
+		return recurringTaskOperation(taskOpCreate, recurring), nil
+	})
+	if err != nil {
+		return recurringTaskErrorResponse(principal.UID, err)
+	}
+
+	log.Printf("INFO: Recurring task %s created for UID %s (%s)", recurring.ID, principal.UID, recurring.RRule)
+	return recurringTaskResponse(req, recurring, revision), http.StatusCreated
+}
+
+// processRecurringTaskUpdateRequest は PUT /api/task/recurring/{recurringId} を処理します
+// 内容・開始日・RRULE・EXDATE を置き換えます。回ごとの変更は引き継ぎます
+func processRecurringTaskUpdateRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	recurring, errBody, status := decodeRecurringTaskRequest(req)
+	if errBody != nil {
+		return errBody, status
+	}
+	recurring.ID = req.PathParam("recurringId")
+
+	revision, err := updateRecurringTasks(ctx, principal.UID, func(doc *TaskDocument) (*TaskOperation, error) {
+		index := findRecurringTask(doc, recurring.ID)
+		if index < 0 {
+			return nil, ErrRecurringTaskNotFound
+		}
+		recurring.Overrides = doc.Recurring[index].Overrides
+		recurring.UpdatedAt = time.Now()
+		doc.Recurring[index] = *recurring
+		return recurringTaskOperation(taskOpUpdate, recurring), nil
+	})
+	if err != nil {
+		return recurringTaskErrorResponse(principal.UID, err)
+	}
+	return recurringTaskResponse(req, recurring, revision), http.StatusOK
+}
+
+// processRecurringTaskDeleteRequest は DELETE /api/task/recurring/{recurringId} を処理します
+func processRecurringTaskDeleteRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	recurringID := req.PathParam("recurringId")
+	revision, err := updateRecurringTasks(ctx, principal.UID, func(doc *TaskDocument) (*TaskOperation, error) {
+		index := findRecurringTask(doc, recurringID)
+		if index < 0 {
+			return nil, ErrRecurringTaskNotFound
+		}
+		deleted := doc.Recurring[index]
+		doc.Recurring = append(doc.Recurring[:index:index], doc.Recurring[index+1:]...)
+		return recurringTaskOperation(taskOpDelete, &deleted), nil
+	})
+	if err != nil {
+		return recurringTaskErrorResponse(principal.UID, err)
+	}
+
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{"message": "繰り返しタスクを削除しました", "id": recurringID, "revision": revision}, http.StatusOK
+}
+
+// processRecurringOccurrenceRequest は1回分の変更・削除を処理します
+//
+//   - PUT:    /api/task/recurring/{recurringId}/occurrences/{date} の回の内容を変更します（date を指定すると移動）
+//   - DELETE: 同じ回を EXDATE に追加して削除します
+//
+// パスの date は移動前の元の日付です
+func processRecurringOccurrenceRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+	recurringID := req.PathParam("recurringId")
+	original := req.PathParam("date")
+
+	var override RecurringTaskOverride
+	if req.Method != http.MethodDelete {
+		var itemData TaskItemRequest
+		if err := req.DecodeJSON(&itemData); err != nil {
+			log.Printf("WARN: Failed to parse occurrence JSON: %v", err)
+			return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+		}
+		if err := validateTaskItemRequest(&itemData, false); err != nil {
+			return validationErrorResponse(err)
+		}
+		override = RecurringTaskOverride(itemData)
+	}
+
+	var updated RecurringTask
+	revision, err := updateRecurringTasks(ctx, principal.UID, func(doc *TaskDocument) (*TaskOperation, error) {
+		index := findRecurringTask(doc, recurringID)
+		if index < 0 || !doc.Recurring[index].isOccurrence(original) {
+			return nil, ErrRecurringTaskNotFound
+		}
+
+		recurring := doc.Recurring[index]
+		overrides := make(map[string]RecurringTaskOverride, len(recurring.Overrides)+1)
+		for date, o := range recurring.Overrides {
+			overrides[date] = o
+		}
+		if req.Method == http.MethodDelete {
+			delete(overrides, original)
+			recurring.ExDates = append(append([]string(nil), recurring.ExDates...), original)
+			sort.Strings(recurring.ExDates)
+		} else {
+			overrides[original] = override
+		}
+		recurring.Overrides = overrides
+		if req.Method != http.MethodDelete {
+			_, task := recurring.occurrenceSlot(original)
+			if err := validateTaskSlot(&task); err != nil {
+				return nil, err
+			}
+		}
+		recurring.UpdatedAt = time.Now()
+		doc.Recurring[index] = recurring
+		updated = recurring
+		return recurringTaskOperation(taskOpUpdate, &recurring), nil
+	})
+	if err != nil {
+		return recurringTaskErrorResponse(principal.UID, err)
+	}
+
+	response := recurringTaskResponse(req, &updated, revision)
+	if req.Method != http.MethodDelete {
+		date, task := updated.occurrenceSlot(original)
+		response["date"] = date
+		response["task"] = task
+	}
+	return response, http.StatusOK
+}
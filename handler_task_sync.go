@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TaskSyncRequest はタスクの差分同期リクエストの構造体です
+type TaskSyncRequest struct {
+	// Cursor は前回の同期で受け取ったカーソルです。初回は 0 を指定します
+	Cursor int64 `json:"cursor"`
+	// Operations は前回の同期以降にクライアントで行った変更です。送信順に適用されます
+	Operations []TaskOperation `json:"operations"`
+}
+
+// TaskSyncRejection は適用できなかった操作です
+type TaskSyncRejection struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Code  string `json:"code"`
+}
+
+// processTaskSyncRequest はタスク・通知の差分同期を処理します
+//
+// クライアントの操作を適用したうえで、cursor より後に他の端末で行われた操作を返します
+// cursor が古すぎて差分を復元できない場合は reset を true とし、events と notifications に全件を返します
+func processTaskSyncRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		return map[string]interface{}{"error": "認証が必要です"}, http.StatusUnauthorized
+	}
+	uid := principal.UID
+
+	var syncData TaskSyncRequest
+	if err := req.DecodeJSON(&syncData); err != nil {
+		log.Printf("WARN: Failed to parse task sync JSON: %v", err)
+		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
+	}
+	if len(syncData.Operations) > taskSyncMaxOperations {
+		return map[string]interface{}{"error": "一度に送信できる操作が多すぎます", "limit": taskSyncMaxOperations}, http.StatusRequestEntityTooLarge
+	}
+	v := &validator{}
+	for i := range syncData.Operations {
+		validateTaskOperation(v, fmt.Sprintf("operations[%d]", i), &syncData.Operations[i])
+	}
+	if err := v.err(); err != nil {
+		return validationErrorResponse(err)
+	}
+
+	var missed []TaskOperation
+	var applied []*TaskOperation
+	var rejected []TaskSyncRejection
+	var reset bool
+	var saved TaskDocument
+	// 操作の日付の月と、操作するタスクが保存されている月のみを読み込む
+	var scope TaskDocumentScope
+	for _, op := range syncData.Operations {
+		if op.Date != "" {
+			scope.Dates = append(scope.Dates, op.Date)
+		}
+		if op.ID != "" {
+			scope.TaskIDs = append(scope.TaskIDs, op.ID)
+		}
+	}
+	err := dataStore.UpdateTaskDocument(ctx, uid, scope, func(taskDoc *TaskDocument) error {
+		// トランザクションの再実行に備えて結果を初期化する
+		applied, rejected = nil, []TaskSyncRejection{}
+
+		if taskDoc.Events == nil {
+			taskDoc.Events = make(map[string][]TaskSlot)
+		}
+		if taskDoc.Notifications == nil {
+			taskDoc.Notifications = make(map[string][]NotificationSlot)
+		}
+		idsAssigned := ensureTaskIDs(taskDoc)
+
+		// クライアントの操作を適用する前に、クライアントが受け取っていない操作を取り出す
+		missed, reset = taskChangesSince(taskDoc, syncData.Cursor)
+
+		for i, op := range syncData.Operations {
+			recorded, err := applyTaskOperation(taskDoc, op)
+			if errors.Is(err, ErrTaskNotFound) {
+				// 他の端末で削除されたタスクの更新は適用しない
+				rejected = append(rejected, TaskSyncRejection{Index: i, ID: op.ID, Code: "task_not_found"})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if recorded != nil {
+				applied = append(applied, recorded)
+			}
+		}
+
+		if len(applied) > 0 || idsAssigned {
+			taskDoc.UpdatedAt = time.Now()
+			recordTaskChanges(taskDoc, applied)
+		}
+		saved = *taskDoc
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to sync tasks for UID %s: %v", uid, err)
+		return map[string]interface{}{"error": "タスクの同期に失敗しました"}, http.StatusInternalServerError
+	}
+
+	// リセットする場合は、読み込まなかった月を含むすべての予定・通知を返す
+	if reset {
+		full, err := getTaskDocument(ctx, uid)
+		if err != nil {
+			log.Printf("ERROR: Failed to get task data for UID %s: %v", uid, err)
+			return map[string]interface{}{"error": "タスクの同期に失敗しました"}, http.StatusInternalServerError
+		}
+		saved = *full
+	}
+
+	appliedOps := make([]TaskOperation, 0, len(applied))
+	for _, op := range applied {
+		appliedOps = append(appliedOps, *op)
+	}
+	log.Printf("DEBUG: Task sync for UID %s: cursor %d -> %d, applied %d, missed %d, reset %t",
+		uid, syncData.Cursor, saved.Revision, len(appliedOps), len(missed), reset)
+
+	req.SetResponseHeader("ETag", revisionETag(saved.Revision))
+	response := map[string]interface{}{
+		"cursor":   saved.Revision,
+		"reset":    reset,
+		"applied":  appliedOps,
+		"rejected": rejected,
+	}
+	if reset {
+		response["events"] = saved.Events
+		response["notifications"] = saved.Notifications
+		// 繰り返しタスクの定義も全件を返し、リセット後のクライアントが定義を再構築できるようにする
+		recurring := saved.Recurring
+		if recurring == nil {
+			recurring = []RecurringTask{}
+		}
+		response["recurring"] = recurring
+	} else {
+		response["operations"] = missed
+	}
+	return response, http.StatusOK
+}
@@ -10,9 +10,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-
-	"firebase.google.com/go/v4/auth"
-	"github.com/aws/aws-lambda-go/events"
 )
 
 // TwitterAuthRequest はTwitter OAuth2.0認証リクエストの構造体です
@@ -210,7 +207,7 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 		log.Printf("INFO: Account linking mode for UID: %s", linkUID)
 		
 		// 指定されたUIDのユーザーが存在するか確認
-		_, err := authClient.GetUser(ctx, linkUID)
+		_, err := identityProvider.GetUser(ctx, linkUID)
 		if err != nil {
 			return "", fmt.Errorf("リンク先のユーザーが見つかりません: %v", err)
 		}
@@ -240,7 +237,7 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			linkUID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, linkUID, userData); err != nil {
+		if err := saveUserData(ctx, linkUID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 		
@@ -248,20 +245,19 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 	}
 	
 	// 新規ログイン時は、メールアドレスで既存のユーザーを確認
-	userRecord, err := authClient.GetUserByEmail(ctx, userInfo.Email)
+	userRecord, err := identityProvider.GetUserByEmail(ctx, userInfo.Email)
 	if err != nil {
 		// ユーザーが存在しない場合は新しいTwitterユーザーを作成
 		twitterUID := "twitter:" + userInfo.ID
-		params := (&auth.UserToCreate{}).
-			UID(twitterUID).
-			DisplayName(userInfo.Name).
-			PhotoURL(userInfo.ProfileImageURL).
-			Email(userInfo.Email).
-			EmailVerified(true)
-
-		userRecord, err = authClient.CreateUser(ctx, params)
+		userRecord, err = identityProvider.CreateUser(ctx, &IdentityUserToCreate{
+			UID:           twitterUID,
+			Email:         userInfo.Email,
+			DisplayName:   userInfo.Name,
+			PhotoURL:      userInfo.ProfileImageURL,
+			EmailVerified: true,
+		})
 		if err != nil {
-			return "", fmt.Errorf("failed to create Firebase user: %v", err)
+			return "", fmt.Errorf("failed to create user: %v", err)
 		}
 
 		log.Printf("INFO: New Twitter user created: %s", userRecord.UID)
@@ -278,7 +274,7 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 		}
 		addOAuthProvider(userData, "twitter", userInfo.Email, twitterUID)
 		
-		if err := saveUserDataToFirestore(ctx, twitterUID, userData); err != nil {
+		if err := saveUserData(ctx, twitterUID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data for new Twitter user: %v", err)
 		}
 	} else {
@@ -313,7 +309,7 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 		// ユーザーデータを保存（既存のユーザー名、カラーは保持される）
 		log.Printf("INFO: About to save user data for UID: %s (UserName: %s, UserColor: %s)", 
 			userRecord.UID, userData.UserName, userData.UserColor)
-		if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+		if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 			log.Printf("WARN: Failed to save user data: %v", err)
 		}
 	}
@@ -321,30 +317,11 @@ func createOrGetFirebaseUserForTwitter(ctx context.Context, userInfo *TwitterUse
 	return userRecord.UID, nil
 }
 
-func processTwitterAuthRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processTwitterAuthRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var authData TwitterAuthRequest
-	if err := json.Unmarshal(bodyBytes, &authData); err != nil {
-		log.Printf("WARN: Failed to parse Twitter auth JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&authData); err != nil {
+		log.Printf("WARN: Failed to parse Twitter auth JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -391,7 +368,7 @@ func processTwitterAuthRequest(ctx context.Context, req interface{}) (map[string
 	}
 
 	// セッショントークンを生成
-	sessionToken, err := generateSessionToken(uid, userInfo.Email)
+	tokens, err := createSession(ctx, uid, userInfo.Email, req.Header("User-Agent"))
 	if err != nil {
 		log.Printf("ERROR: Failed to generate session token for UID %s: %v\n", uid, err)
 		return map[string]interface{}{"error": "セッショントークンの生成に失敗しました"}, http.StatusInternalServerError
@@ -403,6 +380,8 @@ func processTwitterAuthRequest(ctx context.Context, req interface{}) (map[string
 		"message":      "Twitterアカウントでのログインが成功しました",
 		"uid":          uid,
 		"email":        userInfo.Email,
-		"sessionToken": sessionToken,
+		"sessionToken": tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	}, http.StatusOK
 } 
\ No newline at end of file
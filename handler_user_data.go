@@ -2,16 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"strings"
-
-	"firebase.google.com/go/v4/auth"
-	"github.com/aws/aws-lambda-go/events"
+	"time"
 )
 
 // UserData はユーザーデータの構造体です
@@ -19,6 +14,8 @@ type UserData struct {
 	UserName  string                    `json:"userName" firestore:"userName"`
 	UserColor string                    `json:"userColor" firestore:"userColor"`
 	UID       string                    `json:"uid" firestore:"uid"`
+	// TimeZone はユーザーのIANAタイムゾーンです。タスク・通知の日付と時刻はこのタイムゾーンで保存します
+	TimeZone  string                    `json:"timeZone,omitempty" firestore:"timeZone,omitempty"`
 	Email     []EmailProviderInfo       `json:"email,omitempty" firestore:"email,omitempty"`
 	Google    []OAuthProviderInfo       `json:"google,omitempty" firestore:"google,omitempty"`
 	GitHub    []OAuthProviderInfo       `json:"github,omitempty" firestore:"github,omitempty"`
@@ -41,6 +38,8 @@ type OAuthProviderInfo struct {
 type UserDataRequest struct {
 	UserName  string `json:"userName"`
 	UserColor string `json:"userColor"`
+	// TimeZone は省略した場合、保存済みのタイムゾーンを変更しません
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 // UserDataResponse はユーザーデータレスポンスの構造体です
@@ -93,53 +92,29 @@ type ProviderDetail struct {
 }
 
 // processUserDataSaveRequest はユーザーデータ保存リクエストを処理します
-func processUserDataSaveRequest(ctx context.Context, req interface{}, token *auth.Token) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processUserDataSaveRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var userData UserDataRequest
-	if err := json.Unmarshal(bodyBytes, &userData); err != nil {
+	if err := req.DecodeJSON(&userData); err != nil {
 		log.Printf("ERROR: Failed to decode JSON: %v\n", err)
 		return map[string]interface{}{"error": "JSONの解析に失敗しました"}, http.StatusBadRequest
 	}
 
 	// バリデーション
-	if userData.UserName == "" {
-		log.Printf("ERROR: UserName is required")
-		return map[string]interface{}{"error": "ユーザー名は必須です"}, http.StatusBadRequest
-	}
-
-	if userData.UserColor == "" {
-		log.Printf("ERROR: UserColor is required")
-		return map[string]interface{}{"error": "ユーザーカラーは必須です"}, http.StatusBadRequest
+	if err := validateUserDataRequest(&userData); err != nil {
+		log.Printf("WARN: Invalid user data for UID %s: %v", principal.UID, err)
+		return validationErrorResponse(err)
 	}
 
 	// 既存のユーザーデータを取得
-	existingUserData, err := getUserDataByUID(ctx, token.UID)
+	existingUserData, err := getUserDataByUID(ctx, principal.UID)
 	if err != nil {
 		log.Printf("WARN: Failed to get existing user data: %v", err)
 		// 既存データが見つからない場合は新規作成
 		existingUserData = &UserData{
 			UserName:  "",
 			UserColor: "#3b82f6",
-			UID:       token.UID,
+			UID:       principal.UID,
 			Email:     []EmailProviderInfo{},
 			Google:    []OAuthProviderInfo{},
 			GitHub:    []OAuthProviderInfo{},
@@ -150,252 +125,65 @@ func processUserDataSaveRequest(ctx context.Context, req interface{}, token *aut
 	// ユーザー名とカラーのみを更新（プロバイダー情報は保持）
 	existingUserData.UserName = userData.UserName
 	existingUserData.UserColor = userData.UserColor
-	existingUserData.UID = token.UID
+	existingUserData.UID = principal.UID
+	if userData.TimeZone != "" {
+		existingUserData.TimeZone = userData.TimeZone
+	}
 
 	// Firestoreに保存
-	if err := saveUserDataToFirestore(ctx, token.UID, existingUserData); err != nil {
+	if err := saveUserData(ctx, principal.UID, existingUserData); err != nil {
 		log.Printf("ERROR: Failed to save user data to Firestore: %v", err)
 		return map[string]interface{}{"error": "ユーザーデータの保存に失敗しました"}, http.StatusInternalServerError
 	}
 
 	log.Printf("INFO: User data saved successfully for UID: %s (UserName: %s, UserColor: %s)", 
-		token.UID, userData.UserName, userData.UserColor)
+		principal.UID, userData.UserName, userData.UserColor)
 	return map[string]interface{}{
 		"message":   "ユーザーデータを保存しました",
 		"userName":  userData.UserName,
 		"userColor": userData.UserColor,
+		"timeZone":  effectiveTimeZone(existingUserData.TimeZone),
 	}, http.StatusOK
 }
 
 // processUserDataGetRequest はユーザーデータ取得リクエストを処理します
-func processUserDataGetRequest(ctx context.Context, token *auth.Token) (map[string]interface{}, int) {
+func processUserDataGetRequest(ctx context.Context, principal *Principal) (map[string]interface{}, int) {
 	// Firestoreからユーザーデータを取得
-	userData, err := getUserDataFromFirestore(ctx, token.UID)
+	userData, err := getUserData(ctx, principal.UID)
 	if err != nil {
 		// データが見つからない場合はデフォルト値を返す
-		log.Printf("INFO: User data not found for UID: %s, returning default values", token.UID)
+		log.Printf("INFO: User data not found for UID: %s, returning default values", principal.UID)
 		return map[string]interface{}{
 			"userName":  "",
 			"userColor": "#3b82f6",
+			"timeZone":  getDefaultTimeZone(),
 		}, http.StatusOK
 	}
 
-	log.Printf("INFO: User data retrieved successfully for UID: %s", token.UID)
+	log.Printf("INFO: User data retrieved successfully for UID: %s", principal.UID)
 	return map[string]interface{}{
 		"userName":  userData.UserName,
 		"userColor": userData.UserColor,
+		"timeZone":  effectiveTimeZone(userData.TimeZone),
 	}, http.StatusOK
 }
 
-// handleUserDataRequest はユーザーデータリクエストのエントリーポイントです
-func handleUserDataRequest(w http.ResponseWriter, r *http.Request) {
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証情報の取得 - セッショントークン用の構造体からUIDを取得
-	tokenValue := r.Context().Value("token")
-	if tokenValue == nil {
-		log.Printf("ERROR: User not authenticated - no token in context")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// セッショントークン用のmockToken構造体からUIDを取得
-		mockToken, ok := tokenValue.(struct{ UID string })
-		if !ok {
-			log.Printf("ERROR: Invalid token type in context")
-			result = map[string]interface{}{"error": "認証が必要です"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			// Firebase auth.Token形式にマッピング（既存のコードとの互換性のため）
-			token := &auth.Token{
-				UID: mockToken.UID,
-			}
-			log.Printf("DEBUG: handleUserDataRequest - Successfully extracted UID: %s", token.UID)
-			
-			switch r.Method {
-			case http.MethodPost:
-				result, statusCode = processUserDataSaveRequest(r.Context(), r, token)
-			case http.MethodGet:
-				result, statusCode = processUserDataGetRequest(r.Context(), token)
-			default:
-				log.Printf("ERROR: Method not allowed: %s", r.Method)
-				result = map[string]interface{}{"error": "許可されていないメソッドです"}
-				statusCode = http.StatusMethodNotAllowed
-			}
-		}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(result)
-}
-
-// lambdaUserDataHandler はLambda環境でのユーザーデータリクエストを処理します
-func lambdaUserDataHandler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証処理
-	authHeader := ""
-	if auth, exists := request.Headers["authorization"]; exists {
-		authHeader = auth
-	} else if auth, exists := request.Headers["Authorization"]; exists {
-		authHeader = auth
-	}
-
-	if authHeader == "" {
-		log.Printf("ERROR: Authorization header missing")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// Bearerトークンの検証
-		token, err := validateAuthHeader(ctx, authHeader)
-		if err != nil {
-			log.Printf("ERROR: Token validation failed: %v", err)
-			result = map[string]interface{}{"error": "認証に失敗しました"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			switch request.RequestContext.HTTP.Method {
-			case http.MethodPost:
-				result, statusCode = processUserDataSaveRequest(ctx, request, token)
-			case http.MethodGet:
-				result, statusCode = processUserDataGetRequest(ctx, token)
-			default:
-				log.Printf("ERROR: Method not allowed: %s", request.RequestContext.HTTP.Method)
-				result = map[string]interface{}{"error": "許可されていないメソッドです"}
-				statusCode = http.StatusMethodNotAllowed
-			}
-		}
-	}
-
-	body, _ := json.Marshal(result)
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       string(body),
-	}, nil
-}
-
-func lambdaUserProvidersHandler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証処理
-	authHeader := ""
-	if auth, exists := request.Headers["authorization"]; exists {
-		authHeader = auth
-	} else if auth, exists := request.Headers["Authorization"]; exists {
-		authHeader = auth
-	}
-
-	if authHeader == "" {
-		log.Printf("ERROR: Authorization header missing")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// Bearerトークンの検証
-		token, err := validateAuthHeader(ctx, authHeader)
-		if err != nil {
-			log.Printf("ERROR: Token validation failed: %v", err)
-			result = map[string]interface{}{"error": "認証に失敗しました"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			result, statusCode = processUserProvidersRequest(ctx, token)
-		}
-	}
-
-	body, _ := json.Marshal(result)
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       string(body),
-	}, nil
-}
-
-func lambdaUserProvidersDetailHandler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	log.Printf("DEBUG: lambdaUserProvidersDetailHandler called")
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証処理
-	authHeader := ""
-	if auth, exists := request.Headers["authorization"]; exists {
-		authHeader = auth
-	} else if auth, exists := request.Headers["Authorization"]; exists {
-		authHeader = auth
-	}
-
-	if authHeader == "" {
-		log.Printf("ERROR: Authorization header missing")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// Bearerトークンの検証
-		token, err := validateAuthHeader(ctx, authHeader)
-		if err != nil {
-			log.Printf("ERROR: Token validation failed: %v", err)
-			result = map[string]interface{}{"error": "認証に失敗しました"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			log.Printf("DEBUG: Calling processUserProvidersDetailRequest for UID: %s", token.UID)
-			result, statusCode = processUserProvidersDetailRequest(ctx, token)
-			log.Printf("DEBUG: processUserProvidersDetailRequest returned result: %+v", result)
-		}
-	}
-
-	body, _ := json.Marshal(result)
-	log.Printf("DEBUG: lambdaUserProvidersDetailHandler returning body: %s", string(body))
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       string(body),
-	}, nil
-}
-
-// validateAuthHeader はAuthorizationヘッダーを検証してFirebase Auth Tokenを返します
-func validateAuthHeader(ctx context.Context, authHeader string) (*auth.Token, error) {
-	// "Bearer " プレフィックスを検証・削除
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return nil, errors.New("invalid authorization header format")
-	}
-	sessionToken := parts[1]
-
-	log.Printf("DEBUG: validateAuthHeader called with token length: %d", len(sessionToken))
-
-	// セッショントークンを検証
-	userSession, err := validateSessionToken(sessionToken)
-	if err != nil {
-		log.Printf("ERROR: Session token validation failed: %v", err)
-		return nil, err
-	}
-
-	log.Printf("DEBUG: Session token validated successfully for UID: %s, Email: %s", userSession.UID, userSession.Email)
-
-	// Firebase auth.Token形式にマッピング（既存のコードとの互換性のため）
-	token := &auth.Token{
-		UID: userSession.UID,
-	}
-
-	return token, nil
-}
-
 // processUserProvidersRequest はユーザープロバイダー情報取得リクエストを処理します
-func processUserProvidersRequest(ctx context.Context, token *auth.Token) (map[string]interface{}, int) {
+func processUserProvidersRequest(ctx context.Context, principal *Principal) (map[string]interface{}, int) {
 	// データベースからユーザーデータを取得
-	userData, err := getUserDataByUID(ctx, token.UID)
+	userData, err := getUserDataByUID(ctx, principal.UID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user data for UID %s: %v", token.UID, err)
+		log.Printf("ERROR: Failed to get user data for UID %s: %v", principal.UID, err)
 		return map[string]interface{}{"error": "ユーザー情報の取得に失敗しました"}, http.StatusInternalServerError
 	}
 	
 	// userDataがnilの場合はデフォルト値を設定
 	if userData == nil {
-		log.Printf("INFO: User data is nil for UID %s, using default values", token.UID)
+		log.Printf("INFO: User data is nil for UID %s, using default values", principal.UID)
 		userData = &UserData{
 			UserName:  "",
 			UserColor: "#3b82f6",
-			UID:       token.UID,
+			UID:       principal.UID,
 			Email:     []EmailProviderInfo{},
 			Google:    []OAuthProviderInfo{},
 			GitHub:    []OAuthProviderInfo{},
@@ -432,30 +220,30 @@ func processUserProvidersRequest(ctx context.Context, token *auth.Token) (map[st
 		log.Printf("DEBUG: Added Twitter provider")
 	}
 
-	log.Printf("INFO: User providers retrieved for UID: %s, providers: %v", token.UID, providers)
+	log.Printf("INFO: User providers retrieved for UID: %s, providers: %v", principal.UID, providers)
 	return map[string]interface{}{
 		"providers": providers,
 	}, http.StatusOK
 }
 
 // processUserProvidersDetailRequest はユーザープロバイダー詳細情報取得リクエストを処理します
-func processUserProvidersDetailRequest(ctx context.Context, token *auth.Token) (map[string]interface{}, int) {
-	log.Printf("DEBUG: processUserProvidersDetailRequest called for UID: %s", token.UID)
+func processUserProvidersDetailRequest(ctx context.Context, principal *Principal) (map[string]interface{}, int) {
+	log.Printf("DEBUG: processUserProvidersDetailRequest called for UID: %s", principal.UID)
 	
 	// データベースからユーザーデータを取得
-	userData, err := getUserDataByUID(ctx, token.UID)
+	userData, err := getUserDataByUID(ctx, principal.UID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user data for UID %s: %v", token.UID, err)
+		log.Printf("ERROR: Failed to get user data for UID %s: %v", principal.UID, err)
 		return map[string]interface{}{"error": "ユーザー情報の取得に失敗しました"}, http.StatusInternalServerError
 	}
 	
 	// userDataがnilの場合はデフォルト値を設定
 	if userData == nil {
-		log.Printf("INFO: User data is nil for UID %s, using default values", token.UID)
+		log.Printf("INFO: User data is nil for UID %s, using default values", principal.UID)
 		userData = &UserData{
 			UserName:  "",
 			UserColor: "#3b82f6",
-			UID:       token.UID,
+			UID:       principal.UID,
 			Email:     []EmailProviderInfo{},
 			Google:    []OAuthProviderInfo{},
 			GitHub:    []OAuthProviderInfo{},
@@ -524,7 +312,7 @@ func processUserProvidersDetailRequest(ctx context.Context, token *auth.Token) (
 		}
 	}
 
-	log.Printf("INFO: User provider details retrieved for UID: %s, providers: %d", token.UID, len(providerDetails))
+	log.Printf("INFO: User provider details retrieved for UID: %s, providers: %d", principal.UID, len(providerDetails))
 	
 	result := map[string]interface{}{
 		"providers": providerDetails,
@@ -535,18 +323,18 @@ func processUserProvidersDetailRequest(ctx context.Context, token *auth.Token) (
 }
 
 // processUserProfileRequest は統合されたユーザープロフィール情報取得リクエストを処理します
-func processUserProfileRequest(ctx context.Context, token *auth.Token) (map[string]interface{}, int) {
-	log.Printf("DEBUG: processUserProfileRequest called for UID: %s", token.UID)
+func processUserProfileRequest(ctx context.Context, principal *Principal) (map[string]interface{}, int) {
+	log.Printf("DEBUG: processUserProfileRequest called for UID: %s", principal.UID)
 	
 	// 1. データベースからユーザーデータを取得
-	userData, err := getUserDataByUID(ctx, token.UID)
+	userData, err := getUserDataByUID(ctx, principal.UID)
 	if err != nil {
-		log.Printf("WARN: Failed to get user data for UID %s: %v", token.UID, err)
+		log.Printf("WARN: Failed to get user data for UID %s: %v", principal.UID, err)
 		// ユーザーデータが存在しない場合はデフォルト値を設定
 		userData = &UserData{
 			UserName:  "",
 			UserColor: "#3b82f6",
-			UID:       token.UID,
+			UID:       principal.UID,
 			Email:     []EmailProviderInfo{},
 			Google:    []OAuthProviderInfo{},
 			GitHub:    []OAuthProviderInfo{},
@@ -621,11 +409,12 @@ func processUserProfileRequest(ctx context.Context, token *auth.Token) (map[stri
 		}
 	}
 
-	log.Printf("INFO: User profile retrieved for UID: %s, providers: %d", token.UID, len(providers))
+	log.Printf("INFO: User profile retrieved for UID: %s, providers: %d", principal.UID, len(providers))
 	
 	result := map[string]interface{}{
 		"userName":        userData.UserName,
 		"userColor":       userData.UserColor,
+		"timeZone":        effectiveTimeZone(userData.TimeZone),
 		"providers":       providers,
 		"providerDetails": providerDetails,
 	}
@@ -634,70 +423,11 @@ func processUserProfileRequest(ctx context.Context, token *auth.Token) (map[stri
 	return result, http.StatusOK
 }
 
-// handleUserProfileRequest は統合されたユーザープロフィール情報取得リクエストのエントリーポイントです
-func handleUserProfileRequest(w http.ResponseWriter, r *http.Request) {
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証情報の取得 - セッショントークン用の構造体からUIDを取得
-	tokenValue := r.Context().Value("token")
-	if tokenValue == nil {
-		log.Printf("ERROR: User not authenticated - no token in context")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// セッショントークン用のmockToken構造体からUIDを取得
-		mockToken, ok := tokenValue.(struct{ UID string })
-		if !ok {
-			log.Printf("ERROR: Invalid token type in context")
-			result = map[string]interface{}{"error": "認証が必要です"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			// Firebase auth.Token形式にマッピング（既存のコードとの互換性のため）
-			token := &auth.Token{
-				UID: mockToken.UID,
-			}
-			log.Printf("DEBUG: handleUserProfileRequest - Successfully extracted UID: %s", token.UID)
-			
-			if r.Method == http.MethodGet {
-				result, statusCode = processUserProfileRequest(r.Context(), token)
-			} else {
-				log.Printf("ERROR: Method not allowed: %s", r.Method)
-				result = map[string]interface{}{"error": "許可されていないメソッドです"}
-				statusCode = http.StatusMethodNotAllowed
-			}
-		}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(result)
-}
-
 // processLinkAccountRequest はアカウントリンクリクエストを処理します
-func processLinkAccountRequest(ctx context.Context, req interface{}, token *auth.Token) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processLinkAccountRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var linkData LinkAccountRequest
-	if err := json.Unmarshal(bodyBytes, &linkData); err != nil {
+	if err := req.DecodeJSON(&linkData); err != nil {
 		log.Printf("ERROR: Failed to decode JSON: %v\n", err)
 		return map[string]interface{}{"error": "JSONの解析に失敗しました"}, http.StatusBadRequest
 	}
@@ -708,17 +438,15 @@ func processLinkAccountRequest(ctx context.Context, req interface{}, token *auth
 	}
 
 	// 現在のユーザーを取得
-	userRecord, err := authClient.GetUser(ctx, token.UID)
+	userRecord, err := identityProvider.GetUser(ctx, principal.UID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user record for UID %s: %v", token.UID, err)
+		log.Printf("ERROR: Failed to get user record for UID %s: %v", principal.UID, err)
 		return map[string]interface{}{"error": "ユーザー情報の取得に失敗しました"}, http.StatusInternalServerError
 	}
 
 	// 既にリンクされているかチェック
-	for _, provider := range userRecord.ProviderUserInfo {
-		if provider.ProviderID == linkData.Provider {
-			return map[string]interface{}{"error": "このプロバイダーは既にリンクされています"}, http.StatusBadRequest
-		}
+	if userRecord.HasProvider(linkData.Provider) {
+		return map[string]interface{}{"error": "このプロバイダーは既にリンクされています"}, http.StatusBadRequest
 	}
 
 	// プロバイダーに応じたリンク処理
@@ -737,40 +465,21 @@ func processLinkAccountRequest(ctx context.Context, req interface{}, token *auth
 	}
 
 	if err != nil {
-		log.Printf("ERROR: Failed to link account for UID %s: %v", token.UID, err)
+		log.Printf("ERROR: Failed to link account for UID %s: %v", principal.UID, err)
 		return map[string]interface{}{"error": "アカウントのリンクに失敗しました"}, http.StatusInternalServerError
 	}
 
-	log.Printf("INFO: Account linked successfully for UID: %s, provider: %s", token.UID, linkData.Provider)
+	log.Printf("INFO: Account linked successfully for UID: %s, provider: %s", principal.UID, linkData.Provider)
 	return map[string]interface{}{
 		"success": true,
 	}, http.StatusOK
 }
 
 // processUnlinkAccountRequest はアカウント解除リクエストを処理します
-func processUnlinkAccountRequest(ctx context.Context, req interface{}, token *auth.Token) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func processUnlinkAccountRequest(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var unlinkData UnlinkAccountRequest
-	if err := json.Unmarshal(bodyBytes, &unlinkData); err != nil {
+	if err := req.DecodeJSON(&unlinkData); err != nil {
 		log.Printf("ERROR: Failed to decode JSON: %v\n", err)
 		return map[string]interface{}{"error": "JSONの解析に失敗しました"}, http.StatusBadRequest
 	}
@@ -781,39 +490,45 @@ func processUnlinkAccountRequest(ctx context.Context, req interface{}, token *au
 	}
 
 	// 現在のユーザーを取得
-	userRecord, err := authClient.GetUser(ctx, token.UID)
+	userRecord, err := identityProvider.GetUser(ctx, principal.UID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user record for UID %s: %v", token.UID, err)
+		log.Printf("ERROR: Failed to get user record for UID %s: %v", principal.UID, err)
 		return map[string]interface{}{"error": "ユーザー情報の取得に失敗しました"}, http.StatusInternalServerError
 	}
 
 	// プロバイダーがリンクされているかチェック
-	var foundProvider bool
-	for _, provider := range userRecord.ProviderUserInfo {
-		if provider.ProviderID == unlinkData.Provider {
-			foundProvider = true
-			break
-		}
-	}
-
-	if !foundProvider {
+	if !userRecord.HasProvider(unlinkData.Provider) {
 		return map[string]interface{}{"error": "このプロバイダーはリンクされていません"}, http.StatusBadRequest
 	}
 
 	// 最後のプロバイダーを解除しようとしている場合はエラー
-	if len(userRecord.ProviderUserInfo) <= 1 {
+	if len(userRecord.ProviderIDs) <= 1 {
 		return map[string]interface{}{"error": "最後の認証方法は解除できません"}, http.StatusBadRequest
 	}
 
-	// プロバイダーを解除（Firebase Admin SDKでは直接的なUnlinkProviderメソッドがないため、
-	// ユーザー情報を更新してプロバイダーを削除する方法を使用）
-	// 注意：実際の実装では、Firebase Admin SDKの適切なメソッドを使用する必要があります
-	log.Printf("WARN: UnlinkProvider not implemented in current Firebase Admin SDK version")
-	return map[string]interface{}{"error": "アカウント解除機能は現在実装中です"}, http.StatusNotImplemented
+	// プロバイダーを解除
+	update := &IdentityUserToUpdate{ProvidersToDelete: []string{unlinkData.Provider}}
+	if _, err := identityProvider.UpdateUser(ctx, principal.UID, update); err != nil {
+		log.Printf("ERROR: Failed to unlink provider %s for UID %s: %v", unlinkData.Provider, principal.UID, err)
+		return map[string]interface{}{"error": "アカウントの解除に失敗しました"}, http.StatusInternalServerError
+	}
+
+	// 解除したプロバイダーで発行されたセッションが残らないよう、現在のセッション以外を失効させる
+	revokedCount, err := revokeOtherSessions(ctx, principal.UID, principal.SessionID)
+	if err != nil {
+		log.Printf("ERROR: Failed to revoke sessions after unlinking for UID %s: %v", principal.UID, err)
+		return map[string]interface{}{"error": "セッションの失効に失敗しました"}, http.StatusInternalServerError
+	}
+
+	log.Printf("INFO: Account unlinked for UID: %s, provider: %s, revoked sessions: %d", principal.UID, unlinkData.Provider, revokedCount)
+	return map[string]interface{}{
+		"success": true,
+		"revoked": revokedCount,
+	}, http.StatusOK
 }
 
 // linkGoogleAccount はGoogleアカウントをリンクします
-func linkGoogleAccount(ctx context.Context, userRecord *auth.UserRecord, credential string) error {
+func linkGoogleAccount(ctx context.Context, userRecord *IdentityUser, credential string) error {
 	log.Printf("INFO: Linking Google account for user: %s", userRecord.UID)
 	
 	// 既存のユーザーデータを取得
@@ -836,7 +551,7 @@ func linkGoogleAccount(ctx context.Context, userRecord *auth.UserRecord, credent
 	addOAuthProvider(userData, "google", userRecord.Email, userRecord.UID)
 	
 	// ユーザーデータを保存
-	if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+	if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 		return fmt.Errorf("failed to save user data: %v", err)
 	}
 	
@@ -845,7 +560,7 @@ func linkGoogleAccount(ctx context.Context, userRecord *auth.UserRecord, credent
 }
 
 // linkGitHubAccount はGitHubアカウントをリンクします
-func linkGitHubAccount(ctx context.Context, userRecord *auth.UserRecord, credential string) error {
+func linkGitHubAccount(ctx context.Context, userRecord *IdentityUser, credential string) error {
 	log.Printf("INFO: Linking GitHub account for user: %s", userRecord.UID)
 	
 	// 既存のユーザーデータを取得
@@ -868,7 +583,7 @@ func linkGitHubAccount(ctx context.Context, userRecord *auth.UserRecord, credent
 	addOAuthProvider(userData, "github", userRecord.Email, userRecord.UID)
 	
 	// ユーザーデータを保存
-	if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+	if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 		return fmt.Errorf("failed to save user data: %v", err)
 	}
 	
@@ -877,7 +592,7 @@ func linkGitHubAccount(ctx context.Context, userRecord *auth.UserRecord, credent
 }
 
 // linkTwitterAccount はTwitterアカウントをリンクします
-func linkTwitterAccount(ctx context.Context, userRecord *auth.UserRecord, credential string) error {
+func linkTwitterAccount(ctx context.Context, userRecord *IdentityUser, credential string) error {
 	log.Printf("INFO: Linking Twitter account for user: %s", userRecord.UID)
 	
 	// 既存のユーザーデータを取得
@@ -900,7 +615,7 @@ func linkTwitterAccount(ctx context.Context, userRecord *auth.UserRecord, creden
 	addOAuthProvider(userData, "twitter", userRecord.Email, userRecord.UID)
 	
 	// ユーザーデータを保存
-	if err := saveUserDataToFirestore(ctx, userRecord.UID, userData); err != nil {
+	if err := saveUserData(ctx, userRecord.UID, userData); err != nil {
 		return fmt.Errorf("failed to save user data: %v", err)
 	}
 	
@@ -910,59 +625,14 @@ func linkTwitterAccount(ctx context.Context, userRecord *auth.UserRecord, creden
 
 // findOrCreateUserDataByEmail はメールアドレスでユーザーデータを検索または作成します
 func findOrCreateUserDataByEmail(ctx context.Context, email string) (*UserData, error) {
-	// Firestoreからメールアドレスでユーザーデータを検索
-	usersRef := firestoreClient.Collection("users")
-	
-	// メールアドレスプロバイダーで検索
-	query := usersRef.Where("email.emailAddress", "==", email).Limit(1)
-	docs, err := query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found existing user data by email provider for: %s (UserName: %s, UserColor: %s)", 
-			email, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-	
-	// Googleプロバイダーで検索
-	query = usersRef.Where("google.emailAddress", "==", email).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found existing user data by Google provider for: %s (UserName: %s, UserColor: %s)", 
-			email, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-	
-	// GitHubプロバイダーで検索
-	query = usersRef.Where("github.emailAddress", "==", email).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found existing user data by GitHub provider for: %s (UserName: %s, UserColor: %s)", 
-			email, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-	
-	// Twitterプロバイダーで検索
-	query = usersRef.Where("twitter.emailAddress", "==", email).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
+	// メールアドレス、Google、GitHub、Twitterの順にプロバイダーで検索
+	for _, provider := range []string{"email", "google", "github", "twitter"} {
+		userData, err := dataStore.FindUserByProvider(ctx, provider, "emailAddress", email)
+		if err == nil {
+			log.Printf("INFO: Found existing user data by %s provider for: %s (UserName: %s, UserColor: %s)", 
+				provider, email, userData.UserName, userData.UserColor)
+			return userData, nil
 		}
-		log.Printf("INFO: Found existing user data by Twitter provider for: %s (UserName: %s, UserColor: %s)", 
-			email, userData.UserName, userData.UserColor)
-		return &userData, nil
 	}
 
 	// 既存のユーザーデータが見つからない場合はnilを返す（新しいユーザーデータは呼び出し元で作成）
@@ -1023,80 +693,49 @@ func addOAuthProvider(userData *UserData, provider string, email, uid string) {
 		provider, email, uid, userData.UserName, userData.UserColor)
 }
 
+// userTimeZone はユーザーのタイムゾーンを返します。未設定またはユーザーデータがない場合は既定のタイムゾーンです
+func userTimeZone(ctx context.Context, uid string) *time.Location {
+	name := ""
+	if userData, err := getUserDataByUID(ctx, uid); err == nil && userData != nil {
+		name = userData.TimeZone
+	}
+	loc, err := loadTimeZone(name)
+	if err != nil {
+		log.Printf("WARN: Invalid time zone %q for UID %s: %v", name, uid, err)
+		loc, _ = loadTimeZone("")
+	}
+	return loc
+}
+
 // getUserDataByUID はUIDでユーザーデータを取得します
 func getUserDataByUID(ctx context.Context, uid string) (*UserData, error) {
 	log.Printf("DEBUG: getUserDataByUID called for UID: %s", uid)
 	
 	// まず、UIDで直接検索
-	usersRef := firestoreClient.Collection("users")
-	doc, err := usersRef.Doc(uid).Get(ctx)
+	userData, err := dataStore.GetUser(ctx, uid)
 	if err == nil {
-		var userData UserData
-		if err := doc.DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
 		log.Printf("INFO: Found user data by UID for: %s (UserName: %s, UserColor: %s)", 
 			uid, userData.UserName, userData.UserColor)
 		log.Printf("DEBUG: GitHub providers count: %d", len(userData.GitHub))
 		if len(userData.GitHub) > 0 {
 			log.Printf("DEBUG: First GitHub provider: %+v", userData.GitHub[0])
 		}
-		return &userData, nil
+		return userData, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf("WARN: Failed to get user data by UID %s: %v", uid, err)
 	}
 	
 	log.Printf("INFO: User data not found by UID: %s, searching by provider UID", uid)
 
-	// UIDで見つからない場合、各プロバイダーで検索
-	// Googleプロバイダーで検索
-	query := usersRef.Where("google.userUID", "==", uid).Limit(1)
-	docs, err := query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
+	// UIDで見つからない場合、Google、GitHub、Twitter、メールアドレスの順に各プロバイダーで検索
+	for _, provider := range []string{"google", "github", "twitter", "email"} {
+		userData, err := dataStore.FindUserByProvider(ctx, provider, "userUID", uid)
+		if err == nil {
+			log.Printf("INFO: Found user data by %s provider UID for: %s (UserName: %s, UserColor: %s)", 
+				provider, uid, userData.UserName, userData.UserColor)
+			return userData, nil
 		}
-		log.Printf("INFO: Found user data by Google provider UID for: %s (UserName: %s, UserColor: %s)", 
-			uid, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-
-	// GitHubプロバイダーで検索
-	query = usersRef.Where("github.userUID", "==", uid).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found user data by GitHub provider UID for: %s (UserName: %s, UserColor: %s)", 
-			uid, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-
-	// Twitterプロバイダーで検索
-	query = usersRef.Where("twitter.userUID", "==", uid).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found user data by Twitter provider UID for: %s (UserName: %s, UserColor: %s)", 
-			uid, userData.UserName, userData.UserColor)
-		return &userData, nil
-	}
-
-	// メールアドレスプロバイダーで検索
-	query = usersRef.Where("email.userUID", "==", uid).Limit(1)
-	docs, err = query.Documents(ctx).GetAll()
-	if err == nil && len(docs) > 0 {
-		var userData UserData
-		if err := docs[0].DataTo(&userData); err != nil {
-			return nil, fmt.Errorf("failed to parse user data: %v", err)
-		}
-		log.Printf("INFO: Found user data by email provider UID for: %s (UserName: %s, UserColor: %s)", 
-			uid, userData.UserName, userData.UserColor)
-		return &userData, nil
 	}
 
 	// ユーザーデータが見つからない場合はデフォルト値を返す
@@ -1113,47 +752,3 @@ func getUserDataByUID(ctx context.Context, uid string) (*UserData, error) {
 	return defaultUserData, nil
 }
 
-// lambdaUserProfileHandler はLambda環境での統合されたユーザープロフィール情報取得リクエストを処理します
-func lambdaUserProfileHandler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	var result map[string]interface{}
-	var statusCode int
-
-	// 認証処理
-	authHeader := ""
-	if auth, exists := request.Headers["authorization"]; exists {
-		authHeader = auth
-	} else if auth, exists := request.Headers["Authorization"]; exists {
-		authHeader = auth
-	}
-
-	if authHeader == "" {
-		log.Printf("ERROR: Authorization header missing")
-		result = map[string]interface{}{"error": "認証が必要です"}
-		statusCode = http.StatusUnauthorized
-	} else {
-		// Bearerトークンの検証
-		token, err := validateAuthHeader(ctx, authHeader)
-		if err != nil {
-			log.Printf("ERROR: Token validation failed: %v", err)
-			result = map[string]interface{}{"error": "認証に失敗しました"}
-			statusCode = http.StatusUnauthorized
-		} else {
-			if request.RequestContext.HTTP.Method == http.MethodGet {
-				result, statusCode = processUserProfileRequest(ctx, token)
-			} else {
-				log.Printf("ERROR: Method not allowed: %s", request.RequestContext.HTTP.Method)
-				result = map[string]interface{}{"error": "許可されていないメソッドです"}
-				statusCode = http.StatusMethodNotAllowed
-			}
-		}
-	}
-
-	body, _ := json.Marshal(result)
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       string(body),
-	}, nil
-}
-
-
@@ -2,12 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"io"
 	"log"
 	"net/http"
-
-	"github.com/aws/aws-lambda-go/events"
 )
 
 // VerifyRequest は認証リクエストの構造体です
@@ -24,30 +20,11 @@ type VerifyResponse struct {
 }
 
 // ProcessVerifyRequest は認証リクエストを処理します
-func ProcessVerifyRequest(ctx context.Context, req interface{}) (map[string]interface{}, int) {
-	var bodyBytes []byte
-	var err error
-
-	// リクエストソース（ローカルサーバー or Lambda）に応じてリクエストボディをバイトスライスとして取得
-	switch r := req.(type) {
-	case *http.Request:
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read request body: %v\n", err)
-			return map[string]interface{}{"error": "リクエストの処理に失敗しました"}, http.StatusInternalServerError
-		}
-		defer r.Body.Close()
-	case events.APIGatewayV2HTTPRequest:
-		bodyBytes = []byte(r.Body)
-	default:
-		log.Printf("ERROR: Unknown request type: %T\n", r)
-		return map[string]interface{}{"error": "不明なリクエストタイプです"}, http.StatusInternalServerError
-	}
-
+func ProcessVerifyRequest(ctx context.Context, req *Request) (map[string]interface{}, int) {
 	// JSONを構造体にデコード
 	var verifyData VerifyRequest
-	if err := json.Unmarshal(bodyBytes, &verifyData); err != nil {
-		log.Printf("WARN: Failed to parse verify JSON: %v. Body: %s", err, string(bodyBytes))
+	if err := req.DecodeJSON(&verifyData); err != nil {
+		log.Printf("WARN: Failed to parse verify JSON: %v. Body: %s", err, string(req.Body))
 		return map[string]interface{}{"error": "リクエストされたJSONの形式が正しくありません。"}, http.StatusBadRequest
 	}
 
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// 認証プロバイダーが返す共通のエラーです
+var (
+	ErrIdentityNotFound   = errors.New("identity not found")
+	ErrEmailAlreadyExists = errors.New("email already exists")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserDisabled       = errors.New("user disabled")
+	ErrTooManyAttempts    = errors.New("too many attempts")
+)
+
+// IdentityUser は認証プロバイダーが管理するユーザー情報です
+type IdentityUser struct {
+	UID           string   `json:"uid" firestore:"uid"`
+	Email         string   `json:"email" firestore:"email"`
+	DisplayName   string   `json:"displayName,omitempty" firestore:"displayName,omitempty"`
+	PhotoURL      string   `json:"photoURL,omitempty" firestore:"photoURL,omitempty"`
+	EmailVerified bool     `json:"emailVerified" firestore:"emailVerified"`
+	Disabled      bool     `json:"disabled,omitempty" firestore:"disabled,omitempty"`
+	ProviderIDs   []string `json:"providerIds,omitempty" firestore:"providerIds,omitempty"` // "password", "google.com" など
+}
+
+// HasProvider はユーザーに指定されたプロバイダーが紐づいているかを返します
+func (u *IdentityUser) HasProvider(providerID string) bool {
+	for _, id := range u.ProviderIDs {
+		if id == providerID {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityUserToCreate はユーザー作成時のパラメータです
+// UIDが空の場合はプロバイダーが払い出します。Passwordが空の場合はパスワードなしで作成します
+type IdentityUserToCreate struct {
+	UID           string
+	Email         string
+	Password      string
+	DisplayName   string
+	PhotoURL      string
+	EmailVerified bool
+}
+
+// IdentityUserToUpdate はユーザー更新時のパラメータです。nilのフィールドは更新しません
+type IdentityUserToUpdate struct {
+	DisplayName   *string
+	PhotoURL      *string
+	EmailVerified *bool
+	// ProvidersToDelete はリンクを解除するプロバイダーのIDです（"google.com" など）
+	ProvidersToDelete []string
+}
+
+// IdentityProvider はユーザーの作成・認証・管理を行う認証基盤を抽象化します
+type IdentityProvider interface {
+	CreateUser(ctx context.Context, params *IdentityUserToCreate) (*IdentityUser, error)
+	GetUser(ctx context.Context, uid string) (*IdentityUser, error)
+	GetUserByEmail(ctx context.Context, email string) (*IdentityUser, error)
+	UpdateUser(ctx context.Context, uid string, params *IdentityUserToUpdate) (*IdentityUser, error)
+	DeleteUser(ctx context.Context, uid string) error
+	// VerifyPassword はメールアドレスとパスワードを検証し、成功した場合はユーザーを返します
+	VerifyPassword(ctx context.Context, email, password string) (*IdentityUser, error)
+	// VerifyIDToken はクライアントが提示したIDトークンを検証し、認証済みのPrincipalを返します
+	VerifyIDToken(ctx context.Context, idToken string) (*Principal, error)
+}
+
+// identityProvider はプロジェクト全体で共有する認証プロバイダーです
+// firestore_client.go の init() で AUTH_PROVIDER に応じて設定されます
+var identityProvider IdentityProvider
+
+const (
+	authProviderFirebase = "firebase"
+	authProviderLocal    = "local"
+)
+
+// getAuthProviderName は環境変数 AUTH_PROVIDER から使用する認証プロバイダーを取得します
+// 未設定の場合、Firebaseが利用できればFirebase、利用できなければローカルを使用します
+func getAuthProviderName() string {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_PROVIDER")))
+	if name != "" {
+		return name
+	}
+	if authClient != nil {
+		return authProviderFirebase
+	}
+	return authProviderLocal
+}
+
+// newIdentityProvider は指定された名前の認証プロバイダーを生成します
+func newIdentityProvider(name string, store LocalIdentityStore) (IdentityProvider, error) {
+	switch name {
+	case authProviderFirebase:
+		if authClient == nil {
+			return nil, errors.New("Firebase Authを使用するには GOOGLE_APPLICATION_CREDENTIALS_JSON が必要です")
+		}
+		return newFirebaseIdentityProvider(authClient), nil
+	case authProviderLocal:
+		log.Println("Using local identity provider.")
+		return newLocalIdentityProvider(store), nil
+	default:
+		return nil, fmt.Errorf("unknown auth provider: %s", name)
+	}
+}
+
+// isUserEmailVerified はユーザーのメールアドレスが既に認証済みかどうかをチェックします
+func isUserEmailVerified(ctx context.Context, uid string) (bool, error) {
+	user, err := identityProvider.GetUser(ctx, uid)
+	if err != nil {
+		log.Printf("ERROR: Failed to get user record: %v", err)
+		return false, err
+	}
+
+	return user.EmailVerified, nil
+}
+
+// markUserAsVerified はユーザーをメール認証済みとしてマークします
+func markUserAsVerified(ctx context.Context, uid string) error {
+	verified := true
+	if _, err := identityProvider.UpdateUser(ctx, uid, &IdentityUserToUpdate{EmailVerified: &verified}); err != nil {
+		return fmt.Errorf("ユーザーの認証状態の更新に失敗しました: %v", err)
+	}
+
+	log.Printf("INFO: User %s marked as email verified", uid)
+	return nil
+}
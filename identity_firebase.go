@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// firebaseIdentityProvider はFirebase Authenticationを使用する IdentityProvider の実装です
+type firebaseIdentityProvider struct {
+	client *auth.Client
+}
+
+func newFirebaseIdentityProvider(client *auth.Client) *firebaseIdentityProvider {
+	return &firebaseIdentityProvider{client: client}
+}
+
+// toIdentityUser はFirebaseのユーザーレコードを IdentityUser に変換します
+func toIdentityUser(record *auth.UserRecord) *IdentityUser {
+	user := &IdentityUser{
+		UID:           record.UID,
+		Email:         record.Email,
+		DisplayName:   record.DisplayName,
+		PhotoURL:      record.PhotoURL,
+		EmailVerified: record.EmailVerified,
+		Disabled:      record.Disabled,
+	}
+	for _, provider := range record.ProviderUserInfo {
+		user.ProviderIDs = append(user.ProviderIDs, provider.ProviderID)
+	}
+	return user
+}
+
+// wrapFirebaseAuthError はFirebaseのエラーを共通のエラーに変換します
+func wrapFirebaseAuthError(err error) error {
+	switch {
+	case auth.IsUserNotFound(err):
+		return fmt.Errorf("%w: %v", ErrIdentityNotFound, err)
+	case auth.IsEmailAlreadyExists(err):
+		return fmt.Errorf("%w: %v", ErrEmailAlreadyExists, err)
+	}
+	return err
+}
+
+func (p *firebaseIdentityProvider) CreateUser(ctx context.Context, params *IdentityUserToCreate) (*IdentityUser, error) {
+	toCreate := (&auth.UserToCreate{}).
+		Email(params.Email).
+		EmailVerified(params.EmailVerified)
+	if params.UID != "" {
+		toCreate = toCreate.UID(params.UID)
+	}
+	if params.Password != "" {
+		toCreate = toCreate.Password(params.Password)
+	}
+	if params.DisplayName != "" {
+		toCreate = toCreate.DisplayName(params.DisplayName)
+	}
+	if params.PhotoURL != "" {
+		toCreate = toCreate.PhotoURL(params.PhotoURL)
+	}
+
+	record, err := p.client.CreateUser(ctx, toCreate)
+	if err != nil {
+		return nil, wrapFirebaseAuthError(err)
+	}
+	return toIdentityUser(record), nil
+}
+
+func (p *firebaseIdentityProvider) GetUser(ctx context.Context, uid string) (*IdentityUser, error) {
+	record, err := p.client.GetUser(ctx, uid)
+	if err != nil {
+		return nil, wrapFirebaseAuthError(err)
+	}
+	return toIdentityUser(record), nil
+}
+
+func (p *firebaseIdentityProvider) GetUserByEmail(ctx context.Context, email string) (*IdentityUser, error) {
+	record, err := p.client.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, wrapFirebaseAuthError(err)
+	}
+	return toIdentityUser(record), nil
+}
+
+func (p *firebaseIdentityProvider) UpdateUser(ctx context.Context, uid string, params *IdentityUserToUpdate) (*IdentityUser, error) {
+	toUpdate := &auth.UserToUpdate{}
+	if params.DisplayName != nil {
+		toUpdate = toUpdate.DisplayName(*params.DisplayName)
+	}
+	if params.PhotoURL != nil {
+		toUpdate = toUpdate.PhotoURL(*params.PhotoURL)
+	}
+	if params.EmailVerified != nil {
+		toUpdate = toUpdate.EmailVerified(*params.EmailVerified)
+	}
+	if len(params.ProvidersToDelete) > 0 {
+		toUpdate = toUpdate.ProvidersToDelete(params.ProvidersToDelete)
+	}
+
+	record, err := p.client.UpdateUser(ctx, uid, toUpdate)
+	if err != nil {
+		return nil, wrapFirebaseAuthError(err)
+	}
+	return toIdentityUser(record), nil
+}
+
+func (p *firebaseIdentityProvider) DeleteUser(ctx context.Context, uid string) error {
+	return wrapFirebaseAuthError(p.client.DeleteUser(ctx, uid))
+}
+
+// VerifyPassword はFirebase Auth REST APIを使用してパスワード認証を行います
+func (p *firebaseIdentityProvider) VerifyPassword(ctx context.Context, email, password string) (*IdentityUser, error) {
+	authResponse, err := verifyPasswordWithFirebase(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// エラーチェック
+	if errorInfo, ok := authResponse["error"].(map[string]interface{}); ok {
+		errorMessage, _ := errorInfo["message"].(string)
+		log.Printf("WARN: Firebase auth failed for email=%s. Reason: %s\n", email, errorMessage)
+
+		switch errorMessage {
+		case "TOO_MANY_ATTEMPTS_TRY_LATER":
+			return nil, ErrTooManyAttempts
+		case "USER_DISABLED":
+			return nil, ErrUserDisabled
+		default:
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	localId, _ := authResponse["localId"].(string)
+	responseEmail, _ := authResponse["email"].(string)
+	if localId == "" {
+		return nil, fmt.Errorf("認証レスポンスにUIDが含まれていません")
+	}
+
+	return &IdentityUser{UID: localId, Email: responseEmail}, nil
+}
+
+func (p *firebaseIdentityProvider) VerifyIDToken(ctx context.Context, idToken string) (*Principal, error) {
+	token, err := p.client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	email, _ := token.Claims["email"].(string)
+	return &Principal{
+		UID:    token.UID,
+		Email:  email,
+		Method: authMethodFirebaseIDToken,
+	}, nil
+}
+
+// getFirebaseAPIKey は環境変数からFirebase APIキーを取得します
+func getFirebaseAPIKey() string {
+	// 環境変数からAPIキーを取得
+	apiKey := os.Getenv("FIREBASE_API_KEY")
+	if apiKey != "" {
+		log.Printf("DEBUG: Firebase API key found in environment variable")
+		return apiKey
+	}
+
+	log.Printf("WARN: FIREBASE_API_KEY environment variable not set")
+
+	// ローカル開発用のデフォルト値（本番環境では必ず環境変数を設定してください）
+	// 注意: この値は実際のFirebaseプロジェクトのAPIキーに置き換える必要があります
+	defaultKey := "AIzaSyBxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
+	log.Printf("WARN: Using default API key (this will not work in production): %s", defaultKey[:20]+"...")
+	return defaultKey
+}
+
+// verifyPasswordWithFirebase はFirebase Auth REST APIを使用してパスワード認証を行います
+func verifyPasswordWithFirebase(email, password string) (map[string]interface{}, error) {
+	apiKey := getFirebaseAPIKey()
+	if apiKey == "" {
+		return nil, fmt.Errorf("firebase APIキーが設定されていません")
+	}
+
+	url := "https://identitytoolkit.googleapis.com/v1/accounts:signInWithPassword?key=" + apiKey
+
+	// 認証リクエストの作成
+	authRequest := map[string]interface{}{
+		"email":             email,
+		"password":          password,
+		"returnSecureToken": true,
+	}
+
+	authRequestJSON, err := json.Marshal(authRequest)
+	if err != nil {
+		return nil, fmt.Errorf("認証リクエストのJSON化エラー: %v", err)
+	}
+
+	// Firebase Auth REST APIにリクエストを送信
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(authRequestJSON)))
+	if err != nil {
+		return nil, fmt.Errorf("firebase auth APIリクエストエラー: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// レスポンスを読み取り
+	var authResponse map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
+		return nil, fmt.Errorf("認証レスポンスの解析エラー: %v", err)
+	}
+
+	return authResponse, nil
+}
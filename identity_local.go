@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// LocalIdentity はローカル認証プロバイダーが保存するユーザー情報です
+// パスワードは password_hasher.go のPHC文字列で PasswordHash に保持します
+// PasswordSalt は従来のSHA-256形式のハッシュにのみ使用し、次回ログイン時に再ハッシュして空にします
+type LocalIdentity struct {
+	IdentityUser
+	PasswordHash string    `json:"passwordHash,omitempty" firestore:"passwordHash,omitempty"`
+	PasswordSalt string    `json:"passwordSalt,omitempty" firestore:"passwordSalt,omitempty"`
+	CreatedAt    time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+// LocalIdentityStore はローカル認証プロバイダーのユーザー情報の永続化を扱います
+type LocalIdentityStore interface {
+	GetIdentity(ctx context.Context, uid string) (*LocalIdentity, error)
+	FindIdentityByEmail(ctx context.Context, email string) (*LocalIdentity, error)
+	// CreateIdentity はユーザーを新規作成します。メールアドレス（正規化済み）の確認と保存は1つのトランザクションで行い、
+	// 同じメールアドレスのユーザーが既に存在する場合は ErrEmailAlreadyExists、同じUIDの場合は ErrAlreadyExists を返します
+	CreateIdentity(ctx context.Context, identity *LocalIdentity) error
+	SaveIdentity(ctx context.Context, identity *LocalIdentity) error
+	DeleteIdentity(ctx context.Context, uid string) error
+}
+
+// localIdentityProvider は外部サービスに依存せずにユーザーを管理する IdentityProvider の実装です
+// 開発環境やCIなど、ネットワークに接続できない環境で使用します
+type localIdentityProvider struct {
+	store LocalIdentityStore
+}
+
+func newLocalIdentityProvider(store LocalIdentityStore) *localIdentityProvider {
+	return &localIdentityProvider{store: store}
+}
+
+// normalizeEmail はメールアドレスを比較用に正規化します
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(strings.ToLower(email))
+}
+
+// getIdentity は保存されたユーザーを取得し、存在しない場合は ErrIdentityNotFound を返します
+func (p *localIdentityProvider) getIdentity(ctx context.Context, uid string) (*LocalIdentity, error) {
+	identity, err := p.store.GetIdentity(ctx, uid)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: uid=%s", ErrIdentityNotFound, uid)
+	}
+	return identity, err
+}
+
+func (p *localIdentityProvider) CreateUser(ctx context.Context, params *IdentityUserToCreate) (*IdentityUser, error) {
+	email := normalizeEmail(params.Email)
+	uid := params.UID
+	if uid == "" {
+		uid = newDocumentID()
+	}
+
+	identity := &LocalIdentity{
+		IdentityUser: IdentityUser{
+			UID:           uid,
+			Email:         email,
+			DisplayName:   params.DisplayName,
+			PhotoURL:      params.PhotoURL,
+			EmailVerified: params.EmailVerified,
+		},
+		CreatedAt: time.Now(),
+	}
+
+	if params.Password != "" {
+		hash, err := hashPassword(params.Password)
+		if err != nil {
+			return nil, err
+		}
+		identity.PasswordHash = hash
+		identity.ProviderIDs = append(identity.ProviderIDs, "password")
+	}
+
+	// 確認と作成を別々に行うと、同じメールアドレスで同時に登録された場合に重複したユーザーが作成されるため、ストアで一度に行う
+	if err := p.store.CreateIdentity(ctx, identity); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			return nil, fmt.Errorf("uid already exists: %s", uid)
+		}
+		return nil, err
+	}
+	user := identity.IdentityUser
+	return &user, nil
+}
+
+func (p *localIdentityProvider) GetUser(ctx context.Context, uid string) (*IdentityUser, error) {
+	identity, err := p.getIdentity(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	user := identity.IdentityUser
+	return &user, nil
+}
+
+func (p *localIdentityProvider) GetUserByEmail(ctx context.Context, email string) (*IdentityUser, error) {
+	identity, err := p.store.FindIdentityByEmail(ctx, normalizeEmail(email))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: email=%s", ErrIdentityNotFound, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := identity.IdentityUser
+	return &user, nil
+}
+
+func (p *localIdentityProvider) UpdateUser(ctx context.Context, uid string, params *IdentityUserToUpdate) (*IdentityUser, error) {
+	identity, err := p.getIdentity(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.DisplayName != nil {
+		identity.DisplayName = *params.DisplayName
+	}
+	if params.PhotoURL != nil {
+		identity.PhotoURL = *params.PhotoURL
+	}
+	if params.EmailVerified != nil {
+		identity.EmailVerified = *params.EmailVerified
+	}
+	for _, providerID := range params.ProvidersToDelete {
+		for i, id := range identity.ProviderIDs {
+			if id == providerID {
+				identity.ProviderIDs = append(identity.ProviderIDs[:i], identity.ProviderIDs[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if err := p.store.SaveIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+	user := identity.IdentityUser
+	return &user, nil
+}
+
+func (p *localIdentityProvider) DeleteUser(ctx context.Context, uid string) error {
+	if _, err := p.getIdentity(ctx, uid); err != nil {
+		return err
+	}
+	return p.store.DeleteIdentity(ctx, uid)
+}
+
+func (p *localIdentityProvider) VerifyPassword(ctx context.Context, email, password string) (*IdentityUser, error) {
+	identity, err := p.store.FindIdentityByEmail(ctx, normalizeEmail(email))
+	if errors.Is(err, ErrNotFound) {
+		// 存在しないユーザーでも同じ時間がかかるように検証し、応答時間からアカウントの有無を推測させない
+		passwordHasher.VerifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.PasswordHash == "" {
+		passwordHasher.VerifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+	ok, needsRehash, err := verifyPassword(password, identity.PasswordHash, identity.PasswordSalt)
+	if err != nil {
+		log.Printf("ERROR: Failed to verify password hash for UID %s: %v", identity.UID, err)
+		return nil, ErrInvalidCredentials
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if identity.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	// 古いアルゴリズム・パラメータのハッシュは、平文のパスワードがわかるログイン時に再ハッシュする
+	if needsRehash {
+		p.rehashPassword(ctx, identity, password)
+	}
+
+	user := identity.IdentityUser
+	return &user, nil
+}
+
+// VerifyIDToken はローカル環境ではFirebaseのIDトークンが存在しないため、セッショントークンとして検証します
+func (p *localIdentityProvider) VerifyIDToken(ctx context.Context, idToken string) (*Principal, error) {
+	session, err := validateSessionToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	return principalFromSession(session), nil
+}
+
+// rehashPassword は現在の設定でパスワードを再ハッシュして保存します
+// 失敗してもログインは継続し、次回のログイン時に再試行します
+func (p *localIdentityProvider) rehashPassword(ctx context.Context, identity *LocalIdentity, password string) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("WARN: Failed to rehash password for UID %s: %v", identity.UID, err)
+		return
+	}
+	identity.PasswordHash = hash
+	identity.PasswordSalt = ""
+	if err := p.store.SaveIdentity(ctx, identity); err != nil {
+		log.Printf("WARN: Failed to save rehashed password for UID %s: %v", identity.UID, err)
+		return
+	}
+	log.Printf("INFO: Password hash upgraded for UID %s", identity.UID)
+}
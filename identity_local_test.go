@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestLocalCreateUserRejectsDuplicateEmail(t *testing.T) {
+	provider := newLocalIdentityProvider(useMemoryStore(t))
+	ctx := context.Background()
+
+	// 同じメールアドレスで同時に登録しても、作成されるユーザーは1人だけ
+	const attempts = 8
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = provider.CreateUser(ctx, &IdentityUserToCreate{Email: "user@example.com"})
+		}(i)
+	}
+	wg.Wait()
+
+	var created int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrEmailAlreadyExists):
+			t.Errorf("CreateUser() = %v, want ErrEmailAlreadyExists", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d users, want 1", created)
+	}
+
+	// メールアドレスは正規化してから比較する
+	_, err := provider.CreateUser(ctx, &IdentityUserToCreate{Email: " User@Example.com "})
+	if !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("CreateUser() with a differently cased email = %v, want ErrEmailAlreadyExists", err)
+	}
+}
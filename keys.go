@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// 署名アルゴリズム
+const (
+	signingAlgRS256 = "RS256"
+	signingAlgEdDSA = "EdDSA"
+	signingAlgHS256 = "HS256"
+)
+
+// developmentJWTSecret は署名キーが設定されていない開発環境でのみ使用するキーです
+const developmentJWTSecret = "your-default-secret-key-for-development-only"
+
+// SigningKeyConfig は環境変数 SESSION_SIGNING_KEYS（JSON配列）の1要素です
+// privateKey を持つキーは署名と検証に、publicKey のみのキーはローテーション中の検証のみに使用します
+type SigningKeyConfig struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`        // "RS256" または "EdDSA"
+	PrivateKey string `json:"privateKey"` // PKCS#8 または PKCS#1（RSA）のPEM
+	PublicKey  string `json:"publicKey"`  // PKIXのPEM
+}
+
+// signingKey は読み込み済みの署名・検証キーです
+type signingKey struct {
+	kid     string
+	alg     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey // 検証専用のキーは nil
+	public  crypto.PublicKey  // HS256 の場合は共有鍵（[]byte）
+}
+
+// KeySet はセッショントークンの署名に使用するキーの集合です
+// 署名には active のキーのみを使用し、検証には kid が一致するすべてのキーを使用します
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// sessionKeySet はプロジェクト全体で共有するキーセットです
+// firestore_client.go の init() で設定されます
+var sessionKeySet *KeySet
+
+// loadSessionKeySet は環境変数からキーセットを読み込みます
+//
+//   - SESSION_SIGNING_KEYS: キーのJSON配列。SESSION_SIGNING_KEYS_FILE でファイルから読み込むこともできます
+//   - SESSION_ACTIVE_KID: 署名に使用するキーのkid（省略時は秘密鍵を持つ最初のキー）
+//   - JWT_SECRET: 非対称鍵が設定されていない場合にHS256で署名します（従来の方式）
+//
+// 本番モードでいずれのキーも設定されていない場合はエラーを返します
+func loadSessionKeySet() (*KeySet, error) {
+	raw := os.Getenv("SESSION_SIGNING_KEYS")
+	if path := os.Getenv("SESSION_SIGNING_KEYS_FILE"); raw == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("署名キーファイルの読み込みに失敗しました: %v", err)
+		}
+		raw = string(data)
+	}
+
+	keySet := &KeySet{keys: make(map[string]*signingKey)}
+
+	if raw != "" {
+		var configs []SigningKeyConfig
+		if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+			return nil, fmt.Errorf("SESSION_SIGNING_KEYS の形式が正しくありません: %v", err)
+		}
+		for _, config := range configs {
+			key, err := parseSigningKey(config)
+			if err != nil {
+				return nil, fmt.Errorf("署名キー %q の読み込みに失敗しました: %v", config.Kid, err)
+			}
+			if _, exists := keySet.keys[key.kid]; exists {
+				return nil, fmt.Errorf("署名キーのkidが重複しています: %s", key.kid)
+			}
+			keySet.keys[key.kid] = key
+			if keySet.active == nil && key.private != nil {
+				keySet.active = key
+			}
+		}
+
+		if activeKid := os.Getenv("SESSION_ACTIVE_KID"); activeKid != "" {
+			key, ok := keySet.keys[activeKid]
+			if !ok || key.private == nil {
+				return nil, fmt.Errorf("SESSION_ACTIVE_KID %q に対応する秘密鍵がありません", activeKid)
+			}
+			keySet.active = key
+		}
+	}
+
+	// JWT_SECRET は従来のトークン（kidなし）の検証と、非対称鍵がない場合の署名に使用する
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" && keySet.active == nil {
+		if productionMode {
+			return nil, errors.New("本番モードではセッショントークンの署名キー（SESSION_SIGNING_KEYS または JWT_SECRET）の設定が必要です")
+		}
+		log.Println("WARN: No session signing key configured. Using the development secret (do not use in production).")
+		secret = developmentJWTSecret
+	}
+	if secret != "" {
+		legacy := &signingKey{
+			kid:     "",
+			alg:     signingAlgHS256,
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+		keySet.keys[legacy.kid] = legacy
+		if keySet.active == nil {
+			keySet.active = legacy
+		}
+	}
+
+	if keySet.active == nil {
+		return nil, errors.New("署名に使用できる秘密鍵がありません")
+	}
+	log.Printf("INFO: Session signing key loaded (alg=%s, kid=%q, verification keys=%d)", keySet.active.alg, keySet.active.kid, len(keySet.keys))
+	return keySet, nil
+}
+
+// parseSigningKey は設定からキーを読み込みます
+func parseSigningKey(config SigningKeyConfig) (*signingKey, error) {
+	if config.Kid == "" {
+		return nil, errors.New("kid が指定されていません")
+	}
+
+	key := &signingKey{kid: config.Kid, alg: config.Alg}
+	switch config.Alg {
+	case signingAlgRS256:
+		key.method = jwt.SigningMethodRS256
+	case signingAlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("サポートされていないアルゴリズムです: %s", config.Alg)
+	}
+
+	if config.PrivateKey != "" {
+		private, err := parsePrivateKeyPEM(config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+		switch k := private.(type) {
+		case *rsa.PrivateKey:
+			key.public = &k.PublicKey
+		case ed25519.PrivateKey:
+			key.public = k.Public()
+		}
+	} else if config.PublicKey != "" {
+		public, err := parsePublicKeyPEM(config.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.public = public
+	} else {
+		return nil, errors.New("privateKey または publicKey が必要です")
+	}
+
+	// アルゴリズムと鍵の種類の組み合わせを確認する
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if key.alg != signingAlgRS256 {
+			return nil, errors.New("RSA鍵には RS256 を指定してください")
+		}
+	case ed25519.PublicKey:
+		if key.alg != signingAlgEdDSA {
+			return nil, errors.New("Ed25519鍵には EdDSA を指定してください")
+		}
+	default:
+		return nil, fmt.Errorf("サポートされていない鍵の種類です: %T", key.public)
+	}
+	return key, nil
+}
+
+// parsePrivateKeyPEM はPEM形式の秘密鍵（PKCS#8 または PKCS#1）を読み込みます
+func parsePrivateKeyPEM(data string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("秘密鍵のPEMを解析できません")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// parsePublicKeyPEM はPEM形式の公開鍵（PKIX）を読み込みます
+func parsePublicKeyPEM(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("公開鍵のPEMを解析できません")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Sign はアクティブなキーでクレームに署名します。非対称鍵の場合はヘッダーに kid を付与します
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	if ks.active.kid != "" {
+		token.Header["kid"] = ks.active.kid
+	}
+	return token.SignedString(ks.active.private)
+}
+
+// Keyfunc は jwt.Parse に渡す検証キーの解決関数です
+// kid と署名アルゴリズムの両方が一致するキーのみを使用します
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: kid=%q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWKS は公開鍵をJSON Web Key Set（RFC 7517）の形式で返します
+// HS256の共有鍵は公開できないため含めません
+func (ks *KeySet) JWKS() map[string]interface{} {
+	keys := make([]map[string]interface{}, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]interface{}{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": key.alg,
+				"kid": key.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]interface{}{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": key.alg,
+				"kid": key.kid,
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i]["kid"].(string) < keys[j]["kid"].(string)
+	})
+	return map[string]interface{}{"keys": keys}
+}
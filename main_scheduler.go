@@ -15,6 +15,13 @@ import (
 func schedulerHandler(ctx context.Context, event events.CloudWatchEvent) error {
 	log.Printf("INFO: Scheduler Lambda triggered by CloudWatch Event: %s", event.ID)
 	
+	// 有効期限の導入前に作成された非ログインのスペースへの有効期限の設定（移行時に1度だけ有効にする）
+	if shouldBackfillSpaceExpiry() {
+		if err := backfillAnonymousSpaceExpiry(ctx); err != nil {
+			return err
+		}
+	}
+
 	// クリーンアップを実行
 	if err := CleanupExpiredUsers(ctx); err != nil {
 		log.Printf("ERROR: Scheduled cleanup failed: %v", err)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// testEnvironment はパッケージの init() より前に初期化され、テスト用の設定を環境変数に設定します
+// 本番ビルドのテストでも init() がFirestoreや本番用の鍵を必要としないよう、メモリストレージとテスト用の鍵を使用します
+var testEnvironment = setTestEnvironment()
+
+func setTestEnvironment() bool {
+	for name, value := range map[string]string{
+		"STORAGE_BACKEND": "memory",
+		"AUTH_PROVIDER":   "local",
+		"JWT_SECRET":      "test-only-session-secret-0123456789abcdef",
+		// X25519秘密鍵（32バイト）のBase64です。テスト以外では使用しないでください
+		"PASSWORD_TRANSPORT_PRIVATE_KEY": "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=",
+	} {
+		os.Setenv(name, value)
+	}
+	return true
+}
+
+// useMemoryStore はテストの間、dataStore を空のメモリストレージに差し替えます
+func useMemoryStore(t *testing.T) *memoryStore {
+	t.Helper()
+	previous := dataStore
+	store := newMemoryStore()
+	dataStore = store
+	t.Cleanup(func() { dataStore = previous })
+	return store
+}
+
+// mustDate は YYYY-MM-DD 形式の日付を解析します
+func mustDate(t *testing.T, value string) time.Time {
+	t.Helper()
+	d, err := time.Parse(taskDateLayout, value)
+	if err != nil {
+		t.Fatalf("invalid date %q: %v", value, err)
+	}
+	return d
+}
+
+// mustLoadLocation はIANAタイムゾーンを読み込みます
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("failed to load time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+// equalStrings は2つのスライスが同じ要素を同じ順序で持つかを返します（nil と空は同じとみなします）
+func equalStrings(a, b []string) bool {
+	return len(a) == 0 && len(b) == 0 || equalStringSlices(a, b)
+}
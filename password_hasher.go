@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// パスワードハッシュのアルゴリズム
+const (
+	passwordHashArgon2id = "argon2id"
+	passwordHashBcrypt   = "bcrypt"
+)
+
+// ErrUnsupportedPasswordHash は保存されたハッシュの形式を解釈できない場合に返されます
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash format")
+
+// PasswordHashConfig はパスワードハッシュのアルゴリズムとパラメータです
+// 値を変更すると、既存のユーザーは次回ログイン時に新しいパラメータで再ハッシュされます
+type PasswordHashConfig struct {
+	Algorithm         string
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  uint32
+	Argon2KeyLength   uint32
+	BcryptCost        int
+}
+
+// defaultPasswordHashConfig はOWASPの推奨値に沿った既定のパラメータです
+var defaultPasswordHashConfig = PasswordHashConfig{
+	Algorithm:         passwordHashArgon2id,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  3,
+	Argon2Parallelism: 2,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+	BcryptCost:        12,
+}
+
+// PasswordHasher はパスワードをPHC文字列形式でハッシュ化・検証します
+//
+//   - argon2id: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>（saltとhashはパディングなしのBase64）
+//   - bcrypt:   $2a$12$...（bcrypt標準の形式）
+type PasswordHasher struct {
+	config PasswordHashConfig
+
+	// dummyHash は存在しないユーザーのログインで検証に使うハッシュです（VerifyDummy で初回に生成します）
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// bcryptMaxPasswordBytes はbcryptが扱える入力の上限（バイト数）です。超える入力はハッシュ化できません
+const bcryptMaxPasswordBytes = 72
+
+// passwordHasher はプロジェクト全体で共有するパスワードハッシャーです
+// firestore_client.go の init() で設定されます
+var passwordHasher *PasswordHasher
+
+// loadPasswordHasher は環境変数からパスワードハッシュの設定を読み込みます
+//
+//   - PASSWORD_HASH_ALGORITHM: "argon2id"（既定）または "bcrypt"
+//   - ARGON2_MEMORY_KIB / ARGON2_ITERATIONS / ARGON2_PARALLELISM / ARGON2_SALT_LENGTH / ARGON2_KEY_LENGTH
+//   - BCRYPT_COST
+func loadPasswordHasher() (*PasswordHasher, error) {
+	config := defaultPasswordHashConfig
+
+	if algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm != "" {
+		config.Algorithm = strings.ToLower(algorithm)
+	}
+	if config.Algorithm != passwordHashArgon2id && config.Algorithm != passwordHashBcrypt {
+		return nil, fmt.Errorf("サポートされていないパスワードハッシュのアルゴリズムです: %s", config.Algorithm)
+	}
+
+	uintEnvs := []struct {
+		name string
+		dest *uint32
+	}{
+		{"ARGON2_MEMORY_KIB", &config.Argon2Memory},
+		{"ARGON2_ITERATIONS", &config.Argon2Iterations},
+		{"ARGON2_SALT_LENGTH", &config.Argon2SaltLength},
+		{"ARGON2_KEY_LENGTH", &config.Argon2KeyLength},
+	}
+	for _, env := range uintEnvs {
+		raw := os.Getenv(env.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil || value == 0 {
+			return nil, fmt.Errorf("%s の値が正しくありません: %s", env.name, raw)
+		}
+		*env.dest = uint32(value)
+	}
+
+	if raw := os.Getenv("ARGON2_PARALLELISM"); raw != "" {
+		value, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil || value == 0 {
+			return nil, fmt.Errorf("ARGON2_PARALLELISM の値が正しくありません: %s", raw)
+		}
+		config.Argon2Parallelism = uint8(value)
+	}
+
+	if raw := os.Getenv("BCRYPT_COST"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < bcrypt.MinCost || value > bcrypt.MaxCost {
+			return nil, fmt.Errorf("BCRYPT_COST の値が正しくありません: %s", raw)
+		}
+		config.BcryptCost = value
+	}
+
+	log.Printf("INFO: Password hasher configured (algorithm=%s)", config.Algorithm)
+	return &PasswordHasher{config: config}, nil
+}
+
+// Hash は設定されたアルゴリズムでパスワードをハッシュ化し、PHC文字列を返します
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	switch h.config.Algorithm {
+	case passwordHashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.config.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("パスワードのハッシュ化に失敗しました: %v", err)
+		}
+		return string(hash), nil
+	default:
+		salt := make([]byte, h.config.Argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("ソルト生成エラー: %v", err)
+		}
+		key := argon2.IDKey([]byte(password), salt, h.config.Argon2Iterations, h.config.Argon2Memory, h.config.Argon2Parallelism, h.config.Argon2KeyLength)
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			passwordHashArgon2id, argon2.Version,
+			h.config.Argon2Memory, h.config.Argon2Iterations, h.config.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	}
+}
+
+// MaxPasswordBytes はアルゴリズムが扱えるパスワードのバイト数の上限を返します（0 は無制限）
+func (h *PasswordHasher) MaxPasswordBytes() int {
+	if h.config.Algorithm == passwordHashBcrypt {
+		return bcryptMaxPasswordBytes
+	}
+	return 0
+}
+
+// VerifyDummy は存在しないユーザーのログインで、実在するユーザーと同じ時間がかかるようにダミーのハッシュを検証します
+// 応答時間の差からアカウントの有無を推測されることを防ぎます。結果は常に不一致として扱います
+func (h *PasswordHasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		hash, err := h.Hash("dummy-password-for-timing")
+		if err != nil {
+			log.Printf("ERROR: Failed to generate dummy password hash: %v", err)
+			return
+		}
+		h.dummyHash = hash
+	})
+	if h.dummyHash == "" {
+		return
+	}
+	h.Verify(password, h.dummyHash)
+}
+
+// Verify はパスワードがPHC文字列のハッシュと一致するかを定数時間で検証します
+// needsRehash は、一致したハッシュのアルゴリズムやパラメータが現在の設定と異なる場合に true になります
+func (h *PasswordHasher) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+passwordHashArgon2id+"$"):
+		return h.verifyArgon2id(password, encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return h.verifyBcrypt(password, encoded)
+	default:
+		return false, false, ErrUnsupportedPasswordHash
+	}
+}
+
+// verifyArgon2id はargon2idのPHC文字列を検証します
+func (h *PasswordHasher) verifyArgon2id(password, encoded string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, ErrUnsupportedPasswordHash
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrUnsupportedPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnsupportedPasswordHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false, ErrUnsupportedPasswordHash
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("%w: argon2 version %d", ErrUnsupportedPasswordHash, version)
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(actual, expected) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := h.config.Algorithm != passwordHashArgon2id ||
+		memory != h.config.Argon2Memory ||
+		iterations != h.config.Argon2Iterations ||
+		parallelism != h.config.Argon2Parallelism ||
+		uint32(len(salt)) != h.config.Argon2SaltLength ||
+		uint32(len(expected)) != h.config.Argon2KeyLength
+	return true, needsRehash, nil
+}
+
+// verifyBcrypt はbcryptのハッシュを検証します（比較は bcrypt パッケージ内で定数時間に行われます）
+func (h *PasswordHasher) verifyBcrypt(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+	}
+	needsRehash := h.config.Algorithm != passwordHashBcrypt || cost != h.config.BcryptCost
+	return true, needsRehash, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// パスワードポリシー違反のコードです。クライアントはコードを基に表示を切り替えられます
+const (
+	passwordErrWeak           = "weak_password"
+	passwordErrTooShort       = "too_short"
+	passwordErrTooLong        = "too_long"
+	passwordErrTooLongBytes   = "too_long_bytes"
+	passwordErrMissingUpper   = "missing_upper"
+	passwordErrMissingLower   = "missing_lower"
+	passwordErrMissingDigit   = "missing_digit"
+	passwordErrMissingSymbol  = "missing_symbol"
+	passwordErrDisallowedChar = "disallowed_character"
+	passwordErrDenyListed     = "common_password"
+	passwordErrSimilarToEmail = "similar_to_email"
+)
+
+// passwordPolicyMessages はポリシー違反のコードごとの表示メッセージです（言語ごと）
+// {min}・{max}・{chars} はパラメータで置き換えられます
+var passwordPolicyMessages = map[string]map[string]string{
+	"ja": {
+		passwordErrWeak:           "パスワードの強度が不足しています",
+		passwordErrTooShort:       "パスワードは{min}文字以上で入力してください",
+		passwordErrTooLong:        "パスワードは{max}文字以下で入力してください",
+		passwordErrTooLongBytes:   "パスワードが長すぎます（UTF-8で{max}バイト以下で入力してください）",
+		passwordErrMissingUpper:   "大文字を含めてください",
+		passwordErrMissingLower:   "小文字を含めてください",
+		passwordErrMissingDigit:   "数字を含めてください",
+		passwordErrMissingSymbol:  "記号を含めてください",
+		passwordErrDisallowedChar: "使用できない文字が含まれています: {chars}",
+		passwordErrDenyListed:     "よく使われるパスワードのため使用できません",
+		passwordErrSimilarToEmail: "メールアドレスと似たパスワードは使用できません",
+	},
+	"en": {
+		passwordErrWeak:           "Password is too weak",
+		passwordErrTooShort:       "Password must be at least {min} characters",
+		passwordErrTooLong:        "Password must be at most {max} characters",
+		passwordErrTooLongBytes:   "Password is too long (at most {max} bytes in UTF-8)",
+		passwordErrMissingUpper:   "Include an uppercase letter",
+		passwordErrMissingLower:   "Include a lowercase letter",
+		passwordErrMissingDigit:   "Include a digit",
+		passwordErrMissingSymbol:  "Include a symbol",
+		passwordErrDisallowedChar: "Contains characters that are not allowed: {chars}",
+		passwordErrDenyListed:     "This password is too common",
+		passwordErrSimilarToEmail: "Password must not resemble your email address",
+	},
+}
+
+// defaultPasswordSymbols は既定で使用できる記号（ASCIIの記号と空白）です
+const defaultPasswordSymbols = " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// PasswordPolicy はパスワードの要件です
+type PasswordPolicy struct {
+	MinLength int // 文字数（正規化後のUnicode文字数）
+	MaxLength int
+	// MaxBytes はUTF-8でのバイト数の上限です（0 は無制限）。ハッシュのアルゴリズムの制限から決まります
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// AllowedSymbols は英字・数字以外に使用できる文字です
+	AllowedSymbols string
+	// Normalization は "NFKC"・"NFC"・"none" のいずれかです
+	Normalization string
+	// DenyList は使用を禁止するパスワード（正規化・小文字化済み）です
+	DenyList map[string]struct{}
+	// RejectEmailSimilarity が true の場合、メールアドレスに似たパスワードを拒否します
+	RejectEmailSimilarity bool
+}
+
+// PasswordViolation はパスワードポリシーの違反1件です
+type PasswordViolation struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// PasswordStrengthResult はパスワード強度チェックの結果を表します
+type PasswordStrengthResult struct {
+	IsValid    bool
+	Violations []PasswordViolation
+}
+
+// defaultPasswordPolicy は設定がない場合のポリシーです
+var defaultPasswordPolicy = PasswordPolicy{
+	MinLength:             8,
+	MaxLength:             128,
+	RequireUpper:          true,
+	RequireLower:          true,
+	RequireDigit:          true,
+	AllowedSymbols:        defaultPasswordSymbols,
+	Normalization:         "NFKC",
+	RejectEmailSimilarity: true,
+}
+
+// passwordPolicy はプロジェクト全体で共有するパスワードポリシーです
+// firestore_client.go の init() で設定されます
+var passwordPolicy *PasswordPolicy
+
+// loadPasswordPolicy は環境変数からパスワードポリシーを読み込みます
+//
+//   - PASSWORD_MIN_LENGTH / PASSWORD_MAX_LENGTH
+//   - PASSWORD_REQUIRE_UPPER / PASSWORD_REQUIRE_LOWER / PASSWORD_REQUIRE_DIGIT / PASSWORD_REQUIRE_SYMBOL
+//   - PASSWORD_ALLOWED_SYMBOLS: 英字・数字以外に使用できる文字
+//   - PASSWORD_NORMALIZATION: "NFKC"（既定）・"NFC"・"none"
+//   - PASSWORD_DENYLIST_FILE: 禁止パスワードの一覧（1行に1つ、# で始まる行はコメント）
+//   - PASSWORD_REJECT_EMAIL_SIMILARITY: メールアドレスに似たパスワードを拒否するか
+//
+// ハッシュのアルゴリズムに入力の長さの制限がある場合（bcrypt は72バイト）、その値を MaxBytes に設定します
+func loadPasswordPolicy(hasher *PasswordHasher) (*PasswordPolicy, error) {
+	policy := defaultPasswordPolicy
+	policy.MaxBytes = hasher.MaxPasswordBytes()
+
+	intEnvs := []struct {
+		name string
+		dest *int
+	}{
+		{"PASSWORD_MIN_LENGTH", &policy.MinLength},
+		{"PASSWORD_MAX_LENGTH", &policy.MaxLength},
+	}
+	for _, env := range intEnvs {
+		raw := os.Getenv(env.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("%s の値が正しくありません: %s", env.name, raw)
+		}
+		*env.dest = value
+	}
+	if policy.MinLength > policy.MaxLength {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH（%d）が PASSWORD_MAX_LENGTH（%d）を超えています", policy.MinLength, policy.MaxLength)
+	}
+
+	boolEnvs := []struct {
+		name string
+		dest *bool
+	}{
+		{"PASSWORD_REQUIRE_UPPER", &policy.RequireUpper},
+		{"PASSWORD_REQUIRE_LOWER", &policy.RequireLower},
+		{"PASSWORD_REQUIRE_DIGIT", &policy.RequireDigit},
+		{"PASSWORD_REQUIRE_SYMBOL", &policy.RequireSymbol},
+		{"PASSWORD_REJECT_EMAIL_SIMILARITY", &policy.RejectEmailSimilarity},
+	}
+	for _, env := range boolEnvs {
+		raw := os.Getenv(env.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s の値が正しくありません: %s", env.name, raw)
+		}
+		*env.dest = value
+	}
+
+	if symbols, ok := os.LookupEnv("PASSWORD_ALLOWED_SYMBOLS"); ok {
+		policy.AllowedSymbols = symbols
+	}
+
+	if normalization := os.Getenv("PASSWORD_NORMALIZATION"); normalization != "" {
+		policy.Normalization = strings.ToUpper(normalization)
+	}
+	switch policy.Normalization {
+	case "NFKC", "NFC", "NONE":
+	default:
+		return nil, fmt.Errorf("PASSWORD_NORMALIZATION の値が正しくありません: %s", policy.Normalization)
+	}
+
+	if path := os.Getenv("PASSWORD_DENYLIST_FILE"); path != "" {
+		denyList, err := loadPasswordDenyList(path, &policy)
+		if err != nil {
+			return nil, fmt.Errorf("禁止パスワード一覧の読み込みに失敗しました: %v", err)
+		}
+		policy.DenyList = denyList
+	}
+
+	log.Printf("INFO: Password policy loaded (length=%d-%d, max bytes=%d, normalization=%s, deny list=%d entries)",
+		policy.MinLength, policy.MaxLength, policy.MaxBytes, policy.Normalization, len(policy.DenyList))
+	return &policy, nil
+}
+
+// loadPasswordDenyList は禁止パスワードの一覧ファイルを読み込みます
+func loadPasswordDenyList(path string, policy *PasswordPolicy) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	denyList := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denyList[strings.ToLower(policy.Normalize(line))] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return denyList, nil
+}
+
+// Normalize はポリシーのUnicode正規化をパスワードに適用します
+// ハッシュ化と検証の前に必ず適用し、入力方法による表記の揺れを吸収します
+func (p *PasswordPolicy) Normalize(password string) string {
+	switch p.Normalization {
+	case "NFKC":
+		return norm.NFKC.String(password)
+	case "NFC":
+		return norm.NFC.String(password)
+	default:
+		return password
+	}
+}
+
+// Check はパスワード（正規化済み）がポリシーを満たすかを検証します
+func (p *PasswordPolicy) Check(password, email, lang string) PasswordStrengthResult {
+	var violations []PasswordViolation
+	add := func(code string, params map[string]interface{}) {
+		violations = append(violations, PasswordViolation{
+			Code:    code,
+			Message: passwordPolicyMessage(lang, code, params),
+			Params:  params,
+		})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add(passwordErrTooShort, map[string]interface{}{"min": p.MinLength})
+	}
+	if length > p.MaxLength {
+		add(passwordErrTooLong, map[string]interface{}{"max": p.MaxLength})
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		add(passwordErrTooLongBytes, map[string]interface{}{"max": p.MaxBytes})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	var disallowed []string
+	seen := make(map[rune]bool)
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsLetter(r):
+			// 大文字・小文字の区別がない文字（かな・漢字など）はパスフレーズとして許可する
+		case strings.ContainsRune(p.AllowedSymbols, r):
+			hasSymbol = true
+		default:
+			if !seen[r] {
+				seen[r] = true
+				disallowed = append(disallowed, strconv.QuoteRuneToGraphic(r))
+			}
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		add(passwordErrMissingUpper, nil)
+	}
+	if p.RequireLower && !hasLower {
+		add(passwordErrMissingLower, nil)
+	}
+	if p.RequireDigit && !hasDigit {
+		add(passwordErrMissingDigit, nil)
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(passwordErrMissingSymbol, nil)
+	}
+	if len(disallowed) > 0 {
+		add(passwordErrDisallowedChar, map[string]interface{}{"chars": strings.Join(disallowed, " ")})
+	}
+
+	if _, denied := p.DenyList[strings.ToLower(password)]; denied {
+		add(passwordErrDenyListed, nil)
+	}
+	if p.RejectEmailSimilarity && isSimilarToEmail(password, email) {
+		add(passwordErrSimilarToEmail, nil)
+	}
+
+	return PasswordStrengthResult{
+		IsValid:    len(violations) == 0,
+		Violations: violations,
+	}
+}
+
+// isSimilarToEmail はパスワードがメールアドレスやそのローカル部を含む（または含まれる）かを判定します
+func isSimilarToEmail(password, email string) bool {
+	email = normalizeEmail(email)
+	if email == "" {
+		return false
+	}
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(email, "@")
+
+	if lowered == email || strings.Contains(lowered, email) {
+		return true
+	}
+	// 短すぎるローカル部（"a" など）は偶然の一致が多いため対象外とする
+	if len(localPart) >= 4 && (strings.Contains(lowered, localPart) || strings.Contains(localPart, lowered)) {
+		return true
+	}
+	return false
+}
+
+// passwordPolicyMessage は違反コードのメッセージを指定された言語で返します（未対応の言語は日本語）
+func passwordPolicyMessage(lang, code string, params map[string]interface{}) string {
+	messages, ok := passwordPolicyMessages[lang]
+	if !ok {
+		messages = passwordPolicyMessages["ja"]
+	}
+	message := messages[code]
+	for name, value := range params {
+		message = strings.ReplaceAll(message, "{"+name+"}", fmt.Sprint(value))
+	}
+	return message
+}
+
+// preferredLanguage はAccept-Languageヘッダーから対応している言語を選びます（既定は日本語）
+func preferredLanguage(req *Request) string {
+	for _, part := range strings.Split(req.Header("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := passwordPolicyMessages[base]; ok {
+			return base
+		}
+	}
+	return "ja"
+}
+
+// normalizePassword は共有のポリシーに従ってパスワードを正規化します
+func normalizePassword(password string) string {
+	return passwordPolicy.Normalize(password)
+}
+
+// checkPasswordStrength はパスワードの強度をポリシーに従ってチェックします
+func checkPasswordStrength(password, email, lang string) PasswordStrengthResult {
+	return passwordPolicy.Check(password, email, lang)
+}
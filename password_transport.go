@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// passwordTransportAlg はパスワード送信用エンベロープの方式です
+// クライアントの一時X25519鍵とサーバー鍵の共有秘密からHKDF-SHA256でAES-256-GCMの鍵を導出します
+const passwordTransportAlg = "X25519-HKDF-SHA256-A256GCM"
+
+// passwordTransportInfo はHKDFの info と AAD の接頭辞に使用する方式の識別子です
+const passwordTransportInfo = "tokiwa-calendar password transport v1"
+
+// passwordEnvelopeMaxSkew はエンベロープのタイムスタンプとサーバー時刻の許容差です
+const passwordEnvelopeMaxSkew = 5 * time.Minute
+
+// エンベロープの用途（AADに含め、別のエンドポイントへの転用を防ぎます）
+const (
+	passwordPurposeLogin  = "login"
+	passwordPurposeSignup = "signup"
+)
+
+// パスワードエンベロープの検証で返されるエラーです
+var (
+	ErrPasswordEnvelopeRequired = errors.New("password envelope required")
+	ErrPasswordEnvelopeInvalid  = errors.New("invalid password envelope")
+	ErrPasswordEnvelopeKey      = errors.New("unknown password transport key")
+	ErrPasswordEnvelopeExpired  = errors.New("password envelope timestamp out of range")
+	ErrPasswordEnvelopeReplayed = errors.New("password envelope replayed")
+)
+
+// PasswordEnvelope はクライアントが暗号化して送信するパスワードです
+// バイナリ値はすべてパディングなしのBase64URLでエンコードします
+type PasswordEnvelope struct {
+	Kid string `json:"kid"`
+	// EphemeralPublicKey はクライアントがリクエストごとに生成したX25519公開鍵です
+	EphemeralPublicKey string `json:"epk"`
+	// Nonce はAES-GCMのノンス（12バイト）です。リプレイの検知にも使用します
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	// Timestamp は暗号化した時刻（UNIX秒）です
+	Timestamp int64 `json:"timestamp"`
+}
+
+// PasswordTransport はパスワード送信用のサーバー鍵と移行設定です
+type PasswordTransport struct {
+	kid        string
+	privateKey *ecdh.PrivateKey
+	// required が true の場合、サインアップ・ログインはエンベロープのみを受け付けます
+	required bool
+}
+
+// passwordTransport はプロジェクト全体で共有するパスワード送信の設定です
+// firestore_client.go の init() で設定されます
+var passwordTransport *PasswordTransport
+
+// loadPasswordTransport は環境変数からパスワード送信用の鍵を読み込みます
+//
+//   - PASSWORD_TRANSPORT_PRIVATE_KEY: X25519秘密鍵（32バイト）のBase64。未設定の場合は起動ごとに一時鍵を生成します
+//   - PASSWORD_TRANSPORT_REQUIRE_ENVELOPE: true の場合、従来の password フィールドを拒否します。本番でこれを有効にする場合は秘密鍵が必須です
+func loadPasswordTransport() (*PasswordTransport, error) {
+	required, _ := strconv.ParseBool(os.Getenv("PASSWORD_TRANSPORT_REQUIRE_ENVELOPE"))
+
+	var privateKey *ecdh.PrivateKey
+	if raw := os.Getenv("PASSWORD_TRANSPORT_PRIVATE_KEY"); raw != "" {
+		keyBytes, err := decodeBase64Flexible(raw)
+		if err != nil {
+			return nil, fmt.Errorf("PASSWORD_TRANSPORT_PRIVATE_KEY の形式が正しくありません: %v", err)
+		}
+		privateKey, err = ecdh.X25519().NewPrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("PASSWORD_TRANSPORT_PRIVATE_KEY を読み込めません: %v", err)
+		}
+	} else {
+		// Lambdaでは複数のインスタンスが異なる鍵を持つと公開鍵が一致せず暗号化したパスワードを復号できないため、
+		// 暗号化を必須にする本番環境では固定の鍵を必須とする
+		if productionMode && required {
+			return nil, errors.New("PASSWORD_TRANSPORT_REQUIRE_ENVELOPE を有効にした本番モードではパスワード送信用の鍵（PASSWORD_TRANSPORT_PRIVATE_KEY）の設定が必要です")
+		}
+		var err error
+		privateKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("パスワード送信用の鍵の生成に失敗しました: %v", err)
+		}
+		if productionMode {
+			// 鍵を設定するまでの移行期間は、従来の平文の password フィールドでログインできる状態を維持する
+			log.Println("WARN: PASSWORD_TRANSPORT_PRIVATE_KEY is not set in production. Using an ephemeral key; encrypted passwords may fail across instances and the legacy plaintext password field is still accepted.")
+		} else {
+			log.Println("WARN: PASSWORD_TRANSPORT_PRIVATE_KEY is not set. Using an ephemeral key (do not use in production).")
+		}
+	}
+
+	sum := sha256.Sum256(privateKey.PublicKey().Bytes())
+	transport := &PasswordTransport{
+		kid:        hex.EncodeToString(sum[:8]),
+		privateKey: privateKey,
+		required:   required,
+	}
+	log.Printf("INFO: Password transport key loaded (kid=%s, envelope required=%t)", transport.kid, transport.required)
+	return transport, nil
+}
+
+// decodeBase64Flexible は標準・URLセーフ、パディングの有無を問わずBase64をデコードします
+func decodeBase64Flexible(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// PublicKey はクライアントに公開する鍵情報を返します
+func (t *PasswordTransport) PublicKey() map[string]interface{} {
+	return map[string]interface{}{
+		"kid":       t.kid,
+		"alg":       passwordTransportAlg,
+		"publicKey": base64.RawURLEncoding.EncodeToString(t.privateKey.PublicKey().Bytes()),
+		"maxSkew":   int(passwordEnvelopeMaxSkew.Seconds()),
+		"required":  t.required,
+	}
+}
+
+// Required はエンベロープなしのパスワードを拒否するかを返します
+func (t *PasswordTransport) Required() bool {
+	return t.required
+}
+
+// passwordEnvelopeAAD はエンベロープを用途・メールアドレス・時刻に結び付ける追加認証データです
+func passwordEnvelopeAAD(purpose, email string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|%d", passwordTransportInfo, purpose, normalizeEmail(email), timestamp))
+}
+
+// Open はエンベロープを検証して平文のパスワードを返します
+// 鍵・タイムスタンプ・認証タグを確認したうえで、同じエンベロープの再利用を拒否します
+func (t *PasswordTransport) Open(ctx context.Context, envelope *PasswordEnvelope, purpose, email string) (string, error) {
+	if envelope.Kid != t.kid {
+		return "", ErrPasswordEnvelopeKey
+	}
+
+	sentAt := time.Unix(envelope.Timestamp, 0)
+	now := time.Now()
+	if sentAt.Before(now.Add(-passwordEnvelopeMaxSkew)) || sentAt.After(now.Add(passwordEnvelopeMaxSkew)) {
+		return "", ErrPasswordEnvelopeExpired
+	}
+
+	epkBytes, err := base64.RawURLEncoding.DecodeString(envelope.EphemeralPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: epk: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(envelope.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("%w: nonce: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(envelope.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("%w: ciphertext: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+
+	epk, err := ecdh.X25519().NewPublicKey(epkBytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: epk: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+	shared, err := t.privateKey.ECDH(epk)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+
+	// 鍵導出のソルトに両者の公開鍵を含め、鍵を交換の当事者に結び付ける
+	salt := append(append([]byte{}, epkBytes...), t.privateKey.PublicKey().Bytes()...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(passwordTransportInfo)), key); err != nil {
+		return "", fmt.Errorf("鍵の導出に失敗しました: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("AES暗号作成エラー: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("AES-GCM作成エラー: %v", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", fmt.Errorf("%w: nonce size", ErrPasswordEnvelopeInvalid)
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, passwordEnvelopeAAD(purpose, email, envelope.Timestamp))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPasswordEnvelopeInvalid, err)
+	}
+
+	// 認証に成功したエンベロープのみを記録する（不正なリクエストで記録を埋められないようにする）
+	replayKey := sha256.Sum256(append(epkBytes, nonce...))
+	err = dataStore.ClaimNonce(ctx, hex.EncodeToString(replayKey[:]), sentAt.Add(passwordEnvelopeMaxSkew))
+	if errors.Is(err, ErrAlreadyExists) {
+		return "", ErrPasswordEnvelopeReplayed
+	}
+	if err != nil {
+		return "", fmt.Errorf("リプレイ検知用のノンスの保存に失敗しました: %v", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// passwordEnvelopeErrorResponse はエンベロープの検証エラーをレスポンスに変換します
+func passwordEnvelopeErrorResponse(err error) (map[string]interface{}, int) {
+	switch {
+	case errors.Is(err, ErrPasswordEnvelopeRequired):
+		return map[string]interface{}{"error": "パスワードは暗号化して送信してください", "code": "password_envelope_required"}, http.StatusBadRequest
+	case errors.Is(err, ErrPasswordEnvelopeKey):
+		return map[string]interface{}{"error": "暗号化の公開鍵が更新されました。再取得してください", "code": "password_key_rotated"}, http.StatusBadRequest
+	case errors.Is(err, ErrPasswordEnvelopeExpired):
+		return map[string]interface{}{"error": "リクエストの有効期限が切れています。端末の時刻を確認してください", "code": "password_envelope_expired"}, http.StatusBadRequest
+	case errors.Is(err, ErrPasswordEnvelopeReplayed):
+		return map[string]interface{}{"error": "このリクエストは既に使用されています", "code": "password_envelope_replayed"}, http.StatusBadRequest
+	case errors.Is(err, ErrPasswordEnvelopeInvalid):
+		return map[string]interface{}{"error": "パスワードの復号化に失敗しました", "code": "password_envelope_invalid"}, http.StatusBadRequest
+	default:
+		return map[string]interface{}{"error": "パスワードの復号化に失敗しました"}, http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestLoadPasswordTransportWithoutPrivateKey(t *testing.T) {
+	tests := []struct {
+		name            string
+		requireEnvelope string
+		wantErr         bool
+	}{
+		{"legacy password accepted", "", false},
+		{"envelope required", "true", productionMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_TRANSPORT_PRIVATE_KEY", "")
+			t.Setenv("PASSWORD_TRANSPORT_REQUIRE_ENVELOPE", tt.requireEnvelope)
+
+			transport, err := loadPasswordTransport()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("loadPasswordTransport() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && transport.required != (tt.requireEnvelope == "true") {
+				t.Errorf("required = %t, want %s", transport.required, tt.requireEnvelope)
+			}
+		})
+	}
+}
@@ -1,65 +1,14 @@
 package main
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
-	"fmt"
-	"regexp"
 	"strings"
 )
 
-// PasswordStrengthResult はパスワード強度チェックの結果を表します
-type PasswordStrengthResult struct {
-	IsValid bool
-	Errors  []string
-}
-
-// checkPasswordStrength はパスワードの強度をチェックします
-func checkPasswordStrength(password string) PasswordStrengthResult {
-	var errors []string
-
-	// 最小長チェック
-	if len(password) < 8 {
-		errors = append(errors, "パスワードは8文字以上で入力してください")
-	}
-
-	// 大文字チェック
-	if !regexp.MustCompile(`[A-Z]`).MatchString(password) {
-		errors = append(errors, "大文字を含めてください")
-	}
-
-	// 小文字チェック
-	if !regexp.MustCompile(`[a-z]`).MatchString(password) {
-		errors = append(errors, "小文字を含めてください")
-	}
-
-	// 数字チェック
-	if !regexp.MustCompile(`\d`).MatchString(password) {
-		errors = append(errors, "数字を含めてください")
-	}
-
-	// アルファベットと数字以外の文字を禁止
-	if !regexp.MustCompile(`^[A-Za-z0-9]+$`).MatchString(password) {
-		errors = append(errors, "パスワードは英数字のみ使用可能です")
-	}
-
-	return PasswordStrengthResult{
-		IsValid: len(errors) == 0,
-		Errors:  errors,
-	}
-}
-
-// generateSalt はランダムなソルトを生成します
-func generateSalt(length int) (string, error) {
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(bytes), nil
-}
-
 // hashPasswordWithSalt はパスワードをソルト付きでハッシュ化します
+// 従来の保存形式（SHA-256）です。新規のハッシュ化には password_hasher.go の PasswordHasher を使用します
 func hashPasswordWithSalt(password, salt string) string {
 	// パスワードとソルトを結合
 	combined := password + salt
@@ -71,25 +20,22 @@ func hashPasswordWithSalt(password, salt string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// HashPasswordForStorage はパスワードをストレージ用にハッシュ化します
-func HashPasswordForStorage(password string) (string, string, error) {
-	// ソルトを生成
-	salt, err := generateSalt(32)
-	if err != nil {
-		return "", "", fmt.Errorf("ソルト生成エラー: %v", err)
-	}
-
-	// パスワードをハッシュ化
-	hashedPassword := hashPasswordWithSalt(password, salt)
+// verifyLegacyPassword は従来の形式（SHA-256ハッシュと別フィールドのソルト）のパスワードを定数時間で検証します
+func verifyLegacyPassword(password, hashedPassword, salt string) bool {
+	inputHash := hashPasswordWithSalt(password, salt)
+	return subtle.ConstantTimeCompare([]byte(inputHash), []byte(strings.ToLower(hashedPassword))) == 1
+}
 
-	return hashedPassword, salt, nil
+// hashPassword はパスワードをストレージ用にハッシュ化し、PHC文字列を返します
+func hashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
 }
 
-// VerifyPassword はパスワードを検証します
-func VerifyPassword(password, hashedPassword, salt string) bool {
-	// 入力されたパスワードを同じソルトでハッシュ化
-	inputHash := hashPasswordWithSalt(password, salt)
-	
-	// ハッシュ値を比較
-	return strings.EqualFold(inputHash, hashedPassword)
-} 
\ No newline at end of file
+// verifyPassword は保存されたハッシュでパスワードを検証します
+// 従来の形式や古いパラメータのハッシュと一致した場合は needsRehash が true になります
+func verifyPassword(password, hashedPassword, salt string) (ok bool, needsRehash bool, err error) {
+	if salt != "" && !strings.HasPrefix(hashedPassword, "$") {
+		return verifyLegacyPassword(password, hashedPassword, salt), true, nil
+	}
+	return passwordHasher.Verify(password, hashedPassword)
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
 // SchedulePostRequest は、POSTリクエストのJSONボディの構造を定義します。
 // これにより、型安全なデコードが可能になります。
 type SchedulePostRequest struct {
@@ -8,6 +14,10 @@ type SchedulePostRequest struct {
 	EndDate        *string                `json:"endDate,omitempty"`
 	Events         map[string][]TimeEntry `json:"events"`
 	SpaceId        *string                `json:"spaceId,omitempty"` // 既存のspaceId（再同期時）
+	// Title はスペースの名前です。更新で省略した場合は保存済みの値を使用します
+	Title *string `json:"title,omitempty"`
+	// TimeZone はスペースのIANAタイムゾーンです。省略した場合、新規作成では作成者のタイムゾーン、更新では保存済みの値を使用します
+	TimeZone *string `json:"timeZone,omitempty"`
 }
 
 // Firestoreに保存する際のキー名を小文字にするため、`firestore`タグを追加
@@ -20,10 +30,79 @@ type TimeEntry struct {
 }
 
 // StartDateとEndDateをポインタ型(*string)にし、omitemptyタグを追加
+// jsonタグはFirestoreのフィールド名と揃え、GET /api/time のレスポンス形式を保つ
 type ScheduleDocument struct {
 	OwnerUID       string                 `json:"ownerUid,omitempty" firestore:"ownerUid,omitempty"`
-	AllowOtherEdit bool                   `firestore:"allowOtherEdit"`
-	StartDate      *string                `firestore:"startDate,omitempty"`
-	EndDate        *string                `firestore:"endDate,omitempty"`
-	Events         map[string][]TimeEntry `firestore:"events"`
+	Title          string                 `json:"title,omitempty" firestore:"title,omitempty"`
+	AllowOtherEdit bool                   `json:"allowOtherEdit" firestore:"allowOtherEdit"`
+	StartDate      *string                `json:"startDate,omitempty" firestore:"startDate,omitempty"`
+	EndDate        *string                `json:"endDate,omitempty" firestore:"endDate,omitempty"`
+	Events         map[string][]TimeEntry `json:"events" firestore:"events"`
+	// TimeZone はスペースのIANAタイムゾーンです。Events の日付と時刻はこのタイムゾーンで保存します
+	TimeZone string `json:"timeZone,omitempty" firestore:"timeZone,omitempty"`
+	// Participants はログインして予定を登録した参加者です（キーはUID）。レスポンスにはUIDを含めません
+	Participants map[string]SpaceParticipant `json:"participants,omitempty" firestore:"participants,omitempty"`
+	// CreatedAt・UpdatedAt はスペースの作成日時と最後に更新した日時です（導入前のスペースは未設定）
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+	// ExpiresAt は非ログインで作成したスペースの有効期限です。期限を過ぎるとクリーンアップで削除されます
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" firestore:"expiresAt,omitempty"`
+	// Archived はオーナーがアーカイブしたスペースかを表します。アーカイブしたスペースは閲覧のみできます
+	Archived   bool       `json:"archived,omitempty" firestore:"archived,omitempty"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty" firestore:"archivedAt,omitempty"`
+	// Decision はオーナーが確定した日時です。確定したスペースの予定は変更できません
+	Decision *SpaceDecision `json:"decision,omitempty" firestore:"decision,omitempty"`
+	// MemberUIDs はオーナーと、予定を登録したログインユーザーのUIDです（「自分のスペース」の検索用）
+	// レスポンスには含めません
+	MemberUIDs []string `json:"memberUids,omitempty" firestore:"memberUids,omitempty"`
+	// LinkRequired が true の場合、spaceId だけでは閲覧・編集できず、共有リンクの提示が必要です
+	// （オーナーとログインして予定を登録した参加者を除く）
+	LinkRequired bool `json:"linkRequired,omitempty" firestore:"linkRequired,omitempty"`
+	// ShareLinks はオーナーが発行した共有リンクです。レスポンスには含めません
+	ShareLinks []SpaceShareLink `json:"shareLinks,omitempty" firestore:"shareLinks,omitempty"`
+	// EditKeyHash は非ログインで作成したスペースの編集キーのハッシュです。レスポンスには含めません
+	EditKeyHash string `json:"editKeyHash,omitempty" firestore:"editKeyHash,omitempty"`
+	// Revision は保存のたびに1ずつ増えるリビジョン番号です。ETag / If-Match による競合検出に使用します
+	Revision int64 `json:"revision" firestore:"revision"`
+}
+
+// scheduleDocumentToMap はScheduleDocumentをレスポンス用のマップに変換します
+func scheduleDocumentToMap(doc *ScheduleDocument) map[string]interface{} {
+	result := make(map[string]interface{})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return result
+	}
+	json.Unmarshal(data, &result)
+
+	// タイムゾーン導入前のスペースは既定のタイムゾーンとして扱う
+	result["timeZone"] = effectiveTimeZone(doc.TimeZone)
+
+	// 作成日時・更新日時の導入前のスペースは返さない
+	if doc.CreatedAt.IsZero() {
+		delete(result, "createdAt")
+	}
+	if doc.UpdatedAt.IsZero() {
+		delete(result, "updatedAt")
+	}
+
+	// 編集キー・共有リンクのハッシュと参加者のUIDは公開しない
+	delete(result, "editKeyHash")
+	delete(result, "shareLinks")
+	delete(result, "memberUids")
+	delete(result, "participants")
+	if len(doc.Participants) > 0 {
+		participants := make([]map[string]interface{}, 0, len(doc.Participants))
+		for _, participant := range doc.Participants {
+			participants = append(participants, map[string]interface{}{
+				"username": participant.Username,
+				"scope":    participant.Scope,
+			})
+		}
+		sort.Slice(participants, func(i, j int) bool {
+			return participants[i]["username"].(string) < participants[j]["username"].(string)
+		})
+		result["participants"] = participants
+	}
+	return result
 }
\ No newline at end of file
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+)
+
+// AuthMethod はリクエストがどの方式で認証されたかを表します
+type AuthMethod string
+
+const (
+	// authMethodSession はこのサーバーが発行したセッショントークン（JWT）による認証です
+	authMethodSession AuthMethod = "session"
+	// authMethodFirebaseIDToken はFirebase AuthenticationのIDトークンによる認証です
+	authMethodFirebaseIDToken AuthMethod = "firebase_id_token"
+)
+
+// 認証ヘッダーの検証で返されるエラーです
+var (
+	ErrMissingAuthHeader = errors.New("authorization header is missing")
+	ErrInvalidAuthHeader = errors.New("invalid authorization header format")
+)
+
+// Principal は認証済みのリクエスト主体です
+// セッショントークンとFirebaseのIDトークンのどちらで認証された場合もこの型に解決されます
+type Principal struct {
+	UID    string
+	Email  string
+	Method AuthMethod
+	// Scopes はトークンに付与された権限です。空の場合は制限なしとして扱います
+	Scopes []string
+	// SessionID はセッショントークンのIDです（IDトークンによる認証の場合は空）
+	SessionID string
+}
+
+// HasScope はPrincipalが指定された権限を持つかを返します
+func (p *Principal) HasScope(scope string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// principalContextKey は、コンテキスト内でPrincipalを保存・取得するためのキーです。
+const principalContextKey userContextKey = "principal"
+
+// withPrincipal は、Principalを含む新しいコンテキストを生成します。
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// principalFromContext は、コンテキストからPrincipalを取得します。非ログインの場合は false を返します
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// principalFromSession はセッション情報をPrincipalに変換します
+func principalFromSession(session *UserSession) *Principal {
+	return &Principal{
+		UID:       session.UID,
+		Email:     session.Email,
+		Method:    authMethodSession,
+		Scopes:    session.Scopes,
+		SessionID: session.SessionID,
+	}
+}
+
+// parseBearerToken はAuthorizationヘッダーから "Bearer " に続くトークンを取り出します
+func parseBearerToken(authHeader string) (string, error) {
+	if authHeader == "" {
+		return "", ErrMissingAuthHeader
+	}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" || parts[1] == "" {
+		return "", ErrInvalidAuthHeader
+	}
+	return parts[1], nil
+}
+
+// authenticate はAuthorizationヘッダーのトークンを検証し、Principalを返します
+// まずセッショントークンとして検証し、失敗した場合は認証プロバイダーのIDトークンとして検証します
+func authenticate(ctx context.Context, authHeader string) (*Principal, error) {
+	token, err := parseBearerToken(authHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	session, sessionErr := validateSessionToken(ctx, token)
+	if sessionErr == nil {
+		return principalFromSession(session), nil
+	}
+
+	principal, err := identityProvider.VerifyIDToken(ctx, token)
+	if err != nil {
+		log.Printf("DEBUG: Token is neither a valid session token (%v) nor ID token (%v)", sessionErr, err)
+		return nil, err
+	}
+	return principal, nil
+}
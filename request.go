@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Request はトランスポート（net/http・API Gateway V1/V2）に依存しないリクエストです
+// process* 関数はこの型のみを受け取るため、テストでは構造体を直接組み立てて呼び出せます
+// 認証済みのユーザーはリクエストではなくコンテキストの Principal（principal.go）から取得します
+type Request struct {
+	Method string
+	Path   string
+	// PathParams はルートのパスパターン（"/api/time/{spaceId}" など）から抽出された値です
+	PathParams map[string]string
+	// Query はクエリパラメータです。同じキーが複数ある場合は最初の値を保持します
+	Query map[string]string
+	// Headers のキーは小文字に正規化されています
+	Headers map[string]string
+	Body    []byte
+
+	// responseHeaders はハンドラがレスポンスに付与するヘッダーです（SetResponseHeader で設定）
+	responseHeaders map[string]string
+}
+
+// Header は指定されたヘッダーの値を返します（大文字・小文字は区別しません）
+func (r *Request) Header(name string) string {
+	return r.Headers[strings.ToLower(name)]
+}
+
+// PathParam はパスパラメータの値を返します
+func (r *Request) PathParam(name string) string {
+	return r.PathParams[name]
+}
+
+// QueryParam はクエリパラメータの値を返します
+func (r *Request) QueryParam(name string) string {
+	return r.Query[name]
+}
+
+// DecodeJSON はリクエストボディをJSONとして v にデコードします
+func (r *Request) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// SetResponseHeader はレスポンスに付与するヘッダーを設定します（ETag など）
+func (r *Request) SetResponseHeader(name, value string) {
+	if r.responseHeaders == nil {
+		r.responseHeaders = make(map[string]string)
+	}
+	r.responseHeaders[name] = value
+}
+
+// Response はトランスポートに依存しないレスポンスです
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       map[string]interface{}
+}
+
+// normalizeHeaders はヘッダー名を小文字に正規化します
+func normalizeHeaders(headers map[string]string) map[string]string {
+	normalized := make(map[string]string, len(headers))
+	for name, value := range headers {
+		normalized[strings.ToLower(name)] = value
+	}
+	return normalized
+}
+
+// requestFromHTTP は net/http のリクエストを Request に変換します
+func requestFromHTTP(r *http.Request) (*Request, error) {
+	var body []byte
+	if r.Body != nil {
+		defer r.Body.Close()
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	query := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		if len(values) > 0 {
+			query[name] = values[0]
+		}
+	}
+
+	return &Request{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   query,
+		Headers: headers,
+		Body:    body,
+	}, nil
+}
+
+// decodeAPIGatewayBody はAPI Gatewayのイベントのボディを返します
+// IsBase64Encoded が設定されている場合（バイナリとして扱われたボディ）は、Base64をデコードします
+func decodeAPIGatewayBody(body string, isBase64Encoded bool) ([]byte, error) {
+	if !isBase64Encoded {
+		return []byte(body), nil
+	}
+	return base64.StdEncoding.DecodeString(body)
+}
+
+// requestFromAPIGatewayV1 はAPI Gateway REST API（ペイロード1.0）のイベントを Request に変換します
+func requestFromAPIGatewayV1(r events.APIGatewayProxyRequest) (*Request, error) {
+	query := make(map[string]string, len(r.QueryStringParameters))
+	for name, value := range r.QueryStringParameters {
+		query[name] = value
+	}
+
+	body, err := decodeAPIGatewayBody(r.Body, r.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Request{
+		Method:  r.HTTPMethod,
+		Path:    r.Path,
+		Query:   query,
+		Headers: normalizeHeaders(r.Headers),
+		Body:    body,
+	}, nil
+}
+
+// requestFromAPIGatewayV2 はAPI Gateway HTTP API（ペイロード2.0）のイベントを Request に変換します
+func requestFromAPIGatewayV2(r events.APIGatewayV2HTTPRequest) (*Request, error) {
+	query := make(map[string]string, len(r.QueryStringParameters))
+	for name, value := range r.QueryStringParameters {
+		query[name] = value
+	}
+
+	body, err := decodeAPIGatewayBody(r.Body, r.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Request{
+		Method:  r.RequestContext.HTTP.Method,
+		Path:    r.RequestContext.HTTP.Path,
+		Query:   query,
+		Headers: normalizeHeaders(r.Headers),
+		Body:    body,
+	}, nil
+}
+
+// writeHTTPResponse は Response を net/http のレスポンスとして書き込みます
+func writeHTTPResponse(w http.ResponseWriter, resp *Response) {
+	for name, value := range resp.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	json.NewEncoder(w).Encode(resp.Body)
+}
+
+// marshalResponseBody はレスポンスボディをJSON文字列に変換します
+// 変換に失敗した場合は500エラーのボディを返します
+func marshalResponseBody(resp *Response) (int, string) {
+	body, err := json.Marshal(resp.Body)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":\"Failed to process the response\"}"
+	}
+	return resp.StatusCode, string(body)
+}
+
+// responseHeaders はCORSヘッダーとレスポンス固有のヘッダーを結合します
+func responseHeaders(resp *Response) map[string]string {
+	headers := getCorsHeaders()
+	headers["Content-Type"] = "application/json"
+	for name, value := range resp.Headers {
+		headers[name] = value
+	}
+	return headers
+}
+
+// toAPIGatewayV1 は Response をAPI Gatewayのプロキシレスポンス（ペイロード1.0形式）に変換します
+func (resp *Response) toAPIGatewayV1() events.APIGatewayProxyResponse {
+	statusCode, body := marshalResponseBody(resp)
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    responseHeaders(resp),
+		Body:       body,
+	}
+}
+
+// toAPIGatewayV2 は Response をAPI Gateway HTTP APIのレスポンス（ペイロード2.0形式）に変換します
+func (resp *Response) toAPIGatewayV2() events.APIGatewayV2HTTPResponse {
+	statusCode, body := marshalResponseBody(resp)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Headers:    responseHeaders(resp),
+		Body:       body,
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestRequestFromAPIGateway(t *testing.T) {
+	const body = `{"title":"定例"}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+
+	tests := []struct {
+		name     string
+		body     string
+		isBase64 bool
+		wantErr  bool
+	}{
+		{"plain body", body, false, false},
+		{"base64 body", encoded, true, false},
+		{"invalid base64 body", "not base64!", true, true},
+	}
+	for _, tt := range tests {
+		t.Run("v1 "+tt.name, func(t *testing.T) {
+			req, err := requestFromAPIGatewayV1(events.APIGatewayProxyRequest{
+				HTTPMethod:            http.MethodPost,
+				Path:                  "/api/time",
+				Headers:               map[string]string{"If-Match": `"3"`},
+				QueryStringParameters: map[string]string{"limit": "10"},
+				Body:                  tt.body,
+				IsBase64Encoded:       tt.isBase64,
+			})
+			checkAPIGatewayRequest(t, req, err, tt.wantErr, body)
+		})
+		t.Run("v2 "+tt.name, func(t *testing.T) {
+			event := events.APIGatewayV2HTTPRequest{
+				Headers:               map[string]string{"if-match": `"3"`},
+				QueryStringParameters: map[string]string{"limit": "10"},
+				Body:                  tt.body,
+				IsBase64Encoded:       tt.isBase64,
+			}
+			event.RequestContext.HTTP.Method = http.MethodPost
+			event.RequestContext.HTTP.Path = "/api/time"
+			req, err := requestFromAPIGatewayV2(event)
+			checkAPIGatewayRequest(t, req, err, tt.wantErr, body)
+		})
+	}
+}
+
+// checkAPIGatewayRequest はAPI Gatewayのイベントから変換した Request を検証します
+func checkAPIGatewayRequest(t *testing.T, req *Request, err error, wantErr bool, wantBody string) {
+	t.Helper()
+	if wantErr {
+		if err == nil {
+			t.Error("expected a decoding error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Method != http.MethodPost || req.Path != "/api/time" {
+		t.Errorf("Method, Path = %s %s, want POST /api/time", req.Method, req.Path)
+	}
+	if got := req.Header("If-Match"); got != `"3"` {
+		t.Errorf("If-Match = %q, want %q", got, `"3"`)
+	}
+	if got := req.QueryParam("limit"); got != "10" {
+		t.Errorf("limit = %q, want 10", got)
+	}
+	if string(req.Body) != wantBody {
+		t.Errorf("Body = %q, want %q", req.Body, wantBody)
+	}
+}
+
+func TestResponseToAPIGateway(t *testing.T) {
+	resp := &Response{
+		StatusCode: http.StatusConflict,
+		Headers:    map[string]string{"ETag": `"4"`},
+		Body:       map[string]interface{}{"code": "revision_conflict"},
+	}
+	const wantBody = `{"code":"revision_conflict"}`
+
+	v1 := resp.toAPIGatewayV1()
+	if v1.StatusCode != http.StatusConflict || v1.Body != wantBody {
+		t.Errorf("toAPIGatewayV1() = %d %s, want %d %s", v1.StatusCode, v1.Body, http.StatusConflict, wantBody)
+	}
+	if v1.Headers["ETag"] != `"4"` || v1.Headers["Content-Type"] != "application/json" {
+		t.Errorf("toAPIGatewayV1() headers = %v", v1.Headers)
+	}
+
+	v2 := resp.toAPIGatewayV2()
+	if v2.StatusCode != http.StatusConflict || v2.Body != wantBody {
+		t.Errorf("toAPIGatewayV2() = %d %s, want %d %s", v2.StatusCode, v2.Body, http.StatusConflict, wantBody)
+	}
+	if v2.Headers["ETag"] != `"4"` || v2.Headers["Content-Type"] != "application/json" {
+		t.Errorf("toAPIGatewayV2() headers = %v", v2.Headers)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrRevisionConflict は If-Match のリビジョンが保存済みのリビジョンと一致しない場合に返されます
+var ErrRevisionConflict = errors.New("revision conflict")
+
+// revisionETag はリビジョン番号をETagヘッダーの値（強いETag）に変換します
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// ifMatchSatisfied は If-Match ヘッダーが現在のリビジョンと一致するかを返します
+// ヘッダーがない場合と "*" の場合は常に一致とみなします。引用符のない数値も受け付けます
+func ifMatchSatisfied(ifMatch string, revision int64) bool {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		tag = strings.Trim(tag, `"`)
+		if value, err := strconv.ParseInt(tag, 10, 64); err == nil && value == revision {
+			return true
+		}
+	}
+	return false
+}
+
+// revisionConflictResponse は409レスポンスを作成します。current には保存済みの最新の状態を渡します
+func revisionConflictResponse(req *Request, revision int64, current map[string]interface{}) (map[string]interface{}, int) {
+	req.SetResponseHeader("ETag", revisionETag(revision))
+	return map[string]interface{}{
+		"error":    "他の利用者によって更新されています。最新の内容を確認してください",
+		"code":     "revision_conflict",
+		"revision": revision,
+		"current":  current,
+	}, http.StatusConflict
+}
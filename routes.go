@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// RouteHandler はルートに対応する処理です
+// req は各トランスポートのアダプター（request.go）で Request に変換されたものが渡されます
+type RouteHandler func(ctx context.Context, req *Request) (map[string]interface{}, int)
+
+// AuthRequirement はルートが要求する認証レベルです
+type AuthRequirement int
+
+const (
+	// authNone は認証を行いません
+	authNone AuthRequirement = iota
+	// authOptional はIDトークンがあれば検証し、失敗しても非ログインユーザーとして続行します
+	authOptional
+	// authRequired はセッショントークンによる認証を必須とします
+	authRequired
+)
+
+// Route はメソッド・パスパターン・認証要件・ハンドラの組です
+// Pattern の "{name}" で囲まれたセグメントはパスパラメータとして Request.PathParam で取得できます
+type Route struct {
+	Method  string
+	Pattern string
+	Auth    AuthRequirement
+	Handler RouteHandler
+}
+
+// routes はローカルサーバーとLambdaの両方で使用するルート定義です
+// エンドポイントを追加する場合はここに追記してください
+var routes = []Route{
+	{http.MethodPost, "/api/time", authOptional, processPostRequest},
+	{http.MethodGet, "/api/time/{spaceId}", authOptional, processGetTimeRequest},
+	{http.MethodDelete, "/api/time/{spaceId}", authOptional, processSpaceDeleteRequest},
+	{http.MethodPut, "/api/time/{spaceId}/archive", authOptional, processSpaceArchiveRequest},
+	{http.MethodGet, "/api/time/{spaceId}/audit", authOptional, processSpaceAuditRequest},
+	{http.MethodPost, "/api/time/{spaceId}/claim", authRequired, requirePrincipal(processSpaceClaimRequest)},
+	{http.MethodGet, "/api/time/{spaceId}/availability", authOptional, processSpaceAvailabilityRequest},
+	{http.MethodPost, "/api/time/{spaceId}/decision", authOptional, processSpaceDecisionRequest},
+	{http.MethodPut, "/api/time/{spaceId}/share-settings", authOptional, processSpaceShareSettingsRequest},
+	{http.MethodGet, "/api/time/{spaceId}/share-links", authOptional, processSpaceShareLinksRequest},
+	{http.MethodPost, "/api/time/{spaceId}/share-links", authOptional, processSpaceShareLinkCreateRequest},
+	{http.MethodPost, "/api/time/{spaceId}/share-links/{linkId}/regenerate", authOptional, processSpaceShareLinkRegenerateRequest},
+	{http.MethodDelete, "/api/time/{spaceId}/share-links/{linkId}", authOptional, processSpaceShareLinkRevokeRequest},
+	{http.MethodGet, "/api/spaces", authRequired, requirePrincipal(processSpaceListRequest)},
+	{http.MethodPut, "/api/time/{spaceId}/participants/{username}", authOptional, processSpaceParticipantScopeRequest},
+	{http.MethodPut, "/api/time/{spaceId}/entries/{participant}", authOptional, processSpaceEntriesRequest},
+	{http.MethodPatch, "/api/time/{spaceId}/entries/{participant}", authOptional, processSpaceEntriesRequest},
+	{http.MethodDelete, "/api/time/{spaceId}/entries/{participant}", authOptional, processSpaceEntriesRequest},
+
+	{http.MethodPost, "/api/signup", authNone, processSignupRequest},
+	{http.MethodPost, "/api/login", authNone, processLoginRequest},
+	{http.MethodPost, "/api/verify", authNone, ProcessVerifyRequest},
+	{http.MethodPost, "/api/token/refresh", authNone, processRefreshRequest},
+	{http.MethodGet, "/.well-known/jwks.json", authNone, processJWKSRequest},
+	{http.MethodPost, "/api/logout", authRequired, requirePrincipal(processLogoutRequest)},
+	{http.MethodGet, "/api/sessions", authRequired, requirePrincipal(processListSessionsRequest)},
+	{http.MethodDelete, "/api/sessions", authRequired, requirePrincipal(processRevokeOtherSessionsRequest)},
+	{http.MethodDelete, "/api/sessions/{sessionId}", authRequired, requirePrincipal(processRevokeSessionRequest)},
+	{http.MethodPost, "/api/cleanup", authNone, ProcessCleanupRequest},
+
+	{http.MethodGet, "/api/auth/public-key", authNone, processPasswordPublicKeyRequest},
+	{http.MethodPost, "/api/auth/google", authNone, processGoogleAuthRequest},
+	{http.MethodPost, "/api/auth/github", authNone, processGitHubAuthRequest},
+	{http.MethodPost, "/api/auth/twitter", authNone, processTwitterAuthRequest},
+
+	{http.MethodGet, "/api/user-data", authRequired, requirePrincipal(func(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+		return processUserDataGetRequest(ctx, principal)
+	})},
+	{http.MethodPost, "/api/user-data", authRequired, requirePrincipal(processUserDataSaveRequest)},
+	{http.MethodGet, "/api/user-profile", authRequired, requirePrincipal(func(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+		return processUserProfileRequest(ctx, principal)
+	})},
+	{http.MethodGet, "/api/user-providers", authRequired, requirePrincipal(func(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+		return processUserProvidersRequest(ctx, principal)
+	})},
+	{http.MethodGet, "/api/user-providers-detail", authRequired, requirePrincipal(func(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int) {
+		return processUserProvidersDetailRequest(ctx, principal)
+	})},
+	{http.MethodPost, "/api/link-account", authRequired, requirePrincipal(processLinkAccountRequest)},
+	{http.MethodPost, "/api/unlink-account", authRequired, requirePrincipal(processUnlinkAccountRequest)},
+
+	{http.MethodGet, "/api/task", authRequired, processTaskGetRequest},
+	{http.MethodPost, "/api/task", authRequired, processTaskSaveRequest},
+	{http.MethodPost, "/api/task/sync", authRequired, processTaskSyncRequest},
+	{http.MethodPost, "/api/task/items", authRequired, requirePrincipal(processTaskItemCreateRequest)},
+	{http.MethodGet, "/api/task/items/{taskId}", authRequired, requirePrincipal(processTaskItemGetRequest)},
+	{http.MethodPatch, "/api/task/items/{taskId}", authRequired, requirePrincipal(processTaskItemPatchRequest)},
+	{http.MethodDelete, "/api/task/items/{taskId}", authRequired, requirePrincipal(processTaskItemDeleteRequest)},
+	{http.MethodPost, "/api/task/items/{taskId}/move", authRequired, requirePrincipal(processTaskItemMoveRequest)},
+	{http.MethodGet, "/api/task/recurring", authRequired, requirePrincipal(processRecurringTaskListRequest)},
+	{http.MethodPost, "/api/task/recurring", authRequired, requirePrincipal(processRecurringTaskCreateRequest)},
+	{http.MethodPut, "/api/task/recurring/{recurringId}", authRequired, requirePrincipal(processRecurringTaskUpdateRequest)},
+	{http.MethodDelete, "/api/task/recurring/{recurringId}", authRequired, requirePrincipal(processRecurringTaskDeleteRequest)},
+	{http.MethodPut, "/api/task/recurring/{recurringId}/occurrences/{date}", authRequired, requirePrincipal(processRecurringOccurrenceRequest)},
+	{http.MethodDelete, "/api/task/recurring/{recurringId}/occurrences/{date}", authRequired, requirePrincipal(processRecurringOccurrenceRequest)},
+
+	{http.MethodGet, "/email-config", authNone, func(ctx context.Context, req *Request) (map[string]interface{}, int) {
+		return checkEmailConfig()
+	}},
+	{http.MethodGet, "/email-debug", authNone, func(ctx context.Context, req *Request) (map[string]interface{}, int) {
+		return checkEmailDebug()
+	}},
+}
+
+// requirePrincipal はコンテキストのPrincipalを引数で受け取るハンドラを RouteHandler に変換します
+func requirePrincipal(fn func(ctx context.Context, req *Request, principal *Principal) (map[string]interface{}, int)) RouteHandler {
+	return func(ctx context.Context, req *Request) (map[string]interface{}, int) {
+		principal, ok := principalFromContext(ctx)
+		if !ok {
+			return map[string]interface{}{"error": "認証が必要です"}, http.StatusUnauthorized
+		}
+		return fn(ctx, req, principal)
+	}
+}
+
+// splitPath はパスを "/" 区切りのセグメントに分割します（前後のスラッシュは無視）
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// matchPattern はパスがパターンに一致するかを判定し、一致した場合はパスパラメータを返します
+func matchPattern(pattern, path string) (map[string]string, bool) {
+	patternSegments := splitPath(pattern)
+	pathSegments := splitPath(path)
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// findRoute はメソッドとパスに一致するルートを検索します
+// パスは一致するがメソッドが一致しない場合は methodAllowed が false になります
+func findRoute(method, path string) (route *Route, params map[string]string, methodAllowed bool) {
+	pathMatched := false
+	for i := range routes {
+		p, ok := matchPattern(routes[i].Pattern, path)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if routes[i].Method == method {
+			return &routes[i], p, true
+		}
+	}
+	return nil, nil, !pathMatched
+}
+
+// dispatchRoute はルート定義に従って認証とハンドラの呼び出しを行います
+// ローカルサーバーとLambdaの両方からこの関数を経由してリクエストを処理します
+func dispatchRoute(ctx context.Context, req *Request) *Response {
+	route, params, methodAllowed := findRoute(req.Method, req.Path)
+	if route == nil {
+		if !methodAllowed {
+			return &Response{StatusCode: http.StatusMethodNotAllowed, Body: map[string]interface{}{"error": "許可されていないメソッドです"}}
+		}
+		log.Printf("No route matched for method [%s] and path [%s]", req.Method, req.Path)
+		return &Response{StatusCode: http.StatusNotFound, Body: map[string]interface{}{"error": "Not Found", "requestedPath": req.Path}}
+	}
+	req.PathParams = params
+
+	ctx, errResp := authMiddleware(ctx, route.Auth, req)
+	if errResp != nil {
+		return errResp
+	}
+
+	body, statusCode := route.Handler(ctx, req)
+	return &Response{StatusCode: statusCode, Headers: req.responseHeaders, Body: body}
+}
+
+// authMiddleware はルートの認証要件に従ってリクエストを認証し、成功した場合はPrincipalをコンテキストに格納します
+// 認証が必須のルートで認証に失敗した場合は、返すべきエラーレスポンスを返します
+func authMiddleware(ctx context.Context, requirement AuthRequirement, req *Request) (context.Context, *Response) {
+	authHeader := req.Header("Authorization")
+	switch requirement {
+	case authRequired:
+		principal, err := authenticate(ctx, authHeader)
+		if err != nil {
+			log.Printf("ERROR: Authentication failed for %s %s: %v", req.Method, req.Path, err)
+			if errors.Is(err, ErrMissingAuthHeader) {
+				return ctx, &Response{StatusCode: http.StatusUnauthorized, Body: map[string]interface{}{"error": "認証が必要です"}}
+			}
+			return ctx, &Response{StatusCode: http.StatusUnauthorized, Body: map[string]interface{}{"error": "認証に失敗しました"}}
+		}
+		return withPrincipal(ctx, principal), nil
+	case authOptional:
+		// ヘッダーがない場合や検証に失敗した場合は、非ログインユーザーとして続行します
+		if authHeader == "" {
+			return ctx, nil
+		}
+		principal, err := authenticate(ctx, authHeader)
+		if err != nil {
+			log.Printf("WARN: Failed to verify token, proceeding as anonymous: %v\n", err)
+			return ctx, nil
+		}
+		return withPrincipal(ctx, principal), nil
+	}
+	return ctx, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// taskDateLayout はタスクの日付キーの形式です
+const taskDateLayout = "2006-01-02"
+
+// rruleMaxSpan は繰り返しを展開する期間の上限です（COUNT の数え上げを含む）
+const rruleMaxSpan = 100 * 366 * 24 * time.Hour
+
+// ErrUnsupportedRRule はこのサーバーが解釈できないRRULEの場合に返されます
+var ErrUnsupportedRRule = errors.New("unsupported RRULE")
+
+// rruleWeekdays はRRULEの曜日の表記です
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// rruleWeekday はBYDAYの1要素です。N が 0 以外の場合は月（または年）の第N曜日を表します（負数は末尾から）
+type rruleWeekday struct {
+	N   int
+	Day time.Weekday
+}
+
+// RecurrenceRule はRFC 5545のRRULEのうち、日付単位の繰り返しに必要な部分です
+//
+// 対応: FREQ（DAILY・WEEKLY・MONTHLY・YEARLY）、INTERVAL、COUNT、UNTIL、BYDAY、BYMONTHDAY、BYMONTH、WKST
+type RecurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []rruleWeekday
+	ByMonthDay []int
+	ByMonth    []int
+	WeekStart  time.Weekday
+}
+
+// parseRRule はRRULE文字列（"RRULE:" の接頭辞は省略可）を解析します
+func parseRRule(value string) (*RecurrenceRule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	rule := &RecurrenceRule{Interval: 1, WeekStart: time.Monday}
+
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("RRULE の形式が正しくありません: %s", part)
+		}
+
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(raw)
+		case "INTERVAL":
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("INTERVAL の値が正しくありません: %s", raw)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("COUNT の値が正しくありません: %s", raw)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseRRuleDate(raw)
+			if err != nil {
+				return nil, fmt.Errorf("UNTIL の値が正しくありません: %s", raw)
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, item := range strings.Split(strings.ToUpper(raw), ",") {
+				if len(item) < 2 {
+					return nil, fmt.Errorf("BYDAY の値が正しくありません: %s", item)
+				}
+				day, ok := rruleWeekdays[item[len(item)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("BYDAY の値が正しくありません: %s", item)
+				}
+				weekday := rruleWeekday{Day: day}
+				if prefix := item[:len(item)-2]; prefix != "" {
+					n, err := strconv.Atoi(prefix)
+					if err != nil || n == 0 || n < -53 || n > 53 {
+						return nil, fmt.Errorf("BYDAY の値が正しくありません: %s", item)
+					}
+					weekday.N = n
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(raw, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("BYMONTHDAY の値が正しくありません: %s", item)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, item := range strings.Split(raw, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("BYMONTH の値が正しくありません: %s", item)
+				}
+				rule.ByMonth = append(rule.ByMonth, n)
+			}
+		case "WKST":
+			day, ok := rruleWeekdays[strings.ToUpper(raw)]
+			if !ok {
+				return nil, fmt.Errorf("WKST の値が正しくありません: %s", raw)
+			}
+			rule.WeekStart = day
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedRRule, name)
+		}
+	}
+
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return nil, errors.New("RRULE に FREQ が指定されていません")
+	default:
+		return nil, fmt.Errorf("%w: FREQ=%s", ErrUnsupportedRRule, rule.Freq)
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return nil, errors.New("COUNT と UNTIL は同時に指定できません")
+	}
+	for _, weekday := range rule.ByDay {
+		if weekday.N != 0 && rule.Freq != "MONTHLY" && rule.Freq != "YEARLY" {
+			return nil, errors.New("第N曜日の指定は FREQ=MONTHLY または YEARLY でのみ使用できます")
+		}
+	}
+	return rule, nil
+}
+
+// parseRRuleDate はUNTIL・EXDATEの値（YYYYMMDD、日時形式、YYYY-MM-DD）を日付に変換します
+func parseRRuleDate(value string) (time.Time, error) {
+	value = strings.TrimSuffix(value, "Z")
+	if date, _, ok := strings.Cut(value, "T"); ok {
+		value = date
+	}
+	for _, layout := range []string{"20060102", taskDateLayout} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("日付の形式が正しくありません: %s", value)
+}
+
+// Occurrences は dtstart から始まる繰り返しのうち、from から to（両端を含む）の日付を返します
+// RFC 5545 と同様に、dtstart は常に最初の1回として数えます
+func (r *RecurrenceRule) Occurrences(dtstart, from, to time.Time) []time.Time {
+	end := to
+	if r.Until != nil && r.Until.Before(end) {
+		end = *r.Until
+	}
+	if limit := dtstart.Add(rruleMaxSpan); limit.Before(end) {
+		end = limit
+	}
+
+	// COUNT がない場合は from より前の回数を数える必要がないため、from から調べる
+	start := dtstart
+	if r.Count == 0 && from.After(start) {
+		start = from
+	}
+
+	var result []time.Time
+	count := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if !d.Equal(dtstart) && !r.matches(dtstart, d) {
+			continue
+		}
+		count++
+		if !d.Before(from) {
+			result = append(result, d)
+		}
+		if r.Count > 0 && count >= r.Count {
+			break
+		}
+	}
+	return result
+}
+
+// matches は日付 d が繰り返しの条件に一致するかを判定します
+func (r *RecurrenceRule) matches(dtstart, d time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(d.Month())) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !matchesMonthDay(r.ByMonthDay, d) {
+		return false
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		if daysBetween(dtstart, d)%r.Interval != 0 {
+			return false
+		}
+		return len(r.ByDay) == 0 || r.matchesWeekday(d, false)
+	case "WEEKLY":
+		weeks := daysBetween(r.weekStartOf(dtstart), r.weekStartOf(d)) / 7
+		if weeks%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return d.Weekday() == dtstart.Weekday()
+		}
+		return r.matchesWeekday(d, false)
+	case "MONTHLY":
+		months := (d.Year()-dtstart.Year())*12 + int(d.Month()) - int(dtstart.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+			return d.Day() == dtstart.Day()
+		}
+		return len(r.ByDay) == 0 || r.matchesWeekday(d, false)
+	case "YEARLY":
+		if (d.Year()-dtstart.Year())%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) > 0 {
+			// BYMONTH がある場合の第N曜日は月内、ない場合は年内で数える
+			return r.matchesWeekday(d, len(r.ByMonth) == 0)
+		}
+		if len(r.ByMonthDay) > 0 {
+			// BYMONTH がない場合の BYMONTHDAY は毎月の該当日です（BYMONTH・BYMONTHDAY は上で確認済み）
+			return true
+		}
+		if len(r.ByMonth) == 0 && d.Month() != dtstart.Month() {
+			return false
+		}
+		return d.Day() == dtstart.Day()
+	}
+	return false
+}
+
+// matchesWeekday はBYDAYのいずれかに一致するかを判定します。inYear が true の場合、第N曜日を年内で数えます
+func (r *RecurrenceRule) matchesWeekday(d time.Time, inYear bool) bool {
+	for _, weekday := range r.ByDay {
+		if weekday.Day != d.Weekday() {
+			continue
+		}
+		if weekday.N == 0 {
+			return true
+		}
+
+		day, total := d.Day(), daysInMonth(d)
+		if inYear {
+			day, total = d.YearDay(), time.Date(d.Year(), 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		}
+		if weekday.N > 0 && (day-1)/7+1 == weekday.N {
+			return true
+		}
+		if weekday.N < 0 && (total-day)/7+1 == -weekday.N {
+			return true
+		}
+	}
+	return false
+}
+
+// weekStartOf は WKST を週の始まりとして、d を含む週の最初の日を返します
+func (r *RecurrenceRule) weekStartOf(d time.Time) time.Time {
+	offset := (int(d.Weekday()) - int(r.WeekStart) + 7) % 7
+	return d.AddDate(0, 0, -offset)
+}
+
+// matchesMonthDay はBYMONTHDAY（負数は月末から）のいずれかに一致するかを判定します
+func matchesMonthDay(monthDays []int, d time.Time) bool {
+	total := daysInMonth(d)
+	for _, n := range monthDays {
+		if n > 0 && d.Day() == n || n < 0 && d.Day() == total+n+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// daysInMonth は d の月の日数を返します
+func daysInMonth(d time.Time) int {
+	return time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// daysBetween は日付 a から b までの日数を返します
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
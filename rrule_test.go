@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func formatDates(dates []time.Time) []string {
+	result := make([]string, 0, len(dates))
+	for _, d := range dates {
+		result = append(result, d.Format(taskDateLayout))
+	}
+	return result
+}
+
+func TestRecurrenceRuleOccurrences(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		dtstart  string
+		from, to string
+		want     []string
+	}{
+		{
+			name: "daily with interval", rule: "FREQ=DAILY;INTERVAL=2",
+			dtstart: "2026-01-01", from: "2026-01-01", to: "2026-01-07",
+			want: []string{"2026-01-01", "2026-01-03", "2026-01-05", "2026-01-07"},
+		},
+		{
+			name: "count includes occurrences before from", rule: "FREQ=DAILY;COUNT=3",
+			dtstart: "2026-01-01", from: "2026-01-02", to: "2026-01-31",
+			want: []string{"2026-01-02", "2026-01-03"},
+		},
+		{
+			name: "until is inclusive", rule: "RRULE:FREQ=DAILY;UNTIL=20260103T000000Z",
+			dtstart: "2026-01-01", from: "2026-01-01", to: "2026-01-31",
+			want: []string{"2026-01-01", "2026-01-02", "2026-01-03"},
+		},
+		{
+			name: "weekly on several weekdays", rule: "FREQ=WEEKLY;BYDAY=MO,WE",
+			dtstart: "2026-01-05", from: "2026-01-05", to: "2026-01-18",
+			want: []string{"2026-01-05", "2026-01-07", "2026-01-12", "2026-01-14"},
+		},
+		{
+			name: "every other week on the start weekday", rule: "FREQ=WEEKLY;INTERVAL=2",
+			dtstart: "2026-01-05", from: "2026-01-01", to: "2026-02-01",
+			want: []string{"2026-01-05", "2026-01-19"},
+		},
+		{
+			name: "week start MO", rule: "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			dtstart: "1997-08-05", from: "1997-08-01", to: "1997-09-30",
+			want: []string{"1997-08-05", "1997-08-10", "1997-08-19", "1997-08-24"},
+		},
+		{
+			name: "week start SU", rule: "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			dtstart: "1997-08-05", from: "1997-08-01", to: "1997-09-30",
+			want: []string{"1997-08-05", "1997-08-17", "1997-08-19", "1997-08-31"},
+		},
+		{
+			name: "monthly on the last Friday", rule: "FREQ=MONTHLY;BYDAY=-1FR",
+			dtstart: "2026-01-30", from: "2026-01-01", to: "2026-04-30",
+			want: []string{"2026-01-30", "2026-02-27", "2026-03-27", "2026-04-24"},
+		},
+		{
+			name: "monthly on the last day", rule: "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: "2026-01-31", from: "2026-01-01", to: "2026-04-30",
+			want: []string{"2026-01-31", "2026-02-28", "2026-03-31", "2026-04-30"},
+		},
+		{
+			name: "monthly on the 31st skips short months", rule: "FREQ=MONTHLY",
+			dtstart: "2026-01-31", from: "2026-01-01", to: "2026-05-31",
+			want: []string{"2026-01-31", "2026-03-31", "2026-05-31"},
+		},
+		{
+			name: "yearly on a leap day", rule: "FREQ=YEARLY",
+			dtstart: "2024-02-29", from: "2024-01-01", to: "2032-12-31",
+			want: []string{"2024-02-29", "2028-02-29", "2032-02-29"},
+		},
+		{
+			name: "yearly on the fourth Thursday of November", rule: "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+			dtstart: "2026-11-26", from: "2026-01-01", to: "2027-12-31",
+			want: []string{"2026-11-26", "2027-11-25"},
+		},
+		{
+			name: "yearly by month day without month repeats every month", rule: "FREQ=YEARLY;BYMONTHDAY=10;COUNT=4",
+			dtstart: "2026-11-10", from: "2026-01-01", to: "2027-12-31",
+			want: []string{"2026-11-10", "2026-12-10", "2027-01-10", "2027-02-10"},
+		},
+		{
+			name: "range before dtstart", rule: "FREQ=DAILY",
+			dtstart: "2026-01-10", from: "2026-01-01", to: "2026-01-09",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatalf("parseRRule(%q) error: %v", tt.rule, err)
+			}
+			got := formatDates(rule.Occurrences(mustDate(t, tt.dtstart), mustDate(t, tt.from), mustDate(t, tt.to)))
+			if !equalStrings(got, tt.want) {
+				t.Errorf("Occurrences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceRuleMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		date    string
+		want    bool
+	}{
+		{"daily weekdays on Saturday", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", "2026-01-01", "2026-01-03", false},
+		{"daily weekdays on Monday", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", "2026-01-01", "2026-01-05", true},
+		{"daily interval off day", "FREQ=DAILY;INTERVAL=3", "2026-01-01", "2026-01-03", false},
+		{"daily interval on day", "FREQ=DAILY;INTERVAL=3", "2026-01-01", "2026-01-04", true},
+		{"weekly other weekday", "FREQ=WEEKLY", "2026-01-05", "2026-01-13", false},
+		{"weekly same weekday", "FREQ=WEEKLY", "2026-01-05", "2026-01-12", true},
+		{"biweekly week start MO", "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU;WKST=MO", "1997-08-05", "1997-08-10", true},
+		{"biweekly week start SU", "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU;WKST=SU", "1997-08-05", "1997-08-10", false},
+		{"bimonthly skipped month", "FREQ=MONTHLY;INTERVAL=2", "2026-01-15", "2026-02-15", false},
+		{"bimonthly matching month", "FREQ=MONTHLY;INTERVAL=2", "2026-01-15", "2026-03-15", true},
+		{"monthly second Tuesday", "FREQ=MONTHLY;BYDAY=2TU", "2026-01-13", "2026-02-10", true},
+		{"monthly third Tuesday", "FREQ=MONTHLY;BYDAY=2TU", "2026-01-13", "2026-02-17", false},
+		{"yearly first Monday of the year", "FREQ=YEARLY;BYDAY=1MO", "2026-01-05", "2027-01-04", true},
+		{"yearly second Monday of the year", "FREQ=YEARLY;BYDAY=1MO", "2026-01-05", "2027-01-11", false},
+		{"yearly by month outside month", "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1", "2026-03-01", "2027-04-01", false},
+		{"yearly by month and day", "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1", "2026-03-01", "2027-03-01", true},
+		{"yearly by month day without month", "FREQ=YEARLY;BYMONTHDAY=15", "2026-03-15", "2026-07-15", true},
+		{"yearly by month day in another year", "FREQ=YEARLY;INTERVAL=2;BYMONTHDAY=15", "2026-03-15", "2027-07-15", false},
+		{"yearly by month day other day", "FREQ=YEARLY;BYMONTHDAY=15", "2026-03-15", "2026-07-16", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatalf("parseRRule(%q) error: %v", tt.rule, err)
+			}
+			if got := rule.matches(mustDate(t, tt.dtstart), mustDate(t, tt.date)); got != tt.want {
+				t.Errorf("matches(%s) = %t, want %t", tt.date, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,73 +1,273 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// UserSession はユーザーセッション情報を表します
+const (
+	// accessTokenTTL はアクセストークン（セッショントークン）の有効期間です
+	accessTokenTTL = 15 * time.Minute
+	// refreshTokenTTL はリフレッシュトークン（＝サーバー側セッション）の有効期間です
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// セッションの検証で返されるエラーです
+var (
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrSessionExpired      = errors.New("session expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// UserSession はアクセストークンから取り出したセッション情報を表します
 type UserSession struct {
 	UID       string    `json:"uid"`
 	Email     string    `json:"email"`
+	SessionID string    `json:"sid"`
+	Scopes    []string  `json:"scopes,omitempty"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
-// generateSessionToken はUIDとEmailからセッショントークンを生成します
-func generateSessionToken(uid, email string) (string, error) {
-	session := UserSession{
-		UID:       uid,
-		Email:     email,
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24時間有効
+// Session はサーバー側で管理するログインセッションです
+// リフレッシュトークンはハッシュのみを保存し、ローテーションのたびに更新します
+type Session struct {
+	ID        string `json:"id" firestore:"id"`
+	UID       string `json:"uid" firestore:"uid"`
+	Email     string `json:"email" firestore:"email"`
+	UserAgent string `json:"userAgent,omitempty" firestore:"userAgent,omitempty"`
+	// RefreshTokenHash は現在有効なリフレッシュトークンのハッシュです
+	RefreshTokenHash string `json:"refreshTokenHash" firestore:"refreshTokenHash"`
+	// PreviousRefreshTokenHash はローテーション前のハッシュです。再利用の検知に使用します
+	PreviousRefreshTokenHash string     `json:"previousRefreshTokenHash,omitempty" firestore:"previousRefreshTokenHash,omitempty"`
+	CreatedAt                time.Time  `json:"createdAt" firestore:"createdAt"`
+	LastUsedAt               time.Time  `json:"lastUsedAt" firestore:"lastUsedAt"`
+	ExpiresAt                time.Time  `json:"expiresAt" firestore:"expiresAt"`
+	RevokedAt                *time.Time `json:"revokedAt,omitempty" firestore:"revokedAt,omitempty"`
+}
+
+// IsActive はセッションが失効・期限切れでないかを返します
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionTokens はログイン・リフレッシュ時にクライアントへ返すトークンの組です
+type SessionTokens struct {
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // アクセストークンの有効秒数
+}
+
+// createSession はサーバー側セッションを作成し、アクセストークンとリフレッシュトークンを発行します
+func createSession(ctx context.Context, uid, email, userAgent string) (*SessionTokens, error) {
+	now := time.Now()
+	session := &Session{
+		ID:         newDocumentID(),
+		UID:        uid,
+		Email:      email,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
 	}
 
+	refreshToken, err := rotateRefreshToken(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := dataStore.SaveSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("セッションの保存に失敗しました: %v", err)
+	}
+
+	accessToken, err := generateSessionToken(session)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("INFO: Session %s created for UID %s", session.ID, uid)
+	return &SessionTokens{
+		SessionID:    session.ID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// refreshSession はリフレッシュトークンを検証し、新しいアクセストークンとリフレッシュトークンを発行します
+// ローテーション済みの古いリフレッシュトークンが提示された場合は、盗用とみなしてセッションを失効させます
+func refreshSession(ctx context.Context, refreshToken string) (*SessionTokens, error) {
+	sessionID, _, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// 検証とローテーションを1つのトランザクションで行い、同じリフレッシュトークンの同時使用で
+	// 両方が新しいトークンを受け取ることを防ぐ
+	presentedHash := hashRefreshToken(refreshToken)
+	var session *Session
+	var newRefreshToken string
+	reused := false
+	err := dataStore.UpdateSession(ctx, sessionID, func(current *Session) error {
+		session = current
+		reused = false
+		now := time.Now()
+		if current.RevokedAt != nil {
+			return ErrSessionRevoked
+		}
+		if !current.IsActive(now) {
+			return ErrSessionExpired
+		}
+
+		if !hashEqual(presentedHash, current.RefreshTokenHash) {
+			if current.PreviousRefreshTokenHash != "" && hashEqual(presentedHash, current.PreviousRefreshTokenHash) {
+				// 失効を保存するため、エラーではなく reused として扱う
+				current.RevokedAt = &now
+				reused = true
+				return nil
+			}
+			return ErrInvalidRefreshToken
+		}
+
+		token, err := rotateRefreshToken(current)
+		if err != nil {
+			return err
+		}
+		newRefreshToken = token
+		current.LastUsedAt = now
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if errors.Is(err, ErrSessionRevoked) || errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("セッションの保存に失敗しました: %v", err)
+	}
+	if reused {
+		log.Printf("WARN: Refresh token reuse detected for session %s (UID %s). Session revoked.", session.ID, session.UID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	accessToken, err := generateSessionToken(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		SessionID:    session.ID,
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeSession はセッションを失効させます。失効済みのセッションはそのまま成功とします
+func revokeSession(ctx context.Context, session *Session) error {
+	if session.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	if err := dataStore.SaveSession(ctx, session); err != nil {
+		return err
+	}
+	log.Printf("INFO: Session %s revoked for UID %s", session.ID, session.UID)
+	return nil
+}
+
+// revokeSessionByID はIDを指定してセッションを失効させます
+func revokeSessionByID(ctx context.Context, sessionID string) error {
+	session, err := dataStore.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	return revokeSession(ctx, session)
+}
+
+// revokeOtherSessions は keepSessionID 以外のユーザーのセッションをすべて失効させ、失効させた数を返します
+func revokeOtherSessions(ctx context.Context, uid, keepSessionID string) (int, error) {
+	sessions, err := dataStore.ListSessionsForUser(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	var revokedCount int
+	for _, session := range sessions {
+		if session.ID == keepSessionID || session.RevokedAt != nil {
+			continue
+		}
+		if err := revokeSession(ctx, session); err != nil {
+			return revokedCount, err
+		}
+		revokedCount++
+	}
+	return revokedCount, nil
+}
+
+// rotateRefreshToken は新しいリフレッシュトークンを生成してセッションのハッシュを更新します
+// トークンは "<セッションID>.<ランダム値>" の形式で、保存するのはハッシュのみです
+func rotateRefreshToken(session *Session) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("リフレッシュトークンの生成に失敗しました: %v", err)
+	}
+
+	refreshToken := session.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	session.PreviousRefreshTokenHash = session.RefreshTokenHash
+	session.RefreshTokenHash = hashRefreshToken(refreshToken)
+	return refreshToken, nil
+}
+
+// hashRefreshToken はリフレッシュトークンのSHA-256ハッシュを返します
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashEqual はハッシュ文字列を定数時間で比較します
+func hashEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// generateSessionToken はセッションに紐づく短命のアクセストークンを生成します
+func generateSessionToken(session *Session) (string, error) {
+	now := time.Now()
+
 	// JWTクレームを作成
 	claims := jwt.MapClaims{
 		"uid":   session.UID,
 		"email": session.Email,
-		"exp":   session.ExpiresAt.Unix(),
-		"iat":   time.Now().Unix(),
+		"sid":   session.ID,
+		"exp":   now.Add(accessTokenTTL).Unix(),
+		"iat":   now.Unix(),
 	}
 
-	// JWTトークンを生成
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// 署名キーを取得（環境変数から）
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		// 開発環境用のデフォルトキー（本番では必ず環境変数を設定）
-		secretKey = "your-default-secret-key-for-development-only"
-	}
-
-	return token.SignedString([]byte(secretKey))
+	// アクティブなキーで署名（非対称鍵の場合はヘッダーに kid が付与される）
+	return sessionKeySet.Sign(claims)
 }
 
 // validateSessionToken はセッショントークンを検証してUserSessionを返します
-func validateSessionToken(tokenString string) (*UserSession, error) {
+// 署名と有効期限に加えて、サーバー側のセッションが失効していないかを確認します
+func validateSessionToken(ctx context.Context, tokenString string) (*UserSession, error) {
 	// デバッグ情報
 	log.Printf("DEBUG: validateSessionToken called with token length: %d", len(tokenString))
-	
-	// 署名キーを取得
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "your-default-secret-key-for-development-only"
-		log.Printf("DEBUG: Using default JWT secret key")
-	} else {
-		log.Printf("DEBUG: Using environment JWT secret key")
-	}
-
-	// JWTトークンをパース・検証
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// 署名メソッドの確認
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			log.Printf("ERROR: Unexpected signing method: %v", token.Method)
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secretKey), nil
-	})
+
+	// JWTトークンをパース・検証（kid と署名アルゴリズムが一致するキーで検証）
+	token, err := jwt.Parse(tokenString, sessionKeySet.Keyfunc)
 
 	if err != nil {
 		log.Printf("ERROR: JWT parse error: %v", err)
@@ -106,10 +306,37 @@ func validateSessionToken(tokenString string) (*UserSession, error) {
 		return nil, errors.New("token expired")
 	}
 
+	// セッションIDがないトークンは失効できないため受け付けない
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return nil, errors.New("session id not found in token")
+	}
+
+	// サーバー側のセッションが有効かを確認（ログアウト・失効済みのトークンを拒否）
+	session, err := dataStore.GetSession(ctx, sessionID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %v", err)
+	}
+	if session.RevokedAt != nil || session.UID != uid {
+		return nil, ErrSessionRevoked
+	}
+	if !session.IsActive(time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
+	var scopes []string
+	if scope, ok := claims["scope"].(string); ok && scope != "" {
+		scopes = strings.Fields(scope)
+	}
+
 	return &UserSession{
 		UID:       uid,
 		Email:     email,
+		SessionID: sessionID,
+		Scopes:    scopes,
 		ExpiresAt: time.Unix(int64(exp), 0),
 	}, nil
 }
-
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProcessUnlinkAccountRequestRevokesOtherSessions(t *testing.T) {
+	store := useMemoryStore(t)
+	previous := identityProvider
+	identityProvider = newLocalIdentityProvider(store)
+	t.Cleanup(func() { identityProvider = previous })
+	ctx := context.Background()
+
+	identity := &LocalIdentity{IdentityUser: IdentityUser{
+		UID:         "uid",
+		Email:       "user@example.com",
+		ProviderIDs: []string{"password", "google.com"},
+	}}
+	if err := store.SaveIdentity(ctx, identity); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	for _, session := range []*Session{
+		{ID: "current", UID: "uid", ExpiresAt: expiresAt},
+		{ID: "laptop", UID: "uid", ExpiresAt: expiresAt},
+		{ID: "other-user", UID: "other", ExpiresAt: expiresAt},
+	} {
+		if err := store.SaveSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &Request{Body: []byte(`{"provider":"google.com"}`)}
+	resp, status := processUnlinkAccountRequest(ctx, req, &Principal{UID: "uid", SessionID: "current"})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", status, resp)
+	}
+
+	user, err := identityProvider.GetUser(ctx, "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(user.ProviderIDs, []string{"password"}) {
+		t.Errorf("ProviderIDs = %v, want [password]", user.ProviderIDs)
+	}
+
+	for id, wantRevoked := range map[string]bool{"current": false, "laptop": true, "other-user": false} {
+		session, err := store.GetSession(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked := session.RevokedAt != nil; revoked != wantRevoked {
+			t.Errorf("session %s revoked = %t, want %t", id, revoked, wantRevoked)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spaceEditKeyHeader は非ログインで作成したスペースのオーナーを示す編集キーのヘッダーです
+const spaceEditKeyHeader = "X-Space-Edit-Key"
+
+// SpaceEditScope は参加者がスペース内で編集できる範囲です
+type SpaceEditScope string
+
+const (
+	// spaceScopeOwn は自分のユーザー名の予定のみ編集できます（既定）
+	spaceScopeOwn SpaceEditScope = "own"
+	// spaceScopeAll はすべての参加者の予定を編集できます（スペースの設定はオーナーのみ）
+	spaceScopeAll SpaceEditScope = "all"
+	// spaceScopeNone は閲覧のみで編集できません
+	spaceScopeNone SpaceEditScope = "none"
+)
+
+// isValidSpaceEditScope はスコープが定義済みの値かを返します
+func isValidSpaceEditScope(scope SpaceEditScope) bool {
+	return scope == spaceScopeOwn || scope == spaceScopeAll || scope == spaceScopeNone
+}
+
+// SpaceParticipant はスペースに参加しているログインユーザーです
+// Username はこのユーザーが予定を登録したユーザー名で、他の参加者は変更できません
+type SpaceParticipant struct {
+	Username string         `json:"username" firestore:"username"`
+	Scope    SpaceEditScope `json:"scope" firestore:"scope"`
+	JoinedAt time.Time      `json:"joinedAt" firestore:"joinedAt"`
+}
+
+// スペースの更新の認可で返されるエラーです
+var (
+	ErrSpaceEditForbidden     = errors.New("space is not editable by others")
+	ErrSpaceSettingsForbidden = errors.New("only the owner can change space settings")
+	ErrSpaceEntryForbidden    = errors.New("entries belong to another participant")
+	ErrSpaceDecided           = errors.New("space is already decided")
+	ErrSpaceArchived          = errors.New("space is archived")
+)
+
+// SpaceEditor はスペースを更新しようとしている利用者です
+type SpaceEditor struct {
+	// UID は非ログインの場合は空です
+	UID     string
+	IsOwner bool
+	Scope   SpaceEditScope
+	// Username はログイン中の参加者が登録済みのユーザー名です
+	Username string
+	// IsParticipant はログインして予定を登録した参加者かを表します
+	IsParticipant bool
+	// Link は提示された共有リンクの権限です（提示していない場合は空）
+	Link SpaceCapability
+}
+
+// role は監査ログに記録する利用者の種類を返します
+func (e *SpaceEditor) role() string {
+	switch {
+	case e.IsOwner:
+		return "owner"
+	case e.UID != "":
+		return "participant"
+	case e.Link != "":
+		return "link_" + string(e.Link)
+	default:
+		return "anonymous"
+	}
+}
+
+// ScheduleChanges は既存のスペースと更新内容の差分です
+type ScheduleChanges struct {
+	// Settings は変更された設定項目（title・allowOtherEdit・startDate・endDate・timeZone）です
+	Settings []string
+	// Usernames は予定が追加・変更・削除されたユーザー名です
+	Usernames []string
+}
+
+// IsEmpty は差分がないかを返します
+func (c *ScheduleChanges) IsEmpty() bool {
+	return len(c.Settings) == 0 && len(c.Usernames) == 0
+}
+
+// resolveSpaceEditor はPrincipalと編集キーからスペースに対する利用者の権限を決定します
+func resolveSpaceEditor(doc *ScheduleDocument, principal *Principal, editKey string) *SpaceEditor {
+	editor := &SpaceEditor{Scope: spaceScopeOwn}
+	if principal != nil {
+		editor.UID = principal.UID
+	}
+
+	switch {
+	case doc.OwnerUID != "":
+		editor.IsOwner = editor.UID == doc.OwnerUID
+	case doc.EditKeyHash != "":
+		editor.IsOwner = editKey != "" && hashEqual(hashSpaceEditKey(editKey), doc.EditKeyHash)
+	}
+
+	if participant, ok := doc.Participants[editor.UID]; ok && editor.UID != "" {
+		editor.Username = participant.Username
+		editor.IsParticipant = true
+		if participant.Scope != "" {
+			editor.Scope = participant.Scope
+		}
+	}
+	if editor.IsOwner {
+		editor.Scope = spaceScopeAll
+	}
+	return editor
+}
+
+// authorizeScheduleUpdate は利用者が差分の内容を変更できるかを検証します
+//
+//   - オーナーはすべてを変更できます
+//   - LinkRequired のスペースは、参加者と編集リンクを提示した利用者以外は変更できません
+//     （閲覧リンクのみの利用者は閲覧でき、リンクを提示しない利用者は閲覧もできません）
+//   - LinkRequired でないスペースでは、閲覧リンクはリンクを提示しない利用者と同じ権限です
+//   - AllowOtherEdit が false の場合、オーナーと編集リンクを提示した利用者以外は変更できません
+//   - オーナー以外はスペースの設定を変更できません
+//   - スコープが own の参加者は、自分のユーザー名と、誰も登録していないユーザー名の予定のみ変更できます
+func authorizeScheduleUpdate(doc *ScheduleDocument, editor *SpaceEditor, changes *ScheduleChanges) error {
+	if editor.IsOwner {
+		return nil
+	}
+	if err := authorizeSpaceRead(doc, editor); err != nil {
+		return err
+	}
+	if changes.IsEmpty() {
+		return nil
+	}
+	if doc.LinkRequired && !editor.IsParticipant && editor.Link != spaceCapabilityEdit {
+		return ErrSpaceEditForbidden
+	}
+	if !doc.AllowOtherEdit && editor.Link != spaceCapabilityEdit || editor.Scope == spaceScopeNone {
+		return ErrSpaceEditForbidden
+	}
+	if len(changes.Settings) > 0 {
+		return ErrSpaceSettingsForbidden
+	}
+	if editor.Scope == spaceScopeAll {
+		return nil
+	}
+
+	for _, username := range changes.Usernames {
+		claimedBy := spaceParticipantByUsername(doc, username)
+		if claimedBy != "" && claimedBy != editor.UID {
+			return fmt.Errorf("%w: %s", ErrSpaceEntryForbidden, username)
+		}
+		// ログイン中の参加者は、登録済みのユーザー名以外を変更できない
+		if editor.Username != "" && username != editor.Username {
+			return fmt.Errorf("%w: %s", ErrSpaceEntryForbidden, username)
+		}
+	}
+	// ユーザー名を登録していないログインユーザーが変更できるのは1人分のみ（そのユーザー名を登録する）
+	if editor.UID != "" && editor.Username == "" && len(changes.Usernames) > 1 {
+		return fmt.Errorf("%w: %s", ErrSpaceEntryForbidden, strings.Join(changes.Usernames, ", "))
+	}
+	return nil
+}
+
+// spaceParticipantByUsername はユーザー名を登録している参加者のUIDを返します（未登録の場合は空）
+func spaceParticipantByUsername(doc *ScheduleDocument, username string) string {
+	for uid, participant := range doc.Participants {
+		if participant.Username == username {
+			return uid
+		}
+	}
+	return ""
+}
+
+// claimSpaceUsername はログイン中の参加者（オーナーを含む）が初めて予定を登録したユーザー名を、その参加者のものとして記録します
+func claimSpaceUsername(doc *ScheduleDocument, editor *SpaceEditor, changes *ScheduleChanges) {
+	if editor.UID == "" || editor.Username != "" || len(changes.Usernames) != 1 {
+		return
+	}
+	// 他の参加者が登録済みのユーザー名は登録しない（オーナーが他の参加者の予定を編集した場合など）
+	if spaceParticipantByUsername(doc, changes.Usernames[0]) != "" {
+		return
+	}
+	if doc.Participants == nil {
+		doc.Participants = make(map[string]SpaceParticipant)
+	}
+	doc.Participants[editor.UID] = SpaceParticipant{
+		Username: changes.Usernames[0],
+		Scope:    editor.Scope,
+		JoinedAt: time.Now(),
+	}
+	editor.Username = changes.Usernames[0]
+}
+
+// recordSpaceMember はログイン中の利用者がスペースの予定を変更した場合に、参加したスペースとして記録します
+// 導入前に記録されたオーナー・参加者もあわせて MemberUIDs に加えます
+func recordSpaceMember(doc *ScheduleDocument, editor *SpaceEditor, changes *ScheduleChanges) {
+	if editor.UID == "" || len(changes.Usernames) == 0 {
+		return
+	}
+	members := make(map[string]bool, len(doc.MemberUIDs)+len(doc.Participants)+2)
+	for _, uid := range doc.MemberUIDs {
+		members[uid] = true
+	}
+	for uid := range doc.Participants {
+		members[uid] = true
+	}
+	if doc.OwnerUID != "" {
+		members[doc.OwnerUID] = true
+	}
+	members[editor.UID] = true
+	doc.MemberUIDs = sortedSetKeys(members)
+}
+
+// diffSchedule は既存のスペースと更新内容を比較し、変更された設定とユーザー名を返します
+func diffSchedule(current, updated *ScheduleDocument) *ScheduleChanges {
+	changes := &ScheduleChanges{}
+	if current.Title != updated.Title {
+		changes.Settings = append(changes.Settings, "title")
+	}
+	if current.AllowOtherEdit != updated.AllowOtherEdit {
+		changes.Settings = append(changes.Settings, "allowOtherEdit")
+	}
+	if stringPtrValue(current.StartDate) != stringPtrValue(updated.StartDate) {
+		changes.Settings = append(changes.Settings, "startDate")
+	}
+	if stringPtrValue(current.EndDate) != stringPtrValue(updated.EndDate) {
+		changes.Settings = append(changes.Settings, "endDate")
+	}
+	if effectiveTimeZone(current.TimeZone) != effectiveTimeZone(updated.TimeZone) {
+		changes.Settings = append(changes.Settings, "timeZone")
+	}
+
+	currentEntries := entriesByUsername(current.Events)
+	updatedEntries := entriesByUsername(updated.Events)
+	for username := range currentEntries {
+		if _, ok := updatedEntries[username]; !ok {
+			updatedEntries[username] = nil
+		}
+	}
+	for username, entries := range updatedEntries {
+		if !equalStringSlices(currentEntries[username], entries) {
+			changes.Usernames = append(changes.Usernames, username)
+		}
+	}
+	sort.Strings(changes.Usernames)
+	return changes
+}
+
+// entriesByUsername は予定をユーザー名ごとに比較用の文字列（ソート済み）にまとめます
+func entriesByUsername(events map[string][]TimeEntry) map[string][]string {
+	result := make(map[string][]string)
+	for date, entries := range events {
+		for _, entry := range entries {
+			order := 0
+			if entry.Order != nil {
+				order = *entry.Order
+			}
+			key := fmt.Sprintf("%s|%s|%s|%d|%s", date, entry.Start, entry.End, order, entry.UserColor)
+			result[entry.Username] = append(result[entry.Username], key)
+		}
+	}
+	for username := range result {
+		sort.Strings(result[username])
+	}
+	return result
+}
+
+// equalStringSlices は2つのスライスが同じ要素を同じ順序で持つかを返します
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stringPtrValue はポインタが nil の場合は空文字列を返します
+func stringPtrValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// newSpaceEditKey は非ログインで作成したスペースの編集キーを生成し、キーとそのハッシュを返します
+func newSpaceEditKey() (string, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("編集キーの生成に失敗しました: %v", err)
+	}
+	key := base64.RawURLEncoding.EncodeToString(secret)
+	return key, hashSpaceEditKey(key), nil
+}
+
+// hashSpaceEditKey は編集キー・共有リンクのトークンのSHA-256ハッシュを返します。保存するのはハッシュのみです
+func hashSpaceEditKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// spaceAccessErrorResponse は認可エラーを403レスポンスに変換します
+// 確定済み・アーカイブ済みのスペースの場合は409、共有リンクのパスワードが誤っている場合は401、ロック中は429です
+func spaceAccessErrorResponse(err error) (map[string]interface{}, int) {
+	switch {
+	case errors.Is(err, ErrSpaceSettingsForbidden):
+		return map[string]interface{}{"error": "スペースの設定はオーナーのみ変更できます", "code": "space_settings_forbidden"}, http.StatusForbidden
+	case errors.Is(err, ErrSpaceEntryForbidden):
+		return map[string]interface{}{"error": "他の参加者の予定は変更できません", "code": "space_entry_forbidden"}, http.StatusForbidden
+	case errors.Is(err, ErrSpaceDecided):
+		return map[string]interface{}{"error": "日程が確定したスペースは変更できません", "code": "space_decided"}, http.StatusConflict
+	case errors.Is(err, ErrSpaceArchived):
+		return map[string]interface{}{"error": "アーカイブしたスペースは変更できません", "code": "space_archived"}, http.StatusConflict
+	case errors.Is(err, ErrSpaceLinkRequired):
+		return map[string]interface{}{"error": "このスペースを利用するには共有リンクが必要です", "code": "space_link_required"}, http.StatusForbidden
+	case errors.Is(err, ErrSpaceLinkInvalid):
+		return map[string]interface{}{"error": "共有リンクが無効か、取り消されています", "code": "space_link_invalid"}, http.StatusForbidden
+	case errors.Is(err, ErrSpaceLinkPasswordRequired):
+		return map[string]interface{}{"error": "共有リンクのパスワードを入力してください", "code": "space_link_password_required"}, http.StatusUnauthorized
+	case errors.Is(err, ErrSpaceLinkPasswordInvalid):
+		return map[string]interface{}{"error": "共有リンクのパスワードが正しくありません", "code": "space_link_password_invalid"}, http.StatusUnauthorized
+	case errors.Is(err, ErrSpaceLinkLocked):
+		return map[string]interface{}{"error": "パスワードの誤りが続いたため、共有リンクを一時的にロックしています。しばらくしてからお試しください", "code": "space_link_locked"}, http.StatusTooManyRequests
+	default:
+		return map[string]interface{}{"error": "このスペースを編集する権限がありません", "code": "space_edit_forbidden"}, http.StatusForbidden
+	}
+}
+
+// ScheduleAuditEntry はスペースの変更（または拒否された変更）の記録です
+type ScheduleAuditEntry struct {
+	ID        string    `json:"id" firestore:"id"`
+	SpaceID   string    `json:"spaceId" firestore:"spaceId"`
+	ActorUID  string    `json:"actorUid,omitempty" firestore:"actorUid,omitempty"`
+	ActorRole string    `json:"actorRole" firestore:"actorRole"`
+	Action    string    `json:"action" firestore:"action"` // create・update・denied・claim・participant_scope・decide・archive・unarchive・share_link_create・share_link_regenerate・share_link_revoke・share_settings
+	Settings  []string  `json:"settings,omitempty" firestore:"settings,omitempty"`
+	Usernames []string  `json:"usernames,omitempty" firestore:"usernames,omitempty"`
+	Reason    string    `json:"reason,omitempty" firestore:"reason,omitempty"`
+	At        time.Time `json:"at" firestore:"at"`
+}
+
+// recordScheduleAudit は監査ログを保存します。保存に失敗しても処理は継続します
+func recordScheduleAudit(ctx context.Context, spaceId string, editor *SpaceEditor, action string, changes *ScheduleChanges, reason error) {
+	entry := &ScheduleAuditEntry{
+		ID:        newDocumentID(),
+		SpaceID:   spaceId,
+		ActorUID:  editor.UID,
+		ActorRole: editor.role(),
+		Action:    action,
+		At:        time.Now(),
+	}
+	if changes != nil {
+		entry.Settings = changes.Settings
+		entry.Usernames = changes.Usernames
+	}
+	if reason != nil {
+		entry.Reason = reason.Error()
+	}
+
+	if err := dataStore.AppendScheduleAudit(ctx, entry); err != nil {
+		log.Printf("ERROR: Failed to record audit for spaceId %s: %v", spaceId, err)
+		return
+	}
+	log.Printf("INFO: Audit spaceId=%s action=%s actor=%s(%s) settings=%v usernames=%v",
+		spaceId, action, entry.ActorRole, entry.ActorUID, entry.Settings, entry.Usernames)
+}
+
+// latestScheduleAudit は監査ログを新しい順に並べ、最大 limit 件に絞ります
+func latestScheduleAudit(entries []*ScheduleAuditEntry, limit int) []*ScheduleAuditEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].At.After(entries[j].At)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
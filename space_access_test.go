@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAuthorizeScheduleUpdate(t *testing.T) {
+	newSpace := func(modify func(doc *ScheduleDocument)) *ScheduleDocument {
+		doc := &ScheduleDocument{
+			OwnerUID:       "owner",
+			AllowOtherEdit: true,
+			Participants: map[string]SpaceParticipant{
+				"alice-uid": {Username: "alice", Scope: spaceScopeOwn},
+				"carol-uid": {Username: "carol", Scope: spaceScopeAll},
+				"dave-uid":  {Username: "dave", Scope: spaceScopeNone},
+			},
+		}
+		if modify != nil {
+			modify(doc)
+		}
+		return doc
+	}
+	closed := func(doc *ScheduleDocument) { doc.AllowOtherEdit = false }
+	entries := func(usernames ...string) *ScheduleChanges { return &ScheduleChanges{Usernames: usernames} }
+
+	tests := []struct {
+		name    string
+		doc     *ScheduleDocument
+		uid     string
+		changes *ScheduleChanges
+		want    error
+	}{
+		{"owner changes settings", newSpace(closed), "owner", &ScheduleChanges{Settings: []string{"title"}}, nil},
+		{"anonymous adds an unclaimed username", newSpace(nil), "", entries("bob"), nil},
+		{"anonymous changes a claimed username", newSpace(nil), "", entries("alice"), ErrSpaceEntryForbidden},
+		{"anonymous changes settings", newSpace(nil), "", &ScheduleChanges{Settings: []string{"title"}}, ErrSpaceSettingsForbidden},
+		{"participant changes own entries", newSpace(nil), "alice-uid", entries("alice"), nil},
+		{"participant changes another username", newSpace(nil), "alice-uid", entries("bob"), ErrSpaceEntryForbidden},
+		{"participant with scope all", newSpace(nil), "carol-uid", entries("alice", "bob"), nil},
+		{"participant with scope none", newSpace(nil), "dave-uid", entries("dave"), ErrSpaceEditForbidden},
+		{"new user registers one username", newSpace(nil), "erin-uid", entries("erin"), nil},
+		{"new user changes two usernames", newSpace(nil), "erin-uid", entries("erin", "frank"), ErrSpaceEntryForbidden},
+		{"closed space rejects others", newSpace(closed), "", entries("bob"), ErrSpaceEditForbidden},
+		{"legacy space without owner is not owned by anonymous users", newSpace(func(doc *ScheduleDocument) {
+			doc.OwnerUID = ""
+		}), "", &ScheduleChanges{Settings: []string{"title"}}, ErrSpaceSettingsForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			if tt.uid != "" {
+				principal = &Principal{UID: tt.uid}
+			}
+			editor := resolveSpaceEditor(tt.doc, principal, "")
+
+			err := authorizeScheduleUpdate(tt.doc, editor, tt.changes)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("authorizeScheduleUpdate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSpaceEditorWithEditKey(t *testing.T) {
+	key, hash, err := newSpaceEditKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &ScheduleDocument{EditKeyHash: hash}
+
+	tests := []struct {
+		name      string
+		editKey   string
+		wantOwner bool
+	}{
+		{"matching key", key, true},
+		{"wrong key", key + "x", false},
+		{"no key", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor := resolveSpaceEditor(doc, nil, tt.editKey)
+			if editor.IsOwner != tt.wantOwner {
+				t.Errorf("IsOwner = %t, want %t", editor.IsOwner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestProcessSpaceClaimRequest(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+
+	spaces := map[string]*ScheduleDocument{
+		"legacy": {AllowOtherEdit: true, Participants: map[string]SpaceParticipant{
+			"alice-uid": {Username: "alice", Scope: spaceScopeOwn},
+		}},
+		"owned": {OwnerUID: "owner", Participants: map[string]SpaceParticipant{
+			"alice-uid": {Username: "alice", Scope: spaceScopeOwn},
+		}},
+	}
+	for id, doc := range spaces {
+		if err := dataStore.SaveSchedule(ctx, id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		spaceId    string
+		uid        string
+		wantStatus int
+	}{
+		{"stranger cannot claim", "legacy", "mallory-uid", http.StatusForbidden},
+		{"owned space", "owned", "alice-uid", http.StatusConflict},
+		{"missing space", "missing", "alice-uid", http.StatusNotFound},
+		{"participant claims", "legacy", "alice-uid", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{PathParams: map[string]string{"spaceId": tt.spaceId}}
+			if _, status := processSpaceClaimRequest(ctx, req, &Principal{UID: tt.uid}); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+
+	doc, err := getSchedule(ctx, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.OwnerUID != "alice-uid" {
+		t.Errorf("OwnerUID = %q, want alice-uid", doc.OwnerUID)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// availabilityDefaultSlotMinutes は空き状況を集計する時間枠の既定の長さ（分）です
+	availabilityDefaultSlotMinutes = 30
+	// availabilityMinSlotMinutes・availabilityMaxSlotMinutes は時間枠に指定できる長さの範囲です
+	availabilityMinSlotMinutes = 5
+	availabilityMaxSlotMinutes = 240
+	// availabilityDefaultLimit は候補の時間帯を返す既定の件数です
+	availabilityDefaultLimit = 10
+	// availabilityMaxLimit は候補の時間帯を返す件数の上限です
+	availabilityMaxLimit = 100
+)
+
+// AvailabilityOptions は空き状況の集計条件です
+type AvailabilityOptions struct {
+	// SlotMinutes は時間枠の長さ（分）です。1日（1440分）を割り切れる値を指定します
+	SlotMinutes int
+	// MinDuration は候補の時間帯に必要な長さ（分）です
+	MinDuration int
+	// Required は候補の時間帯に必ず参加できる必要があるユーザー名です
+	Required []string
+	// Limit は候補の時間帯の件数です
+	Limit int
+}
+
+// AvailabilityBucket は1つの時間枠に参加できるユーザーです
+type AvailabilityBucket struct {
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	Count     int      `json:"count"`
+	Available []string `json:"available"`
+}
+
+// AvailabilitySlot は候補の時間帯です。連続する時間枠のすべてに参加できるユーザーを Available に持ちます
+type AvailabilitySlot struct {
+	Date            string   `json:"date"`
+	Start           string   `json:"start"`
+	End             string   `json:"end"`
+	DurationMinutes int      `json:"durationMinutes"`
+	Count           int      `json:"count"`
+	Available       []string `json:"available"`
+}
+
+// SpaceAvailability はスペースの空き状況の集計結果です
+type SpaceAvailability struct {
+	SlotMinutes  int                             `json:"slotMinutes"`
+	Participants []string                        `json:"participants"`
+	Dates        map[string][]AvailabilityBucket `json:"dates"`
+	BestSlots    []AvailabilitySlot              `json:"bestSlots"`
+}
+
+// availabilityGrid は日付ごと・時間枠ごとに参加できるユーザーの集合です
+type availabilityGrid struct {
+	slot  int
+	dates map[string][]map[string]bool
+}
+
+// mark は date の start から end（0時からの分）を完全に含む時間枠に username を追加します
+func (g *availabilityGrid) mark(date string, start, end int, username string) {
+	buckets, ok := g.dates[date]
+	if !ok {
+		buckets = make([]map[string]bool, 24*60/g.slot)
+		g.dates[date] = buckets
+	}
+	for b := (start + g.slot - 1) / g.slot; b < len(buckets) && (b+1)*g.slot <= end; b++ {
+		if buckets[b] == nil {
+			buckets[b] = make(map[string]bool)
+		}
+		buckets[b][username] = true
+	}
+}
+
+// add は予定1件を時間枠に反映します
+//
+//   - 開始時刻がない予定は終日参加できるものとして扱います
+//   - 終了時刻がない予定は開始時刻を含む時間枠のみとします
+//   - 終了時刻が開始時刻以前の予定（タイムゾーン変換後など）は翌日の終了時刻までとします
+func (g *availabilityGrid) add(date string, entry TimeEntry) {
+	start, ok := clockMinutes(entry.Start)
+	if !ok {
+		if entry.Start == "" {
+			g.mark(date, 0, 24*60, entry.Username)
+		}
+		return
+	}
+	end, ok := clockMinutes(entry.End)
+	if !ok {
+		end = start/g.slot*g.slot + g.slot
+	}
+	if end > start {
+		g.mark(date, start, end, entry.Username)
+		return
+	}
+	g.mark(date, start, 24*60, entry.Username)
+	if day, err := time.Parse(taskDateLayout, date); err == nil {
+		g.mark(day.AddDate(0, 0, 1).Format(taskDateLayout), 0, end, entry.Username)
+	}
+}
+
+// aggregateAvailability はスペースの予定から、日付・時間枠ごとに参加できるユーザーと候補の時間帯を集計します
+// ユーザー名のない予定と、日付が YYYY-MM-DD 形式でない予定は集計しません
+func aggregateAvailability(events map[string][]TimeEntry, opts AvailabilityOptions) *SpaceAvailability {
+	grid := &availabilityGrid{slot: opts.SlotMinutes, dates: make(map[string][]map[string]bool)}
+	participants := make(map[string]bool)
+	for date, entries := range events {
+		if !isValidTaskDate(date) {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Username == "" {
+				continue
+			}
+			participants[entry.Username] = true
+			grid.add(date, entry)
+		}
+	}
+
+	result := &SpaceAvailability{
+		SlotMinutes:  opts.SlotMinutes,
+		Participants: sortedSetKeys(participants),
+		Dates:        make(map[string][]AvailabilityBucket),
+		BestSlots:    []AvailabilitySlot{},
+	}
+	for date, buckets := range grid.dates {
+		for b, available := range buckets {
+			if len(available) == 0 {
+				continue
+			}
+			result.Dates[date] = append(result.Dates[date], AvailabilityBucket{
+				Start:     formatClockMinutes(b * opts.SlotMinutes),
+				End:       formatClockMinutes((b + 1) * opts.SlotMinutes),
+				Count:     len(available),
+				Available: sortedSetKeys(available),
+			})
+		}
+		if len(result.Dates[date]) == 0 {
+			delete(result.Dates, date)
+			continue
+		}
+		result.BestSlots = append(result.BestSlots, bestAvailabilitySlots(date, buckets, opts)...)
+	}
+
+	sort.Slice(result.BestSlots, func(i, j int) bool {
+		a, b := result.BestSlots[i], result.BestSlots[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.DurationMinutes != b.DurationMinutes {
+			return a.DurationMinutes > b.DurationMinutes
+		}
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.Start < b.Start
+	})
+	if len(result.BestSlots) > opts.Limit {
+		result.BestSlots = result.BestSlots[:opts.Limit]
+	}
+	return result
+}
+
+// bestAvailabilitySlots は1日分の時間枠から候補の時間帯を求めます
+//
+// 時間枠に現れる参加者の組み合わせごとに、その全員が参加できる時間枠が連続する範囲を候補とします
+// 必須の参加者を含まない組み合わせと、MinDuration より短い範囲は除きます
+func bestAvailabilitySlots(date string, buckets []map[string]bool, opts AvailabilityOptions) []AvailabilitySlot {
+	groups := make(map[string][]string)
+	for _, available := range buckets {
+		if len(available) == 0 {
+			continue
+		}
+		names := sortedSetKeys(available)
+		if containsAllStrings(available, opts.Required) {
+			groups[strings.Join(names, "\x00")] = names
+		}
+	}
+
+	candidates := make(map[[2]int]AvailabilitySlot)
+	for _, group := range groups {
+		for start := 0; start < len(buckets); {
+			if !containsAllStrings(buckets[start], group) {
+				start++
+				continue
+			}
+			end := start
+			attendees := buckets[start]
+			for end < len(buckets) && containsAllStrings(buckets[end], group) {
+				attendees = intersectSets(attendees, buckets[end])
+				end++
+			}
+			duration := (end - start) * opts.SlotMinutes
+			key := [2]int{start, end}
+			if _, seen := candidates[key]; !seen && duration >= opts.MinDuration {
+				candidates[key] = AvailabilitySlot{
+					Date:            date,
+					Start:           formatClockMinutes(start * opts.SlotMinutes),
+					End:             formatClockMinutes(end * opts.SlotMinutes),
+					DurationMinutes: duration,
+					Count:           len(attendees),
+					Available:       sortedSetKeys(attendees),
+				}
+			}
+			start = end
+		}
+	}
+
+	slots := make([]AvailabilitySlot, 0, len(candidates))
+	for _, slot := range candidates {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// containsAllStrings は set が names のすべてを含むかを返します
+func containsAllStrings(set map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !set[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// intersectSets は a と b の両方に含まれる要素の集合を返します
+func intersectSets(a, b map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for name := range a {
+		if b[name] {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// sortedSetKeys は集合の要素を昇順で返します
+func sortedSetKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
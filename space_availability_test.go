@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAggregateAvailability(t *testing.T) {
+	defaultOpts := AvailabilityOptions{SlotMinutes: 60, MinDuration: 60, Limit: 10}
+	overlapping := map[string][]TimeEntry{
+		"2026-01-05": {
+			{Username: "alice", Start: "09:00", End: "12:00"},
+			{Username: "bob", Start: "10:00", End: "13:00"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		events           map[string][]TimeEntry
+		opts             AvailabilityOptions
+		wantParticipants []string
+		wantDates        map[string][]string
+		wantBest         []string
+	}{
+		{
+			name:             "overlapping entries",
+			events:           overlapping,
+			opts:             defaultOpts,
+			wantParticipants: []string{"alice", "bob"},
+			wantDates: map[string][]string{"2026-01-05": {
+				"09:00-10:00 alice", "10:00-11:00 alice,bob", "11:00-12:00 alice,bob", "12:00-13:00 bob",
+			}},
+			wantBest: []string{
+				"2026-01-05 10:00-12:00 alice,bob",
+				"2026-01-05 09:00-12:00 alice",
+				"2026-01-05 10:00-13:00 bob",
+			},
+		},
+		{
+			name:             "required participant and limit",
+			events:           overlapping,
+			opts:             AvailabilityOptions{SlotMinutes: 60, MinDuration: 60, Limit: 1, Required: []string{"bob"}},
+			wantParticipants: []string{"alice", "bob"},
+			wantDates: map[string][]string{"2026-01-05": {
+				"09:00-10:00 alice", "10:00-11:00 alice,bob", "11:00-12:00 alice,bob", "12:00-13:00 bob",
+			}},
+			wantBest: []string{"2026-01-05 10:00-12:00 alice,bob"},
+		},
+		{
+			name:             "minimum duration",
+			events:           overlapping,
+			opts:             AvailabilityOptions{SlotMinutes: 60, MinDuration: 150, Limit: 10},
+			wantParticipants: []string{"alice", "bob"},
+			wantDates: map[string][]string{"2026-01-05": {
+				"09:00-10:00 alice", "10:00-11:00 alice,bob", "11:00-12:00 alice,bob", "12:00-13:00 bob",
+			}},
+			wantBest: []string{"2026-01-05 09:00-12:00 alice", "2026-01-05 10:00-13:00 bob"},
+		},
+		{
+			name: "overnight entry continues on the next day",
+			events: map[string][]TimeEntry{
+				"2026-01-05": {{Username: "dave", Start: "23:00", End: "01:00"}},
+			},
+			opts:             defaultOpts,
+			wantParticipants: []string{"dave"},
+			wantDates: map[string][]string{
+				"2026-01-05": {"23:00-24:00 dave"},
+				"2026-01-06": {"00:00-01:00 dave"},
+			},
+			wantBest: []string{"2026-01-05 23:00-24:00 dave", "2026-01-06 00:00-01:00 dave"},
+		},
+		{
+			name: "entry without end covers its starting slot",
+			events: map[string][]TimeEntry{
+				"2026-01-05": {{Username: "erin", Start: "09:00"}},
+			},
+			opts:             defaultOpts,
+			wantParticipants: []string{"erin"},
+			wantDates:        map[string][]string{"2026-01-05": {"09:00-10:00 erin"}},
+			wantBest:         []string{"2026-01-05 09:00-10:00 erin"},
+		},
+		{
+			name: "partial slots, invalid dates and anonymous entries are not counted",
+			events: map[string][]TimeEntry{
+				"2026-01-05": {{Username: "frank", Start: "09:15", End: "10:00"}, {Start: "09:00", End: "10:00"}},
+				"2026-1-6":   {{Username: "grace", Start: "09:00", End: "10:00"}},
+			},
+			opts:             defaultOpts,
+			wantParticipants: []string{"frank"},
+			wantDates:        map[string][]string{},
+			wantBest:         []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateAvailability(tt.events, tt.opts)
+
+			if !equalStrings(got.Participants, tt.wantParticipants) {
+				t.Errorf("Participants = %v, want %v", got.Participants, tt.wantParticipants)
+			}
+			if len(got.Dates) != len(tt.wantDates) {
+				t.Errorf("Dates = %v, want %v", got.Dates, tt.wantDates)
+			}
+			for date, want := range tt.wantDates {
+				var buckets []string
+				for _, bucket := range got.Dates[date] {
+					if bucket.Count != len(bucket.Available) {
+						t.Errorf("%s %s Count = %d, want %d", date, bucket.Start, bucket.Count, len(bucket.Available))
+					}
+					buckets = append(buckets, bucket.Start+"-"+bucket.End+" "+strings.Join(bucket.Available, ","))
+				}
+				if !equalStrings(buckets, want) {
+					t.Errorf("Dates[%s] = %q, want %q", date, buckets, want)
+				}
+			}
+			var best []string
+			for _, slot := range got.BestSlots {
+				best = append(best, slot.Date+" "+slot.Start+"-"+slot.End+" "+strings.Join(slot.Available, ","))
+			}
+			if !equalStrings(best, tt.wantBest) {
+				t.Errorf("BestSlots = %q, want %q", best, tt.wantBest)
+			}
+		})
+	}
+}
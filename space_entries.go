@@ -0,0 +1,67 @@
+package main
+
+import "errors"
+
+// 参加者ごとの予定の更新方法
+const (
+	// entriesModeReplace は参加者の予定をすべて置き換えます
+	entriesModeReplace = "replace"
+	// entriesModeMerge は指定された日付の予定のみ置き換えます（空の配列はその日付の予定を削除）
+	entriesModeMerge = "merge"
+)
+
+// ErrSpaceUsernameRequired は更新対象のユーザー名を決定できない場合に返されます
+var ErrSpaceUsernameRequired = errors.New("participant username is required")
+
+// applyParticipantEntries は1人の参加者の予定だけを変更した新しいEventsを返します
+// 他の参加者の予定には触れず、events 自体も変更しません（トランザクションの再実行に備えるため）
+func applyParticipantEntries(events map[string][]TimeEntry, username string, entries map[string][]TimeEntry, mode string) map[string][]TimeEntry {
+	result := make(map[string][]TimeEntry, len(events)+len(entries))
+	for date, list := range events {
+		replaced := mode == entriesModeReplace
+		if _, ok := entries[date]; ok {
+			replaced = true
+		}
+		if !replaced {
+			result[date] = append([]TimeEntry(nil), list...)
+			continue
+		}
+
+		kept := make([]TimeEntry, 0, len(list))
+		for _, entry := range list {
+			if entry.Username != username {
+				kept = append(kept, entry)
+			}
+		}
+		// 参加者の予定を取り除いて空になった日付は削除する
+		if len(kept) > 0 || len(list) == 0 {
+			result[date] = kept
+		}
+	}
+
+	defaultOrder := 1
+	for date, list := range entries {
+		for _, entry := range list {
+			entry.Username = username
+			if entry.Order == nil {
+				order := defaultOrder
+				entry.Order = &order
+			}
+			result[date] = append(result[date], entry)
+		}
+	}
+	return result
+}
+
+// participantEntries は指定されたユーザー名の予定を日付ごとに返します
+func participantEntries(events map[string][]TimeEntry, username string) map[string][]TimeEntry {
+	result := make(map[string][]TimeEntry)
+	for date, list := range events {
+		for _, entry := range list {
+			if entry.Username == username {
+				result[date] = append(result[date], entry)
+			}
+		}
+	}
+	return result
+}
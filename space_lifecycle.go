@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAnonymousSpaceRetentionDays は非ログインで作成したスペースを最後の更新から保持する既定の日数です
+const defaultAnonymousSpaceRetentionDays = 90
+
+// getAnonymousSpaceRetention は非ログインで作成したスペースの保持期間を
+// 環境変数 ANONYMOUS_SPACE_RETENTION_DAYS（日数）から取得します。0 の場合は期限を設けません
+func getAnonymousSpaceRetention() time.Duration {
+	days := defaultAnonymousSpaceRetentionDays
+	if raw := strings.TrimSpace(os.Getenv("ANONYMOUS_SPACE_RETENTION_DAYS")); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			log.Printf("WARN: Invalid ANONYMOUS_SPACE_RETENTION_DAYS %q. Using default %d days", raw, defaultAnonymousSpaceRetentionDays)
+		} else {
+			days = value
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// touchSchedule はスペースの更新日時を now にし、非ログインで作成したスペースの有効期限を延長します
+// ログインユーザーが作成したスペースには有効期限を設けません
+func touchSchedule(doc *ScheduleDocument, now time.Time) {
+	doc.UpdatedAt = now
+	doc.ExpiresAt = nil
+	if retention := getAnonymousSpaceRetention(); doc.OwnerUID == "" && retention > 0 {
+		expiresAt := now.Add(retention)
+		doc.ExpiresAt = &expiresAt
+	}
+}
+
+// ErrSpaceExpired は有効期限を過ぎたスペースを読み込んだ場合に返されます
+// ErrNotFound をラップしているため、期限切れを区別しない呼び出し元は存在しないスペースとして扱えます
+var ErrSpaceExpired = fmt.Errorf("space has expired: %w", ErrNotFound)
+
+// isScheduleExpired は有効期限を過ぎたスペースかを返します
+// 期限切れのスペースはクリーンアップで削除されるまでの間も、存在しないものとして扱います
+func isScheduleExpired(doc *ScheduleDocument, now time.Time) bool {
+	return doc.ExpiresAt != nil && !now.Before(*doc.ExpiresAt)
+}
+
+// anonymousSpaceExpiry は有効期限が設定されていない非ログインのスペース（有効期限の導入前に作成）に設定する有効期限を返します
+// 最後に更新した日時（未設定の場合は now）から保持期間後とします。設定の対象でない場合は nil です
+func anonymousSpaceExpiry(doc *ScheduleDocument, now time.Time, retention time.Duration) *time.Time {
+	if doc.OwnerUID != "" || doc.ExpiresAt != nil || retention <= 0 {
+		return nil
+	}
+	base := doc.UpdatedAt
+	if base.IsZero() {
+		base = now
+	}
+	expiresAt := base.Add(retention)
+	return &expiresAt
+}
+
+// shouldBackfillSpaceExpiry は環境変数 BACKFILL_SPACE_EXPIRY が true の場合に true を返します
+func shouldBackfillSpaceExpiry() bool {
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("BACKFILL_SPACE_EXPIRY")))
+	return enabled
+}
+
+// backfillAnonymousSpaceExpiry は有効期限の導入前に作成され、その後更新されていない非ログインのスペースに有効期限を設定します
+// コレクション全体を走査するため、定期実行のLambdaで BACKFILL_SPACE_EXPIRY が有効な場合のみ実行する移行処理です
+func backfillAnonymousSpaceExpiry(ctx context.Context) error {
+	retention := getAnonymousSpaceRetention()
+	if retention <= 0 {
+		return nil
+	}
+	now := time.Now()
+	backfilled, err := dataStore.BackfillScheduleExpiry(ctx, func(doc *ScheduleDocument) *time.Time {
+		return anonymousSpaceExpiry(doc, now, retention)
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to set expiry of anonymous spaces: %v", err)
+		return err
+	}
+	log.Printf("INFO: Set expiry of %d anonymous spaces created before expiry was introduced", backfilled)
+	return nil
+}
+
+// checkScheduleWritable は予定を変更できる状態のスペースかを確認します
+// 日程が確定したスペースとアーカイブしたスペースは変更できません
+func checkScheduleWritable(doc *ScheduleDocument) error {
+	switch {
+	case doc.Archived:
+		return ErrSpaceArchived
+	case doc.Decision != nil:
+		return ErrSpaceDecided
+	}
+	return nil
+}
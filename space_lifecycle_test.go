@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateScheduleRejectsExpiredSpace(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+
+	past := mustDate(t, "2020-01-01")
+	if err := dataStore.SaveSchedule(ctx, "expired", &ScheduleDocument{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.SaveSchedule(ctx, "active", &ScheduleDocument{AllowOtherEdit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spaceId string
+		want    error
+	}{
+		{"expired", ErrNotFound},
+		{"missing", ErrNotFound},
+		{"active", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spaceId, func(t *testing.T) {
+			called := false
+			err := updateSchedule(ctx, tt.spaceId, func(doc *ScheduleDocument) error {
+				called = true
+				return nil
+			})
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("updateSchedule() = %v, want %v", err, tt.want)
+			}
+			if called != (tt.want == nil) {
+				t.Errorf("fn called = %t, want %t", called, tt.want == nil)
+			}
+			if _, err := getSchedule(ctx, tt.spaceId); tt.want != nil && !errors.Is(err, ErrNotFound) {
+				t.Errorf("getSchedule() = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestProcessPostRequestRejectsUnknownSpace(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+
+	past := mustDate(t, "2020-01-01")
+	if err := dataStore.SaveSchedule(ctx, "expired", &ScheduleDocument{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spaceId    string
+		wantStatus int
+	}{
+		{"expired", http.StatusGone},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spaceId, func(t *testing.T) {
+			req := &Request{Body: []byte(`{"spaceId": "` + tt.spaceId + `", "allowOtherEdit": true}`)}
+			if _, status := processPostRequest(ctx, req); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			doc, err := dataStore.GetSchedule(ctx, tt.spaceId)
+			switch {
+			case tt.spaceId == "missing" && !errors.Is(err, ErrNotFound):
+				t.Errorf("GetSchedule() = %v, want ErrNotFound", err)
+			case tt.spaceId == "expired" && (err != nil || doc.Revision != 0):
+				t.Errorf("expired space was overwritten: %+v, %v", doc, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProcessSpaceListRequestPaginates(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	spaces := map[string]*ScheduleDocument{
+		"a": {OwnerUID: "uid", UpdatedAt: base.Add(3 * time.Hour)},
+		"b": {OwnerUID: "other", MemberUIDs: []string{"uid"}, UpdatedAt: base.Add(2 * time.Hour)},
+		"c": {OwnerUID: "uid", UpdatedAt: base.Add(2 * time.Hour)},
+		"d": {OwnerUID: "uid", Archived: true, UpdatedAt: base.Add(time.Hour)},
+		"e": {OwnerUID: "uid"},
+		"f": {OwnerUID: "other", UpdatedAt: base.Add(4 * time.Hour)},
+	}
+	for id, doc := range spaces {
+		if err := dataStore.SaveSchedule(ctx, id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query map[string]string
+		want  []string
+	}{
+		{"all spaces", map[string]string{"limit": "2", "includeArchived": "true"}, []string{"a", "c", "b", "d", "e"}},
+		{"archived spaces are skipped", map[string]string{"limit": "2"}, []string{"a", "c", "b", "e"}},
+		{"owner only", map[string]string{"limit": "3", "role": "owner"}, []string{"a", "c", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			query := tt.query
+			for page := 0; ; page++ {
+				if page > len(spaces) {
+					t.Fatal("pagination did not terminate")
+				}
+				resp, status := processSpaceListRequest(ctx, &Request{Query: query}, &Principal{UID: "uid"})
+				if status != http.StatusOK {
+					t.Fatalf("status = %d, body = %v", status, resp)
+				}
+				for _, summary := range resp["spaces"].([]SpaceSummary) {
+					got = append(got, summary.SpaceID)
+				}
+				cursor, ok := resp["nextCursor"].(string)
+				if !ok {
+					break
+				}
+				query = map[string]string{}
+				for name, value := range tt.query {
+					query[name] = value
+				}
+				query["cursor"] = cursor
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("spaces = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessSpaceListRequestRejectsInvalidCursor(t *testing.T) {
+	useMemoryStore(t)
+
+	req := &Request{Query: map[string]string{"cursor": "not-a-cursor"}}
+	if _, status := processSpaceListRequest(context.Background(), req, &Principal{UID: "uid"}); status != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", status)
+	}
+}
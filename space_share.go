@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// spaceShareTokenHeader は共有リンクのトークンを送信するヘッダーです
+	spaceShareTokenHeader = "X-Space-Share-Token"
+	// spaceSharePasswordHeader はパスワード付きの共有リンクのパスワードを送信するヘッダーです
+	spaceSharePasswordHeader = "X-Space-Share-Password"
+	// spaceShareLinksMax は1つのスペースに作成できる共有リンクの上限です
+	spaceShareLinksMax = 20
+	// spaceShareLinkMaxFailures はパスワード付きの共有リンクをロックするまでに許容する連続した誤りの回数です
+	spaceShareLinkMaxFailures = 5
+	// spaceShareLinkLockDuration はパスワードを誤り続けた共有リンクをロックする時間です
+	spaceShareLinkLockDuration = 15 * time.Minute
+)
+
+// SpaceCapability は共有リンクで許可する操作です
+type SpaceCapability string
+
+const (
+	// spaceCapabilityView は閲覧のみを許可します
+	spaceCapabilityView SpaceCapability = "view"
+	// spaceCapabilityEdit は閲覧と予定の登録を許可します（スペースの設定はオーナーのみ）
+	spaceCapabilityEdit SpaceCapability = "edit"
+)
+
+// isValidSpaceCapability は定義済みの値かを返します
+func isValidSpaceCapability(capability SpaceCapability) bool {
+	return capability == spaceCapabilityView || capability == spaceCapabilityEdit
+}
+
+// 共有リンクの検証で返されるエラーです
+var (
+	ErrSpaceLinkRequired         = errors.New("space requires a share link")
+	ErrSpaceLinkInvalid          = errors.New("share link is invalid or revoked")
+	ErrSpaceLinkPasswordRequired = errors.New("share link requires a password")
+	ErrSpaceLinkPasswordInvalid  = errors.New("share link password is incorrect")
+	ErrSpaceLinkLocked           = errors.New("share link is locked after repeated password failures")
+)
+
+// SpaceShareLink はスペースの共有リンクです
+// トークンとパスワードはハッシュのみを保存し、トークンは作成・再発行のレスポンスでのみ返します
+type SpaceShareLink struct {
+	ID            string          `json:"id" firestore:"id"`
+	Capability    SpaceCapability `json:"capability" firestore:"capability"`
+	TokenHash     string          `json:"tokenHash" firestore:"tokenHash"`
+	PasswordHash  string          `json:"passwordHash,omitempty" firestore:"passwordHash,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt" firestore:"createdAt"`
+	RegeneratedAt *time.Time      `json:"regeneratedAt,omitempty" firestore:"regeneratedAt,omitempty"`
+	// FailedAttempts は連続してパスワードを誤った回数です。LockedUntil まではパスワードを検証しません
+	FailedAttempts int        `json:"failedAttempts,omitempty" firestore:"failedAttempts,omitempty"`
+	LockedUntil    *time.Time `json:"lockedUntil,omitempty" firestore:"lockedUntil,omitempty"`
+}
+
+// toMap はトークンとパスワードのハッシュを除いたレスポンス用のマップを返します
+func (l *SpaceShareLink) toMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"id":          l.ID,
+		"capability":  l.Capability,
+		"hasPassword": l.PasswordHash != "",
+		"createdAt":   l.CreatedAt,
+	}
+	if l.RegeneratedAt != nil {
+		result["regeneratedAt"] = l.RegeneratedAt
+	}
+	if l.LockedUntil != nil && time.Now().Before(*l.LockedUntil) {
+		result["lockedUntil"] = l.LockedUntil
+	}
+	return result
+}
+
+// newSpaceShareToken は共有リンクのトークンを生成し、トークンとそのハッシュを返します
+func newSpaceShareToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("共有リンクのトークンの生成に失敗しました: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashSpaceEditKey(token), nil
+}
+
+// findShareLink はIDの共有リンクの位置を返します。見つからない場合は -1 です
+func findShareLink(doc *ScheduleDocument, id string) int {
+	for i := range doc.ShareLinks {
+		if doc.ShareLinks[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// verifyShareLinkRequest はリクエストで提示された共有リンクのトークンとパスワードを検証し、一致したリンクを返します
+// トークンが提示されていない場合は nil を返します。パスワードの検証（argon2id など）は時間がかかるため、
+// 再試行されるトランザクションの外で呼び出します。誤ったパスワードはリンクごとに数え、
+// spaceShareLinkMaxFailures 回続いた場合は spaceShareLinkLockDuration の間ロックします
+func verifyShareLinkRequest(ctx context.Context, spaceId string, doc *ScheduleDocument, req *Request) (*SpaceShareLink, error) {
+	token := req.Header(spaceShareTokenHeader)
+	if token == "" {
+		return nil, nil
+	}
+	tokenHash := hashSpaceEditKey(token)
+	index := -1
+	for i := range doc.ShareLinks {
+		if hashEqual(tokenHash, doc.ShareLinks[i].TokenHash) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, ErrSpaceLinkInvalid
+	}
+	link := doc.ShareLinks[index]
+	if link.PasswordHash == "" {
+		return &link, nil
+	}
+
+	now := time.Now()
+	if link.LockedUntil != nil && now.Before(*link.LockedUntil) {
+		return nil, ErrSpaceLinkLocked
+	}
+	password := req.Header(spaceSharePasswordHeader)
+	if password == "" {
+		return nil, ErrSpaceLinkPasswordRequired
+	}
+	if ok, _, err := verifyPassword(password, link.PasswordHash, ""); err != nil || !ok {
+		return nil, recordShareLinkFailure(ctx, spaceId, link.ID, now)
+	}
+	if link.FailedAttempts > 0 || link.LockedUntil != nil {
+		resetShareLinkFailures(ctx, spaceId, link.ID)
+	}
+	return &link, nil
+}
+
+// recordShareLinkFailure は共有リンクのパスワードの誤りを記録し、上限に達した場合はロックします
+// 返すエラーは ErrSpaceLinkPasswordInvalid、ロックした場合は ErrSpaceLinkLocked です
+func recordShareLinkFailure(ctx context.Context, spaceId, linkId string, now time.Time) error {
+	locked := false
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		locked = false
+		index := findShareLink(doc, linkId)
+		if index < 0 {
+			return nil
+		}
+		link := &doc.ShareLinks[index]
+		link.FailedAttempts++
+		if link.FailedAttempts >= spaceShareLinkMaxFailures {
+			lockedUntil := now.Add(spaceShareLinkLockDuration)
+			link.LockedUntil = &lockedUntil
+			link.FailedAttempts = 0
+			locked = true
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to record share link failure of spaceId %s: %v", spaceId, err)
+	}
+	if locked {
+		log.Printf("WARN: Share link %s of spaceId %s locked until %s", linkId, spaceId, now.Add(spaceShareLinkLockDuration).Format(time.RFC3339))
+		return ErrSpaceLinkLocked
+	}
+	return ErrSpaceLinkPasswordInvalid
+}
+
+// resetShareLinkFailures はパスワードが一致した共有リンクの誤りの回数をリセットします。失敗しても処理は継続します
+func resetShareLinkFailures(ctx context.Context, spaceId, linkId string) {
+	err := updateSchedule(ctx, spaceId, func(doc *ScheduleDocument) error {
+		if index := findShareLink(doc, linkId); index >= 0 {
+			doc.ShareLinks[index].FailedAttempts = 0
+			doc.ShareLinks[index].LockedUntil = nil
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to reset share link failures of spaceId %s: %v", spaceId, err)
+	}
+}
+
+// loadShareLinkForRequest はトランザクションの前にスペースを読み込み、提示された共有リンクを検証します
+// スペースが存在しない場合とトークンが提示されていない場合は nil を返します
+func loadShareLinkForRequest(ctx context.Context, spaceId string, req *Request) (*SpaceShareLink, error) {
+	if req.Header(spaceShareTokenHeader) == "" {
+		return nil, nil
+	}
+	doc, err := getSchedule(ctx, spaceId)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return verifyShareLinkRequest(ctx, spaceId, doc, req)
+}
+
+// applyShareLink は検証済みの共有リンクを利用者の権限に反映します
+// トランザクション内で呼び出し、検証後にリンクが取り消し・再発行されていないことをハッシュの比較のみで確認します
+func (e *SpaceEditor) applyShareLink(doc *ScheduleDocument, req *Request, verified *SpaceShareLink) error {
+	if req.Header(spaceShareTokenHeader) == "" {
+		return nil
+	}
+	if verified == nil {
+		return ErrSpaceLinkInvalid
+	}
+	index := findShareLink(doc, verified.ID)
+	if index < 0 || doc.ShareLinks[index].TokenHash != verified.TokenHash || doc.ShareLinks[index].PasswordHash != verified.PasswordHash {
+		return ErrSpaceLinkInvalid
+	}
+	e.Link = doc.ShareLinks[index].Capability
+	return nil
+}
+
+// resolveRequestEditor はリクエストのPrincipal・編集キーと、検証済みの共有リンクからスペースに対する利用者の権限を決定します
+func resolveRequestEditor(doc *ScheduleDocument, principal *Principal, req *Request, verified *SpaceShareLink) (*SpaceEditor, error) {
+	editor := resolveSpaceEditor(doc, principal, req.Header(spaceEditKeyHeader))
+	if err := editor.applyShareLink(doc, req, verified); err != nil {
+		return editor, err
+	}
+	return editor, nil
+}
+
+// authorizeSpaceRead は利用者がスペースを閲覧できるかを検証します
+// LinkRequired のスペースは、オーナー・ログインして参加した参加者・共有リンクを提示した利用者のみ閲覧できます
+func authorizeSpaceRead(doc *ScheduleDocument, editor *SpaceEditor) error {
+	if !doc.LinkRequired || editor.IsOwner || editor.IsParticipant || editor.Link != "" {
+		return nil
+	}
+	return ErrSpaceLinkRequired
+}
+
+// authorizeSpaceReadRequest はリクエストの利用者がスペースを閲覧できるかを検証します（GET 用）
+func authorizeSpaceReadRequest(ctx context.Context, spaceId string, doc *ScheduleDocument, req *Request) error {
+	verified, err := verifyShareLinkRequest(ctx, spaceId, doc, req)
+	if err != nil {
+		return err
+	}
+	principal, _ := principalFromContext(ctx)
+	editor, err := resolveRequestEditor(doc, principal, req, verified)
+	if err != nil {
+		return err
+	}
+	return authorizeSpaceRead(doc, editor)
+}
+
+// isSpaceAccessError はスペースの認可・状態によるエラー（spaceAccessErrorResponse で変換するもの）かを返します
+func isSpaceAccessError(err error) bool {
+	for _, target := range []error{
+		ErrSpaceEditForbidden, ErrSpaceSettingsForbidden, ErrSpaceEntryForbidden,
+		ErrSpaceDecided, ErrSpaceArchived,
+		ErrSpaceLinkRequired, ErrSpaceLinkInvalid, ErrSpaceLinkPasswordRequired, ErrSpaceLinkPasswordInvalid, ErrSpaceLinkLocked,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAuthorizeScheduleUpdateWithShareLink(t *testing.T) {
+	newSpace := func(modify func(doc *ScheduleDocument)) *ScheduleDocument {
+		doc := &ScheduleDocument{
+			OwnerUID:       "owner",
+			AllowOtherEdit: true,
+			Participants: map[string]SpaceParticipant{
+				"alice-uid": {Username: "alice", Scope: spaceScopeOwn},
+			},
+		}
+		if modify != nil {
+			modify(doc)
+		}
+		return doc
+	}
+	closed := func(doc *ScheduleDocument) { doc.AllowOtherEdit = false }
+	linkRequired := func(doc *ScheduleDocument) { doc.LinkRequired = true }
+	entries := func(usernames ...string) *ScheduleChanges { return &ScheduleChanges{Usernames: usernames} }
+
+	tests := []struct {
+		name    string
+		doc     *ScheduleDocument
+		uid     string
+		link    SpaceCapability
+		changes *ScheduleChanges
+		want    error
+	}{
+		{"closed space accepts an edit link", newSpace(closed), "", spaceCapabilityEdit, entries("bob"), nil},
+		{"closed space rejects a view link", newSpace(closed), "", spaceCapabilityView, entries("bob"), ErrSpaceEditForbidden},
+		{"edit link cannot change settings", newSpace(closed), "", spaceCapabilityEdit, &ScheduleChanges{Settings: []string{"endDate"}}, ErrSpaceSettingsForbidden},
+		{"link required without a link", newSpace(linkRequired), "", "", &ScheduleChanges{}, ErrSpaceLinkRequired},
+		{"link required with a view link reads", newSpace(linkRequired), "", spaceCapabilityView, &ScheduleChanges{}, nil},
+		{"link required with a view link edits", newSpace(linkRequired), "", spaceCapabilityView, entries("bob"), ErrSpaceEditForbidden},
+		{"link required with an edit link", newSpace(linkRequired), "", spaceCapabilityEdit, entries("bob"), nil},
+		{"link required participant", newSpace(linkRequired), "alice-uid", "", entries("alice"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			if tt.uid != "" {
+				principal = &Principal{UID: tt.uid}
+			}
+			editor := resolveSpaceEditor(tt.doc, principal, "")
+			editor.Link = tt.link
+
+			err := authorizeScheduleUpdate(tt.doc, editor, tt.changes)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("authorizeScheduleUpdate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyShareLinkRequestLocksAfterFailures(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+
+	token, tokenHash, err := newSpaceShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := "correct horse"
+	passwordHash, err := hashShareLinkPassword(&password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &ScheduleDocument{ShareLinks: []SpaceShareLink{{
+		ID:           "link",
+		Capability:   spaceCapabilityView,
+		TokenHash:    tokenHash,
+		PasswordHash: passwordHash,
+	}}}
+	if err := dataStore.SaveSchedule(ctx, "space", doc); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(token, password string) error {
+		t.Helper()
+		current, err := getSchedule(ctx, "space")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &Request{Headers: map[string]string{}}
+		if token != "" {
+			req.Headers["x-space-share-token"] = token
+		}
+		if password != "" {
+			req.Headers["x-space-share-password"] = password
+		}
+		_, err = verifyShareLinkRequest(ctx, "space", current, req)
+		return err
+	}
+
+	if err := verify("", ""); err != nil {
+		t.Errorf("no token = %v, want nil", err)
+	}
+	if err := verify(token+"x", "correct horse"); !errors.Is(err, ErrSpaceLinkInvalid) {
+		t.Errorf("wrong token = %v, want ErrSpaceLinkInvalid", err)
+	}
+	if err := verify(token, ""); !errors.Is(err, ErrSpaceLinkPasswordRequired) {
+		t.Errorf("no password = %v, want ErrSpaceLinkPasswordRequired", err)
+	}
+	for i := 1; i < spaceShareLinkMaxFailures; i++ {
+		if err := verify(token, "wrong"); !errors.Is(err, ErrSpaceLinkPasswordInvalid) {
+			t.Fatalf("failure %d = %v, want ErrSpaceLinkPasswordInvalid", i, err)
+		}
+	}
+	if err := verify(token, "wrong"); !errors.Is(err, ErrSpaceLinkLocked) {
+		t.Fatalf("failure %d = %v, want ErrSpaceLinkLocked", spaceShareLinkMaxFailures, err)
+	}
+	if err := verify(token, "correct horse"); !errors.Is(err, ErrSpaceLinkLocked) {
+		t.Errorf("correct password while locked = %v, want ErrSpaceLinkLocked", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound は指定されたドキュメントが存在しない場合に返されるエラーです
+var ErrNotFound = errors.New("document not found")
+
+// ErrAlreadyExists は作成しようとしたドキュメントが既に存在する場合に返されるエラーです
+var ErrAlreadyExists = errors.New("document already exists")
+
+// ScheduleStore はスケジュール（スペース）ドキュメントの永続化を扱います
+type ScheduleStore interface {
+	// NewScheduleID は新しいスペース用の一意なIDを払い出します
+	NewScheduleID(ctx context.Context) string
+	GetSchedule(ctx context.Context, spaceId string) (*ScheduleDocument, error)
+	SaveSchedule(ctx context.Context, spaceId string, data *ScheduleDocument) error
+	// UpdateSchedule は現在のドキュメントを fn で変更して保存する処理をトランザクションとして実行します
+	// 競合時に fn は再実行されることがあるため、fn は doc 以外に副作用を持たないようにしてください
+	// ドキュメントが存在しない場合は ErrNotFound を返します
+	UpdateSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error
+	// DeleteSchedule は現在のドキュメントを fn で確認し、fn が成功した場合に削除する処理をトランザクションとして実行します
+	// fn がエラーを返した場合は削除せずにそのエラーを返します。ドキュメントが存在しない場合は ErrNotFound を返します
+	DeleteSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error
+	// ListSchedulesForUser は uid がオーナー、または参加者（MemberUIDs）であるスペースを
+	// 最終更新日時（updatedAt）の新しい順（同じ日時はspaceIdの降順）に最大 limit 件返します
+	// after を指定した場合は、その位置より後のスペースのみ返します
+	ListSchedulesForUser(ctx context.Context, uid string, limit int, after *ScheduleListCursor) ([]ScheduleListEntry, error)
+	// DeleteExpiredSchedules は now より前に有効期限（expiresAt）が切れたスペースを削除し、削除したspaceIdを返します
+	// 一覧の取得後に更新された（有効期限が延長された）スペースは削除しません
+	DeleteExpiredSchedules(ctx context.Context, now time.Time) ([]string, error)
+	// BackfillScheduleExpiry は有効期限（expiresAt）のないスペースに expiry が返す有効期限を設定し、設定した件数を返します
+	// expiry が nil を返すスペースと、一覧の取得後に更新されたスペースは変更しません
+	BackfillScheduleExpiry(ctx context.Context, expiry func(doc *ScheduleDocument) *time.Time) (int, error)
+}
+
+// ScheduleAuditStore はスペースの変更履歴（監査ログ）の永続化を扱います
+type ScheduleAuditStore interface {
+	AppendScheduleAudit(ctx context.Context, entry *ScheduleAuditEntry) error
+	// ListScheduleAudit はスペースの監査ログを新しい順に最大 limit 件返します
+	ListScheduleAudit(ctx context.Context, spaceId string, limit int) ([]*ScheduleAuditEntry, error)
+	// DeleteScheduleAudit はスペースの監査ログをすべて削除し、削除件数を返します
+	DeleteScheduleAudit(ctx context.Context, spaceId string) (int, error)
+}
+
+// TaskDocumentScope は UpdateTaskDocument で読み込む予定・通知の範囲です
+// 範囲は月単位で、Dates の日付を含む月と、TaskIDs のタスクを含む月を読み込みます
+// fn が読み込んでいない月の予定・通知を追加した場合、保存せずにエラーを返します
+type TaskDocumentScope struct {
+	// All が true の場合はすべての月を読み込みます
+	All     bool
+	Dates   []string
+	TaskIDs []string
+}
+
+// taskScopeAll はすべての月を読み込む範囲です
+var taskScopeAll = TaskDocumentScope{All: true}
+
+// TaskStore はユーザーごとのタスク・通知ドキュメントの永続化を扱います
+type TaskStore interface {
+	// GetTaskDocument はドキュメントが存在しない場合 ErrNotFound を返します
+	GetTaskDocument(ctx context.Context, uid string) (*TaskDocument, error)
+	// GetTaskRange は from から to（YYYY-MM-DD、両端を含む）の予定・通知のみを持つドキュメントを返します
+	// 期間外の月のデータは読み込みません。ドキュメントが存在しない場合 ErrNotFound を返します
+	GetTaskRange(ctx context.Context, uid, from, to string) (*TaskDocument, error)
+	SaveTaskDocument(ctx context.Context, uid string, doc *TaskDocument) error
+	// UpdateTaskDocument は現在のドキュメントを fn で変更して保存する処理をトランザクションとして実行します
+	// fn には scope の月の予定・通知のみが渡され、それ以外の月は変更しません
+	// ドキュメントが存在しない場合は UID のみを設定した空のドキュメントが fn に渡されます
+	UpdateTaskDocument(ctx context.Context, uid string, scope TaskDocumentScope, fn func(doc *TaskDocument) error) error
+}
+
+// UserStore はユーザーデータ（usersコレクション）の永続化を扱います
+type UserStore interface {
+	GetUser(ctx context.Context, uid string) (*UserData, error)
+	// FindUserByProvider は provider（email/google/github/twitter）配下の
+	// field（userUID/emailAddress）が value と一致するユーザーを検索します
+	FindUserByProvider(ctx context.Context, provider, field, value string) (*UserData, error)
+	SaveUser(ctx context.Context, uid string, userData *UserData) error
+}
+
+// VerificationTokenStore はメール認証トークンの永続化を扱います
+type VerificationTokenStore interface {
+	SaveVerificationToken(ctx context.Context, token *VerificationToken) error
+	GetVerificationToken(ctx context.Context, token string) (*VerificationToken, error)
+	DeleteVerificationToken(ctx context.Context, token string) error
+	// DeleteExpiredVerificationTokens は now より前に期限切れとなったトークンを削除し、削除件数を返します
+	DeleteExpiredVerificationTokens(ctx context.Context, now time.Time) (int, error)
+	ListVerificationTokensCreatedBefore(ctx context.Context, cutoff time.Time) ([]*VerificationToken, error)
+	DeleteVerificationTokensForUser(ctx context.Context, uid string) (int, error)
+}
+
+// SessionStore はログインセッション（リフレッシュトークン）の永続化を扱います
+type SessionStore interface {
+	// GetSession はセッションが存在しない場合 ErrNotFound を返します
+	GetSession(ctx context.Context, id string) (*Session, error)
+	SaveSession(ctx context.Context, session *Session) error
+	// UpdateSession は現在のセッションを fn で変更して保存する処理をトランザクションとして実行します
+	// 競合時に fn は再実行されることがあります。セッションが存在しない場合は ErrNotFound を返します
+	UpdateSession(ctx context.Context, id string, fn func(session *Session) error) error
+	ListSessionsForUser(ctx context.Context, uid string) ([]*Session, error)
+	// DeleteExpiredSessions は now より前に期限切れとなったセッションを削除し、削除件数を返します
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error)
+}
+
+// NonceStore はリプレイ攻撃を防ぐための使用済みノンスを記録します
+type NonceStore interface {
+	// ClaimNonce はノンスを使用済みとして記録します。既に記録されている場合は ErrAlreadyExists を返します
+	ClaimNonce(ctx context.Context, nonce string, expiresAt time.Time) error
+	// DeleteExpiredNonces は now より前に期限切れとなったノンスを削除し、削除件数を返します
+	DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error)
+}
+
+// ScheduleListEntry は ListSchedulesForUser が返すスペースです
+type ScheduleListEntry struct {
+	SpaceID string
+	Doc     *ScheduleDocument
+}
+
+// ScheduleListCursor は ListSchedulesForUser で続きを取得する位置（前のページの最後のスペース）です
+type ScheduleListCursor struct {
+	UpdatedAt time.Time
+	SpaceID   string
+}
+
+// after は最終更新日時の新しい順で、スペースがカーソルより後に並ぶかを返します
+func (c *ScheduleListCursor) after(spaceId string, updatedAt time.Time) bool {
+	if !updatedAt.Equal(c.UpdatedAt) {
+		return updatedAt.Before(c.UpdatedAt)
+	}
+	return spaceId < c.SpaceID
+}
+
+// usedNonce は使用済みノンスの記録です
+type usedNonce struct {
+	ExpiresAt time.Time `json:"expiresAt" firestore:"expiresAt"`
+}
+
+// Store はアプリケーションが利用するすべての永続化操作をまとめたインターフェースです
+type Store interface {
+	ScheduleStore
+	ScheduleAuditStore
+	TaskStore
+	UserStore
+	VerificationTokenStore
+	LocalIdentityStore
+	SessionStore
+	NonceStore
+}
+
+// dataStore はプロジェクト全体で共有するストレージの実装です
+// firestore_client.go の init() で STORAGE_BACKEND に応じて設定されます
+var dataStore Store
+
+const (
+	storageBackendFirestore = "firestore"
+	storageBackendMemory    = "memory"
+	storageBackendFile      = "file"
+)
+
+// getStorageBackend は環境変数 STORAGE_BACKEND から使用するストレージを取得します
+// 未設定の場合はFirestoreを使用します
+func getStorageBackend() string {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	if backend == "" {
+		return storageBackendFirestore
+	}
+	return backend
+}
+
+// getStorageFilePath はファイルストレージの保存先を環境変数 STORAGE_FILE_PATH から取得します
+func getStorageFilePath() string {
+	path := os.Getenv("STORAGE_FILE_PATH")
+	if path == "" {
+		return "local_data.json"
+	}
+	return path
+}
+
+// newLocalStore はFirestore以外のストレージ実装を生成します
+func newLocalStore(backend string) (Store, error) {
+	switch backend {
+	case storageBackendMemory:
+		log.Println("Using in-memory storage. Data will be lost when the process exits.")
+		return newMemoryStore(), nil
+	case storageBackendFile:
+		path := getStorageFilePath()
+		log.Printf("Using file storage: %s", path)
+		return newFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// documentIDChars はドキュメントIDに使用する文字の一覧です（Firestoreの自動IDと同じ文字種）
+const documentIDChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// documentIDLength はドキュメントIDの文字数です
+const documentIDLength = 20
+
+// newDocumentID はFirestoreの自動IDと同じ形式（20文字の英数字）のランダムなIDを生成します
+// セッションID・共有リンクIDなどにも使用するため、各文字が偏りなく選ばれるよう棄却サンプリングを行います
+func newDocumentID() string {
+	// 文字種の数の倍数未満のバイトのみ使用する（248 = 62 * 4）
+	limit := 256 - 256%len(documentIDChars)
+	id := make([]byte, 0, documentIDLength)
+	buf := make([]byte, documentIDLength*2)
+	for len(id) < documentIDLength {
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatalf("ドキュメントIDの生成に失敗しました: %v", err)
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			id = append(id, documentIDChars[int(b)%len(documentIDChars)])
+			if len(id) == documentIDLength {
+				break
+			}
+		}
+	}
+	return string(id)
+}
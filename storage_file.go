@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// newFileStore は単一のJSONファイルにデータを保存する Store を生成します
+// 起動時にファイルを読み込み、書き込みのたびにファイル全体を書き出します
+// 開発用の組み込みストアであり、複数プロセスからの同時利用は想定していません
+func newFileStore(path string) (*memoryStore, error) {
+	store := newMemoryStore()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &store.collections); err != nil {
+				return nil, fmt.Errorf("ストレージファイルの読み込みに失敗しました (%s): %v", path, err)
+			}
+		}
+	case os.IsNotExist(err):
+		// 初回起動時はファイルが存在しないため、空の状態から開始する
+	default:
+		return nil, fmt.Errorf("ストレージファイルを開けません (%s): %v", path, err)
+	}
+
+	store.persist = func(collections map[string]map[string]json.RawMessage) error {
+		return writeFileAtomic(path, collections)
+	}
+	return store, nil
+}
+
+// writeFileAtomic は一時ファイルに書き込んだ後にリネームすることで、書き込み途中のファイルが残らないようにします
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,1120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// firestoreStore はFirestoreをバックエンドとする Store の実装です
+type firestoreStore struct {
+	client *firestore.Client
+}
+
+func newFirestoreStore(client *firestore.Client) *firestoreStore {
+	return &firestoreStore{client: client}
+}
+
+// verificationTokenCollection は認証トークン用のコレクション名を返します
+func verificationTokenCollection() string {
+	return firestoreCollectionName + "_verification_tokens"
+}
+
+// identityCollection はローカル認証プロバイダーのユーザー情報用のコレクション名を返します
+func identityCollection() string {
+	return firestoreCollectionName + "_identities"
+}
+
+// identityEmailCollection はローカル認証プロバイダーのメールアドレスの一意性を保証するためのコレクション名を返します
+// ドキュメントIDは正規化したメールアドレスのハッシュで、値としてUIDを持ちます
+func identityEmailCollection() string {
+	return firestoreCollectionName + "_identity_emails"
+}
+
+// identityEmailKey はメールアドレス（正規化済み）から identityEmailCollection のドキュメントIDを返します
+// メールアドレスには "/" などドキュメントIDに使用できない文字が含まれうるため、SHA-256のハッシュを使用します
+func identityEmailKey(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:])
+}
+
+// identityEmail は identityEmailCollection のドキュメントです
+type identityEmail struct {
+	UID string `firestore:"uid"`
+}
+
+// sessionCollection はログインセッション用のコレクション名を返します
+func sessionCollection() string {
+	return firestoreCollectionName + "_sessions"
+}
+
+// nonceCollection はリプレイ検知用の使用済みノンスのコレクション名を返します
+func nonceCollection() string {
+	return firestoreCollectionName + "_nonces"
+}
+
+// scheduleAuditCollection はスペースの監査ログ用のコレクション名を返します
+func scheduleAuditCollection() string {
+	return firestoreCollectionName + "_audit"
+}
+
+// usersCollection はユーザーデータ用のコレクション名です（環境に依存しない固定名）
+const usersCollection = "users"
+
+// taskCollection はタスクデータ用のコレクション名です
+const taskCollection = "task"
+
+// taskMonthCollection はタスクドキュメント配下の、月ごとの予定・通知のサブコレクション名です
+const taskMonthCollection = "months"
+
+// wrapNotFound はFirestoreのNotFoundエラーを ErrNotFound に変換します
+func wrapNotFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+func (s *firestoreStore) NewScheduleID(ctx context.Context) string {
+	return s.client.Collection(firestoreCollectionName).NewDoc().ID
+}
+
+func (s *firestoreStore) GetSchedule(ctx context.Context, spaceId string) (*ScheduleDocument, error) {
+	doc, err := s.client.Collection(firestoreCollectionName).Doc(spaceId).Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+
+	var data ScheduleDocument
+	if err := doc.DataTo(&data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SaveSchedule は、指定されたspaceIdのドキュメントとしてデータを保存します。
+// 既存のドキュメントがある場合は、完全に上書きされます。
+func (s *firestoreStore) SaveSchedule(ctx context.Context, spaceId string, data *ScheduleDocument) error {
+	// .Set() メソッドは構造体を渡すと、自動的にFirestoreのドキュメント形式に変換します。
+	_, err := s.client.Collection(firestoreCollectionName).Doc(spaceId).Set(ctx, data)
+	return err
+}
+
+func (s *firestoreStore) UpdateSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error {
+	ref := s.client.Collection(firestoreCollectionName).Doc(spaceId)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return wrapNotFound(err)
+		}
+
+		var data ScheduleDocument
+		if err := snapshot.DataTo(&data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+		return tx.Set(ref, &data)
+	})
+}
+
+func (s *firestoreStore) DeleteSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error {
+	ref := s.client.Collection(firestoreCollectionName).Doc(spaceId)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return wrapNotFound(err)
+		}
+
+		var data ScheduleDocument
+		if err := snapshot.DataTo(&data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// ListSchedulesForUser はオーナーと参加者の条件をORで結合し、updatedAt とドキュメントIDの降順で Limit・StartAfter によりページングします
+// このクエリには ownerUid・updatedAt と memberUids・updatedAt の複合インデックスが必要です
+func (s *firestoreStore) ListSchedulesForUser(ctx context.Context, uid string, limit int, after *ScheduleListCursor) ([]ScheduleListEntry, error) {
+	query := s.client.Collection(firestoreCollectionName).WhereEntity(firestore.OrFilter{
+		Filters: []firestore.EntityFilter{
+			firestore.PropertyFilter{Path: "ownerUid", Operator: "==", Value: uid},
+			firestore.PropertyFilter{Path: "memberUids", Operator: "array-contains", Value: uid},
+		},
+	}).OrderBy("updatedAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if after != nil {
+		query = query.StartAfter(after.UpdatedAt, after.SpaceID)
+	}
+
+	docs, err := query.Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ScheduleListEntry, 0, len(docs))
+	for _, doc := range docs {
+		var data ScheduleDocument
+		if err := doc.DataTo(&data); err != nil {
+			log.Printf("ERROR: Failed to parse space %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		entries = append(entries, ScheduleListEntry{SpaceID: doc.Ref.ID, Doc: &data})
+	}
+	return entries, nil
+}
+
+func (s *firestoreStore) DeleteExpiredSchedules(ctx context.Context, now time.Time) ([]string, error) {
+	iter := s.client.Collection(firestoreCollectionName).Where("expiresAt", "<", now).Documents(ctx)
+	defer iter.Stop()
+
+	var spaceIds []string
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return spaceIds, err
+		}
+
+		// 一覧の取得後に更新されたスペースは有効期限が延長されているため削除しない
+		if _, err := doc.Ref.Delete(ctx, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.Printf("WARN: Skipped deleting expired space %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		spaceIds = append(spaceIds, doc.Ref.ID)
+	}
+	return spaceIds, nil
+}
+
+// BackfillScheduleExpiry は有効期限のないドキュメントを検索できないため、コレクション全体を必要なフィールドのみで走査します
+func (s *firestoreStore) BackfillScheduleExpiry(ctx context.Context, expiry func(doc *ScheduleDocument) *time.Time) (int, error) {
+	iter := s.client.Collection(firestoreCollectionName).Select("ownerUid", "expiresAt", "updatedAt").Documents(ctx)
+	defer iter.Stop()
+
+	var updatedCount int
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return updatedCount, err
+		}
+
+		var data ScheduleDocument
+		if err := doc.DataTo(&data); err != nil {
+			log.Printf("WARN: Failed to parse space %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		expiresAt := expiry(&data)
+		if expiresAt == nil {
+			continue
+		}
+		// 走査後に更新されたスペースは、更新時に有効期限が設定されているため変更しない
+		updates := []firestore.Update{{Path: "expiresAt", Value: *expiresAt}}
+		if _, err := doc.Ref.Update(ctx, updates, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.Printf("WARN: Skipped setting expiry of space %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		updatedCount++
+	}
+	return updatedCount, nil
+}
+
+func (s *firestoreStore) AppendScheduleAudit(ctx context.Context, entry *ScheduleAuditEntry) error {
+	_, err := s.client.Collection(scheduleAuditCollection()).Doc(entry.ID).Set(ctx, entry)
+	return err
+}
+
+func (s *firestoreStore) ListScheduleAudit(ctx context.Context, spaceId string, limit int) ([]*ScheduleAuditEntry, error) {
+	// 複合インデックスを不要にするため、並べ替えはメモリ上で行う
+	docs, err := s.client.Collection(scheduleAuditCollection()).Where("spaceId", "==", spaceId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ScheduleAuditEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry ScheduleAuditEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("ERROR: Failed to parse audit entry %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return latestScheduleAudit(entries, limit), nil
+}
+
+func (s *firestoreStore) DeleteScheduleAudit(ctx context.Context, spaceId string) (int, error) {
+	iter := s.client.Collection(scheduleAuditCollection()).Where("spaceId", "==", spaceId).Documents(ctx)
+	return deleteDocuments(ctx, iter)
+}
+
+// taskDocumentRef はユーザーのタスクドキュメントを返します
+// タスクドキュメントにはリビジョン・変更履歴・繰り返しタスクの定義を保存し、
+// 予定・通知は配下の taskMonthCollection に月ごとのサブドキュメント（IDは YYYY-MM）として保存します
+func (s *firestoreStore) taskDocumentRef(uid string) *firestore.DocumentRef {
+	return s.client.Collection(taskCollection).Doc(uid)
+}
+
+func (s *firestoreStore) GetTaskDocument(ctx context.Context, uid string) (*TaskDocument, error) {
+	ref := s.taskDocumentRef(uid)
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	taskDoc, err := taskDocumentFromSnapshot(uid, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	months, err := ref.Collection(taskMonthCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range taskMonthsFromSnapshots(months) {
+		mergeTaskMonth(taskDoc, data)
+	}
+	return taskDoc, nil
+}
+
+func (s *firestoreStore) GetTaskRange(ctx context.Context, uid, from, to string) (*TaskDocument, error) {
+	ref := s.taskDocumentRef(uid)
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	taskDoc, err := taskDocumentFromSnapshot(uid, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	months, err := ref.Collection(taskMonthCollection).
+		Where("month", ">=", taskMonthOf(from)).
+		Where("month", "<=", taskMonthOf(to)).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range taskMonthsFromSnapshots(months) {
+		mergeTaskMonth(taskDoc, data)
+	}
+	// 月の途中から始まる期間や、月ごとに分割する前のデータを絞り込む
+	return filterTaskDocument(taskDoc, from, to), nil
+}
+
+func (s *firestoreStore) SaveTaskDocument(ctx context.Context, uid string, doc *TaskDocument) error {
+	return s.UpdateTaskDocument(ctx, uid, taskScopeAll, func(current *TaskDocument) error {
+		*current = *doc
+		return nil
+	})
+}
+
+// taskMonthIndexField はタスクドキュメントの、タスクのIDから月（YYYY-MM）への対応を保存するフィールドです
+// IDを指定した更新で、タスクを含む月のサブドキュメントのみを読み込むために使用します
+const taskMonthIndexField = "taskMonths"
+
+// taskMonthMigrationBatchSize は月ごとに分割する前のドキュメントを移行するときに、1つのトランザクションで書き込む月の数です
+// （Firestoreの1つのトランザクションの書き込み上限は500件です）
+const taskMonthMigrationBatchSize = 500
+
+// errTaskMonthsNotMigrated はタスクドキュメントに月ごとに分割する前の予定・通知が残っている場合に返されます
+var errTaskMonthsNotMigrated = errors.New("task document is not split into months yet")
+
+// UpdateTaskDocument はタスクドキュメントと scope の月のサブドキュメントのみを読み込んで fn に渡します
+// 保存するのは内容が変わった月のみで、予定・通知がなくなった月は削除します
+// 月ごとに分割する前のドキュメントは、先に migrateTaskMonths で月ごとのサブドキュメントに移行します
+func (s *firestoreStore) UpdateTaskDocument(ctx context.Context, uid string, scope TaskDocumentScope, fn func(doc *TaskDocument) error) error {
+	err := s.updateTaskDocument(ctx, uid, scope, fn)
+	if !errors.Is(err, errTaskMonthsNotMigrated) {
+		return err
+	}
+	if err := s.migrateTaskMonths(ctx, uid); err != nil {
+		return err
+	}
+	return s.updateTaskDocument(ctx, uid, scope, fn)
+}
+
+// updateTaskDocument は UpdateTaskDocument のトランザクションです
+// タスクを含む月は taskMonthIndexField で探します。このフィールドのないドキュメント（導入前に保存）は、
+// すべての月を読み込んで作成します
+func (s *firestoreStore) updateTaskDocument(ctx context.Context, uid string, scope TaskDocumentScope, fn func(doc *TaskDocument) error) error {
+	ref := s.taskDocumentRef(uid)
+	monthsRef := ref.Collection(taskMonthCollection)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		scope := scope
+		taskDoc := &TaskDocument{UID: uid}
+		index := make(map[string]string)
+		snapshot, err := tx.Get(ref)
+		if err != nil && !errors.Is(wrapNotFound(err), ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			if taskDoc, err = taskDocumentFromSnapshot(uid, snapshot); err != nil {
+				return err
+			}
+			if len(taskDoc.Events) > 0 || len(taskDoc.Notifications) > 0 {
+				return errTaskMonthsNotMigrated
+			}
+			stored, ok := snapshot.Data()[taskMonthIndexField].(map[string]interface{})
+			if !ok {
+				scope = taskScopeAll
+			}
+			for id, month := range stored {
+				if month, ok := month.(string); ok {
+					index[id] = month
+				}
+			}
+		}
+
+		var monthSnapshots []*firestore.DocumentSnapshot
+		var loaded map[string]bool
+		if scope.All {
+			monthSnapshots, err = tx.Documents(monthsRef).GetAll()
+		} else {
+			loaded = scope.months(func(id string) (string, bool) {
+				month, ok := index[id]
+				return month, ok
+			})
+			refs := make([]*firestore.DocumentRef, 0, len(loaded))
+			for month := range loaded {
+				refs = append(refs, monthsRef.Doc(month))
+			}
+			if len(refs) > 0 {
+				monthSnapshots, err = tx.GetAll(refs)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		stored := taskMonthsFromSnapshots(monthSnapshots)
+		for _, data := range stored {
+			mergeTaskMonth(taskDoc, data)
+		}
+		before := splitTaskMonths(taskDoc)
+
+		if err := fn(taskDoc); err != nil {
+			return err
+		}
+		if loaded != nil {
+			if err := checkTaskMonthsLoaded(taskDoc, loaded); err != nil {
+				return err
+			}
+		}
+
+		after := splitTaskMonths(taskDoc)
+		for month, data := range after {
+			if current, ok := before[month]; ok && reflect.DeepEqual(current, data) {
+				continue
+			}
+			if err := tx.Set(monthsRef.Doc(month), taskMonthData(uid, month, data)); err != nil {
+				return err
+			}
+		}
+		for month := range stored {
+			if _, ok := after[month]; !ok {
+				if err := tx.Delete(monthsRef.Doc(month)); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 読み込んだ月のタスクのみ対応を作り直す（すべての月を読み込んだ場合は全体を作り直す）
+		for id, month := range index {
+			if scope.All || loaded[month] {
+				delete(index, id)
+			}
+		}
+		for month, data := range after {
+			for _, tasks := range data.Events {
+				for _, task := range tasks {
+					if task.ID != "" {
+						index[task.ID] = month
+					}
+				}
+			}
+		}
+		data := taskDocumentData(uid, taskDoc)
+		data[taskMonthIndexField] = index
+		return tx.Set(ref, data)
+	})
+}
+
+// migrateTaskMonths は月ごとに分割する前のタスクドキュメントの予定・通知を、月のサブドキュメントに移行します
+// 1つのトランザクションの書き込み上限を超えないよう taskMonthMigrationBatchSize 件ずつ書き込み、最後に
+// 移行中にタスクドキュメントが更新されていない場合のみ、タスクドキュメントから予定・通知を取り除きます
+// 途中で失敗してもタスクドキュメントに予定・通知が残るため、次の更新で最初から移行し直します
+func (s *firestoreStore) migrateTaskMonths(ctx context.Context, uid string) error {
+	ref := s.taskDocumentRef(uid)
+	monthsRef := ref.Collection(taskMonthCollection)
+	snapshot, err := ref.Get(ctx)
+	if err != nil {
+		return wrapNotFound(err)
+	}
+	legacy, err := taskDocumentFromSnapshot(uid, snapshot)
+	if err != nil {
+		return err
+	}
+	months := splitTaskMonths(legacy)
+	keys := make([]string, 0, len(months))
+	for month := range months {
+		keys = append(keys, month)
+	}
+	sort.Strings(keys)
+
+	for start := 0; start < len(keys); start += taskMonthMigrationBatchSize {
+		batch := keys[start:min(start+taskMonthMigrationBatchSize, len(keys))]
+		err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			refs := make([]*firestore.DocumentRef, 0, len(batch))
+			for _, month := range batch {
+				refs = append(refs, monthsRef.Doc(month))
+			}
+			snapshots, err := tx.GetAll(refs)
+			if err != nil {
+				return err
+			}
+			// 既にサブドキュメントがある月は、読み込み時と同じくサブドキュメントの内容を優先する
+			stored := taskMonthsFromSnapshots(snapshots)
+			for _, month := range batch {
+				data := &taskMonth{Events: make(map[string][]TaskSlot), Notifications: make(map[string][]NotificationSlot)}
+				for date, tasks := range months[month].Events {
+					data.Events[date] = tasks
+				}
+				for date, notifications := range months[month].Notifications {
+					data.Notifications[date] = notifications
+				}
+				if current, ok := stored[month]; ok {
+					for date, tasks := range current.Events {
+						data.Events[date] = tasks
+					}
+					for date, notifications := range current.Notifications {
+						data.Notifications[date] = notifications
+					}
+				}
+				if err := tx.Set(monthsRef.Doc(month), taskMonthData(uid, month, data)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	updates := []firestore.Update{
+		{Path: "events", Value: firestore.Delete},
+		{Path: "notifications", Value: firestore.Delete},
+	}
+	_, err = ref.Update(ctx, updates, firestore.LastUpdateTime(snapshot.UpdateTime))
+	if status.Code(err) == codes.FailedPrecondition {
+		// 同時に実行された他の移行が先に完了した
+		log.Printf("INFO: Task document of UID %s was migrated concurrently", uid)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Split task document of UID %s into %d months", uid, len(keys))
+	return nil
+}
+
+// taskMonthsFromSnapshots はFirestoreの月のサブドキュメントを月ごとの予定・通知に変換します
+func taskMonthsFromSnapshots(snapshots []*firestore.DocumentSnapshot) map[string]*taskMonth {
+	months := make(map[string]*taskMonth, len(snapshots))
+	for _, snapshot := range snapshots {
+		if !snapshot.Exists() {
+			continue
+		}
+		data := snapshot.Data()
+		month := &taskMonth{
+			Events:        make(map[string][]TaskSlot),
+			Notifications: make(map[string][]NotificationSlot),
+		}
+		if eventsMap, ok := data["events"].(map[string]interface{}); ok {
+			month.Events = parseTaskSlots(eventsMap)
+		}
+		if notificationsMap, ok := data["notifications"].(map[string]interface{}); ok {
+			month.Notifications = parseNotificationSlots(notificationsMap)
+		}
+		months[snapshot.Ref.ID] = month
+	}
+	return months
+}
+
+// taskMonthData は1か月分の予定・通知をFirestoreに保存する形式に変換します
+func taskMonthData(uid, month string, data *taskMonth) map[string]interface{} {
+	return map[string]interface{}{
+		"month":         month,
+		"events":        data.Events,
+		"notifications": data.Notifications,
+		"uid":           uid,
+	}
+}
+
+// taskDocumentFromSnapshot はFirestoreのタスクドキュメントをTaskDocumentに変換します
+// events・notifications は月ごとのサブドキュメントに分割する前のドキュメントにのみ含まれます
+func taskDocumentFromSnapshot(uid string, doc *firestore.DocumentSnapshot) (*TaskDocument, error) {
+	var data map[string]interface{}
+	if err := doc.DataTo(&data); err != nil {
+		return nil, err
+	}
+
+	taskDoc := &TaskDocument{
+		UID:           uid,
+		Events:        make(map[string][]TaskSlot),
+		Notifications: make(map[string][]NotificationSlot),
+	}
+	if eventsMap, ok := data["events"].(map[string]interface{}); ok {
+		taskDoc.Events = parseTaskSlots(eventsMap)
+	}
+	if notificationsMap, ok := data["notifications"].(map[string]interface{}); ok {
+		taskDoc.Notifications = parseNotificationSlots(notificationsMap)
+	}
+	if updatedAt, ok := data["updatedAt"].(time.Time); ok {
+		taskDoc.UpdatedAt = updatedAt
+	}
+	if revision, ok := firestoreInt(data, "revision"); ok {
+		taskDoc.Revision = int64(revision)
+	}
+	if changes, ok := data["changes"].([]interface{}); ok {
+		taskDoc.Changes = parseTaskOperations(changes)
+	}
+	if base, ok := firestoreInt(data, "changeLogBase"); ok {
+		taskDoc.ChangeLogBase = int64(base)
+	}
+	if recurring, ok := data["recurring"].([]interface{}); ok {
+		for _, item := range recurring {
+			if recurringMap, ok := item.(map[string]interface{}); ok {
+				taskDoc.Recurring = append(taskDoc.Recurring, parseRecurringTask(recurringMap))
+			}
+		}
+	}
+	return taskDoc, nil
+}
+
+// taskDocumentData はTaskDocumentのうち、予定・通知を除く部分をFirestoreに保存する形式に変換します
+// 予定・通知は月ごとのサブドキュメントに保存します（taskMonthData）
+func taskDocumentData(uid string, doc *TaskDocument) map[string]interface{} {
+	return map[string]interface{}{
+		"updatedAt":     doc.UpdatedAt,
+		"revision":      doc.Revision,
+		"changes":       doc.Changes,
+		"changeLogBase": doc.ChangeLogBase,
+		"recurring":     doc.Recurring,
+		"uid":           uid,
+	}
+}
+
+// parseTaskSlots はFirestoreのeventsフィールドをTaskSlotのマップに変換します
+// 過去のデータはフィールド名が大文字で保存されているため、両方の形式を考慮します
+func parseTaskSlots(eventsMap map[string]interface{}) map[string][]TaskSlot {
+	result := make(map[string][]TaskSlot)
+	for date, tasksData := range eventsMap {
+		// null値の場合は空の配列を設定
+		if tasksData == nil {
+			result[date] = []TaskSlot{}
+			continue
+		}
+
+		tasksArray, ok := tasksData.([]interface{})
+		if !ok {
+			log.Printf("DEBUG: Failed to convert tasksData to array for date %s", date)
+			continue
+		}
+
+		var tasks []TaskSlot
+		for _, taskData := range tasksArray {
+			taskMap, ok := taskData.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			tasks = append(tasks, parseTaskSlot(taskMap))
+		}
+		result[date] = tasks
+	}
+	return result
+}
+
+// parseNotificationSlots はFirestoreのnotificationsフィールドをNotificationSlotのマップに変換します
+func parseNotificationSlots(notificationsMap map[string]interface{}) map[string][]NotificationSlot {
+	result := make(map[string][]NotificationSlot)
+	for date, notifsData := range notificationsMap {
+		// null値の場合は空の配列を設定
+		if notifsData == nil {
+			result[date] = []NotificationSlot{}
+			continue
+		}
+
+		notifsArray, ok := notifsData.([]interface{})
+		if !ok {
+			log.Printf("DEBUG: Failed to convert notifsData to array for date %s", date)
+			continue
+		}
+
+		var notifs []NotificationSlot
+		for _, notifData := range notifsArray {
+			notifMap, ok := notifData.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			notifs = append(notifs, parseNotificationSlot(notifMap))
+		}
+		result[date] = notifs
+	}
+	return result
+}
+
+// parseTaskSlot はFirestoreのタスク1件をTaskSlotに変換します
+func parseTaskSlot(taskMap map[string]interface{}) TaskSlot {
+	task := TaskSlot{}
+	if id, ok := firestoreString(taskMap, "id", "ID"); ok {
+		task.ID = id
+	}
+	if description, ok := firestoreString(taskMap, "description", "Description"); ok {
+		task.Description = description
+	}
+	if start, ok := firestoreString(taskMap, "start", "Start"); ok {
+		task.Start = start
+	}
+	if end, ok := firestoreString(taskMap, "end", "End"); ok {
+		task.End = end
+	}
+	if title, ok := firestoreString(taskMap, "title", "Title"); ok {
+		task.Title = title
+	}
+	if userColor, ok := firestoreString(taskMap, "userColor", "UserColor"); ok {
+		task.UserColor = userColor
+	}
+	if order, ok := firestoreInt(taskMap, "order", "Order"); ok {
+		task.Order = order
+	}
+	// 確定した日程から作成したタスクは、同じスペースの確定を再実行したときにこのIDで見つける
+	if spaceId, ok := firestoreString(taskMap, "spaceId", "SpaceID"); ok {
+		task.SpaceID = spaceId
+	}
+	if recurringId, ok := firestoreString(taskMap, "recurringId", "RecurringID"); ok {
+		task.RecurringID = recurringId
+	}
+	if occurrenceDate, ok := firestoreString(taskMap, "occurrenceDate", "OccurrenceDate"); ok {
+		task.OccurrenceDate = occurrenceDate
+	}
+	return task
+}
+
+// parseNotificationSlot はFirestoreの通知1件をNotificationSlotに変換します
+func parseNotificationSlot(notifMap map[string]interface{}) NotificationSlot {
+	notif := NotificationSlot{}
+	if t, ok := firestoreString(notifMap, "time", "Time"); ok {
+		notif.Time = t
+	}
+	if order, ok := firestoreInt(notifMap, "order", "Order"); ok {
+		notif.Order = order
+	}
+	return notif
+}
+
+// parseTaskOperations はFirestoreのchangesフィールドをTaskOperationのリストに変換します
+func parseTaskOperations(changes []interface{}) []TaskOperation {
+	ops := make([]TaskOperation, 0, len(changes))
+	for _, change := range changes {
+		changeMap, ok := change.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		op := TaskOperation{}
+		if seq, ok := firestoreInt(changeMap, "seq"); ok {
+			op.Seq = int64(seq)
+		}
+		op.Op, _ = firestoreString(changeMap, "op")
+		op.Target, _ = firestoreString(changeMap, "target")
+		op.ID, _ = firestoreString(changeMap, "id")
+		op.Date, _ = firestoreString(changeMap, "date")
+		if taskMap, ok := changeMap["task"].(map[string]interface{}); ok {
+			task := parseTaskSlot(taskMap)
+			op.Task = &task
+		}
+		if notifs, ok := changeMap["notifications"].([]interface{}); ok {
+			for _, notifData := range notifs {
+				if notifMap, ok := notifData.(map[string]interface{}); ok {
+					op.Notifications = append(op.Notifications, parseNotificationSlot(notifMap))
+				}
+			}
+		}
+		if recurringMap, ok := changeMap["recurring"].(map[string]interface{}); ok {
+			recurring := parseRecurringTask(recurringMap)
+			op.Recurring = &recurring
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// parseRecurringTask はFirestoreの繰り返しタスクの定義をRecurringTaskに変換します
+func parseRecurringTask(recurringMap map[string]interface{}) RecurringTask {
+	recurring := RecurringTask{}
+	recurring.ID, _ = firestoreString(recurringMap, "id")
+	recurring.DTStart, _ = firestoreString(recurringMap, "dtstart")
+	recurring.RRule, _ = firestoreString(recurringMap, "rrule")
+	if taskMap, ok := recurringMap["task"].(map[string]interface{}); ok {
+		recurring.Task = parseTaskSlot(taskMap)
+	}
+	if exdates, ok := recurringMap["exdates"].([]interface{}); ok {
+		for _, exdate := range exdates {
+			if date, ok := exdate.(string); ok {
+				recurring.ExDates = append(recurring.ExDates, date)
+			}
+		}
+	}
+	if overrides, ok := recurringMap["overrides"].(map[string]interface{}); ok {
+		recurring.Overrides = make(map[string]RecurringTaskOverride, len(overrides))
+		for date, item := range overrides {
+			overrideMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			override := RecurringTaskOverride{}
+			override.Date, _ = firestoreString(overrideMap, "date")
+			for key, dest := range map[string]**string{
+				"title": &override.Title, "description": &override.Description,
+				"start": &override.Start, "end": &override.End, "userColor": &override.UserColor,
+			} {
+				if value, ok := firestoreString(overrideMap, key); ok {
+					*dest = &value
+				}
+			}
+			if order, ok := firestoreInt(overrideMap, "order"); ok {
+				override.Order = &order
+			}
+			recurring.Overrides[date] = override
+		}
+	}
+	if updatedAt, ok := recurringMap["updatedAt"].(time.Time); ok {
+		recurring.UpdatedAt = updatedAt
+	}
+	return recurring
+}
+
+// firestoreString は指定されたキーのいずれかに格納された文字列を取得します
+func firestoreString(m map[string]interface{}, keys ...string) (string, bool) {
+	for _, key := range keys {
+		if v, ok := m[key].(string); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// firestoreInt は指定されたキーのいずれかに格納された数値を取得します
+// Firestoreの整数はint64、JSON経由の値はfloat64で返されるため両方を扱います
+func firestoreInt(m map[string]interface{}, keys ...string) (int, bool) {
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case int64:
+			return int(v), true
+		case float64:
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+func (s *firestoreStore) GetUser(ctx context.Context, uid string) (*UserData, error) {
+	doc, err := s.client.Collection(usersCollection).Doc(uid).Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+
+	var userData UserData
+	if err := doc.DataTo(&userData); err != nil {
+		return nil, fmt.Errorf("failed to parse user data: %v", err)
+	}
+	return &userData, nil
+}
+
+func (s *firestoreStore) FindUserByProvider(ctx context.Context, provider, field, value string) (*UserData, error) {
+	query := s.client.Collection(usersCollection).Where(provider+"."+field, "==", value).Limit(1)
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var userData UserData
+	if err := docs[0].DataTo(&userData); err != nil {
+		return nil, fmt.Errorf("failed to parse user data: %v", err)
+	}
+	return &userData, nil
+}
+
+func (s *firestoreStore) SaveUser(ctx context.Context, uid string, userData *UserData) error {
+	_, err := s.client.Collection(usersCollection).Doc(uid).Set(ctx, userData)
+	return err
+}
+
+func (s *firestoreStore) SaveVerificationToken(ctx context.Context, token *VerificationToken) error {
+	_, err := s.client.Collection(verificationTokenCollection()).Doc(token.Token).Set(ctx, token)
+	return err
+}
+
+func (s *firestoreStore) GetVerificationToken(ctx context.Context, token string) (*VerificationToken, error) {
+	doc, err := s.client.Collection(verificationTokenCollection()).Doc(token).Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+
+	var verificationToken VerificationToken
+	if err := doc.DataTo(&verificationToken); err != nil {
+		return nil, err
+	}
+	return &verificationToken, nil
+}
+
+func (s *firestoreStore) DeleteVerificationToken(ctx context.Context, token string) error {
+	_, err := s.client.Collection(verificationTokenCollection()).Doc(token).Delete(ctx)
+	return err
+}
+
+func (s *firestoreStore) DeleteExpiredVerificationTokens(ctx context.Context, now time.Time) (int, error) {
+	iter := s.client.Collection(verificationTokenCollection()).Where("expires_at", "<", now).Documents(ctx)
+	return deleteDocuments(ctx, iter)
+}
+
+func (s *firestoreStore) ListVerificationTokensCreatedBefore(ctx context.Context, cutoff time.Time) ([]*VerificationToken, error) {
+	iter := s.client.Collection(verificationTokenCollection()).Where("created_at", "<", cutoff).Documents(ctx)
+	defer iter.Stop()
+
+	var tokens []*VerificationToken
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return tokens, err
+		}
+
+		var token VerificationToken
+		if err := doc.DataTo(&token); err != nil {
+			log.Printf("ERROR: Failed to parse token data for %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, nil
+}
+
+func (s *firestoreStore) DeleteVerificationTokensForUser(ctx context.Context, uid string) (int, error) {
+	iter := s.client.Collection(verificationTokenCollection()).Where("uid", "==", uid).Documents(ctx)
+	return deleteDocuments(ctx, iter)
+}
+
+// deleteDocuments はイテレータが返すすべてのドキュメントを削除し、削除件数を返します
+// 個々の削除に失敗した場合はログに記録して処理を続行します
+func deleteDocuments(ctx context.Context, iter *firestore.DocumentIterator) (int, error) {
+	defer iter.Stop()
+
+	var deletedCount int
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deletedCount, err
+		}
+
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			log.Printf("ERROR: Failed to delete document %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		deletedCount++
+	}
+	return deletedCount, nil
+}
+
+func (s *firestoreStore) GetIdentity(ctx context.Context, uid string) (*LocalIdentity, error) {
+	doc, err := s.client.Collection(identityCollection()).Doc(uid).Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+
+	var identity LocalIdentity
+	if err := doc.DataTo(&identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *firestoreStore) FindIdentityByEmail(ctx context.Context, email string) (*LocalIdentity, error) {
+	docs, err := s.client.Collection(identityCollection()).Where("email", "==", email).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var identity LocalIdentity
+	if err := docs[0].DataTo(&identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *firestoreStore) CreateIdentity(ctx context.Context, identity *LocalIdentity) error {
+	emailRef := s.client.Collection(identityEmailCollection()).Doc(identityEmailKey(identity.Email))
+	identityRef := s.client.Collection(identityCollection()).Doc(identity.UID)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(emailRef); err == nil {
+			return fmt.Errorf("%w: %s", ErrEmailAlreadyExists, identity.Email)
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		// メールアドレスのドキュメントがない従来のユーザーとも重複しないよう確認する
+		query := s.client.Collection(identityCollection()).Where("email", "==", identity.Email).Limit(1)
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			return fmt.Errorf("%w: %s", ErrEmailAlreadyExists, identity.Email)
+		}
+
+		if _, err := tx.Get(identityRef); err == nil {
+			return fmt.Errorf("%w: uid=%s", ErrAlreadyExists, identity.UID)
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if err := tx.Create(emailRef, identityEmail{UID: identity.UID}); err != nil {
+			return err
+		}
+		return tx.Create(identityRef, identity)
+	})
+}
+
+func (s *firestoreStore) SaveIdentity(ctx context.Context, identity *LocalIdentity) error {
+	_, err := s.client.Collection(identityCollection()).Doc(identity.UID).Set(ctx, identity)
+	return err
+}
+
+func (s *firestoreStore) DeleteIdentity(ctx context.Context, uid string) error {
+	identityRef := s.client.Collection(identityCollection()).Doc(uid)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(identityRef)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var identity LocalIdentity
+		if err := snapshot.DataTo(&identity); err != nil {
+			return err
+		}
+		// メールアドレスを再登録できるよう、一意性のためのドキュメントも削除する
+		emailRef := s.client.Collection(identityEmailCollection()).Doc(identityEmailKey(identity.Email))
+		if err := tx.Delete(emailRef); err != nil {
+			return err
+		}
+		return tx.Delete(identityRef)
+	})
+}
+
+func (s *firestoreStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	doc, err := s.client.Collection(sessionCollection()).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+
+	var session Session
+	if err := doc.DataTo(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *firestoreStore) SaveSession(ctx context.Context, session *Session) error {
+	_, err := s.client.Collection(sessionCollection()).Doc(session.ID).Set(ctx, session)
+	return err
+}
+
+func (s *firestoreStore) UpdateSession(ctx context.Context, id string, fn func(session *Session) error) error {
+	ref := s.client.Collection(sessionCollection()).Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return wrapNotFound(err)
+		}
+
+		var session Session
+		if err := snapshot.DataTo(&session); err != nil {
+			return err
+		}
+		if err := fn(&session); err != nil {
+			return err
+		}
+		return tx.Set(ref, &session)
+	})
+}
+
+func (s *firestoreStore) ListSessionsForUser(ctx context.Context, uid string) ([]*Session, error) {
+	docs, err := s.client.Collection(sessionCollection()).Where("uid", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(docs))
+	for _, doc := range docs {
+		var session Session
+		if err := doc.DataTo(&session); err != nil {
+			log.Printf("ERROR: Failed to parse session data for %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+func (s *firestoreStore) DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error) {
+	iter := s.client.Collection(sessionCollection()).Where("expiresAt", "<", now).Documents(ctx)
+	return deleteDocuments(ctx, iter)
+}
+
+func (s *firestoreStore) ClaimNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	// Create はドキュメントが既に存在する場合に失敗するため、複数のインスタンス間でも一度しか成功しない
+	_, err := s.client.Collection(nonceCollection()).Doc(nonce).Create(ctx, usedNonce{ExpiresAt: expiresAt})
+	if status.Code(err) == codes.AlreadyExists {
+		return fmt.Errorf("%w: %v", ErrAlreadyExists, err)
+	}
+	return err
+}
+
+func (s *firestoreStore) DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error) {
+	iter := s.client.Collection(nonceCollection()).Where("expiresAt", "<", now).Documents(ctx)
+	return deleteDocuments(ctx, iter)
+}
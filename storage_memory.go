@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryCollections はメモリストアが保持するコレクション名です
+const (
+	memorySchedules          = "schedules"
+	memoryScheduleAudit      = "schedule_audit"
+	memoryTasks              = "tasks"
+	memoryUsers              = "users"
+	memoryVerificationTokens = "verification_tokens"
+	memoryIdentities         = "identities"
+	memorySessions           = "sessions"
+	memoryNonces             = "nonces"
+)
+
+// memoryStore はプロセス内のマップにデータを保持する Store の実装です
+// ドキュメントはJSONとして保持するため、呼び出し元とデータを共有することはありません
+// オフラインでの開発や動作確認に使用します
+type memoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]json.RawMessage
+
+	// persist は書き込み後に呼び出されます（ファイルストアが永続化に使用）
+	persist func(collections map[string]map[string]json.RawMessage) error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{collections: make(map[string]map[string]json.RawMessage)}
+}
+
+// get はドキュメントを v にデコードします。存在しない場合は ErrNotFound を返します
+func (s *memoryStore) get(collection, id string, v interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	raw, ok := s.collections[collection][id]
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// put はドキュメントを保存（上書き）します
+func (s *memoryStore) put(collection, id string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(collection, map[string]json.RawMessage{id: raw})
+}
+
+// update はドキュメントを v にデコードして fn を呼び出し、fn が成功した場合は v を保存します
+// 読み込みから保存までロックを保持するため、他の書き込みと競合しません
+func (s *memoryStore) update(collection, id string, v interface{}, fn func() error) error {
+	return s.modify(collection, id, v, false, fn)
+}
+
+// upsert は update と同様ですが、ドキュメントが存在しない場合は v を初期値のまま fn に渡して作成します
+func (s *memoryStore) upsert(collection, id string, v interface{}, fn func() error) error {
+	return s.modify(collection, id, v, true, fn)
+}
+
+func (s *memoryStore) modify(collection, id string, v interface{}, create bool, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.collections[collection][id]
+	if !ok && !create {
+		return ErrNotFound
+	}
+	if ok {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+
+	updated, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.apply(collection, map[string]json.RawMessage{id: updated})
+}
+
+// remove はドキュメントを v にデコードして fn を呼び出し、fn が成功した場合はドキュメントを削除します
+// 読み込みから削除までロックを保持するため、他の書き込みと競合しません
+func (s *memoryStore) remove(collection, id string, v interface{}, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.collections[collection][id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.apply(collection, map[string]json.RawMessage{id: nil})
+}
+
+// delete は条件に一致するドキュメントを削除し、削除件数を返します
+func (s *memoryStore) delete(collection string, match func(id string, raw json.RawMessage) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[string]json.RawMessage)
+	for id, raw := range s.collections[collection] {
+		if match(id, raw) {
+			deleted[id] = nil
+		}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+	if err := s.apply(collection, deleted); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+// each はコレクション内のドキュメントをID順に走査します。fn が false を返すと走査を終了します
+func (s *memoryStore) each(collection string, fn func(id string, raw json.RawMessage) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.collections[collection]))
+	for id := range s.collections[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !fn(id, s.collections[collection][id]) {
+			return
+		}
+	}
+}
+
+// apply はドキュメントの保存（値が nil の場合は削除）をまとめて反映し、永続化します
+// 永続化に失敗した場合はメモリ上の変更を元に戻し、ファイルとメモリの内容がずれないようにします
+// 呼び出し元が書き込みロックを保持している必要があります
+func (s *memoryStore) apply(collection string, changes map[string]json.RawMessage) error {
+	if s.collections[collection] == nil {
+		s.collections[collection] = make(map[string]json.RawMessage)
+	}
+	docs := s.collections[collection]
+
+	type previous struct {
+		raw    json.RawMessage
+		exists bool
+	}
+	originals := make(map[string]previous, len(changes))
+	for id, raw := range changes {
+		current, exists := docs[id]
+		originals[id] = previous{raw: current, exists: exists}
+		if raw == nil {
+			delete(docs, id)
+		} else {
+			docs[id] = raw
+		}
+	}
+
+	if err := s.flush(); err != nil {
+		for id, original := range originals {
+			if original.exists {
+				docs[id] = original.raw
+			} else {
+				delete(docs, id)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// flush は persist が設定されていれば呼び出します。ロックを保持した状態で呼び出してください
+func (s *memoryStore) flush() error {
+	if s.persist == nil {
+		return nil
+	}
+	return s.persist(s.collections)
+}
+
+func (s *memoryStore) NewScheduleID(ctx context.Context) string {
+	return newDocumentID()
+}
+
+func (s *memoryStore) GetSchedule(ctx context.Context, spaceId string) (*ScheduleDocument, error) {
+	var data ScheduleDocument
+	if err := s.get(memorySchedules, spaceId, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *memoryStore) SaveSchedule(ctx context.Context, spaceId string, data *ScheduleDocument) error {
+	return s.put(memorySchedules, spaceId, data)
+}
+
+func (s *memoryStore) UpdateSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error {
+	var data ScheduleDocument
+	return s.update(memorySchedules, spaceId, &data, func() error {
+		return fn(&data)
+	})
+}
+
+func (s *memoryStore) DeleteSchedule(ctx context.Context, spaceId string, fn func(doc *ScheduleDocument) error) error {
+	var data ScheduleDocument
+	return s.remove(memorySchedules, spaceId, &data, func() error {
+		return fn(&data)
+	})
+}
+
+func (s *memoryStore) ListSchedulesForUser(ctx context.Context, uid string, limit int, after *ScheduleListCursor) ([]ScheduleListEntry, error) {
+	var entries []ScheduleListEntry
+	s.each(memorySchedules, func(id string, raw json.RawMessage) bool {
+		var data ScheduleDocument
+		if json.Unmarshal(raw, &data) != nil || data.OwnerUID != uid && !containsString(data.MemberUIDs, uid) {
+			return true
+		}
+		if after == nil || after.after(id, data.UpdatedAt) {
+			entries = append(entries, ScheduleListEntry{SpaceID: id, Doc: &data})
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Doc.UpdatedAt, entries[j].Doc.UpdatedAt
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return entries[i].SpaceID > entries[j].SpaceID
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) DeleteExpiredSchedules(ctx context.Context, now time.Time) ([]string, error) {
+	var spaceIds []string
+	_, err := s.delete(memorySchedules, func(id string, raw json.RawMessage) bool {
+		var data ScheduleDocument
+		if json.Unmarshal(raw, &data) != nil || data.ExpiresAt == nil || !data.ExpiresAt.Before(now) {
+			return false
+		}
+		spaceIds = append(spaceIds, id)
+		return true
+	})
+	sort.Strings(spaceIds)
+	return spaceIds, err
+}
+
+func (s *memoryStore) BackfillScheduleExpiry(ctx context.Context, expiry func(doc *ScheduleDocument) *time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updates := make(map[string]json.RawMessage)
+	for id, raw := range s.collections[memorySchedules] {
+		var data ScheduleDocument
+		if json.Unmarshal(raw, &data) != nil || data.ExpiresAt != nil {
+			continue
+		}
+		expiresAt := expiry(&data)
+		if expiresAt == nil {
+			continue
+		}
+		data.ExpiresAt = expiresAt
+		updated, err := json.Marshal(&data)
+		if err != nil {
+			return 0, err
+		}
+		updates[id] = updated
+	}
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	if err := s.apply(memorySchedules, updates); err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+func (s *memoryStore) AppendScheduleAudit(ctx context.Context, entry *ScheduleAuditEntry) error {
+	return s.put(memoryScheduleAudit, entry.ID, entry)
+}
+
+func (s *memoryStore) ListScheduleAudit(ctx context.Context, spaceId string, limit int) ([]*ScheduleAuditEntry, error) {
+	var entries []*ScheduleAuditEntry
+	s.each(memoryScheduleAudit, func(id string, raw json.RawMessage) bool {
+		var entry ScheduleAuditEntry
+		if json.Unmarshal(raw, &entry) == nil && entry.SpaceID == spaceId {
+			entries = append(entries, &entry)
+		}
+		return true
+	})
+	return latestScheduleAudit(entries, limit), nil
+}
+
+func (s *memoryStore) DeleteScheduleAudit(ctx context.Context, spaceId string) (int, error) {
+	return s.delete(memoryScheduleAudit, func(id string, raw json.RawMessage) bool {
+		var entry ScheduleAuditEntry
+		return json.Unmarshal(raw, &entry) == nil && entry.SpaceID == spaceId
+	})
+}
+
+func (s *memoryStore) GetTaskDocument(ctx context.Context, uid string) (*TaskDocument, error) {
+	var doc TaskDocument
+	if err := s.get(memoryTasks, uid, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// GetTaskRange はドキュメント全体を読み込んだ後に期間で絞り込みます（メモリ上のため読み込みの量は問題になりません）
+func (s *memoryStore) GetTaskRange(ctx context.Context, uid, from, to string) (*TaskDocument, error) {
+	doc, err := s.GetTaskDocument(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return filterTaskDocument(doc, from, to), nil
+}
+
+func (s *memoryStore) SaveTaskDocument(ctx context.Context, uid string, doc *TaskDocument) error {
+	return s.put(memoryTasks, uid, doc)
+}
+
+// UpdateTaskDocument はFirestoreと同じく、scope の月の予定・通知のみを fn に渡し、それ以外の月はそのまま残します
+func (s *memoryStore) UpdateTaskDocument(ctx context.Context, uid string, scope TaskDocumentScope, fn func(doc *TaskDocument) error) error {
+	doc := TaskDocument{UID: uid}
+	return s.upsert(memoryTasks, uid, &doc, func() error {
+		if scope.All {
+			return fn(&doc)
+		}
+		loaded := scope.months(func(id string) (string, bool) {
+			date, index := findTask(&doc, id)
+			return taskMonthOf(date), index >= 0
+		})
+		inScope := func(date string) bool { return loaded[taskMonthOf(date)] }
+
+		scoped := doc
+		scoped.Events = make(map[string][]TaskSlot)
+		scoped.Notifications = make(map[string][]NotificationSlot)
+		outside := &taskMonth{Events: make(map[string][]TaskSlot), Notifications: make(map[string][]NotificationSlot)}
+		for date, tasks := range doc.Events {
+			if inScope(date) {
+				scoped.Events[date] = tasks
+			} else {
+				outside.Events[date] = tasks
+			}
+		}
+		for date, notifications := range doc.Notifications {
+			if inScope(date) {
+				scoped.Notifications[date] = notifications
+			} else {
+				outside.Notifications[date] = notifications
+			}
+		}
+
+		if err := fn(&scoped); err != nil {
+			return err
+		}
+		if err := checkTaskMonthsLoaded(&scoped, loaded); err != nil {
+			return err
+		}
+		doc = scoped
+		mergeTaskMonth(&doc, outside)
+		return nil
+	})
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, uid string) (*UserData, error) {
+	var userData UserData
+	if err := s.get(memoryUsers, uid, &userData); err != nil {
+		return nil, err
+	}
+	return &userData, nil
+}
+
+func (s *memoryStore) FindUserByProvider(ctx context.Context, provider, field, value string) (*UserData, error) {
+	var found *UserData
+	s.each(memoryUsers, func(id string, raw json.RawMessage) bool {
+		var userData UserData
+		if err := json.Unmarshal(raw, &userData); err != nil {
+			return true
+		}
+		if userHasProviderValue(&userData, provider, field, value) {
+			found = &userData
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// userHasProviderValue はユーザーデータの指定プロバイダーに value と一致するフィールドがあるかを判定します
+func userHasProviderValue(userData *UserData, provider, field, value string) bool {
+	if provider == "email" {
+		for _, info := range userData.Email {
+			if (field == "userUID" && info.UserUID == value) || (field == "emailAddress" && info.EmailAddress == value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var infos []OAuthProviderInfo
+	switch provider {
+	case "google":
+		infos = userData.Google
+	case "github":
+		infos = userData.GitHub
+	case "twitter":
+		infos = userData.Twitter
+	}
+	for _, info := range infos {
+		if (field == "userUID" && info.UserUID == value) || (field == "emailAddress" && info.EmailAddress == value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) SaveUser(ctx context.Context, uid string, userData *UserData) error {
+	return s.put(memoryUsers, uid, userData)
+}
+
+func (s *memoryStore) SaveVerificationToken(ctx context.Context, token *VerificationToken) error {
+	return s.put(memoryVerificationTokens, token.Token, token)
+}
+
+func (s *memoryStore) GetVerificationToken(ctx context.Context, token string) (*VerificationToken, error) {
+	var verificationToken VerificationToken
+	if err := s.get(memoryVerificationTokens, token, &verificationToken); err != nil {
+		return nil, err
+	}
+	return &verificationToken, nil
+}
+
+func (s *memoryStore) DeleteVerificationToken(ctx context.Context, token string) error {
+	_, err := s.delete(memoryVerificationTokens, func(id string, raw json.RawMessage) bool {
+		return id == token
+	})
+	return err
+}
+
+func (s *memoryStore) DeleteExpiredVerificationTokens(ctx context.Context, now time.Time) (int, error) {
+	return s.delete(memoryVerificationTokens, func(id string, raw json.RawMessage) bool {
+		var token VerificationToken
+		return json.Unmarshal(raw, &token) == nil && token.ExpiresAt.Before(now)
+	})
+}
+
+func (s *memoryStore) ListVerificationTokensCreatedBefore(ctx context.Context, cutoff time.Time) ([]*VerificationToken, error) {
+	var tokens []*VerificationToken
+	s.each(memoryVerificationTokens, func(id string, raw json.RawMessage) bool {
+		var token VerificationToken
+		if err := json.Unmarshal(raw, &token); err == nil && token.CreatedAt.Before(cutoff) {
+			tokens = append(tokens, &token)
+		}
+		return true
+	})
+	return tokens, nil
+}
+
+func (s *memoryStore) DeleteVerificationTokensForUser(ctx context.Context, uid string) (int, error) {
+	return s.delete(memoryVerificationTokens, func(id string, raw json.RawMessage) bool {
+		var token VerificationToken
+		return json.Unmarshal(raw, &token) == nil && token.UID == uid
+	})
+}
+
+func (s *memoryStore) GetIdentity(ctx context.Context, uid string) (*LocalIdentity, error) {
+	var identity LocalIdentity
+	if err := s.get(memoryIdentities, uid, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *memoryStore) FindIdentityByEmail(ctx context.Context, email string) (*LocalIdentity, error) {
+	var found *LocalIdentity
+	s.each(memoryIdentities, func(id string, raw json.RawMessage) bool {
+		var identity LocalIdentity
+		if err := json.Unmarshal(raw, &identity); err == nil && identity.Email == email {
+			found = &identity
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (s *memoryStore) CreateIdentity(ctx context.Context, identity *LocalIdentity) error {
+	raw, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.collections[memoryIdentities][identity.UID]; exists {
+		return fmt.Errorf("%w: uid=%s", ErrAlreadyExists, identity.UID)
+	}
+	for _, existingRaw := range s.collections[memoryIdentities] {
+		var existing LocalIdentity
+		if err := json.Unmarshal(existingRaw, &existing); err == nil && existing.Email == identity.Email {
+			return fmt.Errorf("%w: %s", ErrEmailAlreadyExists, identity.Email)
+		}
+	}
+	return s.apply(memoryIdentities, map[string]json.RawMessage{identity.UID: raw})
+}
+
+func (s *memoryStore) SaveIdentity(ctx context.Context, identity *LocalIdentity) error {
+	return s.put(memoryIdentities, identity.UID, identity)
+}
+
+func (s *memoryStore) DeleteIdentity(ctx context.Context, uid string) error {
+	_, err := s.delete(memoryIdentities, func(id string, raw json.RawMessage) bool {
+		return id == uid
+	})
+	return err
+}
+
+func (s *memoryStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := s.get(memorySessions, id, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *memoryStore) SaveSession(ctx context.Context, session *Session) error {
+	return s.put(memorySessions, session.ID, session)
+}
+
+func (s *memoryStore) UpdateSession(ctx context.Context, id string, fn func(session *Session) error) error {
+	var session Session
+	return s.update(memorySessions, id, &session, func() error {
+		return fn(&session)
+	})
+}
+
+func (s *memoryStore) ListSessionsForUser(ctx context.Context, uid string) ([]*Session, error) {
+	var sessions []*Session
+	s.each(memorySessions, func(id string, raw json.RawMessage) bool {
+		var session Session
+		if err := json.Unmarshal(raw, &session); err == nil && session.UID == uid {
+			sessions = append(sessions, &session)
+		}
+		return true
+	})
+	return sessions, nil
+}
+
+func (s *memoryStore) DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error) {
+	return s.delete(memorySessions, func(id string, raw json.RawMessage) bool {
+		var session Session
+		return json.Unmarshal(raw, &session) == nil && session.ExpiresAt.Before(now)
+	})
+}
+
+func (s *memoryStore) ClaimNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	raw, err := json.Marshal(usedNonce{ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.collections[memoryNonces][nonce]; exists {
+		return ErrAlreadyExists
+	}
+	return s.apply(memoryNonces, map[string]json.RawMessage{nonce: raw})
+}
+
+func (s *memoryStore) DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error) {
+	return s.delete(memoryNonces, func(id string, raw json.RawMessage) bool {
+		var record usedNonce
+		return json.Unmarshal(raw, &record) == nil && record.ExpiresAt.Before(now)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// taskPageDefaultLimit は期間を指定した GET /api/task で1回に返す日付数の既定値です
+	taskPageDefaultLimit = 100
+	// taskPageMaxLimit は limit に指定できる日付数の上限です
+	taskPageMaxLimit = 366
+)
+
+// taskMonthUndated は YYYY-MM-DD 形式でない日付キーをまとめる月のキーです（過去のデータ用）
+const taskMonthUndated = "undated"
+
+// taskMonth は1か月分の予定・通知です
+// Firestoreではユーザーのタスクドキュメント配下に、月ごとのサブドキュメントとして保存します
+type taskMonth struct {
+	Events        map[string][]TaskSlot
+	Notifications map[string][]NotificationSlot
+}
+
+// taskMonthOf は日付キーが属する月（YYYY-MM）を返します
+func taskMonthOf(date string) string {
+	if !isValidTaskDate(date) {
+		return taskMonthUndated
+	}
+	return date[:7]
+}
+
+// splitTaskMonths は予定・通知を月ごとに分割します
+// 呼び出し元が doc を変更しても影響しないよう、日付ごとのリストはコピーします
+func splitTaskMonths(doc *TaskDocument) map[string]*taskMonth {
+	months := make(map[string]*taskMonth)
+	monthOf := func(date string) *taskMonth {
+		key := taskMonthOf(date)
+		if months[key] == nil {
+			months[key] = &taskMonth{
+				Events:        make(map[string][]TaskSlot),
+				Notifications: make(map[string][]NotificationSlot),
+			}
+		}
+		return months[key]
+	}
+	for date, tasks := range doc.Events {
+		monthOf(date).Events[date] = append([]TaskSlot{}, tasks...)
+	}
+	for date, notifications := range doc.Notifications {
+		monthOf(date).Notifications[date] = append([]NotificationSlot{}, notifications...)
+	}
+	return months
+}
+
+// mergeTaskMonth は1か月分の予定・通知をドキュメントに加えます
+func mergeTaskMonth(doc *TaskDocument, month *taskMonth) {
+	if doc.Events == nil {
+		doc.Events = make(map[string][]TaskSlot)
+	}
+	if doc.Notifications == nil {
+		doc.Notifications = make(map[string][]NotificationSlot)
+	}
+	for date, tasks := range month.Events {
+		doc.Events[date] = tasks
+	}
+	for date, notifications := range month.Notifications {
+		doc.Notifications[date] = notifications
+	}
+}
+
+// months は範囲が読み込む月を返します。monthOfTask はタスクのIDからそのタスクを含む月を探します
+func (s TaskDocumentScope) months(monthOfTask func(id string) (string, bool)) map[string]bool {
+	months := make(map[string]bool)
+	for _, date := range s.Dates {
+		months[taskMonthOf(date)] = true
+	}
+	for _, id := range s.TaskIDs {
+		if month, ok := monthOfTask(id); ok {
+			months[month] = true
+		}
+	}
+	return months
+}
+
+// checkTaskMonthsLoaded は読み込んでいない月に予定・通知が追加されていないかを確認します
+// 読み込んでいない月を保存すると、保存済みの予定・通知を上書きしてしまうためです
+func checkTaskMonthsLoaded(doc *TaskDocument, loaded map[string]bool) error {
+	for date := range doc.Events {
+		if !loaded[taskMonthOf(date)] {
+			return fmt.Errorf("task date %s is outside the loaded months", date)
+		}
+	}
+	for date := range doc.Notifications {
+		if !loaded[taskMonthOf(date)] {
+			return fmt.Errorf("notification date %s is outside the loaded months", date)
+		}
+	}
+	return nil
+}
+
+// filterTaskDocument は from から to（YYYY-MM-DD、両端を含む）の予定・通知のみを持つドキュメントを返します
+// リビジョンや繰り返しタスクの定義などはそのまま引き継ぎます
+func filterTaskDocument(doc *TaskDocument, from, to string) *TaskDocument {
+	filtered := *doc
+	filtered.Events = make(map[string][]TaskSlot)
+	filtered.Notifications = make(map[string][]NotificationSlot)
+	inRange := func(date string) bool {
+		return isValidTaskDate(date) && date >= from && date <= to
+	}
+	for date, tasks := range doc.Events {
+		if inRange(date) {
+			filtered.Events[date] = tasks
+		}
+	}
+	for date, notifications := range doc.Notifications {
+		if inRange(date) {
+			filtered.Notifications[date] = notifications
+		}
+	}
+	return &filtered
+}
+
+// paginateTaskDates は予定・通知のある日付を古い順に limit 件までに絞り込みます
+// 残りの日付がある場合は、次のページの from に指定する日付を nextFrom に返します
+func paginateTaskDates(events map[string][]TaskSlot, notifications map[string][]NotificationSlot, limit int) (map[string][]TaskSlot, map[string][]NotificationSlot, string) {
+	seen := make(map[string]bool, len(events)+len(notifications))
+	var dates []string
+	for date := range events {
+		if !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
+		}
+	}
+	for date := range notifications {
+		if !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
+		}
+	}
+	if len(dates) <= limit {
+		return events, notifications, ""
+	}
+
+	sort.Strings(dates)
+	pageEvents := make(map[string][]TaskSlot)
+	pageNotifications := make(map[string][]NotificationSlot)
+	for _, date := range dates[:limit] {
+		if tasks, ok := events[date]; ok {
+			pageEvents[date] = tasks
+		}
+		if list, ok := notifications[date]; ok {
+			pageNotifications[date] = list
+		}
+	}
+	return pageEvents, pageNotifications, dates[limit]
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+func TestUpdateTaskDocumentScope(t *testing.T) {
+	ctx := context.Background()
+	const uid = "uid"
+
+	newStore := func(t *testing.T) {
+		t.Helper()
+		useMemoryStore(t)
+		doc := &TaskDocument{
+			UID: uid,
+			Events: map[string][]TaskSlot{
+				"2026-01-05": {{ID: "jan", Title: "January"}},
+				"2026-03-10": {{ID: "mar", Title: "March"}},
+			},
+			Notifications: map[string][]NotificationSlot{
+				"2026-03-10": {{Time: "09:00"}},
+			},
+		}
+		if err := dataStore.SaveTaskDocument(ctx, uid, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dates := func(events map[string][]TaskSlot) []string {
+		var result []string
+		for date := range events {
+			result = append(result, date)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		scope     TaskDocumentScope
+		fn        func(doc *TaskDocument)
+		wantSeen  []string
+		wantSaved []string
+		wantErr   bool
+	}{
+		{
+			name:      "dates load only their month",
+			scope:     TaskDocumentScope{Dates: []string{"2026-01-20"}},
+			fn:        func(doc *TaskDocument) { doc.Events["2026-01-20"] = []TaskSlot{{ID: "new"}} },
+			wantSeen:  []string{"2026-01-05"},
+			wantSaved: []string{"2026-01-05", "2026-01-20", "2026-03-10"},
+		},
+		{
+			name:      "task IDs load the month of the task",
+			scope:     TaskDocumentScope{TaskIDs: []string{"mar"}},
+			fn:        func(doc *TaskDocument) { delete(doc.Events, "2026-03-10") },
+			wantSeen:  []string{"2026-03-10"},
+			wantSaved: []string{"2026-01-05"},
+		},
+		{
+			name:      "empty scope loads no months",
+			scope:     TaskDocumentScope{},
+			fn:        func(doc *TaskDocument) { doc.Recurring = []RecurringTask{{ID: "r"}} },
+			wantSeen:  nil,
+			wantSaved: []string{"2026-01-05", "2026-03-10"},
+		},
+		{
+			name:      "writing outside the loaded months fails",
+			scope:     TaskDocumentScope{Dates: []string{"2026-01-05"}},
+			fn:        func(doc *TaskDocument) { doc.Events["2026-03-11"] = []TaskSlot{{ID: "new"}} },
+			wantSeen:  []string{"2026-01-05"},
+			wantSaved: []string{"2026-01-05", "2026-03-10"},
+			wantErr:   true,
+		},
+		{
+			name:      "all months",
+			scope:     taskScopeAll,
+			fn:        func(doc *TaskDocument) {},
+			wantSeen:  []string{"2026-01-05", "2026-03-10"},
+			wantSaved: []string{"2026-01-05", "2026-03-10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newStore(t)
+			var seen []string
+			err := dataStore.UpdateTaskDocument(ctx, uid, tt.scope, func(doc *TaskDocument) error {
+				seen = dates(doc.Events)
+				tt.fn(doc)
+				return nil
+			})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("UpdateTaskDocument() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !equalStrings(seen, tt.wantSeen) {
+				t.Errorf("fn saw %v, want %v", seen, tt.wantSeen)
+			}
+
+			saved, err := dataStore.GetTaskDocument(ctx, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dates(saved.Events); !equalStrings(got, tt.wantSaved) {
+				t.Errorf("saved dates = %v, want %v", got, tt.wantSaved)
+			}
+			if len(saved.Notifications["2026-03-10"]) != 1 {
+				t.Errorf("notifications outside the scope were lost: %v", saved.Notifications)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// taskTargetRecurring は繰り返しタスクの定義を対象とする同期操作です（サーバーからの通知のみ）
+const taskTargetRecurring = "recurring"
+
+// taskExpandMaxDays は GET /api/task で繰り返しを展開できる期間の最大日数です
+const taskExpandMaxDays = 366
+
+// ErrRecurringTaskNotFound は繰り返しタスクの定義、または指定した日付の回が存在しない場合に返されます
+var ErrRecurringTaskNotFound = errors.New("recurring task not found")
+
+// RecurringTaskOverride は繰り返しの1回分の変更です。指定したフィールドのみ元の内容を置き換えます
+// Date を指定した場合、その回は指定した日付に移動します
+// TaskItemRequest と同じフィールドを持つため、変換して applyTo を使用できます
+type RecurringTaskOverride struct {
+	Date        string  `json:"date,omitempty" firestore:"date,omitempty"`
+	Title       *string `json:"title,omitempty" firestore:"title,omitempty"`
+	Description *string `json:"description,omitempty" firestore:"description,omitempty"`
+	Start       *string `json:"start,omitempty" firestore:"start,omitempty"`
+	End         *string `json:"end,omitempty" firestore:"end,omitempty"`
+	UserColor   *string `json:"userColor,omitempty" firestore:"userColor,omitempty"`
+	Order       *int    `json:"order,omitempty" firestore:"order,omitempty"`
+}
+
+// RecurringTask は繰り返しタスクの定義です
+type RecurringTask struct {
+	ID string `json:"id" firestore:"id"`
+	// Task は各回に共通の内容です（ID は使用しません）
+	Task TaskSlot `json:"task" firestore:"task"`
+	// DTStart は最初の回の日付（YYYY-MM-DD）です
+	DTStart string `json:"dtstart" firestore:"dtstart"`
+	// RRule はRFC 5545のRRULEです（例: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"）
+	RRule string `json:"rrule" firestore:"rrule"`
+	// ExDates は除外する回の日付（YYYY-MM-DD）です
+	ExDates []string `json:"exdates,omitempty" firestore:"exdates,omitempty"`
+	// Overrides は回ごとの変更です（キーは元の日付）
+	Overrides map[string]RecurringTaskOverride `json:"overrides,omitempty" firestore:"overrides,omitempty"`
+	UpdatedAt time.Time                        `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// normalizeRecurringTask はRRULE・DTSTART・EXDATE・各回の内容を検証し、日付を YYYY-MM-DD 形式、時刻を HH:MM 形式にそろえます
+// 検証エラーは ValidationErrors として返します
+func normalizeRecurringTask(recurring *RecurringTask) error {
+	v := &validator{}
+	if recurring.DTStart == "" {
+		v.add("dtstart", validationRequired, "dtstart を指定してください")
+	} else if dtstart := v.date("dtstart", recurring.DTStart); dtstart != "" {
+		recurring.DTStart = dtstart
+	}
+
+	recurring.RRule = strings.TrimPrefix(strings.TrimSpace(recurring.RRule), "RRULE:")
+	if recurring.RRule == "" {
+		v.add("rrule", validationRequired, "rrule を指定してください")
+	} else if _, err := parseRRule(recurring.RRule); err != nil {
+		code := validationInvalidFormat
+		if errors.Is(err, ErrUnsupportedRRule) {
+			code = validationUnsupported
+		}
+		v.add("rrule", code, err.Error())
+	}
+
+	if v.maxItems("exdates", len(recurring.ExDates), recurringExDatesMax) {
+		exdates := make([]string, 0, len(recurring.ExDates))
+		for i, exdate := range recurring.ExDates {
+			d, err := parseRRuleDate(exdate)
+			if err != nil {
+				v.add(fmt.Sprintf("exdates[%d]", i), validationInvalidFormat, "日付は YYYY-MM-DD 形式で指定してください")
+				continue
+			}
+			exdates = append(exdates, d.Format(taskDateLayout))
+		}
+		sort.Strings(exdates)
+		recurring.ExDates = exdates
+	}
+
+	v.taskSlot("task", &recurring.Task)
+	recurring.Task.ID, recurring.Task.RecurringID, recurring.Task.OccurrenceDate = "", "", ""
+	return v.err()
+}
+
+// findRecurringTask はIDの繰り返しタスクの位置を返します。見つからない場合は -1 です
+func findRecurringTask(doc *TaskDocument, id string) int {
+	for i := range doc.Recurring {
+		if doc.Recurring[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// isOccurrence は date（元の日付）が繰り返しの回に当たるかを返します。除外された回は含みません
+func (r *RecurringTask) isOccurrence(date string) bool {
+	d, err := time.Parse(taskDateLayout, date)
+	if err != nil || containsString(r.ExDates, date) {
+		return false
+	}
+	return len(r.occurrences(d, d)) == 1
+}
+
+// occurrences は from から to までの回の元の日付を返します（EXDATE による除外前）
+func (r *RecurringTask) occurrences(from, to time.Time) []time.Time {
+	rule, err := parseRRule(r.RRule)
+	if err != nil {
+		return nil
+	}
+	dtstart, err := time.Parse(taskDateLayout, r.DTStart)
+	if err != nil {
+		return nil
+	}
+	return rule.Occurrences(dtstart, from, to)
+}
+
+// occurrenceSlot は元の日付の回を、変更を反映したタスクとその日付に変換します
+func (r *RecurringTask) occurrenceSlot(original string) (string, TaskSlot) {
+	task := r.Task
+	task.ID = r.ID + "_" + strings.ReplaceAll(original, "-", "")
+	task.RecurringID = r.ID
+	task.OccurrenceDate = original
+
+	date := original
+	if override, ok := r.Overrides[original]; ok {
+		request := TaskItemRequest(override)
+		request.applyTo(&task)
+		if override.Date != "" {
+			date = override.Date
+		}
+	}
+	return date, task
+}
+
+// expandRecurringTasks は from から to（両端を含む）に表示される繰り返しタスクの回を日付ごとに返します
+// 別の日付へ移動した回は、移動先の日付が期間内にある場合に含めます
+func expandRecurringTasks(recurring []RecurringTask, from, to time.Time) map[string][]TaskSlot {
+	result := make(map[string][]TaskSlot)
+	inRange := func(date string) bool {
+		d, err := time.Parse(taskDateLayout, date)
+		return err == nil && !d.Before(from) && !d.After(to)
+	}
+
+	for i := range recurring {
+		r := &recurring[i]
+		originals := make(map[string]bool)
+		for _, d := range r.occurrences(from, to) {
+			originals[d.Format(taskDateLayout)] = true
+		}
+		// 期間外から期間内へ移動した回
+		for original, override := range r.Overrides {
+			if override.Date != "" && inRange(override.Date) && !originals[original] && r.isOccurrence(original) {
+				originals[original] = true
+			}
+		}
+
+		for original := range originals {
+			if containsString(r.ExDates, original) {
+				continue
+			}
+			date, task := r.occurrenceSlot(original)
+			if inRange(date) {
+				result[date] = append(result[date], task)
+			}
+		}
+	}
+	return result
+}
+
+// recurringTaskOperation は繰り返しタスクの定義の変更を同期操作として返します
+func recurringTaskOperation(op string, recurring *RecurringTask) *TaskOperation {
+	operation := &TaskOperation{Op: op, Target: taskTargetRecurring, ID: recurring.ID}
+	if op != taskOpDelete {
+		copied := *recurring
+		operation.Recurring = &copied
+	}
+	return operation
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
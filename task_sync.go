@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// タスクの同期操作の種類
+const (
+	taskOpCreate = "create"
+	taskOpUpdate = "update"
+	taskOpDelete = "delete"
+)
+
+// 同期操作の対象
+const (
+	// taskTargetTask は1件のタスクを対象とします（既定）
+	taskTargetTask = "task"
+	// taskTargetNotification は1日分の通知を対象とします
+	taskTargetNotification = "notification"
+)
+
+// taskChangeLogLimit はタスクドキュメントに保持する変更履歴の最大件数です
+// これより古いカーソルで同期したクライアントには全件を返します
+const taskChangeLogLimit = 1000
+
+// taskSyncMaxOperations は1回の同期リクエストで受け付ける操作の最大件数です
+const taskSyncMaxOperations = 500
+
+// ErrTaskNotFound は更新対象のタスクが存在しない（他の端末で削除された）場合に返されます
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskOperation はタスク・通知に対する1件の変更操作です
+//
+//   - target が "task" の場合: create・update は task の内容で置き換え（date が変わると移動）、delete は id のタスクを削除します
+//   - target が "notification" の場合: create・update は date の通知を notifications で置き換え、delete は date の通知を削除します
+type TaskOperation struct {
+	// Seq は操作を適用した後のドキュメントのリビジョンです（サーバーが設定します）
+	Seq           int64              `json:"seq,omitempty" firestore:"seq"`
+	Op            string             `json:"op" firestore:"op"`
+	Target        string             `json:"target,omitempty" firestore:"target,omitempty"`
+	ID            string             `json:"id,omitempty" firestore:"id,omitempty"`
+	Date          string             `json:"date,omitempty" firestore:"date,omitempty"`
+	Task          *TaskSlot          `json:"task,omitempty" firestore:"task,omitempty"`
+	Notifications []NotificationSlot `json:"notifications,omitempty" firestore:"notifications,omitempty"`
+	// Recurring は target が "recurring" の場合の変更後の定義です
+	Recurring *RecurringTask `json:"recurring,omitempty" firestore:"recurring,omitempty"`
+}
+
+// validateTaskOperation は操作の形式を確認し、target と id を補完します
+// 時刻は正規形（HH:MM）にそろえます。エラーは path を接頭辞として v に追加します
+func validateTaskOperation(v *validator, path string, op *TaskOperation) {
+	if op.Target == "" {
+		op.Target = taskTargetTask
+	}
+	if op.Op != taskOpCreate && op.Op != taskOpUpdate && op.Op != taskOpDelete {
+		v.add(path+".op", validationInvalidValue, "op は create・update・delete のいずれかを指定してください")
+		return
+	}
+	if op.Date != "" {
+		if date := v.date(path+".date", op.Date); date != "" {
+			op.Date = date
+		}
+	}
+
+	switch op.Target {
+	case taskTargetTask:
+		if op.Op != taskOpDelete {
+			if op.Date == "" {
+				v.add(path+".date", validationRequired, op.Op+" には date が必要です")
+			}
+			if op.Task == nil {
+				v.add(path+".task", validationRequired, op.Op+" には task が必要です")
+			}
+		}
+		if op.ID == "" && op.Task != nil {
+			op.ID = op.Task.ID
+		}
+		if op.ID == "" && op.Op != taskOpCreate {
+			v.add(path+".id", validationRequired, op.Op+" には id が必要です")
+		}
+		if op.ID != "" && !isValidTaskID(op.ID) {
+			v.add(path+".id", validationInvalidFormat, "id の形式が正しくありません")
+		}
+		if op.Task != nil {
+			v.taskSlot(path+".task", op.Task)
+		}
+	case taskTargetNotification:
+		if op.Date == "" {
+			v.add(path+".date", validationRequired, "notification の操作には date が必要です")
+		}
+		v.notifications(path+".notifications", op.Notifications)
+	default:
+		v.add(path+".target", validationInvalidValue, "target は task・notification のいずれかを指定してください")
+	}
+}
+
+// isValidTaskID はクライアントが指定したタスクIDが使用できる形式かを返します
+// オフラインで作成したタスクは、クライアントが生成したIDのまま保存できます
+func isValidTaskID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// findTask はIDのタスクの日付と位置を返します。見つからない場合の位置は -1 です
+func findTask(doc *TaskDocument, id string) (string, int) {
+	for date, tasks := range doc.Events {
+		for i, task := range tasks {
+			if task.ID == id {
+				return date, i
+			}
+		}
+	}
+	return "", -1
+}
+
+// removeTaskAt は日付の i 番目のタスクを取り除きます。空になった日付は削除します
+func removeTaskAt(doc *TaskDocument, date string, i int) {
+	tasks := append(append([]TaskSlot(nil), doc.Events[date][:i]...), doc.Events[date][i+1:]...)
+	if len(tasks) == 0 {
+		delete(doc.Events, date)
+		return
+	}
+	doc.Events[date] = tasks
+}
+
+// ensureTaskIDs はIDのないタスク（ID導入前のデータ）にIDを割り当てます。割り当てた場合は true を返します
+func ensureTaskIDs(doc *TaskDocument) bool {
+	assigned := false
+	for date, tasks := range doc.Events {
+		for i := range tasks {
+			if tasks[i].ID == "" {
+				tasks[i].ID = newDocumentID()
+				assigned = true
+			}
+		}
+		doc.Events[date] = tasks
+	}
+	return assigned
+}
+
+// applyTaskOperation は操作をドキュメントに適用します
+// 適用した場合は記録用の操作を返し、変更がない場合（削除済みのタスクの削除など）は nil を返します
+// 削除済みのタスクの update は ErrTaskNotFound を返します
+func applyTaskOperation(doc *TaskDocument, op TaskOperation) (*TaskOperation, error) {
+	if op.Target == taskTargetNotification {
+		current, exists := doc.Notifications[op.Date]
+		if op.Op == taskOpDelete || len(op.Notifications) == 0 {
+			if !exists {
+				return nil, nil
+			}
+			delete(doc.Notifications, op.Date)
+			return &TaskOperation{Op: taskOpDelete, Target: taskTargetNotification, Date: op.Date}, nil
+		}
+		if exists && notificationSlotsEqual(current, op.Notifications) {
+			return nil, nil
+		}
+		doc.Notifications[op.Date] = append([]NotificationSlot(nil), op.Notifications...)
+		return &TaskOperation{Op: taskOpUpdate, Target: taskTargetNotification, Date: op.Date, Notifications: op.Notifications}, nil
+	}
+
+	date, index := "", -1
+	if op.ID != "" {
+		date, index = findTask(doc, op.ID)
+	}
+
+	switch op.Op {
+	case taskOpDelete:
+		if index < 0 {
+			return nil, nil
+		}
+		removeTaskAt(doc, date, index)
+		return &TaskOperation{Op: taskOpDelete, Target: taskTargetTask, ID: op.ID, Date: date}, nil
+	case taskOpUpdate:
+		if index < 0 {
+			return nil, ErrTaskNotFound
+		}
+	case taskOpCreate:
+		if op.ID == "" {
+			op.ID = newDocumentID()
+		}
+	}
+
+	task := *op.Task
+	task.ID = op.ID
+	// 繰り返しタスクを展開した回の情報は保存しない
+	task.RecurringID, task.OccurrenceDate = "", ""
+	if index >= 0 {
+		// 同じIDのタスクが既にある場合（作成の再送を含む）は内容を置き換える
+		if date == op.Date && doc.Events[date][index] == task {
+			return nil, nil
+		}
+		if date == op.Date {
+			doc.Events[date][index] = task
+			return &TaskOperation{Op: taskOpUpdate, Target: taskTargetTask, ID: task.ID, Date: op.Date, Task: &task}, nil
+		}
+		removeTaskAt(doc, date, index)
+		doc.Events[op.Date] = append(doc.Events[op.Date], task)
+		return &TaskOperation{Op: taskOpUpdate, Target: taskTargetTask, ID: task.ID, Date: op.Date, Task: &task}, nil
+	}
+
+	doc.Events[op.Date] = append(doc.Events[op.Date], task)
+	return &TaskOperation{Op: taskOpCreate, Target: taskTargetTask, ID: task.ID, Date: op.Date, Task: &task}, nil
+}
+
+// notificationSlotsEqual は2つの通知リストが同じ内容かを返します
+func notificationSlotsEqual(a, b []NotificationSlot) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recordTaskChanges はリビジョンを進め、適用した操作を変更履歴に追加します
+// 履歴が taskChangeLogLimit を超えた場合は古い操作から削除し、ChangeLogBase を更新します
+func recordTaskChanges(doc *TaskDocument, ops []*TaskOperation) {
+	if len(doc.Changes) == 0 && doc.ChangeLogBase < doc.Revision {
+		// 履歴の記録を始める前の変更は履歴から復元できない
+		doc.ChangeLogBase = doc.Revision
+	}
+
+	doc.Revision++
+	for _, op := range ops {
+		op.Seq = doc.Revision
+		doc.Changes = append(doc.Changes, *op)
+	}
+
+	if len(doc.Changes) > taskChangeLogLimit {
+		cut := len(doc.Changes) - taskChangeLogLimit
+		// 同じリビジョンの操作が途中で分かれないよう、境界のリビジョンの操作はすべて削除する
+		base := doc.Changes[cut-1].Seq
+		for cut < len(doc.Changes) && doc.Changes[cut].Seq == base {
+			cut++
+		}
+		doc.Changes = append([]TaskOperation(nil), doc.Changes[cut:]...)
+		doc.ChangeLogBase = base
+	}
+}
+
+// taskChangesSince はカーソル（リビジョン）より後の操作を返します
+// 履歴から差分を復元できない場合は reset に true を返します（クライアントは全件を取得し直します）
+func taskChangesSince(doc *TaskDocument, cursor int64) (ops []TaskOperation, reset bool) {
+	base := doc.ChangeLogBase
+	if len(doc.Changes) == 0 {
+		base = doc.Revision
+	}
+	if cursor <= 0 || cursor < base || cursor > doc.Revision {
+		return nil, true
+	}
+
+	ops = []TaskOperation{}
+	for _, op := range doc.Changes {
+		if op.Seq > cursor {
+			ops = append(ops, op)
+		}
+	}
+	return ops, false
+}
+
+// 一括保存（POST /api/task）の保存方法
+const (
+	// taskSaveModeMerge は送信された日付のみ置き換えます（既定）
+	taskSaveModeMerge = "merge"
+	// taskSaveModeReplace は送信されなかった日付の予定・通知を削除し、全体を置き換えます
+	taskSaveModeReplace = "replace"
+)
+
+// bulkTaskOperations は従来の一括保存（日付ごとの置き換え）を同期操作に変換します
+// IDのないタスクは、同じ日付の同じ位置にあったタスクのIDを引き継ぎ、それ以外は新しいIDを割り当てます
+// 戻り値の replaced は送信された順序で保存する日付ごとのタスク、removed は日付ごと削除する日付です
+func bulkTaskOperations(doc *TaskDocument, request *TaskSaveRequest) (ops []TaskOperation, replaced map[string][]TaskSlot, removed []string) {
+	events := request.Events
+	replaceAll := request.Mode == taskSaveModeReplace
+
+	dates := make([]string, 0, len(events))
+	for date := range events {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	// リクエストで明示されたIDは他のタスクに引き継がない
+	claimed := make(map[string]bool)
+	for _, date := range dates {
+		for _, task := range events[date] {
+			if task.ID != "" {
+				claimed[task.ID] = true
+			}
+		}
+	}
+
+	replaced = make(map[string][]TaskSlot, len(events))
+	incoming := make(map[string]bool)
+	for _, date := range dates {
+		existing := doc.Events[date]
+		tasks := make([]TaskSlot, 0, len(events[date]))
+		for i, task := range events[date] {
+			if task.RecurringID != "" {
+				// GET /api/task で展開された繰り返しタスクの回は保存しない
+				continue
+			}
+			switch {
+			case task.ID != "" && isValidTaskID(task.ID) && !incoming[task.ID]:
+			case task.ID == "" && i < len(existing) && !claimed[existing[i].ID] && !incoming[existing[i].ID]:
+				task.ID = existing[i].ID
+			default:
+				task.ID = newDocumentID()
+			}
+			incoming[task.ID] = true
+			tasks = append(tasks, task)
+
+			slot := task
+			ops = append(ops, TaskOperation{Op: taskOpCreate, Target: taskTargetTask, ID: slot.ID, Date: date, Task: &slot})
+		}
+		if len(tasks) == 0 && len(events[date]) > 0 {
+			// 繰り返しタスクの回だけが送信された日付は、空の日付として保存しない
+			continue
+		}
+		replaced[date] = tasks
+	}
+
+	// 削除する日付: deletedEvents で指定された日付と、置き換えモードで送信されなかった日付
+	removedDates := make(map[string]bool)
+	for _, date := range request.DeletedEvents {
+		removedDates[date] = true
+	}
+	if replaceAll {
+		for date := range doc.Events {
+			if _, ok := events[date]; !ok {
+				removedDates[date] = true
+			}
+		}
+	}
+	for date := range removedDates {
+		removed = append(removed, date)
+	}
+	sort.Strings(removed)
+
+	// 置き換えた日付から外れたタスクと、削除する日付のタスクは削除する
+	for _, date := range append(append([]string(nil), dates...), removed...) {
+		for _, task := range doc.Events[date] {
+			if !incoming[task.ID] {
+				ops = append(ops, TaskOperation{Op: taskOpDelete, Target: taskTargetTask, ID: task.ID})
+			}
+		}
+	}
+
+	// 通知はマージモードでは従来どおり空でない日付のみ置き換え、空の日付は deletedNotifications で削除する
+	// 置き換えモードでは送信されなかった日付と空の日付を削除する
+	notificationDates := make([]string, 0, len(request.Notifications))
+	for date, notifs := range request.Notifications {
+		if len(notifs) > 0 {
+			notificationDates = append(notificationDates, date)
+		}
+	}
+	sort.Strings(notificationDates)
+	for _, date := range notificationDates {
+		ops = append(ops, TaskOperation{Op: taskOpUpdate, Target: taskTargetNotification, Date: date, Notifications: request.Notifications[date]})
+	}
+
+	deletedNotifications := append([]string(nil), request.DeletedNotifications...)
+	if replaceAll {
+		for date := range doc.Notifications {
+			if len(request.Notifications[date]) == 0 {
+				deletedNotifications = append(deletedNotifications, date)
+			}
+		}
+	}
+	sort.Strings(deletedNotifications)
+	for _, date := range deletedNotifications {
+		ops = append(ops, TaskOperation{Op: taskOpDelete, Target: taskTargetNotification, Date: date})
+	}
+
+	return ops, replaced, removed
+}
+
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// describeTaskOps は操作を比較用の文字列にします。known に含まれないIDは "<new>" とします
+func describeTaskOps(ops []TaskOperation, known map[string]bool) []string {
+	result := make([]string, 0, len(ops))
+	for _, op := range ops {
+		id := op.ID
+		if id != "" && !known[id] {
+			id = "<new>"
+		}
+		switch op.Target {
+		case taskTargetNotification:
+			result = append(result, fmt.Sprintf("%s notification %s", op.Op, op.Date))
+		case taskTargetTask, "":
+			result = append(result, fmt.Sprintf("%s task %s %s", op.Op, id, op.Date))
+		}
+	}
+	return result
+}
+
+func newTaskChanges(seqs ...int64) []TaskOperation {
+	changes := make([]TaskOperation, 0, len(seqs))
+	for _, seq := range seqs {
+		changes = append(changes, TaskOperation{Seq: seq, Op: taskOpDelete, Target: taskTargetTask, ID: fmt.Sprintf("t%d", seq)})
+	}
+	return changes
+}
+
+func TestRecordTaskChanges(t *testing.T) {
+	fullLog := make([]int64, 0, taskChangeLogLimit)
+	for seq := int64(1); seq <= taskChangeLogLimit; seq++ {
+		fullLog = append(fullLog, seq)
+	}
+	// 先頭の3件が同じリビジョンの履歴
+	sharedLog := append([]int64{1, 1, 1}, fullLog[3:]...)
+
+	tests := []struct {
+		name         string
+		doc          TaskDocument
+		ops          int
+		wantRevision int64
+		wantBase     int64
+		wantChanges  int
+	}{
+		{
+			name:         "first change starts the log at the current revision",
+			doc:          TaskDocument{Revision: 5},
+			ops:          2,
+			wantRevision: 6, wantBase: 5, wantChanges: 2,
+		},
+		{
+			name:         "no operations still advances the revision",
+			doc:          TaskDocument{Revision: 3, ChangeLogBase: 1, Changes: newTaskChanges(2, 3)},
+			ops:          0,
+			wantRevision: 4, wantBase: 1, wantChanges: 2,
+		},
+		{
+			name:         "log over the limit drops the oldest revisions",
+			doc:          TaskDocument{Revision: taskChangeLogLimit, Changes: newTaskChanges(fullLog...)},
+			ops:          2,
+			wantRevision: taskChangeLogLimit + 1, wantBase: 2, wantChanges: taskChangeLogLimit,
+		},
+		{
+			name:         "trimming does not split a revision",
+			doc:          TaskDocument{Revision: taskChangeLogLimit, Changes: newTaskChanges(sharedLog...)},
+			ops:          2,
+			wantRevision: taskChangeLogLimit + 1, wantBase: 1, wantChanges: taskChangeLogLimit - 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := tt.doc
+			ops := make([]*TaskOperation, 0, tt.ops)
+			for i := 0; i < tt.ops; i++ {
+				ops = append(ops, &TaskOperation{Op: taskOpCreate, Target: taskTargetTask, ID: fmt.Sprintf("new%d", i)})
+			}
+			recordTaskChanges(&doc, ops)
+
+			if doc.Revision != tt.wantRevision {
+				t.Errorf("Revision = %d, want %d", doc.Revision, tt.wantRevision)
+			}
+			if doc.ChangeLogBase != tt.wantBase {
+				t.Errorf("ChangeLogBase = %d, want %d", doc.ChangeLogBase, tt.wantBase)
+			}
+			if len(doc.Changes) != tt.wantChanges {
+				t.Errorf("len(Changes) = %d, want %d", len(doc.Changes), tt.wantChanges)
+			}
+			for _, op := range ops {
+				if op.Seq != tt.wantRevision {
+					t.Errorf("op %s Seq = %d, want %d", op.ID, op.Seq, tt.wantRevision)
+				}
+			}
+			if len(doc.Changes) > 0 && doc.Changes[0].Seq <= doc.ChangeLogBase {
+				t.Errorf("oldest change Seq %d is not after ChangeLogBase %d", doc.Changes[0].Seq, doc.ChangeLogBase)
+			}
+		})
+	}
+}
+
+func TestTaskChangesSince(t *testing.T) {
+	withLog := &TaskDocument{Revision: 5, ChangeLogBase: 2, Changes: newTaskChanges(3, 4, 5)}
+	withoutLog := &TaskDocument{Revision: 3}
+
+	tests := []struct {
+		name      string
+		doc       *TaskDocument
+		cursor    int64
+		wantSeqs  []int64
+		wantReset bool
+	}{
+		{"initial sync", withLog, 0, nil, true},
+		{"cursor older than the log", withLog, 1, nil, true},
+		{"cursor at the log base", withLog, 2, []int64{3, 4, 5}, false},
+		{"cursor in the log", withLog, 4, []int64{5}, false},
+		{"cursor up to date", withLog, 5, []int64{}, false},
+		{"cursor ahead of the document", withLog, 6, nil, true},
+		{"no log and up to date", withoutLog, 3, []int64{}, false},
+		{"no log and behind", withoutLog, 2, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, reset := taskChangesSince(tt.doc, tt.cursor)
+			if reset != tt.wantReset {
+				t.Fatalf("reset = %t, want %t", reset, tt.wantReset)
+			}
+			if reset {
+				return
+			}
+			if ops == nil {
+				t.Fatal("ops = nil, want an empty slice when not resetting")
+			}
+			var seqs []int64
+			for _, op := range ops {
+				seqs = append(seqs, op.Seq)
+			}
+			if fmt.Sprint(seqs) != fmt.Sprint(tt.wantSeqs) {
+				t.Errorf("seqs = %v, want %v", seqs, tt.wantSeqs)
+			}
+		})
+	}
+}
+
+func TestBulkTaskOperations(t *testing.T) {
+	newDoc := func() *TaskDocument {
+		return &TaskDocument{
+			Events: map[string][]TaskSlot{
+				"2026-01-01": {{ID: "a", Title: "A"}, {ID: "b", Title: "B"}},
+				"2026-01-02": {{ID: "c", Title: "C"}},
+			},
+			Notifications: map[string][]NotificationSlot{
+				"2026-01-02": {{Time: "09:00"}},
+			},
+		}
+	}
+	known := map[string]bool{"a": true, "b": true, "c": true}
+
+	tests := []struct {
+		name         string
+		request      TaskSaveRequest
+		wantOps      []string
+		wantReplaced map[string][]string
+		wantRemoved  []string
+	}{
+		{
+			name: "task without ID keeps the ID at the same position",
+			request: TaskSaveRequest{Events: map[string][]TaskSlot{
+				"2026-01-01": {{Title: "A2"}},
+			}},
+			wantOps:      []string{"create task a 2026-01-01", "delete task b "},
+			wantReplaced: map[string][]string{"2026-01-01": {"a"}},
+		},
+		{
+			name: "IDs sent in the request are not reassigned",
+			request: TaskSaveRequest{Events: map[string][]TaskSlot{
+				"2026-01-01": {{Title: "new"}, {ID: "a", Title: "A"}},
+			}},
+			wantOps:      []string{"create task <new> 2026-01-01", "create task a 2026-01-01", "delete task b "},
+			wantReplaced: map[string][]string{"2026-01-01": {"<new>", "a"}},
+		},
+		{
+			name: "replace mode removes dates that were not sent",
+			request: TaskSaveRequest{Mode: taskSaveModeReplace, Events: map[string][]TaskSlot{
+				"2026-01-01": {{ID: "a"}, {ID: "b"}},
+			}},
+			wantOps:      []string{"create task a 2026-01-01", "create task b 2026-01-01", "delete task c ", "delete notification 2026-01-02"},
+			wantReplaced: map[string][]string{"2026-01-01": {"a", "b"}},
+			wantRemoved:  []string{"2026-01-02"},
+		},
+		{
+			name:         "deleted events remove the whole date",
+			request:      TaskSaveRequest{DeletedEvents: []string{"2026-01-02"}},
+			wantOps:      []string{"delete task c "},
+			wantReplaced: map[string][]string{},
+			wantRemoved:  []string{"2026-01-02"},
+		},
+		{
+			name: "recurring occurrences are not saved",
+			request: TaskSaveRequest{Events: map[string][]TaskSlot{
+				"2026-01-03": {{Title: "weekly", RecurringID: "r1", OccurrenceDate: "2026-01-03"}},
+			}},
+			wantOps:      []string{},
+			wantReplaced: map[string][]string{},
+		},
+		{
+			name: "task moved between dates is kept",
+			request: TaskSaveRequest{Events: map[string][]TaskSlot{
+				"2026-01-02": {{ID: "c"}, {ID: "b"}},
+			}},
+			wantOps:      []string{"create task c 2026-01-02", "create task b 2026-01-02"},
+			wantReplaced: map[string][]string{"2026-01-02": {"c", "b"}},
+		},
+		{
+			name: "notifications are replaced and deleted by date",
+			request: TaskSaveRequest{
+				Notifications:        map[string][]NotificationSlot{"2026-01-05": {{Time: "08:00"}}},
+				DeletedNotifications: []string{"2026-01-02"},
+			},
+			wantOps:      []string{"update notification 2026-01-05", "delete notification 2026-01-02"},
+			wantReplaced: map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, replaced, removed := bulkTaskOperations(newDoc(), &tt.request)
+
+			if got := describeTaskOps(ops, known); !equalStrings(got, tt.wantOps) {
+				t.Errorf("ops = %q, want %q", got, tt.wantOps)
+			}
+			if len(replaced) != len(tt.wantReplaced) {
+				t.Errorf("replaced dates = %d, want %d", len(replaced), len(tt.wantReplaced))
+			}
+			for date, wantIDs := range tt.wantReplaced {
+				var ids []string
+				for _, task := range replaced[date] {
+					if known[task.ID] {
+						ids = append(ids, task.ID)
+					} else {
+						ids = append(ids, "<new>")
+					}
+				}
+				if !equalStrings(ids, wantIDs) {
+					t.Errorf("replaced[%s] = %v, want %v", date, ids, wantIDs)
+				}
+			}
+			if !equalStrings(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestSaveTaskDataRecordsChanges(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	const uid = "user1"
+
+	first, err := saveTaskData(ctx, uid, "", &TaskSaveRequest{Events: map[string][]TaskSlot{
+		"2026-01-01": {{Title: "A", Start: "09:00", End: "10:00"}},
+	}})
+	if err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+	if first.Revision != 1 {
+		t.Fatalf("first revision = %d, want 1", first.Revision)
+	}
+	taskID := first.Events["2026-01-01"][0].ID
+
+	second, err := saveTaskData(ctx, uid, revisionETag(first.Revision), &TaskSaveRequest{Events: map[string][]TaskSlot{
+		"2026-01-02": {{ID: taskID, Title: "A", Start: "09:00", End: "10:00"}},
+	}})
+	if err != nil {
+		t.Fatalf("second save: %v", err)
+	}
+
+	stored, err := dataStore.GetTaskDocument(ctx, uid)
+	if err != nil {
+		t.Fatalf("GetTaskDocument: %v", err)
+	}
+	ops, reset := taskChangesSince(stored, first.Revision)
+	if reset {
+		t.Fatal("taskChangesSince reset after a recorded save")
+	}
+	if got, want := describeTaskOps(ops, map[string]bool{taskID: true}), []string{"update task " + taskID + " 2026-01-02"}; !equalStrings(got, want) {
+		t.Errorf("changes since first save = %q, want %q", got, want)
+	}
+
+	// 古いリビジョンの If-Match は保存せずに競合を返す
+	conflict, err := saveTaskData(ctx, uid, revisionETag(first.Revision), &TaskSaveRequest{DeletedEvents: []string{"2026-01-02"}})
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("stale If-Match error = %v, want ErrRevisionConflict", err)
+	}
+	if conflict.Revision != second.Revision {
+		t.Errorf("conflict revision = %d, want %d", conflict.Revision, second.Revision)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 実行環境にタイムゾーンデータベースがない場合（Lambdaなど）に備えて埋め込む
+	_ "time/tzdata"
+)
+
+// fallbackTimeZone は DEFAULT_TIME_ZONE が未設定の場合のタイムゾーンです
+// タイムゾーン導入前のスペース・タスクの日付と時刻はこのタイムゾーンとして扱います
+const fallbackTimeZone = "Asia/Tokyo"
+
+// clockLayout は予定・タスク・通知の時刻の正規形です
+const clockLayout = "15:04"
+
+// clockEndOfDay は終了時刻にのみ指定できる、その日の終わりを表す時刻です
+const clockEndOfDay = "24:00"
+
+// getDefaultTimeZone はタイムゾーンが設定されていないユーザー・スペースのタイムゾーンを
+// 環境変数 DEFAULT_TIME_ZONE から取得します
+func getDefaultTimeZone() string {
+	name := strings.TrimSpace(os.Getenv("DEFAULT_TIME_ZONE"))
+	if name == "" {
+		return fallbackTimeZone
+	}
+	return name
+}
+
+// loadTimeZone はIANAタイムゾーン名（例: "Asia/Tokyo"）を読み込みます。空の場合は既定のタイムゾーンです
+// サーバーの環境に依存する "Local" は使用できません
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		name = getDefaultTimeZone()
+	}
+	if name == "Local" {
+		return nil, errors.New("タイムゾーンはIANAの名前（例: Asia/Tokyo）で指定してください")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("タイムゾーンが正しくありません: %s", name)
+	}
+	return loc, nil
+}
+
+// effectiveTimeZone は設定されたタイムゾーン名を返します。未設定の場合は既定のタイムゾーンです
+func effectiveTimeZone(name string) string {
+	if name == "" {
+		return getDefaultTimeZone()
+	}
+	return name
+}
+
+// normalizeClockTime は時刻（"9:00"・"09:00"・"09:00:00"）を "HH:MM" 形式にそろえます
+// 空の場合は時刻なしとしてそのまま返します。endOfDay が true の場合は "24:00" を許可します
+func normalizeClockTime(value string, endOfDay bool) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	minutes, ok := clockMinutes(value)
+	if !ok || minutes == 24*60 && !endOfDay {
+		return "", fmt.Errorf("時刻は HH:MM 形式で指定してください: %s", value)
+	}
+	return formatClockMinutes(minutes), nil
+}
+
+// clockMinutes は時刻を0時からの分数に変換します（"24:00" は 1440）
+func clockMinutes(value string) (int, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) > 2 || len(parts[1]) != 2 {
+		return 0, false
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, false
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, false
+	}
+	if len(parts) == 3 {
+		if second, err := strconv.Atoi(parts[2]); err != nil || len(parts[2]) != 2 || second < 0 || second > 59 {
+			return 0, false
+		}
+	}
+	if hour == 24 && minute != 0 {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
+
+// formatClockMinutes は0時からの分数を "HH:MM" 形式にします
+func formatClockMinutes(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// normalizeDateKey は日付キー（"2026-1-5" など）を YYYY-MM-DD 形式にそろえます
+func normalizeDateKey(date string) (string, error) {
+	for _, layout := range []string{taskDateLayout, "2006-1-2"} {
+		if d, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+			return d.Format(taskDateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("日付は YYYY-MM-DD 形式で指定してください: %s", date)
+}
+
+// convertClockRange は from のタイムゾーンでの日付・開始時刻・終了時刻を to のタイムゾーンに変換します
+//
+// 終了時刻が開始時刻以前の場合は翌日の時刻として扱い、変換後も同じ規則で表します
+// 開始時刻がない（終日の）場合や、形式が正しくない過去のデータは変換しません
+func convertClockRange(date, start, end string, from, to *time.Location) (string, string, string) {
+	day, err := time.Parse(taskDateLayout, date)
+	startMinutes, ok := clockMinutes(start)
+	if err != nil || !ok || from.String() == to.String() {
+		return date, start, end
+	}
+
+	startAt := time.Date(day.Year(), day.Month(), day.Day(), 0, startMinutes, 0, 0, from)
+	converted := startAt.In(to)
+	newDate := converted.Format(taskDateLayout)
+	newStart := converted.Format(clockLayout)
+
+	endMinutes, ok := clockMinutes(end)
+	if !ok {
+		return newDate, newStart, end
+	}
+	if endMinutes <= startMinutes {
+		endMinutes += 24 * 60
+	}
+	endAt := time.Date(day.Year(), day.Month(), day.Day(), 0, endMinutes, 0, 0, from).In(to)
+	newEnd := endAt.Format(clockLayout)
+	// 変換後の日付の翌日0時ちょうどに終わる場合は "24:00" とする
+	nextDay := time.Date(converted.Year(), converted.Month(), converted.Day()+1, 0, 0, 0, 0, to)
+	if endAt.Equal(nextDay) {
+		newEnd = clockEndOfDay
+	}
+	return newDate, newStart, newEnd
+}
+
+// convertScheduleEvents はスペースの予定を from のタイムゾーンから to のタイムゾーンに変換した新しいマップを返します
+func convertScheduleEvents(events map[string][]TimeEntry, from, to *time.Location) map[string][]TimeEntry {
+	if from.String() == to.String() {
+		return events
+	}
+	converted := make(map[string][]TimeEntry, len(events))
+	for date, entries := range events {
+		if len(entries) == 0 {
+			if _, ok := converted[date]; !ok {
+				converted[date] = []TimeEntry{}
+			}
+			continue
+		}
+		for _, entry := range entries {
+			var newDate string
+			newDate, entry.Start, entry.End = convertClockRange(date, entry.Start, entry.End, from, to)
+			converted[newDate] = append(converted[newDate], entry)
+		}
+	}
+	return converted
+}
+
+// convertTaskEvents はタスクを from のタイムゾーンから to のタイムゾーンに変換した新しいマップを返します
+func convertTaskEvents(events map[string][]TaskSlot, from, to *time.Location) map[string][]TaskSlot {
+	if from.String() == to.String() {
+		return events
+	}
+	converted := make(map[string][]TaskSlot, len(events))
+	for date, tasks := range events {
+		if len(tasks) == 0 {
+			if _, ok := converted[date]; !ok {
+				converted[date] = []TaskSlot{}
+			}
+			continue
+		}
+		for _, task := range tasks {
+			var newDate string
+			newDate, task.Start, task.End = convertClockRange(date, task.Start, task.End, from, to)
+			converted[newDate] = append(converted[newDate], task)
+		}
+	}
+	return converted
+}
+
+// convertNotifications は通知を from のタイムゾーンから to のタイムゾーンに変換した新しいマップを返します
+func convertNotifications(notifications map[string][]NotificationSlot, from, to *time.Location) map[string][]NotificationSlot {
+	if from.String() == to.String() {
+		return notifications
+	}
+	converted := make(map[string][]NotificationSlot, len(notifications))
+	for date, list := range notifications {
+		if len(list) == 0 {
+			if _, ok := converted[date]; !ok {
+				converted[date] = []NotificationSlot{}
+			}
+			continue
+		}
+		for _, notification := range list {
+			var newDate string
+			newDate, notification.Time, _ = convertClockRange(date, notification.Time, "", from, to)
+			converted[newDate] = append(converted[newDate], notification)
+		}
+	}
+	return converted
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConvertClockRange(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name                         string
+		date, start, end             string
+		from, to                     string
+		wantDate, wantStart, wantEnd string
+	}{
+		{"same day", "2026-01-05", "09:00", "10:00", "Asia/Tokyo", "UTC", "2026-01-05", "00:00", "01:00"},
+		{"moves to the previous day and ends at midnight", "2026-01-05", "08:00", "09:00", "Asia/Tokyo", "UTC", "2026-01-04", "23:00", "24:00"},
+		{"overnight range moves to the next day", "2026-01-05", "20:00", "02:00", "UTC", "Asia/Tokyo", "2026-01-06", "05:00", "11:00"},
+		{"end of day", "2026-01-05", "15:00", "24:00", "UTC", "Asia/Tokyo", "2026-01-06", "00:00", "09:00"},
+		{"before daylight saving time starts", "2026-03-08", "06:00", "07:00", "UTC", "America/New_York", "2026-03-08", "01:00", "03:00"},
+		{"after daylight saving time starts", "2026-03-08", "12:00", "13:00", "UTC", "America/New_York", "2026-03-08", "08:00", "09:00"},
+		{"without end time", "2026-01-05", "09:00", "", "Asia/Tokyo", "UTC", "2026-01-05", "00:00", ""},
+		{"all-day entry is not converted", "2026-01-05", "", "", "Asia/Tokyo", "UTC", "2026-01-05", "", ""},
+		{"invalid date is not converted", "2026/01/05", "09:00", "10:00", "Asia/Tokyo", "UTC", "2026/01/05", "09:00", "10:00"},
+		{"same time zone is not converted", "2026-01-05", "9:00", "10:00", "Asia/Tokyo", "Asia/Tokyo", "2026-01-05", "9:00", "10:00"},
+	}
+
+	locations := map[string]*time.Location{"Asia/Tokyo": tokyo, "America/New_York": newYork, "UTC": time.UTC}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, start, end := convertClockRange(tt.date, tt.start, tt.end, locations[tt.from], locations[tt.to])
+			if date != tt.wantDate || start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("convertClockRange() = %s %s-%s, want %s %s-%s", date, start, end, tt.wantDate, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
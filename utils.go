@@ -2,7 +2,6 @@
 package main
 
 import (
-	"context"
 	"os"
 	"strings"
 )
@@ -12,8 +11,9 @@ import (
 func getCorsHeaders() map[string]string {
 	return map[string]string{
 		"Access-Control-Allow-Origin":      "*",
-		"Access-Control-Allow-Methods":     "POST, GET, OPTIONS, PUT, DELETE",
-		"Access-Control-Allow-Headers":     "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization",
+		"Access-Control-Allow-Methods":     "POST, GET, OPTIONS, PUT, DELETE, PATCH",
+		"Access-Control-Allow-Headers":     "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Space-Edit-Key, X-Space-Share-Token, X-Space-Share-Password, If-Match",
+		"Access-Control-Expose-Headers":    "ETag",
 		"Access-Control-Allow-Credentials": "true",
 	}
 }
@@ -21,20 +21,6 @@ func getCorsHeaders() map[string]string {
 // userContextKey は、コンテキストキーの衝突を避けるためのカスタム型です。
 type userContextKey string
 
-// uidContextKey は、コンテキスト内でユーザーIDを保存・取得するためのキーです。
-const uidContextKey userContextKey = "userUID"
-
-// setUIDInContext は、ユーザーIDを含む新しいコンテキストを生成します。
-func setUIDInContext(ctx context.Context, uid string) context.Context {
-	return context.WithValue(ctx, uidContextKey, uid)
-}
-
-// getUIDFromContext は、コンテキストからユーザーIDを取得します。
-func getUIDFromContext(ctx context.Context) (string, bool) {
-	uid, ok := ctx.Value(uidContextKey).(string)
-	return uid, ok
-}
-
 // isLambdaEnvironment は現在の環境がAWS Lambdaかどうかを判定します
 func isLambdaEnvironment() bool {
 	// AWS Lambda環境では以下の環境変数が設定されます
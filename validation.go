@@ -0,0 +1,480 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// 検証エラーのコード
+const (
+	validationRequired       = "required"
+	validationInvalidFormat  = "invalid_format"
+	validationInvalidValue   = "invalid_value"
+	validationEndBeforeStart = "end_before_start"
+	validationOutOfRange     = "out_of_range"
+	validationTooMany        = "too_many"
+	validationTooLong        = "too_long"
+	validationConflict       = "conflict"
+	validationUnsupported    = "unsupported"
+)
+
+// 書き込みリクエストのサイズの上限
+const (
+	// scheduleMaxDates はスペースに登録できる日付数の上限です
+	scheduleMaxDates = 366
+	// scheduleMaxEntriesPerDate は1日に登録できる予定の上限です（全参加者の合計）
+	scheduleMaxEntriesPerDate = 200
+	// taskSaveMaxDates は1回の一括保存で送信できる日付数の上限です（deletedEvents・deletedNotifications を含む）
+	taskSaveMaxDates = 3660
+	// taskMaxPerDate は1日に登録できるタスクの上限です
+	taskMaxPerDate = 100
+	// notificationMaxPerDate は1日に登録できる通知の上限です
+	notificationMaxPerDate = 50
+	// recurringExDatesMax は繰り返しタスクの除外日の上限です
+	recurringExDatesMax = 1000
+
+	spaceTitleMaxLength      = 100
+	usernameMaxLength        = 50
+	userColorMaxLength       = 32
+	taskTitleMaxLength       = 200
+	taskDescriptionMaxLength = 5000
+
+	// spaceSharePasswordMinLength・spaceSharePasswordMaxLength は共有リンクのパスワードの文字数です
+	spaceSharePasswordMinLength = 4
+	spaceSharePasswordMaxLength = 128
+)
+
+// FieldError はリクエストの1つのフィールドの検証エラーです
+// Path はJSON上の位置です（例: "events.2026-01-01[0].start"）
+type FieldError struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors はリクエストの検証エラーの一覧です
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 0 {
+		return "validation failed"
+	}
+	return fmt.Sprintf("validation failed: %s: %s", e[0].Path, e[0].Message)
+}
+
+// validationErrorResponse は検証エラーのレスポンスを作成します
+func validationErrorResponse(err error) (map[string]interface{}, int) {
+	var errs ValidationErrors
+	errors.As(err, &errs)
+	return map[string]interface{}{
+		"error":  "入力内容に誤りがあります",
+		"code":   "validation_failed",
+		"errors": errs,
+	}, http.StatusBadRequest
+}
+
+// validator は検証エラーを集めます。値の検証と同時に正規形への変換も行います
+type validator struct {
+	errors ValidationErrors
+}
+
+// add は検証エラーを追加します
+func (v *validator) add(path, code, message string) {
+	v.errors = append(v.errors, FieldError{Path: path, Code: code, Message: message})
+}
+
+// err は検証エラーがある場合に ValidationErrors を返します
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+// fieldPath は path の下のフィールドの位置を返します。path が空の場合はフィールド名のみです
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// date は日付を YYYY-MM-DD 形式にそろえます。形式が正しくない場合は空文字列を返します
+func (v *validator) date(path, value string) string {
+	normalized, err := normalizeDateKey(value)
+	if err != nil {
+		v.add(path, validationInvalidFormat, "日付は YYYY-MM-DD 形式で指定してください")
+		return ""
+	}
+	return normalized
+}
+
+// clock は時刻を HH:MM 形式にそろえます。endOfDay が true の場合は "24:00" を許可します
+func (v *validator) clock(path, value string, endOfDay bool) string {
+	normalized, err := normalizeClockTime(value, endOfDay)
+	if err != nil {
+		v.add(path, validationInvalidFormat, "時刻は HH:MM 形式で指定してください")
+		return value
+	}
+	return normalized
+}
+
+// timeRange は開始・終了時刻をそろえ、終了時刻が開始時刻より後であることを確認します
+// 終了時刻のみの指定はできません。日付をまたぐ予定は日付ごとに分けて登録します
+func (v *validator) timeRange(path string, start, end *string) {
+	before := len(v.errors)
+	*start = v.clock(fieldPath(path, "start"), *start, false)
+	*end = v.clock(fieldPath(path, "end"), *end, true)
+	if len(v.errors) > before {
+		return
+	}
+	if *start == "" && *end != "" {
+		v.add(fieldPath(path, "start"), validationRequired, "終了時刻を指定する場合は開始時刻も指定してください")
+		return
+	}
+	if *end == "" {
+		return
+	}
+	startMinutes, _ := clockMinutes(*start)
+	endMinutes, _ := clockMinutes(*end)
+	if endMinutes <= startMinutes {
+		v.add(fieldPath(path, "end"), validationEndBeforeStart, "終了時刻は開始時刻より後の時刻を指定してください")
+	}
+}
+
+// maxLength は文字列の長さ（文字数）が上限以下であることを確認します
+func (v *validator) maxLength(path, value string, limit int) {
+	if utf8.RuneCountInString(value) > limit {
+		v.add(path, validationTooLong, fmt.Sprintf("%d 文字以内で指定してください", limit))
+	}
+}
+
+// maxItems は要素数が上限以下であることを確認します
+func (v *validator) maxItems(path string, count, limit int) bool {
+	if count > limit {
+		v.add(path, validationTooMany, fmt.Sprintf("%d 件以内で指定してください", limit))
+		return false
+	}
+	return true
+}
+
+// timeZone はIANAタイムゾーン名であることを確認します
+func (v *validator) timeZone(path, value string) {
+	if value == "" {
+		v.add(path, validationRequired, "タイムゾーンを指定してください")
+		return
+	}
+	if _, err := loadTimeZone(value); err != nil {
+		v.add(path, validationInvalidValue, "タイムゾーンはIANAの名前（例: Asia/Tokyo）で指定してください")
+	}
+}
+
+// scheduleEvents はスペースの予定を検証し、日付と時刻を正規形にそろえた新しいマップを返します
+// from・to を指定した場合は、日付がその範囲（両端を含む）にあることを確認します
+func (v *validator) scheduleEvents(path string, events map[string][]TimeEntry, from, to string) map[string][]TimeEntry {
+	if events == nil {
+		return nil
+	}
+	if !v.maxItems(path, len(events), scheduleMaxDates) {
+		return events
+	}
+
+	normalized := make(map[string][]TimeEntry, len(events))
+	for _, date := range slices.Sorted(maps.Keys(events)) {
+		datePath := path + "." + date
+		key := v.date(datePath, date)
+		if key == "" {
+			continue
+		}
+		if from != "" && key < from || to != "" && key > to {
+			v.add(datePath, validationOutOfRange, "スペースの期間外の日付です")
+			continue
+		}
+		entries := events[date]
+		if !v.maxItems(datePath, len(entries), scheduleMaxEntriesPerDate) {
+			continue
+		}
+		if _, ok := normalized[key]; !ok {
+			normalized[key] = []TimeEntry{}
+		}
+		for i, entry := range entries {
+			entryPath := fmt.Sprintf("%s[%d]", datePath, i)
+			v.timeRange(entryPath, &entry.Start, &entry.End)
+			v.maxLength(entryPath+".username", entry.Username, usernameMaxLength)
+			v.maxLength(entryPath+".userColor", entry.UserColor, userColorMaxLength)
+			normalized[key] = append(normalized[key], entry)
+		}
+	}
+	return normalized
+}
+
+// validateSchedulePost は POST /api/time のリクエストを検証し、日付と時刻を正規形にそろえます
+func validateSchedulePost(post *SchedulePostRequest) error {
+	v := &validator{}
+	var from, to string
+	if post.StartDate != nil && *post.StartDate != "" {
+		if from = v.date("startDate", *post.StartDate); from != "" {
+			post.StartDate = &from
+		}
+	}
+	if post.EndDate != nil && *post.EndDate != "" {
+		if to = v.date("endDate", *post.EndDate); to != "" {
+			post.EndDate = &to
+		}
+	}
+	if from != "" && to != "" && to < from {
+		v.add("endDate", validationEndBeforeStart, "endDate は startDate 以降の日付を指定してください")
+		from, to = "", ""
+	}
+	if post.TimeZone != nil {
+		v.timeZone("timeZone", *post.TimeZone)
+	}
+	if post.Title != nil {
+		v.maxLength("title", *post.Title, spaceTitleMaxLength)
+	}
+	post.Events = v.scheduleEvents("events", post.Events, from, to)
+	return v.err()
+}
+
+// validateSpaceEntries は参加者ごとの予定の更新リクエストを検証し、日付と時刻を正規形にそろえます
+// スペースの期間の確認は、スペースのタイムゾーンに変換した後に validateEntriesInSpaceRange で行います
+func validateSpaceEntries(request *SpaceEntriesRequest) error {
+	v := &validator{}
+	v.maxLength("username", request.Username, usernameMaxLength)
+	if request.TimeZone != "" {
+		v.timeZone("timeZone", request.TimeZone)
+	}
+	request.Entries = v.scheduleEvents("entries", request.Entries, "", "")
+	return v.err()
+}
+
+// spaceDateRange はスペースの期間（startDate〜endDate）を YYYY-MM-DD 形式で返します
+// 形式が正しくない（検証の導入前に保存された）期間は空として扱います
+func spaceDateRange(doc *ScheduleDocument) (string, string) {
+	from, _ := normalizeDateKey(stringPtrValue(doc.StartDate))
+	to, _ := normalizeDateKey(stringPtrValue(doc.EndDate))
+	return from, to
+}
+
+// inSpaceRange は日付がスペースの期間にあることを確認します
+func (v *validator) inSpaceRange(path, date, from, to string) {
+	if from != "" && date < from || to != "" && date > to {
+		v.add(path, validationOutOfRange, "スペースの期間外の日付です")
+	}
+}
+
+// validateEntriesInSpaceRange は予定の日付がスペースの期間（startDate〜endDate）にあることを確認します
+func validateEntriesInSpaceRange(doc *ScheduleDocument, entries map[string][]TimeEntry) error {
+	from, to := spaceDateRange(doc)
+	if from == "" && to == "" {
+		return nil
+	}
+	v := &validator{}
+	for _, date := range slices.Sorted(maps.Keys(entries)) {
+		v.inSpaceRange("entries."+date, date, from, to)
+	}
+	return v.err()
+}
+
+// validateSpaceDecisionRequest は日時の確定リクエストを検証し、日付と時刻を正規形にそろえます
+// スペースの期間の確認は、スペースを読み込んだ後に validateDecisionInSpaceRange で行います
+func validateSpaceDecisionRequest(request *SpaceDecisionRequest) error {
+	v := &validator{}
+	if request.Date == "" {
+		v.add("date", validationRequired, "date を指定してください")
+	} else {
+		request.Date = v.date("date", request.Date)
+	}
+	if request.Start == "" {
+		v.add("start", validationRequired, "start を指定してください")
+	} else {
+		v.timeRange("", &request.Start, &request.End)
+	}
+	if strings.TrimSpace(request.Title) == "" {
+		v.add("title", validationRequired, "title を指定してください")
+	}
+	v.maxLength("title", request.Title, taskTitleMaxLength)
+	v.maxLength("description", request.Description, taskDescriptionMaxLength)
+	return v.err()
+}
+
+// validateSpaceShareLinkRequest は共有リンクの作成・再発行リクエストを検証します
+// requireCapability が false の場合（再発行）は capability を省略できます
+func validateSpaceShareLinkRequest(request *SpaceShareLinkRequest, requireCapability bool) error {
+	v := &validator{}
+	switch {
+	case request.Capability == "" && requireCapability:
+		v.add("capability", validationRequired, "capability を指定してください")
+	case request.Capability != "" && !isValidSpaceCapability(request.Capability):
+		v.add("capability", validationInvalidValue, "capability は view・edit のいずれかを指定してください")
+	}
+	if request.Password != nil && *request.Password != "" {
+		if utf8.RuneCountInString(*request.Password) < spaceSharePasswordMinLength {
+			v.add("password", validationInvalidValue, fmt.Sprintf("%d 文字以上で指定してください", spaceSharePasswordMinLength))
+		}
+		v.maxLength("password", *request.Password, spaceSharePasswordMaxLength)
+	}
+	return v.err()
+}
+
+// validateDecisionInSpaceRange は確定する日付がスペースの期間にあることを確認します
+func validateDecisionInSpaceRange(doc *ScheduleDocument, date string) error {
+	from, to := spaceDateRange(doc)
+	v := &validator{}
+	v.inSpaceRange("date", date, from, to)
+	return v.err()
+}
+
+// taskSlot はタスクを検証し、時刻を正規形にそろえます
+func (v *validator) taskSlot(path string, task *TaskSlot) {
+	v.timeRange(path, &task.Start, &task.End)
+	v.maxLength(fieldPath(path, "title"), task.Title, taskTitleMaxLength)
+	v.maxLength(fieldPath(path, "description"), task.Description, taskDescriptionMaxLength)
+	v.maxLength(fieldPath(path, "userColor"), task.UserColor, userColorMaxLength)
+}
+
+// notifications は1日分の通知を検証し、時刻を正規形にそろえます
+func (v *validator) notifications(path string, notifications []NotificationSlot) {
+	if !v.maxItems(path, len(notifications), notificationMaxPerDate) {
+		return
+	}
+	for i := range notifications {
+		timePath := fmt.Sprintf("%s[%d].time", path, i)
+		if notifications[i].Time == "" {
+			v.add(timePath, validationRequired, "通知の時刻を指定してください")
+			continue
+		}
+		notifications[i].Time = v.clock(timePath, notifications[i].Time, false)
+	}
+}
+
+// dateList は日付のリストを検証し、YYYY-MM-DD 形式にそろえます
+func (v *validator) dateList(path string, dates []string) []string {
+	normalized := make([]string, 0, len(dates))
+	for i, date := range dates {
+		if key := v.date(fmt.Sprintf("%s[%d]", path, i), date); key != "" {
+			normalized = append(normalized, key)
+		}
+	}
+	return normalized
+}
+
+// validateTaskSaveRequest は POST /api/task のリクエストを検証し、日付と時刻を正規形にそろえます
+func validateTaskSaveRequest(request *TaskSaveRequest) error {
+	v := &validator{}
+	if request.Mode != "" && request.Mode != taskSaveModeMerge && request.Mode != taskSaveModeReplace {
+		v.add("mode", validationInvalidValue, "mode は merge・replace のいずれかを指定してください")
+	}
+	total := len(request.Events) + len(request.Notifications) + len(request.DeletedEvents) + len(request.DeletedNotifications)
+	if !v.maxItems("events", total, taskSaveMaxDates) {
+		return v.err()
+	}
+
+	if request.Events != nil {
+		events := make(map[string][]TaskSlot, len(request.Events))
+		for _, date := range slices.Sorted(maps.Keys(request.Events)) {
+			datePath := "events." + date
+			key := v.date(datePath, date)
+			tasks := request.Events[date]
+			if key == "" || !v.maxItems(datePath, len(tasks), taskMaxPerDate) {
+				continue
+			}
+			for i := range tasks {
+				v.taskSlot(fmt.Sprintf("%s[%d]", datePath, i), &tasks[i])
+			}
+			events[key] = append(events[key], tasks...)
+		}
+		request.Events = events
+	}
+	if request.Notifications != nil {
+		notifications := make(map[string][]NotificationSlot, len(request.Notifications))
+		for _, date := range slices.Sorted(maps.Keys(request.Notifications)) {
+			datePath := "notifications." + date
+			key := v.date(datePath, date)
+			if key == "" {
+				continue
+			}
+			v.notifications(datePath, request.Notifications[date])
+			notifications[key] = append(notifications[key], request.Notifications[date]...)
+		}
+		request.Notifications = notifications
+	}
+
+	request.DeletedEvents = v.dateList("deletedEvents", request.DeletedEvents)
+	request.DeletedNotifications = v.dateList("deletedNotifications", request.DeletedNotifications)
+	for i, date := range request.DeletedEvents {
+		if _, ok := request.Events[date]; ok {
+			v.add(fmt.Sprintf("deletedEvents[%d]", i), validationConflict, "同じ日付を events と deletedEvents の両方に指定することはできません")
+		}
+	}
+	for i, date := range request.DeletedNotifications {
+		if len(request.Notifications[date]) > 0 {
+			v.add(fmt.Sprintf("deletedNotifications[%d]", i), validationConflict, "同じ日付を notifications と deletedNotifications の両方に指定することはできません")
+		}
+	}
+	return v.err()
+}
+
+// taskItem は1件のタスクの作成・変更リクエストを検証し、時刻を正規形にそろえます
+// 開始・終了時刻の前後関係は、既存のタスクに変更を反映した後に validateTaskSlot で確認します
+func (v *validator) taskItem(request *TaskItemRequest, requireDate bool) {
+	if request.Date != "" || requireDate {
+		if date := v.date("date", request.Date); date != "" {
+			request.Date = date
+		}
+	}
+	if request.Start != nil {
+		start := v.clock("start", *request.Start, false)
+		request.Start = &start
+	}
+	if request.End != nil {
+		end := v.clock("end", *request.End, true)
+		request.End = &end
+	}
+	if request.Title != nil {
+		v.maxLength("title", *request.Title, taskTitleMaxLength)
+	}
+	if request.Description != nil {
+		v.maxLength("description", *request.Description, taskDescriptionMaxLength)
+	}
+	if request.UserColor != nil {
+		v.maxLength("userColor", *request.UserColor, userColorMaxLength)
+	}
+}
+
+// validateTaskItemRequest は1件のタスクの作成・変更リクエストを検証します
+func validateTaskItemRequest(request *TaskItemRequest, requireDate bool) error {
+	v := &validator{}
+	v.taskItem(request, requireDate)
+	return v.err()
+}
+
+// validateTaskSlot は変更を反映した後のタスクの内容（開始・終了時刻の前後関係など）を検証します
+// 1件のタスクのリクエストと同じく、フィールド名のみを位置とします
+func validateTaskSlot(task *TaskSlot) error {
+	v := &validator{}
+	v.taskSlot("", task)
+	return v.err()
+}
+
+// validateUserDataRequest はユーザーデータの更新リクエストを検証します
+func validateUserDataRequest(request *UserDataRequest) error {
+	v := &validator{}
+	if strings.TrimSpace(request.UserName) == "" {
+		v.add("userName", validationRequired, "ユーザー名は必須です")
+	}
+	v.maxLength("userName", request.UserName, usernameMaxLength)
+	if request.UserColor == "" {
+		v.add("userColor", validationRequired, "ユーザーカラーは必須です")
+	}
+	v.maxLength("userColor", request.UserColor, userColorMaxLength)
+	if request.TimeZone != "" {
+		v.timeZone("timeZone", request.TimeZone)
+	}
+	return v.err()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// validationCodes は検証エラーを "path:code" の一覧にします
+func validationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not ValidationErrors", err)
+	}
+	codes := make([]string, 0, len(errs))
+	for _, e := range errs {
+		codes = append(codes, e.Path+":"+e.Code)
+	}
+	return codes
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestValidateSchedulePost(t *testing.T) {
+	tests := []struct {
+		name       string
+		post       SchedulePostRequest
+		wantErrors []string
+		wantEvents map[string][]string
+	}{
+		{
+			name: "normalizes dates and times",
+			post: SchedulePostRequest{
+				StartDate: stringPtr("2026-1-5"),
+				Events: map[string][]TimeEntry{
+					"2026-1-5": {{Username: "alice", Start: "9:00", End: "10:00:00"}},
+				},
+			},
+			wantEvents: map[string][]string{"2026-01-05": {"09:00-10:00"}},
+		},
+		{
+			name: "end of day is allowed as end time",
+			post: SchedulePostRequest{Events: map[string][]TimeEntry{
+				"2026-01-05": {{Start: "23:00", End: "24:00"}},
+			}},
+			wantEvents: map[string][]string{"2026-01-05": {"23:00-24:00"}},
+		},
+		{
+			name: "end before start",
+			post: SchedulePostRequest{Events: map[string][]TimeEntry{
+				"2026-01-05": {{Start: "10:00", End: "09:00"}},
+			}},
+			wantErrors: []string{"events.2026-01-05[0].end:end_before_start"},
+		},
+		{
+			name: "end without start",
+			post: SchedulePostRequest{Events: map[string][]TimeEntry{
+				"2026-01-05": {{End: "09:00"}},
+			}},
+			wantErrors: []string{"events.2026-01-05[0].start:required"},
+		},
+		{
+			name: "invalid clock and date",
+			post: SchedulePostRequest{Events: map[string][]TimeEntry{
+				"2026-01-05": {{Start: "24:00", End: ""}},
+				"tomorrow":   {{Start: "09:00"}},
+			}},
+			wantErrors: []string{"events.2026-01-05[0].start:invalid_format", "events.tomorrow:invalid_format"},
+		},
+		{
+			name: "date outside the space period",
+			post: SchedulePostRequest{
+				StartDate: stringPtr("2026-01-01"),
+				EndDate:   stringPtr("2026-01-31"),
+				Events:    map[string][]TimeEntry{"2026-02-01": {{Start: "09:00"}}},
+			},
+			wantErrors: []string{"events.2026-02-01:out_of_range"},
+		},
+		{
+			name: "end date before start date",
+			post: SchedulePostRequest{
+				StartDate: stringPtr("2026-01-31"),
+				EndDate:   stringPtr("2026-01-01"),
+			},
+			wantErrors: []string{"endDate:end_before_start"},
+		},
+		{
+			name: "time zone and title",
+			post: SchedulePostRequest{
+				TimeZone: stringPtr("Mars/Olympus_Mons"),
+				Title:    stringPtr(strings.Repeat("あ", spaceTitleMaxLength+1)),
+			},
+			wantErrors: []string{"timeZone:invalid_value", "title:too_long"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := tt.post
+			got := validationCodes(t, validateSchedulePost(&post))
+			if !equalStrings(got, tt.wantErrors) {
+				t.Fatalf("errors = %q, want %q", got, tt.wantErrors)
+			}
+			for date, want := range tt.wantEvents {
+				var ranges []string
+				for _, entry := range post.Events[date] {
+					ranges = append(ranges, entry.Start+"-"+entry.End)
+				}
+				if !equalStrings(ranges, want) {
+					t.Errorf("Events[%s] = %q, want %q", date, ranges, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTaskSaveRequest(t *testing.T) {
+	tooMany := make([]TaskSlot, taskMaxPerDate+1)
+
+	tests := []struct {
+		name       string
+		request    TaskSaveRequest
+		wantErrors []string
+	}{
+		{
+			name: "valid request",
+			request: TaskSaveRequest{
+				Mode:          taskSaveModeReplace,
+				Events:        map[string][]TaskSlot{"2026-01-05": {{Title: "A", Start: "09:00", End: "10:00"}}},
+				Notifications: map[string][]NotificationSlot{"2026-01-05": {{Time: "8:30"}}},
+			},
+		},
+		{
+			name:       "unknown mode",
+			request:    TaskSaveRequest{Mode: "overwrite"},
+			wantErrors: []string{"mode:invalid_value"},
+		},
+		{
+			name:       "too many tasks on a date",
+			request:    TaskSaveRequest{Events: map[string][]TaskSlot{"2026-01-05": tooMany}},
+			wantErrors: []string{"events.2026-01-05:too_many"},
+		},
+		{
+			name: "notification without time",
+			request: TaskSaveRequest{Notifications: map[string][]NotificationSlot{
+				"2026-01-05": {{Time: ""}},
+			}},
+			wantErrors: []string{"notifications.2026-01-05[0].time:required"},
+		},
+		{
+			name: "date both saved and deleted",
+			request: TaskSaveRequest{
+				Events:        map[string][]TaskSlot{"2026-01-05": {{Title: "A"}}},
+				DeletedEvents: []string{"2026-1-5"},
+			},
+			wantErrors: []string{"deletedEvents[0]:conflict"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request
+			got := validationCodes(t, validateTaskSaveRequest(&request))
+			if !equalStrings(got, tt.wantErrors) {
+				t.Errorf("errors = %q, want %q", got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestValidateTaskItemRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     TaskItemRequest
+		requireDate bool
+		wantErrors  []string
+	}{
+		{"create requires a date", TaskItemRequest{Title: stringPtr("A")}, true, []string{"date:invalid_format"}},
+		{"patch without date", TaskItemRequest{Title: stringPtr("A")}, false, nil},
+		{"invalid start", TaskItemRequest{Start: stringPtr("25:00")}, false, []string{"start:invalid_format"}},
+		{"end of day as end", TaskItemRequest{End: stringPtr("24:00")}, false, nil},
+		{"long title", TaskItemRequest{Title: stringPtr(strings.Repeat("a", taskTitleMaxLength+1))}, false, []string{"title:too_long"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request
+			got := validationCodes(t, validateTaskItemRequest(&request, tt.requireDate))
+			if !equalStrings(got, tt.wantErrors) {
+				t.Errorf("errors = %q, want %q", got, tt.wantErrors)
+			}
+		})
+	}
+}